@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// firebaseUserDeleter は Firebase Auth のユーザーを削除する (*auth.Client が満たす)
+type firebaseUserDeleter interface {
+	DeleteUser(ctx context.Context, uid string) error
+}
+
+// Firebase ユーザー削除の再試行間隔 (アカウント削除APIの中で待つため短くしておく)
+var firebaseDeleteRetryDelays = []time.Duration{500 * time.Millisecond, 2 * time.Second}
+
+// deleteFirebaseUser は Firebase Auth のユーザーを削除する (一時的なエラーに備えて数回再試行する)
+// 既に削除済みのユーザーは成功として扱う
+func deleteFirebaseUser(ctx context.Context, client firebaseUserDeleter, uid string) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = client.DeleteUser(ctx, uid)
+		if err == nil || auth.IsUserNotFound(err) {
+			return nil
+		}
+		if attempt >= len(firebaseDeleteRetryDelays) {
+			return err
+		}
+		log.Printf("error deleting Firebase user %s (attempt %d): %v", uid, attempt+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(firebaseDeleteRetryDelays[attempt]):
+		}
+	}
+}
+
+// markPendingUserDeletion は Firebase ユーザーの削除に失敗したアカウントを記録し、後で再試行できるようにする
+// (ログインできるまま残ると、削除済みのアカウントに紐づくデータが再び作られてしまうため)
+func markPendingUserDeletion(uid string, cause error) {
+	_, err := db.Exec(`
+		INSERT INTO pending_user_deletions (user_uid, last_error) VALUES (?, ?)
+		ON CONFLICT(user_uid) DO UPDATE SET attempts = attempts + 1, last_error = excluded.last_error`,
+		uid, cause.Error())
+	if err != nil {
+		log.Printf("error recording pending deletion for Firebase user %s: %v", uid, err)
+	}
+}
+
+// sweepPendingUserDeletions は削除に失敗していた Firebase ユーザーの削除を再試行し、成功したものを記録から消す
+func sweepPendingUserDeletions(ctx context.Context, client firebaseUserDeleter) {
+	rows, err := db.Query("SELECT user_uid FROM pending_user_deletions ORDER BY created_at ASC")
+	if err != nil {
+		log.Printf("error querying pending user deletions: %v", err)
+		return
+	}
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err == nil {
+			uids = append(uids, uid)
+		}
+	}
+	rows.Close()
+
+	for _, uid := range uids {
+		if err := deleteFirebaseUser(ctx, client, uid); err != nil {
+			markPendingUserDeletion(uid, err)
+			continue
+		}
+		if _, err := db.Exec("DELETE FROM pending_user_deletions WHERE user_uid = ?", uid); err != nil {
+			log.Printf("error clearing pending deletion for Firebase user %s: %v", uid, err)
+			continue
+		}
+		log.Printf("Deleted Firebase user %s on retry", uid)
+	}
+}
+
+// runPendingUserDeletions は interval ごとに sweepPendingUserDeletions を実行し続ける (goroutine で起動する)
+func runPendingUserDeletions(client firebaseUserDeleter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sweepPendingUserDeletions(context.Background(), client)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeleteAccountRemovesDataAndFirebaseUser(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	bob := s.addUser("bob")
+	mine := s.insertTrackWithAudio(t, "alice", "Mine", testMP3(time.Second))
+	theirs := insertTrack(t, "bob", "Theirs")
+	insertComment(t, theirs, "alice", "hello")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('alice', ?)", theirs)
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", mine)
+	s.callJSON(t, http.MethodPost, "/api/user/bob/follow", alice, nil, http.StatusOK, nil)
+
+	s.callJSON(t, http.MethodDelete, "/api/account", alice, nil, http.StatusOK, nil)
+
+	for _, q := range []string{
+		"SELECT COUNT(*) FROM tracks WHERE uploader_uid = 'alice'",
+		"SELECT COUNT(*) FROM comments WHERE user_uid = 'alice'",
+		"SELECT COUNT(*) FROM likes WHERE user_uid = 'alice' OR track_id = " + strconv.Itoa(mine),
+		"SELECT COUNT(*) FROM follows WHERE follower_uid = 'alice' OR following_uid = 'alice'",
+		"SELECT COUNT(*) FROM pending_user_deletions",
+	} {
+		if n := queryInt(t, q); n != 0 {
+			t.Errorf("%s = %d, want 0", q, n)
+		}
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE uploader_uid = 'bob'"); n != 1 {
+		t.Error("another user's track was deleted")
+	}
+	if deleted := s.auth.deletedUIDs(); len(deleted) != 1 || deleted[0] != "alice" {
+		t.Errorf("Firebase users deleted: %v, want [alice]", deleted)
+	}
+	// 削除したユーザーのトークンは使えなくなる
+	s.callJSON(t, http.MethodGet, "/api/me", alice, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodGet, "/api/me", bob, nil, http.StatusOK, nil)
+}
+
+func TestDeleteAccountRecordsFailedFirebaseDeletion(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	prev := firebaseDeleteRetryDelays
+	firebaseDeleteRetryDelays = []time.Duration{time.Millisecond}
+	t.Cleanup(func() { firebaseDeleteRetryDelays = prev })
+	s.auth.mu.Lock()
+	s.auth.failDeletes = true
+	s.auth.mu.Unlock()
+
+	// Firebase のユーザーを削除できなくても、データの削除は成功として返し、後で再試行する
+	s.callJSON(t, http.MethodDelete, "/api/account", alice, nil, http.StatusOK, nil)
+	if n := queryInt(t, "SELECT COUNT(*) FROM pending_user_deletions WHERE user_uid = 'alice'"); n != 1 {
+		t.Fatalf("pending deletions for alice = %d, want 1", n)
+	}
+
+	// 再試行に失敗した場合は記録を残し、成功したら消す
+	d := &fakeUserDeleter{errs: []error{errors.New("unavailable"), errors.New("unavailable")}}
+	sweepPendingUserDeletions(context.Background(), d)
+	if n := queryInt(t, "SELECT attempts FROM pending_user_deletions WHERE user_uid = 'alice'"); n < 1 {
+		t.Fatal("failed retry cleared the pending deletion")
+	}
+	d = &fakeUserDeleter{}
+	sweepPendingUserDeletions(context.Background(), d)
+	if len(d.calls) != 1 || d.calls[0] != "alice" {
+		t.Errorf("DeleteUser calls = %v, want [alice]", d.calls)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM pending_user_deletions"); n != 0 {
+		t.Errorf("pending deletions after sweep = %d, want 0", n)
+	}
+}
+
+// fakeUserDeleter は firebaseUserDeleter の呼び出しを記録し、errs の順にエラーを返す
+type fakeUserDeleter struct {
+	calls []string
+	errs  []error
+}
+
+func (f *fakeUserDeleter) DeleteUser(ctx context.Context, uid string) error {
+	f.calls = append(f.calls, uid)
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func TestDeleteFirebaseUserRetries(t *testing.T) {
+	prev := firebaseDeleteRetryDelays
+	firebaseDeleteRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { firebaseDeleteRetryDelays = prev })
+
+	transient := errors.New("unavailable")
+	d := &fakeUserDeleter{errs: []error{transient, transient}}
+	if err := deleteFirebaseUser(context.Background(), d, "alice"); err != nil {
+		t.Errorf("succeeded on the third attempt, got %v", err)
+	}
+	if len(d.calls) != 3 {
+		t.Errorf("DeleteUser called %d times, want 3", len(d.calls))
+	}
+
+	d = &fakeUserDeleter{errs: []error{transient, transient, transient}}
+	if err := deleteFirebaseUser(context.Background(), d, "alice"); err != transient {
+		t.Errorf("all attempts failed, got %v", err)
+	}
+	if len(d.calls) != 3 {
+		t.Errorf("DeleteUser called %d times, want 3", len(d.calls))
+	}
+}
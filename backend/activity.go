@@ -0,0 +1,58 @@
+package main
+
+import "time"
+
+// ActivityEvent はユーザーのアクティビティ (アップロード・コメント・いいね) の1件
+// comment_id と content はコメントの場合のみ返す
+type ActivityEvent struct {
+	Type       string    `json:"type"` // upload / comment / like
+	CreatedAt  time.Time `json:"created_at"`
+	TrackID    int       `json:"track_id"`
+	TrackTitle string    `json:"track_title"`
+	CommentID  *int      `json:"comment_id,omitempty"`
+	Content    *string   `json:"content,omitempty"`
+}
+
+// userActivitySQL はユーザーのアップロード・コメント・いいねを1つの一覧にまとめるサブクエリ
+// 引数は uid, uid, includeHidden (非表示のコメントを含めるか), uid, includeLikes (いいねを含めるか)
+// event_id は同じ時刻のイベントの順番を確定させるための各テーブルのID
+const userActivitySQL = `
+	SELECT 'upload' AS type, t.created_at, t.id AS track_id, t.title AS track_title, NULL AS comment_id, NULL AS content, t.id AS event_id
+	FROM tracks t WHERE t.uploader_uid = ?
+	UNION ALL
+	SELECT 'comment', c.created_at, c.track_id, t.title, c.id, c.content, c.id
+	FROM comments c JOIN tracks t ON t.id = c.track_id WHERE c.user_uid = ? AND (NOT c.hidden OR ?)
+	UNION ALL
+	SELECT 'like', l.created_at, l.track_id, t.title, NULL, NULL, l.id
+	FROM likes l JOIN tracks t ON t.id = l.track_id WHERE l.user_uid = ? AND ?`
+
+// queryUserActivity はユーザーのアクティビティを新しい順に返す (総件数も返す)
+// includePrivate が false の場合は、非表示のコメントといいねを含めない (いいねは本人にしか公開していないため)
+func queryUserActivity(uid string, includePrivate bool, limit, offset int) ([]ActivityEvent, int, error) {
+	args := []interface{}{uid, uid, includePrivate, uid, includePrivate}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ("+userActivitySQL+")", args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT type, created_at, track_id, track_title, comment_id, content
+		FROM (`+userActivitySQL+`)
+		ORDER BY created_at DESC, event_id DESC, type
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := make([]ActivityEvent, 0)
+	for rows.Next() {
+		var ev ActivityEvent
+		if err := rows.Scan(&ev.Type, &ev.CreatedAt, &ev.TrackID, &ev.TrackTitle, &ev.CommentID, &ev.Content); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, ev)
+	}
+	return events, total, rows.Err()
+}
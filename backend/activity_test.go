@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUserActivityMixesEventTypesNewestFirst(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	s.addUser("bob")
+	mine := insertTrack(t, "alice", "Mine")
+	theirs := insertTrack(t, "bob", "Theirs")
+	comment := insertComment(t, theirs, "alice", "great")
+	hidden := insertComment(t, theirs, "alice", "hidden")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id, created_at) VALUES ('alice', ?, '2024-01-04 00:00:00')", theirs)
+	mustExec(t, "UPDATE tracks SET created_at = '2024-01-01 00:00:00' WHERE id = ?", mine)
+	mustExec(t, "UPDATE comments SET created_at = '2024-01-02 00:00:00' WHERE id = ?", comment)
+	mustExec(t, "UPDATE comments SET created_at = '2024-01-03 00:00:00', hidden = TRUE WHERE id = ?", hidden)
+
+	// 本人にはいいねと非表示のコメントも含めて新しい順に返す
+	var activity listEnvelope[ActivityEvent]
+	s.callJSON(t, http.MethodGet, "/api/user/alice/activity", alice, nil, http.StatusOK, &activity)
+	want := []string{"like", "comment", "comment", "upload"}
+	if len(activity.Data) != len(want) || activity.Meta.Total != len(want) {
+		t.Fatalf("got %d events (total %d), want %d", len(activity.Data), activity.Meta.Total, len(want))
+	}
+	for i, ev := range activity.Data {
+		if ev.Type != want[i] {
+			t.Errorf("event %d: type %q, want %q", i, ev.Type, want[i])
+		}
+		if i > 0 && ev.CreatedAt.After(activity.Data[i-1].CreatedAt) {
+			t.Errorf("event %d is newer than event %d", i, i-1)
+		}
+	}
+	if ev := activity.Data[2]; ev.CommentID == nil || *ev.CommentID != comment || *ev.Content != "great" || ev.TrackTitle != "Theirs" {
+		t.Errorf("comment event = %+v", ev)
+	}
+
+	// 他のユーザーには公開しているイベントだけを返す
+	s.callJSON(t, http.MethodGet, "/api/user/alice/activity", "", nil, http.StatusOK, &activity)
+	if len(activity.Data) != 2 || activity.Data[0].Type != "comment" || activity.Data[1].Type != "upload" {
+		t.Errorf("public activity = %+v", activity.Data)
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/user/alice/activity?limit=1", alice, nil, http.StatusOK, &activity)
+	if len(activity.Data) != 1 || !activity.Meta.HasMore {
+		t.Errorf("paged activity: %d events, meta %+v", len(activity.Data), activity.Meta)
+	}
+	s.callJSON(t, http.MethodGet, "/api/user/nobody/activity", "", nil, http.StatusNotFound, nil)
+}
+
+func TestRecentlyActiveUsers(t *testing.T) {
+	s := newTestServer(t)
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		s.addUser(uid)
+	}
+	backdate := func(table string, id int, modifier string) {
+		mustExec(t, "UPDATE "+table+" SET created_at = datetime('now', ?) WHERE id = ?", modifier, id)
+	}
+	song := insertTrack(t, "alice", "Song")
+	backdate("tracks", song, "-3 days")
+	backdate("comments", insertComment(t, song, "bob", "nice"), "-1 hours")
+	backdate("comments", insertComment(t, song, "alice", "thanks"), "-2 hours")
+	// 7日より前の活動と、非表示のコメントは数えない
+	backdate("tracks", insertTrack(t, "dave", "Old"), "-8 days")
+	hidden := insertComment(t, song, "erin", "spam")
+	mustExec(t, "UPDATE comments SET hidden = TRUE WHERE id = ?", hidden)
+	backdate("comments", insertComment(t, song, "carol", "hello"), "-2 days")
+
+	var res struct {
+		Users []ActiveUser `json:"users"`
+	}
+	s.callJSON(t, http.MethodGet, "/api/users/active", "", nil, http.StatusOK, &res)
+	var got []string
+	for _, u := range res.Users {
+		got = append(got, u.UID+":"+u.DisplayName)
+	}
+	if want := "[bob:User bob alice:User alice carol:User carol]"; fmt.Sprint(got) != want {
+		t.Errorf("active users = %v, want %s", got, want)
+	}
+	if len(res.Users) > 0 {
+		if d := time.Since(res.Users[0].LastActiveAt); d < 59*time.Minute || d > 61*time.Minute {
+			t.Errorf("bob's last_active_at = %s, want about an hour ago", res.Users[0].LastActiveAt)
+		}
+	}
+
+	// 1分間はキャッシュを返す
+	insertTrack(t, "frank", "New")
+	s.callJSON(t, http.MethodGet, "/api/users/active", "", nil, http.StatusOK, &res)
+	if len(res.Users) != 3 {
+		t.Errorf("%d active users within the cache TTL, want the cached 3", len(res.Users))
+	}
+
+	// 最大20人まで
+	for i := 0; i < 25; i++ {
+		insertTrack(t, fmt.Sprintf("user%02d", i), "Track")
+	}
+	activeUsersCache.Lock()
+	activeUsersCache.data = nil
+	activeUsersCache.Unlock()
+	s.callJSON(t, http.MethodGet, "/api/users/active", "", nil, http.StatusOK, &res)
+	if len(res.Users) != 20 {
+		t.Errorf("%d active users, want 20", len(res.Users))
+	}
+
+	// Auth に問い合わせできなかった結果 (表示名が空) はキャッシュしない
+	resetServerGlobals()
+	setUnavailable := func(v bool) {
+		s.auth.mu.Lock()
+		s.auth.unavailable = v
+		s.auth.mu.Unlock()
+	}
+	s.addUser("frank")
+	frankName := func() string {
+		for _, u := range res.Users {
+			if u.UID == "frank" {
+				return u.DisplayName
+			}
+		}
+		t.Fatalf("frank is not in the active users: %+v", res.Users)
+		return ""
+	}
+	setUnavailable(true)
+	s.callJSON(t, http.MethodGet, "/api/users/active", "", nil, http.StatusOK, &res)
+	if name := frankName(); name != "" {
+		t.Errorf("frank's name while Auth is down = %q, want empty", name)
+	}
+	setUnavailable(false)
+	s.callJSON(t, http.MethodGet, "/api/users/active", "", nil, http.StatusOK, &res)
+	if name := frankName(); name != "User frank" {
+		t.Errorf("frank's name after Auth recovered = %q, want the name from Auth", name)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/labstack/echo/v4"
+)
+
+// APIキーで許可できるスコープ
+const (
+	apiKeyScopeRead   = "read"   // GETリクエストのみ
+	apiKeyScopeUpload = "upload" // POST /api/upload, POST /api/upload/batch
+)
+
+// APIKey構造体: 一覧表示用 (キー本体やハッシュは含めない)
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// generateAPIKey は新しいAPIキーを生成する (平文のキーは発行時に一度だけ返す)
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "sl_" + hex.EncodeToString(b), nil
+}
+
+// hashAPIKey はDBに保存するためのキーのハッシュを返す (平文のキーは保存しない)
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeAPIKeyScopes はスコープの重複を除き、未知のスコープがあれば false を返す
+func normalizeAPIKeyScopes(scopes []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, s := range scopes {
+		s = strings.TrimSpace(s)
+		if s != apiKeyScopeRead && s != apiKeyScopeUpload {
+			return nil, false
+		}
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result, len(result) > 0
+}
+
+// apiKeyAllows はAPIキーのスコープでこのリクエストが許可されるかを判定する
+// APIキーの管理など、スコープに含まれない操作は Firebase 認証でのみ行える
+func apiKeyAllows(c echo.Context, scopes []string) bool {
+	if strings.HasPrefix(c.Path(), "/api/account/api-keys") {
+		return false
+	}
+	method := c.Request().Method
+	for _, s := range scopes {
+		switch s {
+		case apiKeyScopeRead:
+			if method == http.MethodGet || method == http.MethodHead {
+				return true
+			}
+		case apiKeyScopeUpload:
+			if method == http.MethodPost && (c.Path() == "/api/upload" || c.Path() == "/api/upload/batch") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apiKeyOwnerCacheTTL はAPIキーの持ち主の情報 (表示名・メール認証状態) をキャッシュする期間
+// キーの失効はリクエストごとにDBで確認するため、キャッシュするのは Auth から取得する情報だけ
+const apiKeyOwnerCacheTTL = 1 * time.Minute
+
+// errAPIKeyOwnerDisabled はAPIキーの持ち主のアカウントが無効化されている場合のエラー
+var errAPIKeyOwnerDisabled = errors.New("API key owner is disabled")
+
+type cachedAPIKeyOwner struct {
+	claims    map[string]interface{}
+	fetchedAt time.Time
+}
+
+// apiKeyOwnerCache はAPIキーでのリクエストのたびに Firebase Auth を叩かないためのキャッシュ (UIDごと)
+var apiKeyOwnerCache = struct {
+	sync.Mutex
+	entries map[string]cachedAPIKeyOwner
+}{entries: make(map[string]cachedAPIKeyOwner)}
+
+// apiKeyOwnerClaims はAPIキーの持ち主のトークンに載せるクレームを返す
+// キャッシュが古い場合は Auth から取得し直す (取得に失敗した結果はキャッシュしない)
+func apiKeyOwnerClaims(ctx context.Context, authClient *auth.Client, uid string) (map[string]interface{}, error) {
+	apiKeyOwnerCache.Lock()
+	entry, ok := apiKeyOwnerCache.entries[uid]
+	apiKeyOwnerCache.Unlock()
+	if !ok || time.Since(entry.fetchedAt) >= apiKeyOwnerCacheTTL {
+		userRecord, err := authClient.GetUser(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		if userRecord.Disabled {
+			return nil, errAPIKeyOwnerDisabled
+		}
+		entry = cachedAPIKeyOwner{
+			claims: map[string]interface{}{
+				"name":           userRecord.DisplayName,
+				"email":          userRecord.Email,
+				"email_verified": userRecord.EmailVerified,
+			},
+			fetchedAt: time.Now(),
+		}
+		apiKeyOwnerCache.Lock()
+		// 期限切れのエントリーはここでまとめて削除する (キーを削除したユーザーの分が残り続けないように)
+		for key, e := range apiKeyOwnerCache.entries {
+			if time.Since(e.fetchedAt) >= apiKeyOwnerCacheTTL {
+				delete(apiKeyOwnerCache.entries, key)
+			}
+		}
+		apiKeyOwnerCache.entries[uid] = entry
+		apiKeyOwnerCache.Unlock()
+	}
+
+	// ハンドラーがトークンのクレームを書き換えても共有のキャッシュに影響しないよう、コピーを返す
+	claims := make(map[string]interface{}, len(entry.claims))
+	for k, v := range entry.claims {
+		claims[k] = v
+	}
+	return claims, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	readKey := s.createAPIKey(t, token, "read")
+	uploadKey := s.createAPIKey(t, token, "upload")
+
+	withKey := func(method, path, key string, body []byte, contentType string) int {
+		req := s.newRequest(t, method, path, "", body)
+		req.Header.Set("X-API-Key", key)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, _ := s.do(t, req)
+		return resp.StatusCode
+	}
+
+	if status := withKey(http.MethodGet, "/api/me", readKey, nil, ""); status != http.StatusOK {
+		t.Errorf("read key GET /api/me: status %d", status)
+	}
+	if status := withKey(http.MethodGet, "/api/me", uploadKey, nil, ""); status != http.StatusForbidden {
+		t.Errorf("upload key GET /api/me: status %d, want 403", status)
+	}
+	if status := withKey(http.MethodGet, "/api/account/api-keys", readKey, nil, ""); status != http.StatusForbidden {
+		t.Errorf("read key listing API keys: status %d, want 403", status)
+	}
+
+	audio := testMP3(time.Second)
+	body, contentType := multipartForm(t, map[string]string{"title": "Single"}, formFile{"file", "a.mp3", audio})
+	if status := withKey(http.MethodPost, "/api/upload", readKey, body, contentType); status != http.StatusForbidden {
+		t.Errorf("read key upload: status %d, want 403", status)
+	}
+	if status := withKey(http.MethodPost, "/api/upload", uploadKey, body, contentType); status != http.StatusOK {
+		t.Errorf("upload key upload: status %d", status)
+	}
+
+	metadata, _ := json.Marshal([]trackMetadata{{Title: "One"}, {Title: "Two"}})
+	body, contentType = multipartForm(t, map[string]string{"metadata": string(metadata)},
+		formFile{"files", "1.mp3", audio}, formFile{"files", "2.mp3", audio})
+	if status := withKey(http.MethodPost, "/api/upload/batch", readKey, body, contentType); status != http.StatusForbidden {
+		t.Errorf("read key batch upload: status %d, want 403", status)
+	}
+	if status := withKey(http.MethodPost, "/api/upload/batch", uploadKey, body, contentType); status != http.StatusOK {
+		t.Errorf("upload key batch upload: status %d", status)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE uploader_uid = 'alice'"); n != 3 {
+		t.Errorf("got %d tracks, want 3", n)
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	token, bob := s.addUser("alice"), s.addUser("bob")
+
+	var created struct {
+		ID     int      `json:"id"`
+		Key    string   `json:"key"`
+		Prefix string   `json:"prefix"`
+		Scopes []string `json:"scopes"`
+	}
+	s.callJSON(t, http.MethodPost, "/api/account/api-keys", token, map[string]interface{}{"name": "CI", "scopes": []string{"read", "read"}}, http.StatusCreated, &created)
+	if !strings.HasPrefix(created.Key, created.Prefix) || len(created.Scopes) != 1 {
+		t.Fatalf("created key = %+v", created)
+	}
+	// 平文のキーは保存しない
+	if n := queryInt(t, "SELECT COUNT(*) FROM api_keys WHERE key_hash = ? AND key_hash != ?", hashAPIKey(created.Key), created.Key); n != 1 {
+		t.Error("API key is not stored as a hash")
+	}
+
+	// 一覧にはキー本体を含めない
+	resp, body := s.call(t, http.MethodGet, "/api/account/api-keys", token, nil)
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(body), created.Key) {
+		t.Errorf("GET /api/account/api-keys: status %d, body %s", resp.StatusCode, body)
+	}
+	var keys []APIKey
+	json.Unmarshal(body, &keys)
+	if len(keys) != 1 || keys[0].ID != created.ID || keys[0].Name != "CI" {
+		t.Errorf("listed keys = %+v", keys)
+	}
+
+	withKey := func(key string) int {
+		req := s.newRequest(t, http.MethodGet, "/api/me", "", nil)
+		req.Header.Set("X-API-Key", key)
+		resp, _ := s.do(t, req)
+		return resp.StatusCode
+	}
+	if status := withKey(created.Key); status != http.StatusOK {
+		t.Errorf("request with the key: status %d", status)
+	}
+	// 無効な ID トークンと同じく 403
+	if status := withKey(created.Key + "x"); status != http.StatusForbidden {
+		t.Errorf("request with an unknown key: status %d, want 403", status)
+	}
+
+	for _, req := range []map[string]interface{}{
+		{"name": "", "scopes": []string{"read"}},
+		{"name": "No scopes", "scopes": []string{}},
+		{"name": "Admin", "scopes": []string{"admin"}},
+	} {
+		s.callJSON(t, http.MethodPost, "/api/account/api-keys", token, req, http.StatusBadRequest, nil)
+	}
+
+	// 他人のキーは失効できない
+	path := fmt.Sprintf("/api/account/api-keys/%d", created.ID)
+	s.callJSON(t, http.MethodDelete, path, bob, nil, http.StatusNotFound, nil)
+	s.callJSON(t, http.MethodDelete, path, token, nil, http.StatusOK, nil)
+	if status := withKey(created.Key); status != http.StatusForbidden {
+		t.Errorf("request with a revoked key: status %d, want 403", status)
+	}
+}
+
+func TestAPIKeyOwnerIsCached(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	key := s.createAPIKey(t, token, "read")
+
+	withKey := func() int {
+		req := s.newRequest(t, http.MethodGet, "/api/me", "", nil)
+		req.Header.Set("X-API-Key", key)
+		resp, _ := s.do(t, req)
+		return resp.StatusCode
+	}
+	if status := withKey(); status != http.StatusOK {
+		t.Fatalf("request with the key: status %d", status)
+	}
+	// 持ち主の情報はキャッシュするため、続くリクエストでは Auth に問い合わせない
+	s.auth.mu.Lock()
+	s.auth.unavailable = true
+	s.auth.mu.Unlock()
+	if status := withKey(); status != http.StatusOK {
+		t.Errorf("request with a cached key owner while Auth is down: status %d, want 200", status)
+	}
+
+	// キャッシュが切れた後は Auth から取得し直す
+	apiKeyOwnerCache.Lock()
+	entry := apiKeyOwnerCache.entries["alice"]
+	entry.fetchedAt = time.Now().Add(-apiKeyOwnerCacheTTL)
+	apiKeyOwnerCache.entries["alice"] = entry
+	apiKeyOwnerCache.Unlock()
+	if status := withKey(); status != http.StatusServiceUnavailable {
+		t.Errorf("request with an expired key owner while Auth is down: status %d, want 503", status)
+	}
+}
+
+func TestCORSAllowsAPIKeyHeader(t *testing.T) {
+	s := newTestServer(t)
+	req := s.newRequest(t, http.MethodOptions, "/api/me", "", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "X-API-Key")
+	resp, _ := s.do(t, req)
+	if allowed := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(strings.ToLower(allowed), "x-api-key") {
+		t.Errorf("Access-Control-Allow-Headers = %q, want X-API-Key", allowed)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestArtistNamesAreCanonicalized(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+
+	upload := func(token, title, artist string, d time.Duration) {
+		t.Helper()
+		body, contentType := multipartForm(t, map[string]string{"title": title, "artist": artist}, formFile{"file", "a.mp3", testMP3(d)})
+		req := s.newRequest(t, http.MethodPost, "/api/upload", token, body)
+		req.Header.Set("Content-Type", contentType)
+		if resp, data := s.do(t, req); resp.StatusCode != http.StatusOK {
+			t.Fatalf("upload %s: status %d (%s)", title, resp.StatusCode, data)
+		}
+	}
+	upload(alice, "First", "  DJ   Cat ", time.Second)
+	upload(bob, "Second", "dj cat", 2*time.Second)
+	upload(bob, "Third", "Catherine", 3*time.Second)
+	upload(alice, "Fourth", "The Cats", 4*time.Second)
+
+	// 表記ゆれは最初に登録された表記にそろえ、同じアーティストとして扱う
+	if n := queryInt(t, "SELECT COUNT(DISTINCT artist_id) FROM tracks WHERE title IN ('First', 'Second')"); n != 1 {
+		t.Errorf("variants of the same artist have %d artist ids, want 1", n)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE artist = 'DJ Cat'"); n != 2 {
+		t.Errorf("%d tracks use the canonical name, want 2", n)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM artists"); n != 3 {
+		t.Errorf("%d artists, want 3", n)
+	}
+
+	suggest := func(q string) string {
+		t.Helper()
+		var names []string
+		s.callJSON(t, http.MethodGet, "/api/artists/suggest?q="+q, "", nil, http.StatusOK, &names)
+		return fmt.Sprint(names)
+	}
+	for q, want := range map[string]string{
+		// 前方一致を優先する
+		"cat":       "[Catherine DJ Cat The Cats]",
+		"DJ%20%20C": "[DJ Cat]",
+		"dj+cat":    "[DJ Cat]",
+		"%25":       "[]", // LIKE のワイルドカードは文字として扱う
+		"":          "[]",
+	} {
+		if got := suggest(q); got != want {
+			t.Errorf("suggest %q = %s, want %s", q, got, want)
+		}
+	}
+}
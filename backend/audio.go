@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// minAudioDuration より短い音声は再生できるファイルとして扱わない
+const minAudioDuration = 1 * time.Second
+
+// errCorruptAudio は有効なMP3フレームが見つからない、または短すぎる場合のエラー
+var errCorruptAudio = errors.New("file appears to be corrupt or empty")
+
+// MPEGバージョン・レイヤーごとのビットレート表 (kbps)
+var (
+	mp3BitratesV1L1 = [16]int{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0}
+	mp3BitratesV1L2 = [16]int{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0}
+	mp3BitratesV1L3 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3BitratesV2L1 = [16]int{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0}
+	mp3BitratesV2L2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+)
+
+// mp3Frame はフレームヘッダーから読み取った情報
+type mp3Frame struct {
+	size       int // ヘッダーを含むフレーム全体のバイト数
+	samples    int // 1フレームあたりのサンプル数
+	sampleRate int
+}
+
+// parseMP3FrameHeader は4バイトのフレームヘッダーを解析する (不正なヘッダーなら ok=false)
+func parseMP3FrameHeader(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := (h[1] >> 3) & 0x03 // 0: MPEG2.5, 1: 予約, 2: MPEG2, 3: MPEG1
+	layer := (h[1] >> 1) & 0x03   // 1: Layer III, 2: Layer II, 3: Layer I
+	bitrateIndex := h[2] >> 4
+	sampleRateIndex := (h[2] >> 2) & 0x03
+	padding := int((h[2] >> 1) & 0x01)
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	sampleRate := [3]int{44100, 48000, 32000}[sampleRateIndex]
+	switch version {
+	case 2:
+		sampleRate /= 2
+	case 0:
+		sampleRate /= 4
+	}
+
+	var bitrate, samples int
+	switch {
+	case version == 3 && layer == 3:
+		bitrate, samples = mp3BitratesV1L1[bitrateIndex], 384
+	case version == 3 && layer == 2:
+		bitrate, samples = mp3BitratesV1L2[bitrateIndex], 1152
+	case version == 3:
+		bitrate, samples = mp3BitratesV1L3[bitrateIndex], 1152
+	case layer == 3:
+		bitrate, samples = mp3BitratesV2L1[bitrateIndex], 384
+	case layer == 2:
+		bitrate, samples = mp3BitratesV2L2[bitrateIndex], 1152
+	default:
+		bitrate, samples = mp3BitratesV2L2[bitrateIndex], 576
+	}
+
+	var size int
+	if layer == 3 {
+		// Layer I は4バイト単位のスロット
+		size = (12*bitrate*1000/sampleRate + padding) * 4
+	} else {
+		size = samples/8*bitrate*1000/sampleRate + padding
+	}
+	return mp3Frame{size: size, samples: samples, sampleRate: sampleRate}, true
+}
+
+// skipID3v2 は先頭に ID3v2 タグがあればその直後のオフセットを返す
+func skipID3v2(data []byte) int {
+	offset := id3v2TagEnd(data)
+	if offset > len(data) {
+		return len(data)
+	}
+	return offset
+}
+
+// id3v2TagEnd は先頭の ID3v2 タグの直後のオフセットを返す (タグがなければ 0)
+// ヘッダーの10バイトだけで求められるため、data がタグの途中で終わっていてもよい
+func id3v2TagEnd(data []byte) int {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0
+	}
+	// サイズは各バイト下位7ビットの syncsafe integer
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	offset := 10 + size
+	if data[5]&0x10 != 0 { // フッターあり
+		offset += 10
+	}
+	return offset
+}
+
+// mp3SyncSearchWindow は最初のフレームを探す範囲 (ID3v2 タグの直後から)
+const mp3SyncSearchWindow = 64 << 10
+
+// firstMP3Frame は最初のフレームの位置を返す (見つからなければ -1)
+// 偶然 0xFF が並んだだけの箇所を誤検出しないよう、3フレーム続けて正しく繋がる位置を先頭とみなす
+func firstMP3Frame(data []byte) int {
+	start := skipID3v2(data)
+	for i := start; i+4 <= len(data) && i < start+mp3SyncSearchWindow; i++ {
+		if chainedMP3Frames(data, i, 3) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mp3ChainPeekBytes は同期位置の確認で3フレーム分を先読みするための余裕 (最大のフレームでも約2.9KB)
+const mp3ChainPeekBytes = 16 << 10
+
+// inspectMP3 はMP3のフレームを先頭から辿って再生時間を求める
+// 連続した有効なフレームが見つからない場合や、再生時間が短すぎる場合は errCorruptAudio を返す
+func inspectMP3(data []byte) (time.Duration, error) {
+	return inspectMP3Reader(bytes.NewReader(data))
+}
+
+// inspectMP3Reader は inspectMP3 と同じ検証を r から順に読みながら行う
+// ファイル全体をメモリに読み込まないため、アップロードされたファイルの検証に使う
+func inspectMP3Reader(r io.Reader) (time.Duration, error) {
+	br := bufio.NewReaderSize(r, mp3SyncSearchWindow+mp3ChainPeekBytes)
+	header, err := br.Peek(10)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if _, err := br.Discard(id3v2TagEnd(header)); err != nil {
+		if err == io.EOF {
+			return 0, errCorruptAudio
+		}
+		return 0, err
+	}
+
+	window, err := br.Peek(mp3SyncSearchWindow + mp3ChainPeekBytes)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	first := -1
+	for i := 0; i+4 <= len(window) && i < mp3SyncSearchWindow; i++ {
+		if chainedMP3Frames(window, i, 3) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return 0, errCorruptAudio
+	}
+	br.Discard(first)
+
+	var duration time.Duration
+	for {
+		h, err := br.Peek(4)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		frame, ok := parseMP3FrameHeader(h)
+		if !ok {
+			// 末尾の ID3v1 タグや壊れた部分に達したらそこで打ち切る
+			break
+		}
+		if _, err := br.Peek(frame.size); err != nil {
+			if err != io.EOF {
+				return 0, err
+			}
+			break
+		}
+		duration += time.Duration(frame.samples) * time.Second / time.Duration(frame.sampleRate)
+		br.Discard(frame.size)
+	}
+
+	if duration < minAudioDuration {
+		return duration, errCorruptAudio
+	}
+	return duration, nil
+}
+
+// chainedMP3Frames は pos から n 個のフレームが途切れずに続いているかを確認する
+func chainedMP3Frames(data []byte, pos, n int) bool {
+	for i := 0; i < n; i++ {
+		if pos+4 > len(data) {
+			return false
+		}
+		frame, ok := parseMP3FrameHeader(data[pos : pos+4])
+		if !ok || pos+frame.size > len(data) {
+			return false
+		}
+		pos += frame.size
+	}
+	return true
+}
+
+// mp3MaxBytesPerSecond は MP3 の最大ビットレート (Layer I の 448kbps) での1秒あたりのバイト数
+const mp3MaxBytesPerSecond = 448 * 1000 / 8
+
+// mp3ClipReadLimit は先頭から d 分を clipMP3 で切り出すために読み込めば十分なバイト数を返す
+// header はファイルの先頭 (ID3v2 タグのサイズを求めるため、10バイト以上)
+func mp3ClipReadLimit(header []byte, d time.Duration) int64 {
+	const maxFrameBytes = 2048
+	return int64(id3v2TagEnd(header)) + mp3SyncSearchWindow + int64(d.Seconds()*mp3MaxBytesPerSecond) + maxFrameBytes
+}
+
+// clipMP3 は先頭から maxDuration に収まるフレームだけを切り出す (試聴用、ID3v2 タグは含めない)
+// フレームの境界で切るため、切り出したデータもそのまま再生できる
+func clipMP3(data []byte, maxDuration time.Duration) ([]byte, error) {
+	first := firstMP3Frame(data)
+	if first < 0 {
+		return nil, errCorruptAudio
+	}
+
+	var duration time.Duration
+	pos := first
+	for pos+4 <= len(data) {
+		frame, ok := parseMP3FrameHeader(data[pos : pos+4])
+		if !ok || pos+frame.size > len(data) {
+			break
+		}
+		frameDuration := time.Duration(frame.samples) * time.Second / time.Duration(frame.sampleRate)
+		if duration+frameDuration > maxDuration {
+			break
+		}
+		duration += frameDuration
+		pos += frame.size
+	}
+	if pos == first {
+		return nil, errCorruptAudio
+	}
+	return data[first:pos], nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestClipMP3(t *testing.T) {
+	data := testMP3(5 * time.Second)
+	clip, err := clipMP3(data, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := inspectMP3(clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d > 2*time.Second || d < 2*time.Second-50*time.Millisecond {
+		t.Errorf("clip duration = %v, want about 2s", d)
+	}
+
+	// 指定より短い音声はそのまま返す
+	short := testMP3(time.Second)
+	clip, err = clipMP3(short, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clip) != len(short) {
+		t.Errorf("short audio clipped to %d bytes, want %d", len(clip), len(short))
+	}
+
+	if _, err := clipMP3([]byte("not an mp3 file"), time.Second); err == nil {
+		t.Error("clipMP3 accepted non-MP3 data")
+	}
+}
+
+func TestTrackPreviewIsShorterThanOriginal(t *testing.T) {
+	s := newTestServer(t, "PREVIEW_SECONDS=2")
+	audio := testMP3(5 * time.Second)
+	id := s.insertTrackWithAudio(t, "alice", "Song", audio)
+
+	resp, clip := s.call(t, http.MethodGet, fmt.Sprintf("/api/track/%d/preview", id), "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/mpeg" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if len(clip) >= len(audio) {
+		t.Fatalf("preview is %d bytes, original is %d bytes", len(clip), len(audio))
+	}
+	d, err := inspectMP3(clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d > 2*time.Second {
+		t.Errorf("preview duration = %v, want at most 2s", d)
+	}
+
+	// Range リクエストにも応答する
+	req := s.newRequest(t, http.MethodGet, fmt.Sprintf("/api/track/%d/preview", id), "", nil)
+	req.Header.Set("Range", "bytes=0-99")
+	if resp, body := s.do(t, req); resp.StatusCode != http.StatusPartialContent || len(body) != 100 {
+		t.Errorf("range request: status %d, %d bytes", resp.StatusCode, len(body))
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/track/9999/preview", "", nil, http.StatusNotFound, nil)
+}
+
+func TestInspectMP3(t *testing.T) {
+	audio := testMP3(3 * time.Second)
+	// 10バイトのヘッダー + 20バイトの本文の ID3v2 タグ
+	id3 := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 20}, make([]byte, 20)...)
+
+	for name, data := range map[string][]byte{
+		"plain":             audio,
+		"with ID3v2 tag":    append(append([]byte{}, id3...), audio...),
+		"with leading junk": append([]byte("junk"), audio...),
+		"with ID3v1 tag":    append(append([]byte{}, audio...), append([]byte("TAG"), make([]byte, 125)...)...),
+	} {
+		d, err := inspectMP3(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if d < 3*time.Second || d > 3*time.Second+50*time.Millisecond {
+			t.Errorf("%s: duration = %v, want about 3s", name, d)
+		}
+	}
+
+	// バッファより大きい ID3v2 タグ (カバー画像など) の後ろの音声や、少しずつしか読めない reader でも辿れる
+	bigID3 := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0x0C, 0, 0}, make([]byte, 0x0C<<14)...)
+	for name, r := range map[string]io.Reader{
+		"large ID3v2 tag": bytes.NewReader(append(bigID3, audio...)),
+		"one byte reads":  iotest.OneByteReader(bytes.NewReader(audio)),
+	} {
+		if d, err := inspectMP3Reader(r); err != nil || d < 3*time.Second {
+			t.Errorf("%s: duration = %v, err = %v", name, d, err)
+		}
+	}
+	if _, err := inspectMP3Reader(iotest.ErrReader(io.ErrUnexpectedEOF)); err != io.ErrUnexpectedEOF {
+		t.Errorf("read error: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":         nil,
+		"zeros":         make([]byte, 64<<10),
+		"text":          []byte("this is not an mp3 file at all"),
+		"ID3 tag only":  id3,
+		"too short":     testMP3(500 * time.Millisecond),
+		"single frames": append(testMP3(time.Millisecond), make([]byte, 1000)...),
+	} {
+		if _, err := inspectMP3(data); err != errCorruptAudio {
+			t.Errorf("%s: err = %v, want errCorruptAudio", name, err)
+		}
+	}
+}
+
+func TestCorruptAudioUploadIsRejected(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	for name, data := range map[string][]byte{
+		"zeros":     make([]byte, 4096),
+		"too short": testMP3(500 * time.Millisecond),
+	} {
+		status, body := s.uploadTrack(t, token, name, data)
+		if status != http.StatusBadRequest || !strings.Contains(string(body), "corrupt or empty") {
+			t.Errorf("%s: status %d (%s), want 400 corrupt or empty", name, status, body)
+		}
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks"); n != 0 {
+		t.Errorf("%d corrupt tracks were stored", n)
+	}
+}
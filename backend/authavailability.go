@@ -0,0 +1,20 @@
+package main
+
+import (
+	"firebase.google.com/go/v4/auth"
+	"firebase.google.com/go/v4/errorutils"
+)
+
+// authUnavailableMessage は Firebase Auth に接続できず、認証が必要なリクエストを処理できないときのメッセージ
+const authUnavailableMessage = "Auth service unavailable. Please try again later."
+
+// isAuthUnavailable は Firebase Auth のエラーがトークンやユーザーの問題ではなく、
+// Firebase 側の障害やネットワークの問題 (公開鍵の取得失敗・タイムアウト・接続できない等) によるものかを返す
+// これに当てはまる場合は、無効なトークンとして 403 を返すのではなく 503 を返す
+func isAuthUnavailable(err error) bool {
+	return auth.IsCertificateFetchFailed(err) ||
+		errorutils.IsUnavailable(err) ||
+		errorutils.IsDeadlineExceeded(err) ||
+		errorutils.IsInternal(err) ||
+		errorutils.IsUnknown(err)
+}
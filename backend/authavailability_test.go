@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// setUnavailable は Firebase Auth の障害の有無を切り替える
+func (f *fakeAuth) setUnavailable(unavailable bool) {
+	f.mu.Lock()
+	f.unavailable = unavailable
+	f.mu.Unlock()
+}
+
+func TestAuthServiceUnavailable(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	key := s.createAPIKey(t, token, "read")
+	id := insertTrack(t, "alice", "Song")
+	withKey := func(key string) (int, string) {
+		t.Helper()
+		req := s.newRequest(t, http.MethodGet, "/api/me", "", nil)
+		req.Header.Set("X-API-Key", key)
+		resp, body := s.do(t, req)
+		return resp.StatusCode, string(body)
+	}
+
+	s.auth.setUnavailable(true)
+	// 認証が必要なAPIは、無効な認証情報 (403) と区別できる 503 を返す
+	if status, body := withKey(key); status != http.StatusServiceUnavailable {
+		t.Errorf("API key while auth is down: status %d (%s), want 503", status, body)
+	}
+	if status, _ := withKey("sl_invalid"); status != http.StatusForbidden {
+		t.Errorf("invalid API key while auth is down: status %d, want 403", status)
+	}
+	s.callJSON(t, http.MethodGet, "/api/me", "not-a-token", nil, http.StatusForbidden, nil)
+
+	// 公開APIは閲覧を続けられる
+	var tracks []Track
+	s.callJSON(t, http.MethodGet, "/api/tracks", token, nil, http.StatusOK, &tracks)
+	if len(tracks) != 1 {
+		t.Errorf("GET /api/tracks while auth is down returned %d tracks", len(tracks))
+	}
+	s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d", id), token, nil, http.StatusOK, nil)
+
+	s.auth.setUnavailable(false)
+	if status, body := withKey(key); status != http.StatusOK {
+		t.Errorf("API key after recovery: status %d (%s)", status, body)
+	}
+}
+
+func TestIsAuthUnavailable(t *testing.T) {
+	if isAuthUnavailable(errors.New("token has expired")) {
+		t.Error("plain error classified as unavailable")
+	}
+	if isAuthUnavailable(nil) {
+		t.Error("nil error classified as unavailable")
+	}
+}
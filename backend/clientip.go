@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// parseTrustedProxies は TRUSTED_PROXIES (カンマ区切りの CIDR または IP アドレス) を読み込む
+func parseTrustedProxies(raw string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ipNet, err := parseIPRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// parseIPRange は CIDR または IP アドレス (1つのアドレスだけの範囲として扱う) を読み込む
+func parseIPRange(entry string) (*net.IPNet, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		if ip.To4() != nil {
+			entry += "/32"
+		} else {
+			entry += "/128"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", entry)
+	}
+	return ipNet, nil
+}
+
+// newClientIPExtractor は c.RealIP() でクライアントの IP を求める方法を返す
+// 信頼するプロキシが指定されていない場合は接続元のアドレスをそのまま使い、ヘッダーは信用しない
+// 指定されている場合は、そのプロキシから届いた X-Forwarded-For (なければ X-Real-IP) を使う
+func newClientIPExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// デフォルトで信頼されるループバック・プライベートアドレスも、明示的に指定された範囲以外は信頼しない
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range trusted {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	fromXFF := echo.ExtractIPFromXFFHeader(options...)
+	fromRealIP := echo.ExtractIPFromRealIPHeader(options...)
+	return func(req *http.Request) string {
+		if req.Header.Get(echo.HeaderXForwardedFor) != "" {
+			return fromXFF(req)
+		}
+		return fromRealIP(req)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	ranges, err := parseTrustedProxies(" 10.0.0.0/8, 203.0.113.7 ,,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "203.0.113.7/32", "2001:db8::1/128"}
+	if len(ranges) != len(want) {
+		t.Fatalf("got %v, want %v", ranges, want)
+	}
+	for i, r := range ranges {
+		if r.String() != want[i] {
+			t.Errorf("range %d = %s, want %s", i, r, want[i])
+		}
+	}
+
+	for _, raw := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1/abc"} {
+		if _, err := parseTrustedProxies(raw); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestClientIPExtractor(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		trusted    bool
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"no proxies configured ignores headers", false, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "10.1.2.3"},
+		{"forwarded by trusted proxy", true, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"chain through trusted proxies", true, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"spoofed entry before the client", true, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"untrusted remote", true, "192.0.2.10:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.10"},
+		{"loopback is not trusted by default", true, "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "127.0.0.1"},
+		{"X-Real-IP from trusted proxy", true, "10.1.2.3:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"X-Real-IP from untrusted remote", true, "192.0.2.10:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "192.0.2.10"},
+		{"no headers", true, "10.1.2.3:5000", nil, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		var ranges = trusted
+		if !tt.trusted {
+			ranges = nil
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if got := newClientIPExtractor(ranges)(req); got != tt.want {
+			t.Errorf("%s: client IP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitUsesForwardedClientIP(t *testing.T) {
+	get := func(s *testServer, clientIP string) int {
+		t.Helper()
+		req := s.newRequest(t, http.MethodGet, "/", "", nil)
+		req.Header.Set("X-Forwarded-For", clientIP)
+		resp, _ := s.do(t, req)
+		return resp.StatusCode
+	}
+
+	// テストサーバーへの接続元 (127.0.0.1) をプロキシとして信頼すると、クライアントごとに制限する
+	s := newTestServer(t, "TRUSTED_PROXIES=127.0.0.1", "RATE_LIMIT_ANONYMOUS_PER_SECOND=1")
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		if status := get(s, ip); status != http.StatusOK {
+			t.Errorf("first request from %s: status %d", ip, status)
+		}
+	}
+	if status := get(s, "198.51.100.1"); status != http.StatusTooManyRequests {
+		t.Errorf("second request from the same client: status %d, want 429", status)
+	}
+
+	// 信頼していなければヘッダーは無視し、全て接続元のアドレスで数える
+	s = newTestServer(t, "TRUSTED_PROXIES=", "RATE_LIMIT_ANONYMOUS_PER_SECOND=1")
+	get(s, "198.51.100.1")
+	if status := get(s, "198.51.100.2"); status != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For bypassed the limit: status %d", status)
+	}
+}
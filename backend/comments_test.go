@@ -0,0 +1,653 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisabledComments(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.addUser("alice")
+	commenter := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	insertComment(t, id, "bob", "before")
+	trackPath := fmt.Sprintf("/api/track/%d", id)
+	commentsPath := trackPath + "/comments"
+
+	var detail TrackDetail
+	s.callJSON(t, http.MethodGet, trackPath, "", nil, http.StatusOK, &detail)
+	if !detail.CommentsEnabled {
+		t.Fatal("comments_enabled is false for a new track")
+	}
+
+	// 本人以外は変更できない
+	s.callJSON(t, http.MethodPatch, trackPath, commenter, map[string]bool{"comments_enabled": false}, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodPatch, trackPath, owner, map[string]bool{"comments_enabled": false}, http.StatusOK, nil)
+
+	s.callJSON(t, http.MethodGet, trackPath, "", nil, http.StatusOK, &detail)
+	if detail.CommentsEnabled {
+		t.Error("track detail: comments_enabled is still true")
+	}
+
+	var envelope struct {
+		Data            []Comment `json:"data"`
+		CommentsEnabled *bool     `json:"comments_enabled"`
+	}
+	resp := s.callJSON(t, http.MethodGet, commentsPath+"?meta=true", "", nil, http.StatusOK, &envelope)
+	if envelope.CommentsEnabled == nil || *envelope.CommentsEnabled {
+		t.Errorf("comments envelope: comments_enabled = %v, want false", envelope.CommentsEnabled)
+	}
+	if len(envelope.Data) != 1 {
+		t.Errorf("existing comments: got %d, want 1", len(envelope.Data))
+	}
+	if h := resp.Header.Get("X-Comments-Enabled"); h != "false" {
+		t.Errorf("X-Comments-Enabled = %q, want false", h)
+	}
+	var list []Comment
+	resp = s.callJSON(t, http.MethodGet, commentsPath, "", nil, http.StatusOK, &list)
+	if len(list) != 1 || resp.Header.Get("X-Comments-Enabled") != "false" {
+		t.Errorf("plain list: %d comments, X-Comments-Enabled %q", len(list), resp.Header.Get("X-Comments-Enabled"))
+	}
+
+	s.callJSON(t, http.MethodPost, trackPath+"/comment", commenter, map[string]string{"content": "after"}, http.StatusForbidden, nil)
+
+	s.callJSON(t, http.MethodPatch, trackPath, owner, map[string]bool{"comments_enabled": true}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPost, trackPath+"/comment", commenter, map[string]string{"content": "after"}, http.StatusOK, nil)
+}
+
+func TestCommentRateLimit(t *testing.T) {
+	s := newTestServer(t, "COMMENT_MIN_INTERVAL_SECONDS=10", "COMMENT_MAX_PER_HOUR=30")
+	token := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/comment", id)
+
+	s.callJSON(t, http.MethodPost, path, token, map[string]string{"content": "first"}, http.StatusOK, nil)
+	var limited struct {
+		RetryAfter int `json:"retry_after"`
+	}
+	resp := s.callJSON(t, http.MethodPost, path, token, map[string]string{"content": "second"}, http.StatusTooManyRequests, &limited)
+	if limited.RetryAfter < 1 || limited.RetryAfter > 10 {
+		t.Errorf("retry_after = %d, want 1-10", limited.RetryAfter)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("429 response has no Retry-After header")
+	}
+	// 他のユーザーは制限されない
+	s.callJSON(t, http.MethodPost, path, s.addUser("carol"), map[string]string{"content": "second"}, http.StatusOK, nil)
+}
+
+func TestCommentHourlyLimit(t *testing.T) {
+	s := newTestServer(t, "COMMENT_MIN_INTERVAL_SECONDS=0", "COMMENT_MAX_PER_HOUR=2")
+	token := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/comment", id)
+
+	s.callJSON(t, http.MethodPost, path, token, map[string]string{"content": "one"}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPost, path, token, map[string]string{"content": "two"}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPost, path, token, map[string]string{"content": "three"}, http.StatusTooManyRequests, nil)
+	// 1時間より前のコメントは数えない
+	mustExec(t, "UPDATE comments SET created_at = datetime('now', '-2 hours')")
+	s.callJSON(t, http.MethodPost, path, token, map[string]string{"content": "three"}, http.StatusOK, nil)
+}
+
+func TestDuplicateCommentSuppressed(t *testing.T) {
+	s := newTestServer(t, "COMMENT_MIN_INTERVAL_SECONDS=10")
+	token := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/comment", id)
+	body := map[string]string{"content": "nice track"}
+
+	// 新規の投稿も二重送信も、同じ形 (コメント) で返す
+	var posted, dup Comment
+	s.callJSON(t, http.MethodPost, path, token, body, http.StatusOK, &posted)
+	firstID := queryInt(t, "SELECT MIN(id) FROM comments WHERE track_id = ?", id)
+	if posted.ID != firstID || posted.Content != "nice track" || posted.UserUID != "bob" || posted.TrackID != id {
+		t.Errorf("post returned %+v, want comment %d", posted, firstID)
+	}
+	// 二重送信はレートリミットにかからず、既存のコメントが返る
+	s.callJSON(t, http.MethodPost, path, token, body, http.StatusOK, &dup)
+	if dup != posted {
+		t.Errorf("duplicate post returned %+v, want the existing comment %+v", dup, posted)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE track_id = ?", id); n != 1 {
+		t.Fatalf("comments = %d after duplicate post, want 1", n)
+	}
+
+	// 30秒を過ぎていれば同じ内容でも新しいコメントとして扱う
+	mustExec(t, "UPDATE comments SET created_at = datetime('now', '-1 minute')")
+	s.callJSON(t, http.MethodPost, path, token, body, http.StatusOK, nil)
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE track_id = ?", id); n != 2 {
+		t.Errorf("comments = %d after posting again later, want 2", n)
+	}
+}
+
+func TestCommentSortOrders(t *testing.T) {
+	s := newTestServer(t)
+	id := insertTrack(t, "alice", "Song")
+	var ids []int
+	for i, content := range []string{"first", "second", "third"} {
+		cid := insertComment(t, id, "bob", content)
+		mustExec(t, "UPDATE comments SET created_at = datetime('now', ?) WHERE id = ?", fmt.Sprintf("-%d minutes", 10-i), cid)
+		ids = append(ids, cid)
+	}
+	path := fmt.Sprintf("/api/track/%d/comments", id)
+
+	commentIDs := func(query string) []int {
+		t.Helper()
+		var comments []Comment
+		s.callJSON(t, http.MethodGet, path+query, "", nil, http.StatusOK, &comments)
+		got := make([]int, len(comments))
+		for i, c := range comments {
+			got[i] = c.ID
+		}
+		return got
+	}
+	for _, tt := range []struct {
+		query string
+		want  []int
+	}{
+		{"", ids},
+		{"?sort=oldest", ids},
+		{"?sort=newest", []int{ids[2], ids[1], ids[0]}},
+		{fmt.Sprintf("?sort=newest&after=%d", ids[2]), []int{ids[1], ids[0]}},
+	} {
+		if got := commentIDs(tt.query); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("GET comments%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// コメントへのいいねがないため top は未対応
+	for _, sort := range []string{"top", "random"} {
+		s.callJSON(t, http.MethodGet, path+"?sort="+sort, "", nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestMaxCommentsPerTrack(t *testing.T) {
+	s := newTestServer(t, "COMMENT_MAX_PER_TRACK=2")
+	owner := s.addUser("alice")
+	commenter := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/comment", id)
+	post := func(content string, want int) {
+		t.Helper()
+		s.callJSON(t, http.MethodPost, path, commenter, map[string]string{"content": content}, want, nil)
+	}
+
+	post("one", http.StatusOK)
+	post("two", http.StatusOK)
+	post("three", http.StatusForbidden)
+
+	// 削除されたコメントは数に含めない
+	first := queryInt(t, "SELECT MIN(id) FROM comments WHERE track_id = ?", id)
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/comment/%d", first), commenter, nil, http.StatusOK, nil)
+	post("three", http.StatusOK)
+	post("four", http.StatusForbidden)
+
+	// アップロード者はトラックごとに上限を変更できるが、サーバー全体の上限までに制限される
+	trackPath := fmt.Sprintf("/api/track/%d", id)
+	patch := func(body map[string]interface{}, want int) int {
+		t.Helper()
+		var res struct {
+			MaxComments int `json:"max_comments"`
+		}
+		s.callJSON(t, http.MethodPatch, trackPath, owner, body, want, &res)
+		return res.MaxComments
+	}
+	if got := patch(map[string]interface{}{"max_comments": 3}, http.StatusOK); got != 2 {
+		t.Errorf("max_comments above the server cap = %d, want it clamped to 2", got)
+	}
+	post("four", http.StatusForbidden)
+	if n := queryInt(t, "SELECT max_comments FROM tracks WHERE id = ?", id); n != 2 {
+		t.Errorf("stored max_comments = %d, want 2", n)
+	}
+	for _, body := range []map[string]interface{}{{"max_comments": 0}, {"max_comments": -1}} {
+		patch(body, http.StatusBadRequest)
+	}
+
+	// null で既定値に戻す (カラムは NULL になる)
+	if got := patch(map[string]interface{}{"max_comments": 1}, http.StatusOK); got != 1 {
+		t.Errorf("max_comments = %d, want 1", got)
+	}
+	if got := patch(map[string]interface{}{"max_comments": nil}, http.StatusOK); got != 2 {
+		t.Errorf("max_comments after reset = %d, want the default 2", got)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE id = ? AND max_comments IS NULL", id); n != 1 {
+		t.Error("max_comments was not reset to NULL")
+	}
+	// 他のフィールドだけを変更した場合は上限を変えない
+	patch(map[string]interface{}{"max_comments": 1}, http.StatusOK)
+	if got := patch(map[string]interface{}{"title": "Renamed"}, http.StatusOK); got != 1 {
+		t.Errorf("max_comments after editing the title = %d, want 1", got)
+	}
+
+	// 以前の仕様で保存された 0 (無制限) もサーバー全体の上限に従う
+	mustExec(t, "UPDATE tracks SET max_comments = 0 WHERE id = ?", id)
+	post("four", http.StatusForbidden)
+
+	// サーバー全体の上限がない場合は、任意の上限を設定できる
+	s = newTestServer(t, "COMMENT_MAX_PER_TRACK=0")
+	owner, commenter = s.addUser("alice"), s.addUser("bob")
+	id = insertTrack(t, "alice", "Song")
+	path, trackPath = fmt.Sprintf("/api/track/%d/comment", id), fmt.Sprintf("/api/track/%d", id)
+	if got := patch(map[string]interface{}{"max_comments": 1000}, http.StatusOK); got != 1000 {
+		t.Errorf("max_comments without a server cap = %d, want 1000", got)
+	}
+	if got := patch(map[string]interface{}{"max_comments": nil}, http.StatusOK); got != 0 {
+		t.Errorf("max_comments reset without a server cap = %d, want 0 (unlimited)", got)
+	}
+}
+
+func TestReportedCommentIsHiddenAboveThreshold(t *testing.T) {
+	s := newTestServer(t, "COMMENT_REPORT_HIDE_THRESHOLD=2")
+	admin := s.addAdmin("root")
+	author := s.addUser("alice")
+	reporters := []string{s.addUser("bob"), s.addUser("carol"), s.addUser("dave")}
+	track := insertTrack(t, "alice", "Song")
+	id := insertComment(t, track, "alice", "questionable")
+	listPath := fmt.Sprintf("/api/track/%d/comments", track)
+	reportPath := fmt.Sprintf("/api/comment/%d/report", id)
+
+	visibleTo := func(token string) (bool, bool) {
+		t.Helper()
+		var comments []Comment
+		s.callJSON(t, http.MethodGet, listPath, token, nil, http.StatusOK, &comments)
+		for _, c := range comments {
+			if c.ID == id {
+				return true, c.Hidden
+			}
+		}
+		return false, false
+	}
+
+	s.callJSON(t, http.MethodPost, reportPath, reporters[0], map[string]string{"reason": "spam"}, http.StatusCreated, nil)
+	if visible, _ := visibleTo(""); !visible {
+		t.Fatal("comment hidden below the threshold")
+	}
+	s.callJSON(t, http.MethodPost, reportPath, reporters[0], map[string]string{"reason": "spam"}, http.StatusConflict, nil)
+	s.callJSON(t, http.MethodPost, reportPath, reporters[1], map[string]string{"reason": "harassment"}, http.StatusCreated, nil)
+	// しきい値ちょうどの件数では、まだ非表示にしない
+	if visible, _ := visibleTo(""); !visible {
+		t.Fatal("comment hidden at exactly the threshold")
+	}
+	s.callJSON(t, http.MethodPost, reportPath, reporters[2], map[string]string{"reason": "other"}, http.StatusCreated, nil)
+
+	if visible, _ := visibleTo(""); visible {
+		t.Error("comment is still public after exceeding the threshold")
+	}
+	if visible, _ := visibleTo(reporters[0]); visible {
+		t.Error("hidden comment is visible to another user")
+	}
+	for name, token := range map[string]string{"author": author, "admin": admin} {
+		if visible, hidden := visibleTo(token); !visible || !hidden {
+			t.Errorf("%s: visible %v, hidden flag %v; want the comment marked hidden", name, visible, hidden)
+		}
+	}
+
+	// 管理者が確認して再表示すると、通報もリセットされる
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/admin/comment/%d/unhide", id), admin, nil, http.StatusOK, nil)
+	if visible, _ := visibleTo(""); !visible {
+		t.Error("comment is still hidden after unhide")
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM comment_reports WHERE comment_id = ?", id); n != 0 {
+		t.Errorf("%d reports remain after unhide", n)
+	}
+}
+
+func TestCommentThread(t *testing.T) {
+	s := newTestServer(t, "COMMENT_MAX_DEPTH=2")
+	token := s.addUser("bob")
+	track := insertTrack(t, "alice", "Song")
+	postPath := fmt.Sprintf("/api/track/%d/comment", track)
+	reply := func(parentID int, content string, want int) int {
+		t.Helper()
+		s.callJSON(t, http.MethodPost, postPath, token, map[string]interface{}{"content": content, "parent_id": parentID}, want, nil)
+		return queryInt(t, "SELECT MAX(id) FROM comments")
+	}
+
+	root := insertComment(t, track, "alice", "root")
+	a := reply(root, "a", http.StatusOK)
+	a1 := reply(a, "a1", http.StatusOK)
+	reply(a1, "too deep", http.StatusBadRequest)
+	b := reply(root, "b", http.StatusOK)
+	other := insertComment(t, insertTrack(t, "alice", "Other"), "alice", "elsewhere")
+	reply(other, "wrong track", http.StatusBadRequest)
+	reply(999999, "missing parent", http.StatusBadRequest)
+
+	// shape は木構造を "id(子, 子)" の形の文字列にする
+	var shape func(n *CommentNode) string
+	shape = func(n *CommentNode) string {
+		var children []string
+		for _, r := range n.Replies {
+			children = append(children, shape(r))
+		}
+		return fmt.Sprintf("%d(%s)", n.ID, strings.Join(children, ","))
+	}
+	for id, want := range map[int]string{
+		root: fmt.Sprintf("%d(%d(%d()),%d())", root, a, a1, b),
+		a:    fmt.Sprintf("%d(%d())", a, a1),
+		a1:   fmt.Sprintf("%d()", a1),
+	} {
+		var tree CommentNode
+		s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/comment/%d/thread", id), "", nil, http.StatusOK, &tree)
+		if got := shape(&tree); got != want {
+			t.Errorf("thread of %d = %s, want %s", id, got, want)
+		}
+	}
+	s.callJSON(t, http.MethodGet, "/api/comment/999999/thread", "", nil, http.StatusNotFound, nil)
+}
+
+func TestSameSecondCommentsPageStably(t *testing.T) {
+	s := newTestServer(t)
+	track := insertTrack(t, "alice", "Song")
+	var ids []int
+	for i := 0; i < 7; i++ {
+		ids = append(ids, insertComment(t, track, "bob", fmt.Sprintf("comment %d", i)))
+	}
+	mustExec(t, "UPDATE comments SET created_at = '2024-01-01 00:00:00'")
+	path := fmt.Sprintf("/api/track/%d/comments", track)
+
+	page := func(query string) []int {
+		t.Helper()
+		var comments []Comment
+		s.callJSON(t, http.MethodGet, path+query, "", nil, http.StatusOK, &comments)
+		got := make([]int, len(comments))
+		for i, c := range comments {
+			got[i] = c.ID
+		}
+		return got
+	}
+	reversed := make([]int, len(ids))
+	for i, id := range ids {
+		reversed[len(ids)-1-i] = id
+	}
+
+	for sort, want := range map[string][]int{"oldest": ids, "newest": reversed} {
+		// offset でも id のカーソル (?after=) でも、重複も抜けもなく同じ順番になる
+		var byOffset, byCursor []int
+		for offset := 0; offset < len(ids); offset += 3 {
+			byOffset = append(byOffset, page(fmt.Sprintf("?sort=%s&limit=3&offset=%d", sort, offset))...)
+		}
+		for after := ""; ; {
+			got := page(fmt.Sprintf("?sort=%s&limit=3%s", sort, after))
+			if len(got) == 0 {
+				break
+			}
+			byCursor = append(byCursor, got...)
+			after = fmt.Sprintf("&after=%d", got[len(got)-1])
+		}
+		for name, got := range map[string][]int{"offset": byOffset, "cursor": byCursor} {
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("sort=%s paging by %s = %v, want %v", sort, name, got, want)
+			}
+		}
+	}
+
+	// 存在しないコメントをカーソルにした場合と、offset と併用した場合は 400
+	s.callJSON(t, http.MethodGet, path+"?after=999999", "", nil, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodGet, fmt.Sprintf("%s?after=%d&offset=1", path, ids[0]), "", nil, http.StatusBadRequest, nil)
+}
+
+func TestPinnedComment(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+	track := insertTrack(t, "alice", "Song")
+	first := insertComment(t, track, "bob", "first")
+	second := insertComment(t, track, "bob", "second")
+	announcement := insertComment(t, track, "alice", "announcement")
+	elsewhere := insertComment(t, insertTrack(t, "alice", "Other"), "bob", "elsewhere")
+	pinPath := fmt.Sprintf("/api/track/%d/pinned-comment", track)
+
+	order := func() string {
+		t.Helper()
+		var comments []Comment
+		s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d/comments", track), "", nil, http.StatusOK, &comments)
+		var ids []string
+		for _, c := range comments {
+			id := fmt.Sprint(c.ID)
+			if c.Pinned {
+				id += "*"
+			}
+			ids = append(ids, id)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// アップロード者以外はピン留めできない
+	s.callJSON(t, http.MethodPost, pinPath, bob, map[string]int{"comment_id": first}, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodDelete, pinPath, bob, nil, http.StatusForbidden, nil)
+	// 別のトラックのコメントはピン留めできない
+	s.callJSON(t, http.MethodPost, pinPath, alice, map[string]int{"comment_id": elsewhere}, http.StatusBadRequest, nil)
+
+	s.callJSON(t, http.MethodPost, pinPath, alice, map[string]int{"comment_id": announcement}, http.StatusOK, nil)
+	if got, want := order(), fmt.Sprintf("%d*,%d,%d", announcement, first, second); got != want {
+		t.Errorf("comments after pinning = %s, want %s", got, want)
+	}
+
+	// ピン留めを置き換える
+	s.callJSON(t, http.MethodPost, pinPath, alice, map[string]int{"comment_id": second}, http.StatusOK, nil)
+	if got, want := order(), fmt.Sprintf("%d*,%d,%d", second, first, announcement); got != want {
+		t.Errorf("comments after re-pinning = %s, want %s", got, want)
+	}
+
+	// ピン留めしたコメントを削除すると解除される
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/comment/%d", second), bob, nil, http.StatusOK, nil)
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE pinned_comment_id IS NOT NULL"); n != 0 {
+		t.Errorf("pinned_comment_id still set after the comment was deleted")
+	}
+	if got, want := order(), fmt.Sprintf("%d,%d", first, announcement); got != want {
+		t.Errorf("comments after deleting the pinned comment = %s, want %s", got, want)
+	}
+
+	s.callJSON(t, http.MethodPost, pinPath, alice, map[string]int{"comment_id": first}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodDelete, pinPath, alice, nil, http.StatusOK, nil)
+	if got, want := order(), fmt.Sprintf("%d,%d", first, announcement); got != want {
+		t.Errorf("comments after unpinning = %s, want %s", got, want)
+	}
+}
+
+func TestCommentPreviewDoesNotPersist(t *testing.T) {
+	s := newTestServer(t, "PROFANITY_LIST="+writeProfanityList(t), "PROFANITY_MODE=mask")
+	token := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+
+	var preview struct {
+		Content string `json:"content"`
+		HTML    string `json:"html"`
+	}
+	s.callJSON(t, http.MethodPost, "/api/comment/preview", token, map[string]string{"content": `<b>darn</b> & "good"`}, http.StatusOK, &preview)
+	if preview.Content != `<b>****</b> & "good"` || preview.HTML != `&lt;b&gt;****&lt;/b&gt; &amp; &#34;good&#34;` {
+		t.Errorf("preview = %+v", preview)
+	}
+	s.callJSON(t, http.MethodPost, "/api/comment/preview", token, map[string]string{"content": ""}, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPost, "/api/comment/preview", token, map[string]string{"content": strings.Repeat("a", defaultMaxCommentLength+1)}, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPost, "/api/comment/preview", "", map[string]string{"content": "hi"}, http.StatusUnauthorized, nil)
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments"); n != 0 {
+		t.Fatalf("preview created %d comments", n)
+	}
+
+	// 実際に投稿すると、プレビューと同じ本文で保存される
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/comment", id), token, map[string]string{"content": `<b>darn</b> & "good"`}, http.StatusOK, nil)
+	var stored string
+	if err := db.QueryRow("SELECT content FROM comments").Scan(&stored); err != nil || stored != preview.Content {
+		t.Errorf("posted content = %q (%v), want the previewed %q", stored, err, preview.Content)
+	}
+}
+
+func TestCommentRemovalNotification(t *testing.T) {
+	brevo := newFakeBrevo(t)
+	s := newTestServer(t, "BREVO_API_KEY=test-key", "BREVO_SENDER_EMAIL=noreply@example.com")
+	admin, carol := s.addAdmin("admin"), s.addUser("carol")
+	s.addUser("bob")
+	track := insertTrack(t, "alice", "Night <i>Drive</i>")
+
+	// 本人による削除では通知しない
+	own := insertComment(t, track, "carol", "my own words")
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/comment/%d", own), carol, nil, http.StatusOK, nil)
+	// 管理者による削除は投稿者に通知する
+	removed := insertComment(t, track, "bob", "<b>rude</b> remark")
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/comment/%d", removed), admin, nil, http.StatusOK, nil)
+
+	// 他のテストで行列に残ったメールも送られることがあるため、宛先で絞り込む
+	var toBob []fakeBrevoEmail
+	deadline := time.Now().Add(5 * time.Second)
+	for len(toBob) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		toBob = toBob[:0]
+		for _, m := range brevo.emails() {
+			if m.To == "bob@example.com" {
+				toBob = append(toBob, m)
+			}
+		}
+	}
+	if len(toBob) != 1 {
+		t.Fatalf("bob received %d removal emails, want 1", len(toBob))
+	}
+	// トラックのタイトルは件名に含めず、本文ではエスケープしてトラックページへのリンクを付ける
+	m := toBob[0]
+	if strings.Contains(m.Subject, "Night") {
+		t.Errorf("removal email subject contains the track title: %q", m.Subject)
+	}
+	for _, want := range []string{
+		"Night &lt;i&gt;Drive&lt;/i&gt;",
+		"&lt;b&gt;rude&lt;/b&gt; remark",
+		fmt.Sprintf(`href="http://localhost:3000/track/%d"`, track),
+	} {
+		if !strings.Contains(m.Body, want) {
+			t.Errorf("removal email does not contain %s: %+v", want, m)
+		}
+	}
+	for _, m := range brevo.emails() {
+		if m.To == "carol@example.com" {
+			t.Errorf("self-deleted comment sent a removal email: %+v", m)
+		}
+	}
+}
+
+func TestUserComments(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.auth.addUser(fakeAuthUser{UID: "alice", DisplayName: "Alice Renamed", EmailVerified: true, PhotoURL: "https://example.com/alice.png"})
+	bob := s.addUser("bob")
+	admin := s.addAdmin("admin")
+	song, other := insertTrack(t, "bob", "Song"), insertTrack(t, "bob", "Other")
+
+	first := insertComment(t, song, "alice", "first")
+	second := insertComment(t, other, "alice", "second")
+	hidden := insertComment(t, song, "alice", "hidden")
+	insertComment(t, song, "bob", "not alice")
+	mustExec(t, "UPDATE comments SET created_at = '2026-01-01 00:00:00' WHERE id = ?", first)
+	mustExec(t, "UPDATE comments SET created_at = '2026-01-02 00:00:00' WHERE id = ?", second)
+	mustExec(t, "UPDATE comments SET created_at = '2026-01-03 00:00:00', hidden = 1 WHERE id = ?", hidden)
+
+	type userComment struct {
+		Comment
+		TrackTitle string `json:"track_title"`
+	}
+	var page struct {
+		Comments []userComment `json:"comments"`
+		Total    int           `json:"total"`
+	}
+	contents := func() []string {
+		var got []string
+		for _, c := range page.Comments {
+			got = append(got, c.Content)
+		}
+		return got
+	}
+
+	// 新しい順で、コメント先のトラックと現在の表示名を返す
+	s.callJSON(t, http.MethodGet, "/api/user/alice/comments", bob, nil, http.StatusOK, &page)
+	if page.Total != 2 || fmt.Sprint(contents()) != "[second first]" {
+		t.Fatalf("alice's comments seen by bob = %+v", page)
+	}
+	if c := page.Comments[0]; c.TrackID != other || c.TrackTitle != "Other" || c.UserName != "Alice Renamed" || c.UserAvatarURL != "https://example.com/alice.png" {
+		t.Errorf("comment = %+v", c)
+	}
+	s.callJSON(t, http.MethodGet, "/api/user/alice/comments?limit=1&offset=1", "", nil, http.StatusOK, &page)
+	if page.Total != 2 || fmt.Sprint(contents()) != "[first]" {
+		t.Errorf("second page = %+v", page)
+	}
+
+	// 非表示のコメントは本人と管理者にだけ返す
+	for _, token := range []string{alice, admin} {
+		s.callJSON(t, http.MethodGet, "/api/user/alice/comments", token, nil, http.StatusOK, &page)
+		if page.Total != 3 || fmt.Sprint(contents()) != "[hidden second first]" {
+			t.Errorf("alice's comments including hidden = %v (total %d)", contents(), page.Total)
+		}
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/user/nobody/comments", "", nil, http.StatusNotFound, nil)
+	s.callJSON(t, http.MethodGet, "/api/user/alice/comments?offset=-1", "", nil, http.StatusBadRequest, nil)
+}
+
+func TestCommentAuthorAvatars(t *testing.T) {
+	s := newTestServer(t)
+	s.auth.addUser(fakeAuthUser{UID: "alice", DisplayName: "Alice", EmailVerified: true, PhotoURL: "https://example.com/alice.png"})
+	s.addUser("bob")
+	track := insertTrack(t, "alice", "Song")
+	insertComment(t, track, "alice", "with avatar")
+	insertComment(t, track, "bob", "without avatar")
+	insertComment(t, track, "ghost", "deleted user")
+
+	avatars := func() map[string]string {
+		t.Helper()
+		var comments []Comment
+		s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d/comments", track), "", nil, http.StatusOK, &comments)
+		got := make(map[string]string)
+		for _, c := range comments {
+			got[c.UserUID] = c.UserAvatarURL
+		}
+		return got
+	}
+	want := map[string]string{"alice": "https://example.com/alice.png", "bob": "", "ghost": ""}
+	if got := avatars(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("avatars = %v, want %v", got, want)
+	}
+
+	// アバターはコメントに保存しないため、削除するとキャッシュが切れた後のレスポンスから消える
+	s.auth.addUser(fakeAuthUser{UID: "alice", DisplayName: "Alice", EmailVerified: true})
+	displayNameCache.Lock()
+	displayNameCache.entries = make(map[string]cachedDisplayName)
+	displayNameCache.Unlock()
+	if got := avatars()["alice"]; got != "" {
+		t.Errorf("avatar after removal = %q, want empty", got)
+	}
+}
+
+func TestDeleteCommentIsAtomic(t *testing.T) {
+	s := newTestServer(t)
+	bob := s.addUser("bob")
+	track := insertTrack(t, "alice", "Song")
+	parent := insertComment(t, track, "bob", "parent")
+	reply := insertComment(t, track, "carol", "reply")
+	mustExec(t, "UPDATE comments SET parent_id = ? WHERE id = ?", parent, reply)
+	mustExec(t, "INSERT INTO comment_reports (comment_id, reporter_uid, reason) VALUES (?, 'dave', 'spam')", parent)
+	mustExec(t, "UPDATE tracks SET pinned_comment_id = ? WHERE id = ?", parent, track)
+
+	// 途中 (ピン留めの解除) で失敗した場合は、コメントの削除も取り消して 500 を返す
+	mustExec(t, "CREATE TRIGGER reject_unpin BEFORE UPDATE OF pinned_comment_id ON tracks BEGIN SELECT RAISE(ABORT, 'boom'); END")
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/comment/%d", parent), bob, nil, http.StatusInternalServerError, nil)
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE id = ?", parent); n != 1 {
+		t.Error("comment was deleted although the deletion failed")
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM comment_reports WHERE comment_id = ?", parent); n != 1 {
+		t.Error("reports were deleted although the deletion failed")
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE id = ? AND parent_id = ?", reply, parent); n != 1 {
+		t.Error("reply was reattached although the deletion failed")
+	}
+
+	mustExec(t, "DROP TRIGGER reject_unpin")
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/comment/%d", parent), bob, nil, http.StatusOK, nil)
+	if n := queryInt(t, "SELECT COUNT(*) FROM comment_reports WHERE comment_id = ?", parent); n != 0 {
+		t.Errorf("%d reports left for the deleted comment", n)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE id = ? AND parent_id IS NULL", reply); n != 1 {
+		t.Error("reply was not reattached to the top level")
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE pinned_comment_id IS NOT NULL"); n != 0 {
+		t.Error("deleted comment is still pinned")
+	}
+}
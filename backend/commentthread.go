@@ -0,0 +1,47 @@
+package main
+
+import "database/sql"
+
+// CommentNode はスレッド取得APIで返す、返信をネストしたコメント
+type CommentNode struct {
+	Comment
+	Replies []*CommentNode `json:"replies"`
+}
+
+// buildCommentTree は rootID のコメントとその返信の一覧 (古い順) を木構造に組み立てる
+// 親が一覧に含まれない返信 (非表示や深さの上限で除外されたもの) は木に含めない
+func buildCommentTree(rootID int, comments []Comment) *CommentNode {
+	nodes := make(map[int]*CommentNode, len(comments))
+	for _, cm := range comments {
+		nodes[cm.ID] = &CommentNode{Comment: cm, Replies: make([]*CommentNode, 0)}
+	}
+	for _, cm := range comments {
+		if cm.ID == rootID || cm.ParentID == nil {
+			continue
+		}
+		if parent, ok := nodes[*cm.ParentID]; ok {
+			parent.Replies = append(parent.Replies, nodes[cm.ID])
+		}
+	}
+	return nodes[rootID]
+}
+
+// commentReplyDepth は parentID のコメントへの返信を投稿した場合の深さ (トップレベルのコメントへの返信が 1) と、
+// 親コメントのトラックIDを返す。親コメントが存在しない場合は sql.ErrNoRows を返す
+func commentReplyDepth(parentID int) (trackID, depth int, err error) {
+	var parentTrackID, parentDepth sql.NullInt64
+	err = db.QueryRow(`
+		WITH RECURSIVE ancestors(id, parent_id, depth) AS (
+			SELECT id, parent_id, 1 FROM comments WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, a.depth + 1 FROM comments c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT (SELECT track_id FROM comments WHERE id = ?), MAX(depth) FROM ancestors`, parentID, parentID).Scan(&parentTrackID, &parentDepth)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !parentTrackID.Valid {
+		return 0, 0, sql.ErrNoRows
+	}
+	return int(parentTrackID.Int64), int(parentDepth.Int64), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// placeholderCoverSVG はカバー画像がないトラック用の代替画像を生成する
+// 背景色はトラックIDから決まるため、同じトラックには常に同じ画像を返す
+func placeholderCoverSVG(trackID int, title string) []byte {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "track:%d", trackID)
+	hue := h.Sum32() % 360
+
+	initial := "♪"
+	for _, r := range strings.TrimSpace(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			initial = string(unicode.ToUpper(r))
+		}
+		break
+	}
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="512" height="512" viewBox="0 0 512 512">`+
+		`<rect width="512" height="512" fill="hsl(%d, 55%%, 45%%)"/>`+
+		`<text x="50%%" y="50%%" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="240" fill="#fff">%s</text>`+
+		`</svg>`, hue, html.EscapeString(initial)))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderCoverSVG(t *testing.T) {
+	// 同じトラックには常に同じ画像を返す
+	if a, b := placeholderCoverSVG(7, "Song"), placeholderCoverSVG(7, "Song"); !bytes.Equal(a, b) {
+		t.Error("placeholder cover is not deterministic")
+	}
+
+	for title, want := range map[string]string{
+		"  hello": "H",
+		"日本の歌":    "日",
+		"42 Hz":   "4",
+		"!bang":   "♪",
+		"":        "♪",
+	} {
+		var svg struct {
+			Rect struct {
+				Fill string `xml:"fill,attr"`
+			} `xml:"rect"`
+			Text string `xml:"text"`
+		}
+		if err := xml.Unmarshal(placeholderCoverSVG(1, title), &svg); err != nil {
+			t.Fatalf("placeholder for %q is not valid XML: %v", title, err)
+		}
+		if svg.Text != want {
+			t.Errorf("initial for %q = %q, want %q", title, svg.Text, want)
+		}
+		if !strings.HasPrefix(svg.Rect.Fill, "hsl(") {
+			t.Errorf("background for %q = %q", title, svg.Rect.Fill)
+		}
+	}
+}
+
+func TestTrackCoverEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/cover", id)
+
+	resp, body := s.call(t, http.MethodGet, path, "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("GET %s: status %d, Content-Type %q", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !bytes.Equal(body, placeholderCoverSVG(id, "Song")) {
+		t.Error("cover endpoint did not return the placeholder")
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" || !strings.Contains(resp.Header.Get("Cache-Control"), "max-age=") {
+		t.Errorf("caching headers: ETag %q, Cache-Control %q", etag, resp.Header.Get("Cache-Control"))
+	}
+
+	req := s.newRequest(t, http.MethodGet, path, "", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, _ := s.do(t, req); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional GET: status %d, want 304", resp.StatusCode)
+	}
+
+	// タイトルを変えると頭文字が変わり、ETag も変わる
+	s.callJSON(t, http.MethodPatch, fmt.Sprintf("/api/track/%d", id), token, map[string]string{"title": "Another"}, http.StatusOK, nil)
+	req = s.newRequest(t, http.MethodGet, path, "", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, _ := s.do(t, req); resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("after renaming: status %d, ETag %q; want 200 with a new ETag", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/track/999999/cover", "", nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestContentSecurityPolicy(t *testing.T) {
+	csp := func(s *testServer, path string) string {
+		t.Helper()
+		resp, _ := s.call(t, http.MethodGet, path, "", nil)
+		return resp.Header.Get("Content-Security-Policy")
+	}
+
+	// JSON API には厳格なポリシー、HTMLページにだけ緩めたポリシーを適用する
+	s := newTestServer(t)
+	for path, want := range map[string]string{
+		"/api/tracks":        defaultAPICSP,
+		"/api/openapi.json":  defaultAPICSP,
+		"/api/docs/init.js":  defaultAPICSP,
+		"/api/docs":          defaultHTMLCSP,
+		"/api/no-such-route": defaultAPICSP,
+	} {
+		if got := csp(s, path); got != want {
+			t.Errorf("CSP for %s = %q, want %q", path, got, want)
+		}
+	}
+
+	// どちらも環境変数で上書きできる
+	const apiPolicy, htmlPolicy = "default-src 'none'; frame-ancestors 'none'", "default-src 'self'"
+	s = newTestServer(t, "CSP_API="+apiPolicy, "CSP_HTML="+htmlPolicy)
+	if got := csp(s, "/api/tracks"); got != apiPolicy {
+		t.Errorf("CSP for /api/tracks with CSP_API = %q, want %q", got, apiPolicy)
+	}
+	if got := csp(s, "/api/docs"); got != htmlPolicy {
+		t.Errorf("CSP for /api/docs with CSP_HTML = %q, want %q", got, htmlPolicy)
+	}
+}
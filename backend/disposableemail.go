@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// disposableEmailMessage は使い捨てメールのドメインのユーザーの操作を拒否したときのメッセージ
+const disposableEmailMessage = "Disposable email addresses cannot be used for this action. Please use a permanent email address."
+
+// EmailDomainBlocklist は使い捨て (一時的な) メールアドレスのドメインの一覧
+// スパム用のアカウントによるアップロードなどを防ぐ。nil の場合は何もしない (DISPOSABLE_EMAIL_BLOCKLIST が未設定のとき)
+type EmailDomainBlocklist struct {
+	domains map[string]bool
+}
+
+// loadEmailDomainBlocklist は1行1ドメインのリストを読み込む (空行と # で始まる行は無視)
+func loadEmailDomainBlocklist(path string) (*EmailDomainBlocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	domains := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[normalizeEmailDomain(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &EmailDomainBlocklist{domains: domains}, nil
+}
+
+// normalizeEmailDomain はドメインを小文字にし、前後の "@" や "." を取り除く
+func normalizeEmailDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), "@.")
+}
+
+// Blocked はメールアドレスのドメイン (またはその親ドメイン) がリストに含まれるかを返す
+// (mail.example.com は example.com が登録されていれば拒否する)
+func (b *EmailDomainBlocklist) Blocked(email string) bool {
+	if b == nil {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := normalizeEmailDomain(email[at+1:])
+	for domain != "" {
+		if b.domains[domain] {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
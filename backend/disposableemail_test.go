@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeEmailBlocklist はテスト用のドメインリストを書き出してそのパスを返す
+func writeEmailBlocklist(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disposable.txt")
+	if err := os.WriteFile(path, []byte("# throwaway domains\n\nMailinator.com\n@tempmail.dev.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEmailDomainBlocklist(t *testing.T) {
+	b, err := loadEmailDomainBlocklist(writeEmailBlocklist(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for email, want := range map[string]bool{
+		"spam@mailinator.com":      true,
+		"spam@MAILINATOR.COM":      true,
+		"spam@eu.mailinator.com":   true, // サブドメイン
+		"spam@tempmail.dev":        true,
+		"user@example.com":         false,
+		"user@notmailinator.com":   false,
+		"user@mailinator.com.evil": false,
+		"no-at-sign":               false,
+	} {
+		if got := b.Blocked(email); got != want {
+			t.Errorf("Blocked(%q) = %v, want %v", email, got, want)
+		}
+	}
+
+	var none *EmailDomainBlocklist
+	if none.Blocked("spam@mailinator.com") {
+		t.Error("nil blocklist blocked an address")
+	}
+}
+
+func TestDisposableEmailIsRejected(t *testing.T) {
+	s := newTestServer(t, "DISPOSABLE_EMAIL_BLOCKLIST="+writeEmailBlocklist(t))
+	blocked := s.auth.addUser(fakeAuthUser{UID: "spammer", DisplayName: "Spammer", Email: "x@mailinator.com", EmailVerified: true})
+	allowed := s.addUser("alice")
+
+	var res map[string]string
+	s.callJSON(t, http.MethodPost, "/api/profile", blocked, map[string]string{"display_name": "Spammer2"}, http.StatusForbidden, &res)
+	if res["message"] != disposableEmailMessage {
+		t.Errorf("message = %q", res["message"])
+	}
+	if status, _ := s.uploadTrack(t, blocked, "Spam", testMP3(time.Second)); status != http.StatusForbidden {
+		t.Errorf("upload with a disposable email: status %d, want 403", status)
+	}
+	if status, body := s.uploadTrack(t, allowed, "Song", testMP3(time.Second)); status != http.StatusOK {
+		t.Errorf("upload with a permanent email: status %d (%s)", status, body)
+	}
+
+	// トークンにメールアドレスがない場合は Auth から取得して判定する
+	noEmailClaim := s.auth.token(fakeAuthUser{UID: "spammer", DisplayName: "Spammer", EmailVerified: true})
+	s.callJSON(t, http.MethodPost, "/api/profile", noEmailClaim, map[string]string{"display_name": "Spammer2"}, http.StatusForbidden, nil)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// emailJob は送信待ちのメール1通
+type emailJob struct {
+	to      []string
+	subject string
+	body    string
+}
+
+// defaultEmailQueueMax は送信待ち行列に積めるメールの件数の既定値 (EMAIL_QUEUE_MAX で変更できる)
+const defaultEmailQueueMax = 1000
+
+// emailDrainTimeout はシャットダウン時に送信待ちのメールを送りきるまで待つ時間の上限
+const emailDrainTimeout = 10 * time.Second
+
+// mailQueue は通知メールの送信待ち行列
+// メールプロバイダーの送信レート制限に引っかからないよう、ワーカーが一定の間隔で1通ずつ送信する
+// 行列が上限に達している場合は新しいメールを捨ててログに残す (メモリを使い果たさないように)
+type mailQueue struct {
+	sync.Mutex
+	cond    *sync.Cond
+	jobs    []emailJob
+	max     int
+	sending int // ワーカーが取り出して送信中の件数
+	dropped int // 上限を超えて捨てた件数
+}
+
+func newMailQueue(max int) *mailQueue {
+	q := &mailQueue{max: max}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// emailQueue はサーバー全体で共有する送信待ち行列
+var emailQueue = newMailQueue(defaultEmailQueueMax)
+
+// setMax は行列に積めるメールの件数を変更する (1未満は1とみなす)
+func (q *mailQueue) setMax(max int) {
+	if max < 1 {
+		max = 1
+	}
+	q.Lock()
+	q.max = max
+	q.Unlock()
+}
+
+// push はメールを行列に追加する。行列が上限に達している場合は追加せずに false を返す
+func (q *mailQueue) push(job emailJob) bool {
+	q.Lock()
+	if len(q.jobs) >= q.max {
+		q.dropped++
+		q.Unlock()
+		return false
+	}
+	q.jobs = append(q.jobs, job)
+	q.Unlock()
+	q.cond.Signal()
+	return true
+}
+
+// pop は行列の先頭のメールを取り出す (空の場合は積まれるまで待つ)
+// 送信が終わったら done を呼ぶこと
+func (q *mailQueue) pop() emailJob {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.jobs) == 0 {
+		q.cond.Wait()
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	q.sending++
+	return job
+}
+
+// done は pop で取り出したメールの送信が終わったことを記録する
+func (q *mailQueue) done() {
+	q.Lock()
+	q.sending--
+	q.Unlock()
+}
+
+// depth は送信待ちのメールの件数を返す
+func (q *mailQueue) depth() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.jobs)
+}
+
+// droppedCount は上限を超えて捨てたメールの件数を返す
+func (q *mailQueue) droppedCount() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.dropped
+}
+
+// drain は送信待ちと送信中のメールがなくなるまで待つ
+// ctx の期限までに送りきれなかった場合は、残りの件数を返す
+func (q *mailQueue) drain(ctx context.Context) int {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		q.Lock()
+		remaining := len(q.jobs) + q.sending
+		q.Unlock()
+		if remaining == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return remaining
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueEmail はメールを送信待ち行列に追加する (送信はワーカーが非同期に行う)
+// 行列が上限に達している場合は送信せずにログに残す
+func queueEmail(to []string, subject, body string) {
+	if !emailQueue.push(emailJob{to: to, subject: subject, body: body}) {
+		log.Printf("Email queue is full; dropping email %q to %d recipient(s)", subject, len(to))
+	}
+}
+
+// emailQueueDepth は送信待ちのメールの件数を返す
+func emailQueueDepth() int {
+	return emailQueue.depth()
+}
+
+// drainEmailQueue はシャットダウン時に、送信待ちのメールを emailDrainTimeout まで送り続ける
+// 送りきれなかったメールは件数をログに残す
+func drainEmailQueue() {
+	ctx, cancel := context.WithTimeout(context.Background(), emailDrainTimeout)
+	defer cancel()
+	if remaining := emailQueue.drain(ctx); remaining > 0 {
+		log.Printf("Shutting down with %d email(s) still queued", remaining)
+	}
+}
+
+// emailRateLimiter は送信レートのトークンバケット (1宛先につきトークンを1つ使う)
+// 最大 ratePerMinute 通までは続けて送れる
+type emailRateLimiter struct {
+	ratePerMinute int
+	tokens        float64
+	last          time.Time
+}
+
+func newEmailRateLimiter(ratePerMinute int, now time.Time) *emailRateLimiter {
+	return &emailRateLimiter{ratePerMinute: ratePerMinute, tokens: float64(ratePerMinute), last: now}
+}
+
+// reserve は n 通分のトークンを予約し、送信する前に待つべき時間を返す
+// トークンが足りない場合は不足分を前借りし、その分は次の予約までの補充で返済する
+func (l *emailRateLimiter) reserve(now time.Time, n int) time.Duration {
+	interval := time.Minute / time.Duration(l.ratePerMinute)
+	l.tokens += now.Sub(l.last).Seconds() / interval.Seconds()
+	if l.tokens > float64(l.ratePerMinute) {
+		l.tokens = float64(l.ratePerMinute)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens * float64(interval))
+}
+
+// runEmailWorker は行列からメールを取り出して送信する
+// 宛先が複数あるメールは宛先ごとに1通ずつ送るため、レートも宛先の数で数える
+func runEmailWorker(ratePerMinute int) {
+	limiter := newEmailRateLimiter(ratePerMinute, time.Now())
+
+	for {
+		job := emailQueue.pop()
+
+		// トークンが足りなければ、宛先の数だけたまるまで待つ
+		time.Sleep(limiter.reserve(time.Now(), len(job.to)))
+
+		// 一部の宛先に送れなくても、残りの宛先には送信する (失敗した宛先だけをログに残す)
+		result := sendEmail(job.to, job.subject, job.body)
+		for addr, err := range result.Failed {
+			log.Printf("Failed to send email %q to %s: %v", job.subject, addr, err)
+		}
+		emailQueue.done()
+	}
+}
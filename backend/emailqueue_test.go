@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEmailRateLimiterChargesPerRecipient(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newEmailRateLimiter(60, start) // 1秒に1通
+
+	// バケットの容量 (60通) までは待たずに送れる
+	if wait := l.reserve(start, 50); wait != 0 {
+		t.Fatalf("first 50 recipients: wait %v, want 0", wait)
+	}
+	if wait := l.reserve(start, 10); wait != 0 {
+		t.Fatalf("next 10 recipients: wait %v, want 0", wait)
+	}
+	// 3つの宛先があるメールは3通分のトークンを待つ
+	if wait := l.reserve(start, 3); wait != 3*time.Second {
+		t.Fatalf("3 recipients over the limit: wait %v, want 3s", wait)
+	}
+	// 待ち時間が過ぎた後の1通は、さらに1秒待つ
+	if wait := l.reserve(start.Add(3*time.Second), 1); wait != time.Second {
+		t.Fatalf("next recipient: wait %v, want 1s", wait)
+	}
+	// 十分に時間が経てば容量まで回復する (それ以上はたまらない)
+	later := start.Add(time.Hour)
+	if wait := l.reserve(later, 60); wait != 0 {
+		t.Fatalf("after an hour: wait %v, want 0", wait)
+	}
+	if wait := l.reserve(later, 1); wait != time.Second {
+		t.Fatalf("bucket overfilled: wait %v, want 1s", wait)
+	}
+}
+
+func TestSendEmailReportsFailuresPerRecipient(t *testing.T) {
+	brevo := newFakeBrevo(t)
+	result := sendEmail([]string{"a@example.com", "invalid@example", "b@example.com"}, "Digest", "<p>hi</p>")
+	if result.Skipped {
+		t.Fatal("send was skipped")
+	}
+	if len(result.Sent) != 2 || result.Sent[0] != "a@example.com" || result.Sent[1] != "b@example.com" {
+		t.Errorf("Sent = %v", result.Sent)
+	}
+	if len(result.Failed) != 1 || result.Failed["invalid@example"] == nil {
+		t.Errorf("Failed = %v", result.Failed)
+	}
+	if sent := brevo.emails(); len(sent) != 2 {
+		t.Errorf("provider received %d emails, want 2", len(sent))
+	}
+
+	t.Setenv("BREVO_API_KEY", "")
+	if result := sendEmail([]string{"a@example.com"}, "Digest", ""); !result.Skipped {
+		t.Error("send without configuration was not skipped")
+	}
+}
+
+func TestEmailQueueDeliversBurst(t *testing.T) {
+	brevo := newFakeBrevo(t)
+	s := newTestServer(t, "EMAIL_RATE_PER_MINUTE=6000", "BREVO_API_KEY=test-key", "BREVO_SENDER_EMAIL=noreply@example.com")
+
+	// 一度に積まれたメールも捨てずにすべて送る
+	const n = 20
+	for i := 0; i < n; i++ {
+		queueEmail([]string{fmt.Sprintf("user%02d@example.com", i)}, "Burst", "<p>hi</p>")
+	}
+	sent := brevo.waitForEmails(t, n)
+	recipients := make(map[string]bool)
+	for _, m := range sent {
+		recipients[m.To] = true
+	}
+	if len(recipients) != n {
+		t.Errorf("%d distinct recipients, want %d", len(recipients), n)
+	}
+	if depth := emailQueueDepth(); depth != 0 {
+		t.Errorf("queue depth after delivery = %d, want 0", depth)
+	}
+
+	var status map[string]int
+	s.callJSON(t, http.MethodGet, "/api/admin/email-queue", s.addAdmin("admin"), nil, http.StatusOK, &status)
+	if status["depth"] != 0 || status["dropped"] != 0 || status["rate_per_minute"] != 6000 {
+		t.Errorf("email queue status = %v", status)
+	}
+	s.callJSON(t, http.MethodGet, "/api/admin/email-queue", s.addUser("alice"), nil, http.StatusForbidden, nil)
+}
+
+func TestMailQueueDropsWhenFull(t *testing.T) {
+	q := newMailQueue(2)
+	for i := 0; i < 3; i++ {
+		ok := q.push(emailJob{to: []string{fmt.Sprintf("user%d@example.com", i)}, subject: "Full"})
+		if want := i < 2; ok != want {
+			t.Errorf("push %d: ok = %v, want %v", i, ok, want)
+		}
+	}
+	if depth, dropped := q.depth(), q.droppedCount(); depth != 2 || dropped != 1 {
+		t.Errorf("depth %d, dropped %d; want 2 and 1", depth, dropped)
+	}
+	// 取り出した分だけ再び積める
+	q.pop()
+	if !q.push(emailJob{to: []string{"late@example.com"}, subject: "Full"}) {
+		t.Error("push after pop was rejected")
+	}
+}
+
+func TestMailQueueDrain(t *testing.T) {
+	q := newMailQueue(10)
+	for i := 0; i < 3; i++ {
+		q.push(emailJob{to: []string{fmt.Sprintf("user%d@example.com", i)}, subject: "Drain"})
+	}
+
+	// 送信するワーカーがいなければ、期限までに送りきれなかった件数を返す
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if remaining := q.drain(ctx); remaining != 3 {
+		t.Errorf("drain without a worker: %d remaining, want 3", remaining)
+	}
+
+	// 送信中のメールも送り終わるまで待つ
+	go func() {
+		for i := 0; i < 3; i++ {
+			q.pop()
+			time.Sleep(20 * time.Millisecond)
+			q.done()
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if remaining := q.drain(ctx); remaining != 0 {
+		t.Errorf("drain with a worker: %d remaining, want 0", remaining)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationGate(t *testing.T) {
+	for _, tt := range []struct {
+		env        string
+		wantStatus int
+	}{
+		{"REQUIRE_EMAIL_VERIFICATION=", http.StatusForbidden}, // 未設定なら必須
+		{"REQUIRE_EMAIL_VERIFICATION=true", http.StatusForbidden},
+		{"REQUIRE_EMAIL_VERIFICATION=false", http.StatusOK},
+	} {
+		t.Run(tt.env, func(t *testing.T) {
+			s := newTestServer(t, tt.env)
+			s.addUser("alice")
+			token := s.auth.addUser(fakeAuthUser{UID: "newbie", DisplayName: "Newbie"})
+			id := insertTrack(t, "alice", "Song")
+
+			s.callJSON(t, http.MethodPut, fmt.Sprintf("/api/track/%d/like", id), token, nil, tt.wantStatus, nil)
+			s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/comment", id), token, map[string]string{"content": "hi"}, tt.wantStatus, nil)
+			s.callJSON(t, http.MethodPut, "/api/user/alice/follow", token, nil, tt.wantStatus, nil)
+			s.callJSON(t, http.MethodPost, "/api/profile", token, map[string]string{"display_name": "Newbie Two"}, tt.wantStatus, nil)
+			if status, body := s.uploadTrack(t, token, "First", testMP3(time.Second)); status != tt.wantStatus {
+				t.Errorf("upload: status %d (%s), want %d", status, body, tt.wantStatus)
+			}
+
+			// 読み取りは認証の有無に関係なくできる
+			s.callJSON(t, http.MethodGet, "/api/me", token, nil, http.StatusOK, nil)
+		})
+	}
+}
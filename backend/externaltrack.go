@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// 外部URLのトラック: 音声を他のサービス (ポッドキャストのCDNなど) に置いたまま、メタデータだけを登録する
+// ストリーミングAPIは external_url にリダイレクトする
+
+// maxExternalURLLength は external_url の最大文字数
+const maxExternalURLLength = 2000
+
+// externalTrackFilenamePrefix は外部URLのトラックの tracks.filename に入れる値の接頭辞
+// filename は NOT NULL のため、ファイルを持たないトラックにも他と重複しない値を入れる (uploads ディレクトリには存在しない)
+const externalTrackFilenamePrefix = "external:"
+
+func externalTrackFilename() string {
+	return externalTrackFilenamePrefix + uuid.New().String()
+}
+
+// isExternalTrackFilename は tracks.filename が外部URLのトラックのもの (削除するファイルがない) かを返す
+func isExternalTrackFilename(name string) bool {
+	return strings.HasPrefix(name, externalTrackFilenamePrefix)
+}
+
+// validateExternalAudioURL は外部の音声URLが http(s) で、内部アドレスを指していないかを確認する
+func validateExternalAudioURL(raw string) *uploadError {
+	if len(raw) > maxExternalURLLength {
+		return badUpload("external_url is too long")
+	}
+	if err := validatePublicURL(raw); err != nil {
+		return badUpload("Invalid external_url: " + err.Error())
+	}
+	return nil
+}
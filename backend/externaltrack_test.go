@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExternalTrackUpload(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	// IPアドレスのホストは名前解決が不要なため、ネットワークのない環境でも検証できる
+	const externalURL = "https://192.0.2.10/podcast/ep1.mp3"
+
+	upload := func(fields map[string]string, files ...formFile) (int, string) {
+		t.Helper()
+		body, contentType := multipartForm(t, fields, files...)
+		req := s.newRequest(t, http.MethodPost, "/api/upload", token, body)
+		req.Header.Set("Content-Type", contentType)
+		resp, data := s.do(t, req)
+		return resp.StatusCode, string(data)
+	}
+
+	// 外部URLだけで登録でき、ストリーミングはリダイレクトする
+	if status, body := upload(map[string]string{"title": "Episode 1", "external_url": externalURL}); status != http.StatusOK {
+		t.Fatalf("external upload: status %d (%s)", status, body)
+	}
+	var id int
+	var filename string
+	if err := db.QueryRow("SELECT id, filename FROM tracks WHERE title = 'Episode 1'").Scan(&id, &filename); err != nil {
+		t.Fatal(err)
+	}
+	if !isExternalTrackFilename(filename) {
+		t.Errorf("external track filename = %q", filename)
+	}
+	resp, _ := s.call(t, http.MethodGet, fmt.Sprintf("/api/track/%d/stream", id), "", nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != externalURL {
+		t.Errorf("stream: status %d, Location %q; want 302 to %s", resp.StatusCode, resp.Header.Get("Location"), externalURL)
+	}
+	var track Track
+	s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d", id), "", nil, http.StatusOK, &track)
+	if track.ExternalURL != externalURL {
+		t.Errorf("track external_url = %q", track.ExternalURL)
+	}
+
+	// ファイルのアップロードは従来どおり
+	audio := testMP3(time.Second)
+	if status, body := upload(map[string]string{"title": "File"}, formFile{"file", "a.mp3", audio}); status != http.StatusOK {
+		t.Fatalf("file upload: status %d (%s)", status, body)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE title = 'File' AND external_url IS NULL"); n != 1 {
+		t.Error("file upload stored an external_url")
+	}
+
+	// ファイルと外部URLはどちらか一方だけ
+	if status, _ := upload(map[string]string{"title": "Both", "external_url": externalURL}, formFile{"file", "b.mp3", testMP3(2 * time.Second)}); status != http.StatusBadRequest {
+		t.Errorf("file and external_url: status %d, want 400", status)
+	}
+	if status, _ := upload(map[string]string{"title": "Neither"}); status != http.StatusBadRequest {
+		t.Errorf("neither file nor external_url: status %d, want 400", status)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks"); n != 2 {
+		t.Errorf("%d tracks stored, want 2", n)
+	}
+}
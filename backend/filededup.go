@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// sharedFileDedup は起動時に SHARED_FILE_DEDUP=true で有効にする
+// 有効な場合、内容が同じファイルはアップロードしたユーザーに関係なく1つだけ保存し、
+// files テーブルで参照しているトラックの数を数える (最後のトラックが削除されたときにファイルを削除する)
+var sharedFileDedup = false
+
+// storeUploadFile は r から読み込んだ音声データを保存し、tracks.filename に保存する相対パスを返す
+// sharedFileDedup が有効な場合は保存しながら内容のハッシュを求め、同じ内容のファイルが既にあれば
+// 保存したファイルを削除してそのファイルの参照数を増やす
+// 返したファイルが不要になった場合 (DBへの登録に失敗した場合など) は releaseUploadFile を呼ぶ
+func storeUploadFile(dir string, r io.Reader) (string, error) {
+	if !sharedFileDedup {
+		return saveUploadFile(dir, r)
+	}
+	hasher := sha256.New()
+	name, err := saveUploadFile(dir, io.TeeReader(r, hasher))
+	if err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if shared, ok, err := acquireSharedFile(hash); err != nil || ok {
+		removeUploadFile(dir, name)
+		return shared, err
+	}
+
+	// 同じ内容のファイルが同時にアップロードされた場合は、先に登録された方を使い、こちらで保存したファイルは削除する
+	if _, err := db.Exec("INSERT INTO files (content_hash, filename, ref_count) VALUES (?, ?, 1) ON CONFLICT(content_hash) DO NOTHING", hash, name); err != nil {
+		removeUploadFile(dir, name)
+		return "", err
+	}
+	var registered string
+	if err := db.QueryRow("SELECT filename FROM files WHERE content_hash = ?", hash).Scan(&registered); err != nil {
+		removeUploadFile(dir, name)
+		return "", err
+	}
+	if registered != name {
+		// 登録済みのファイルが直後に削除されていた場合は、こちらのファイルを登録せずにそのまま使う
+		// (files に登録されていないファイルは releaseUploadFile がそのまま削除する)
+		if shared, ok, err := acquireSharedFile(hash); err == nil && ok {
+			removeUploadFile(dir, name)
+			return shared, nil
+		}
+	}
+	return name, nil
+}
+
+// acquireSharedFile は hash の内容のファイルが登録されていれば参照数を増やしてファイル名を返す
+func acquireSharedFile(hash string) (string, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE files SET ref_count = ref_count + 1 WHERE content_hash = ?", hash)
+	if err != nil {
+		return "", false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", false, nil
+	}
+	var name string
+	if err := tx.QueryRow("SELECT filename FROM files WHERE content_hash = ?", hash).Scan(&name); err != nil {
+		return "", false, err
+	}
+	return name, true, tx.Commit()
+}
+
+// releaseUploadFile はトラックが参照しなくなったファイルを削除する
+// files テーブルに登録されているファイルは参照数を減らし、0 になったときだけ削除する
+// (登録されていないファイルは、SHARED_FILE_DEDUP を有効にする前に保存されたものとしてそのまま削除する)
+// 外部URLのトラックは削除するファイルがないため何もしない
+func releaseUploadFile(dir, name string) error {
+	if isExternalTrackFilename(name) {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE files SET ref_count = ref_count - 1 WHERE filename = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		var refCount int
+		if err := tx.QueryRow("SELECT ref_count FROM files WHERE filename = ?", name).Scan(&refCount); err != nil {
+			return err
+		}
+		if refCount > 0 {
+			return tx.Commit()
+		}
+		if _, err := tx.Exec("DELETE FROM files WHERE filename = ?", name); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return removeUploadFile(dir, name)
+}
+
+// dropTrackFilenameUnique は既存のデータベースの tracks.filename から UNIQUE 制約を外す (簡易マイグレーション)
+// SQLite は制約だけを削除できないため、同じ定義 (後から追加したカラムを含む) の UNIQUE なしのテーブルを作ってデータを移す
+// tracks のトリガーはテーブルと一緒に削除されるため、この後の createUserStatsSQL で作り直す
+func dropTrackFilenameUnique() error {
+	const uniqueColumn = "filename TEXT NOT NULL UNIQUE"
+	var createSQL string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'tracks'").Scan(&createSQL); err != nil {
+		return err
+	}
+	if !strings.Contains(createSQL, uniqueColumn) {
+		return nil
+	}
+	if !strings.HasPrefix(createSQL, "CREATE TABLE tracks") {
+		return fmt.Errorf("unexpected tracks schema: %s", createSQL)
+	}
+	rebuildSQL := strings.Replace(createSQL, uniqueColumn, "filename TEXT NOT NULL", 1)
+	rebuildSQL = strings.Replace(rebuildSQL, "CREATE TABLE tracks", "CREATE TABLE tracks_rebuild", 1)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// AUTOINCREMENT の続きの番号を引き継ぎ、削除済みのトラックの ID を再利用しないようにする
+	var seq sql.NullInt64
+	if err := tx.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'tracks'").Scan(&seq); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for _, stmt := range []string{
+		rebuildSQL,
+		"INSERT INTO tracks_rebuild SELECT * FROM tracks",
+		"DROP TABLE tracks",
+		"ALTER TABLE tracks_rebuild RENAME TO tracks",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	if seq.Valid {
+		if _, err := tx.Exec("DELETE FROM sqlite_sequence WHERE name = 'tracks'"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO sqlite_sequence (name, seq) VALUES ('tracks', ?)", seq.Int64); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Println("Migrated: Removed UNIQUE constraint from tracks.filename.")
+	return nil
+}
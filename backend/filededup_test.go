@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedFileRefCountLifecycle(t *testing.T) {
+	s := newTestServer(t, "SHARED_FILE_DEDUP=true")
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+	audio := testMP3(time.Second)
+
+	upload := func(token, title string, audio []byte) (int, string) {
+		t.Helper()
+		if status, body := s.uploadTrack(t, token, title, audio); status != http.StatusOK {
+			t.Fatalf("upload %s: status %d (%s)", title, status, body)
+		}
+		var id int
+		var filename string
+		if err := db.QueryRow("SELECT id, filename FROM tracks WHERE title = ?", title).Scan(&id, &filename); err != nil {
+			t.Fatal(err)
+		}
+		return id, filename
+	}
+	refCount := func(filename string) int {
+		t.Helper()
+		return queryInt(t, "SELECT ref_count FROM files WHERE filename = ?", filename)
+	}
+	exists := func(filename string) bool {
+		_, err := os.Stat(uploadFilePath(s.uploadsDir, filename))
+		return err == nil
+	}
+
+	aliceID, aliceFile := upload(alice, "Alice's copy", audio)
+	bobID, bobFile := upload(bob, "Bob's copy", audio)
+	if aliceFile != bobFile {
+		t.Fatalf("identical uploads stored as %s and %s, want one shared file", aliceFile, bobFile)
+	}
+	if n := refCount(aliceFile); n != 2 {
+		t.Errorf("ref_count = %d, want 2", n)
+	}
+	_, otherFile := upload(bob, "Different", testMP3(2*time.Second))
+	if otherFile == aliceFile || refCount(otherFile) != 1 {
+		t.Errorf("different audio shared a file or has ref_count %d", refCount(otherFile))
+	}
+
+	// 最初のトラックを削除してもファイルは残る
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d", aliceID), alice, nil, http.StatusOK, nil)
+	if !exists(aliceFile) || refCount(aliceFile) != 1 {
+		t.Fatalf("after the first deletion: exists %v, ref_count %d; want the file kept with 1 reference", exists(aliceFile), refCount(aliceFile))
+	}
+	if resp, _ := s.call(t, http.MethodGet, fmt.Sprintf("/api/track/%d/stream", bobID), "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("remaining track cannot be streamed: status %d", resp.StatusCode)
+	}
+
+	// 最後のトラックを削除するとファイルも消える
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d", bobID), bob, nil, http.StatusOK, nil)
+	if exists(aliceFile) {
+		t.Error("file still exists after the last reference was deleted")
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM files WHERE filename = ?", aliceFile); n != 0 {
+		t.Errorf("files row remains after the last reference was deleted")
+	}
+}
+
+func TestSharedFileDedupDisabled(t *testing.T) {
+	s := newTestServer(t)
+	audio := testMP3(time.Second)
+	for _, uid := range []string{"alice", "bob"} {
+		if status, body := s.uploadTrack(t, s.addUser(uid), uid+"'s copy", audio); status != http.StatusOK {
+			t.Fatalf("upload: status %d (%s)", status, body)
+		}
+	}
+	if n := queryInt(t, "SELECT COUNT(DISTINCT filename) FROM tracks"); n != 2 {
+		t.Errorf("%d distinct files, want 2 when SHARED_FILE_DEDUP is off", n)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM files"); n != 0 {
+		t.Errorf("files table has %d rows, want 0", n)
+	}
+}
+
+func TestTrackFilenameUniqueMigration(t *testing.T) {
+	// UNIQUE 制約のある古いスキーマのデータベースを用意する
+	dataDir := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old, err := sql.Open("sqlite3", filepath.Join(dataDir, "soundlike.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE tracks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			filename TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			artist TEXT,
+			lyrics TEXT,
+			uploader_uid TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"INSERT INTO tracks (id, filename, title, uploader_uid) VALUES (1, 'old.mp3', 'Old', 'alice'), (7, 'gone.mp3', 'Gone', 'alice')",
+		"DELETE FROM tracks WHERE id = 7",
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	s := newTestServer(t, "DATA_DIR="+dataDir, "SHARED_FILE_DEDUP=true")
+	var schema string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'tracks'").Scan(&schema); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(schema, "UNIQUE") {
+		t.Errorf("tracks.filename is still UNIQUE after migration: %s", schema)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE id = 1 AND filename = 'old.mp3'"); n != 1 {
+		t.Error("existing track was lost during migration")
+	}
+
+	audio := testMP3(time.Second)
+	for _, uid := range []string{"alice", "bob"} {
+		if status, body := s.uploadTrack(t, s.addUser(uid), uid+"'s copy", audio); status != http.StatusOK {
+			t.Fatalf("upload after migration: status %d (%s)", status, body)
+		}
+	}
+	// 削除済みの ID は再利用されない
+	if n := queryInt(t, "SELECT MIN(id) FROM tracks WHERE id > 1"); n != 8 {
+		t.Errorf("first new track has id %d, want 8", n)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type followState struct {
+	IsFollowing   bool `json:"is_following"`
+	FollowerCount int  `json:"follower_count"`
+}
+
+// concurrentFollow は同じリクエストを n 個同時に送り、全て 200 で返ることを確認する
+func (s *testServer) concurrentFollow(t *testing.T, method, token string, n int) []followState {
+	t.Helper()
+	states := make([]followState, n)
+	errs := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := s.client.Do(s.newRequest(t, method, "/api/user/alice/follow", token, nil))
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- resp.Status
+				return
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&states[i]); err != nil {
+				errs <- err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("%s /api/user/alice/follow: %s", method, err)
+	}
+	return states
+}
+
+func TestIdempotentFollowIsSafeUnderConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+	s.addUser("alice")
+
+	for _, st := range s.concurrentFollow(t, http.MethodPut, token, 10) {
+		if !st.IsFollowing || st.FollowerCount != 1 {
+			t.Errorf("PUT follow returned %+v, want following with 1 follower", st)
+		}
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM follows WHERE following_uid = 'alice'"); n != 1 {
+		t.Fatalf("follows rows = %d after concurrent PUTs, want 1", n)
+	}
+
+	for _, st := range s.concurrentFollow(t, http.MethodDelete, token, 10) {
+		if st.IsFollowing || st.FollowerCount != 0 {
+			t.Errorf("DELETE follow returned %+v, want not following with 0 followers", st)
+		}
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM follows WHERE following_uid = 'alice'"); n != 0 {
+		t.Fatalf("follows rows = %d after concurrent DELETEs, want 0", n)
+	}
+}
+
+func TestFollowVerbs(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+	s.addUser("alice")
+
+	// PUT / DELETE は繰り返しても同じ状態になる
+	var st followState
+	for i := 0; i < 2; i++ {
+		s.callJSON(t, http.MethodPut, "/api/user/alice/follow", token, nil, http.StatusOK, &st)
+		if !st.IsFollowing || st.FollowerCount != 1 {
+			t.Errorf("PUT #%d: %+v", i+1, st)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		s.callJSON(t, http.MethodDelete, "/api/user/alice/follow", token, nil, http.StatusOK, &st)
+		if st.IsFollowing || st.FollowerCount != 0 {
+			t.Errorf("DELETE #%d: %+v", i+1, st)
+		}
+	}
+	s.callJSON(t, http.MethodPut, "/api/user/bob/follow", token, nil, http.StatusBadRequest, nil)
+
+	// 従来の POST はトグルのまま
+	for _, want := range []bool{true, false} {
+		s.callJSON(t, http.MethodPost, "/api/user/alice/follow", token, nil, http.StatusOK, &st)
+		if st.IsFollowing != want {
+			t.Errorf("POST toggle: is_following = %v, want %v", st.IsFollowing, want)
+		}
+	}
+}
+
+func TestMutualFollows(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	for _, uid := range []string{"bob", "carol", "dave"} {
+		s.addUser(uid)
+	}
+	// alice ⇄ bob、alice ⇄ carol は相互。dave は一方的にフォローされているだけ、erin は alice をフォローしているだけ
+	mustExec(t, `INSERT INTO follows (follower_uid, following_uid, created_at) VALUES
+		('alice', 'bob', '2026-01-01 00:00:00'), ('bob', 'alice', '2026-01-01 00:00:00'),
+		('alice', 'carol', '2026-01-02 00:00:00'), ('carol', 'alice', '2026-01-01 00:00:00'),
+		('alice', 'dave', '2026-01-03 00:00:00'),
+		('erin', 'alice', '2026-01-03 00:00:00')`)
+
+	var page struct {
+		Users []UserSummary `json:"users"`
+		Total int           `json:"total"`
+	}
+	s.callJSON(t, http.MethodGet, "/api/user/alice/mutuals", "", nil, http.StatusOK, &page)
+	// 自分がフォローした日時の新しい順で、表示名を解決する
+	want := []UserSummary{{"carol", "User carol"}, {"bob", "User bob"}}
+	if page.Total != 2 || !reflect.DeepEqual(page.Users, want) {
+		t.Errorf("mutuals = %+v", page)
+	}
+	s.callJSON(t, http.MethodGet, "/api/user/alice/mutuals?limit=1&offset=1", "", nil, http.StatusOK, &page)
+	if page.Total != 2 || !reflect.DeepEqual(page.Users, want[1:]) {
+		t.Errorf("second page of mutuals = %+v", page)
+	}
+	s.callJSON(t, http.MethodGet, "/api/user/dave/mutuals", "", nil, http.StatusOK, &page)
+	if page.Total != 0 || len(page.Users) != 0 {
+		t.Errorf("dave's mutuals = %+v", page)
+	}
+	s.callJSON(t, http.MethodGet, "/api/user/alice/mutuals?limit=0", "", nil, http.StatusBadRequest, nil)
+
+	var status struct {
+		IsFollowing bool `json:"is_following"`
+		IsMutual    bool `json:"is_mutual"`
+	}
+	for uid, want := range map[string][2]bool{
+		"bob":  {true, true},
+		"dave": {true, false},
+		"erin": {false, false},
+	} {
+		s.callJSON(t, http.MethodGet, "/api/user/"+uid+"/follow/status", alice, nil, http.StatusOK, &status)
+		if status.IsFollowing != want[0] || status.IsMutual != want[1] {
+			t.Errorf("follow status for %s = %+v, want is_following %v, is_mutual %v", uid, status, want[0], want[1])
+		}
+	}
+}
+
+func TestFollowRecommendations(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	for _, uid := range []string{"bob", "carol", "dave", "erin"} {
+		s.addUser(uid)
+	}
+	// bob のトラック1件と dave のトラック2件にいいね。ghost は Auth から削除済み
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('alice', ?), ('alice', ?), ('alice', ?), ('alice', ?), ('alice', ?)",
+		insertTrack(t, "bob", "B"), insertTrack(t, "dave", "D1"), insertTrack(t, "dave", "D2"),
+		insertTrack(t, "ghost", "G"), insertTrack(t, "alice", "Mine"))
+	// フォロー中の carol がフォローしている dave / erin / alice (自分自身) と、フォロー済みの carol
+	mustExec(t, `INSERT INTO follows (follower_uid, following_uid) VALUES
+		('alice', 'carol'), ('carol', 'dave'), ('carol', 'erin'), ('carol', 'alice'), ('bob', 'carol')`)
+
+	type recommendation struct {
+		UserSummary
+		Score int `json:"score"`
+	}
+	var got []recommendation
+	s.callJSON(t, http.MethodGet, "/api/recommendations/users", alice, nil, http.StatusOK, &got)
+	want := []recommendation{
+		{UserSummary{"dave", "User dave"}, 5},
+		{UserSummary{"bob", "User bob"}, 2},
+		{UserSummary{"erin", "User erin"}, 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recommendations = %+v, want %+v", got, want)
+	}
+
+	// フォローするとおすすめから外れる
+	s.callJSON(t, http.MethodPut, "/api/user/dave/follow", alice, nil, http.StatusOK, nil)
+	s.callJSON(t, http.MethodGet, "/api/recommendations/users", alice, nil, http.StatusOK, &got)
+	if len(got) != 2 || got[0].UID != "bob" || got[1].UID != "erin" {
+		t.Errorf("recommendations after following dave = %+v", got)
+	}
+
+	// 最大20人まで (削除済みの ghost は上位20人の枠を使うため、ここでは候補から外しておく)
+	mustExec(t, "DELETE FROM likes WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = 'ghost')")
+	for i := 0; i < 25; i++ {
+		uid := fmt.Sprintf("user%02d", i)
+		s.addUser(uid)
+		mustExec(t, "INSERT INTO follows (follower_uid, following_uid) VALUES ('carol', ?)", uid)
+	}
+	s.callJSON(t, http.MethodGet, "/api/recommendations/users", alice, nil, http.StatusOK, &got)
+	if len(got) != 20 {
+		t.Errorf("%d recommendations, want 20", len(got))
+	}
+
+	// 何もフォロー・いいねしていなければ空配列
+	s.callJSON(t, http.MethodGet, "/api/recommendations/users", s.addUser("newbie"), nil, http.StatusOK, &got)
+	if got == nil || len(got) != 0 {
+		t.Errorf("recommendations for a new user = %#v, want []", got)
+	}
+	s.callJSON(t, http.MethodGet, "/api/recommendations/users", "", nil, http.StatusUnauthorized, nil)
+}
+
+func TestTrackDetailUploaderFollows(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+	carol := s.addUser("carol")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d", id)
+
+	s.callJSON(t, http.MethodPut, "/api/user/alice/follow", bob, nil, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPut, "/api/user/alice/follow", carol, nil, http.StatusOK, nil)
+	s.callJSON(t, http.MethodDelete, "/api/user/alice/follow", carol, nil, http.StatusOK, nil)
+
+	var detail TrackDetail
+	// 未ログインでは is_following_uploader を返さない
+	s.callJSON(t, http.MethodGet, path, "", nil, http.StatusOK, &detail)
+	if detail.FollowerCount != 1 || detail.IsFollowingUploader != nil || detail.Title != "Song" {
+		t.Errorf("anonymous detail = %+v", detail)
+	}
+	for token, want := range map[string]bool{bob: true, carol: false, alice: false} {
+		detail = TrackDetail{}
+		s.callJSON(t, http.MethodGet, path, token, nil, http.StatusOK, &detail)
+		if detail.FollowerCount != 1 || detail.IsFollowingUploader == nil || *detail.IsFollowingUploader != want {
+			t.Errorf("detail = %+v, want follower_count 1 and is_following_uploader %v", detail, want)
+		}
+	}
+	s.callJSON(t, http.MethodGet, "/api/track/999999", "", nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackListIsGzipCompressed(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 50; i++ {
+		insertTrack(t, "alice", fmt.Sprintf("Track number %d with a reasonably long title", i))
+	}
+
+	req := s.newRequest(t, http.MethodGet, "/api/tracks?limit=50", "", nil)
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, plain := s.do(t, req)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("identity response: %d, Content-Encoding %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+
+	req = s.newRequest(t, http.MethodGet, "/api/tracks?limit=50", "", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, compressed := s.do(t, req)
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	if len(compressed)*2 > len(plain) {
+		t.Errorf("gzip response is %d bytes, plain is %d bytes; expected at least 50%% reduction", len(compressed), len(plain))
+	}
+	zr, err := gzip.NewReader(strings.NewReader(string(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != string(plain) {
+		t.Error("decompressed response differs from the identity response")
+	}
+}
+
+func TestAudioIsNotGzipCompressed(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertTrackWithAudio(t, "alice", "Audio", testMP3(2*time.Second))
+	var filename string
+	if err := db.QueryRow("SELECT filename FROM tracks WHERE id = ?", id).Scan(&filename); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{fmt.Sprintf("/api/track/%d/stream", id), "/uploads/" + filename} {
+		req := s.newRequest(t, http.MethodGet, path, "", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, _ := s.do(t, req)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+			t.Errorf("GET %s: Content-Encoding = %q, want none", path, enc)
+		}
+	}
+}
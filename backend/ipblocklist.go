@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// uploadIPBlockedMessage はブロックリストに含まれる IP アドレスからのアップロードを拒否したときのメッセージ
+const uploadIPBlockedMessage = "Uploads from your network are not allowed."
+
+// IPBlocklist はアップロードを拒否する IP アドレス・CIDR の一覧
+// 再デプロイせずに更新できるよう、SIGHUP を受けたときと一定間隔でファイルを読み直す
+// nil の場合は何もしない (UPLOAD_IP_BLOCKLIST が未設定のとき)
+type IPBlocklist struct {
+	path string
+
+	mu      sync.RWMutex
+	ranges  []*net.IPNet
+	modTime time.Time
+}
+
+// loadIPBlocklist は1行1件 (IP アドレスまたは CIDR) のリストを読み込む (空行と # で始まる行は無視)
+func loadIPBlocklist(path string) (*IPBlocklist, error) {
+	b := &IPBlocklist{path: path}
+	if err := b.reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// reload はファイルを読み直す。読み込みに失敗した場合は、それまでの一覧をそのまま使う
+func (b *IPBlocklist) reload() error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var ranges []*net.IPNet
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ipNet, err := parseIPRange(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.ranges = ranges
+	b.modTime = info.ModTime()
+	b.mu.Unlock()
+	return nil
+}
+
+// Len は読み込んでいる件数を返す
+func (b *IPBlocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.ranges)
+}
+
+// Blocked は ip がリストのいずれかの範囲に含まれるかを返す
+func (b *IPBlocklist) Blocked(ip string) bool {
+	if b == nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ipNet := range b.ranges {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch は SIGHUP を受けたとき、および interval ごとにファイルが更新されていれば読み直す (interval が 0 なら SIGHUP のみ)
+func (b *IPBlocklist) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			info, err := os.Stat(b.path)
+			if err != nil {
+				log.Printf("error checking upload IP blocklist: %v", err)
+				continue
+			}
+			b.mu.RLock()
+			unchanged := info.ModTime().Equal(b.modTime)
+			b.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+		}
+		if err := b.reload(); err != nil {
+			log.Printf("error reloading upload IP blocklist (keeping the previous list): %v", err)
+			continue
+		}
+		log.Printf("Reloaded upload IP blocklist: %d entries", b.Len())
+	}
+}
+
+// middleware はブロックリストに含まれる IP アドレス (c.RealIP() で求めたクライアントの IP) からのリクエストを 403 で拒否する
+func (b *IPBlocklist) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if ip := c.RealIP(); b.Blocked(ip) {
+				log.Printf("Rejected upload from blocklisted IP: %s", ip)
+				return c.JSON(http.StatusForbidden, map[string]string{"message": uploadIPBlockedMessage})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeIPBlocklist はブロックリストを書き出してそのパスを返す
+func writeIPBlocklist(t *testing.T, path, list string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "blocklist.txt")
+	}
+	if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIPBlocklist(t *testing.T) {
+	path := writeIPBlocklist(t, "", "# abusive sources\n203.0.113.7\n\n198.51.100.0/24\n2001:db8::/32\n")
+	b, err := loadIPBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"203.0.113.7":   true,
+		"203.0.113.8":   false,
+		"198.51.100.42": true,
+		"2001:db8::1":   true,
+		"2001:db9::1":   false,
+		"not an ip":     false,
+	} {
+		if got := b.Blocked(ip); got != want {
+			t.Errorf("Blocked(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	// 読み直すと新しい一覧に入れ替わる
+	writeIPBlocklist(t, path, "192.0.2.0/24\n")
+	if err := b.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Blocked("203.0.113.7") || !b.Blocked("192.0.2.1") {
+		t.Error("reload did not replace the list")
+	}
+
+	// 不正な行がある場合はエラーにし、それまでの一覧を使い続ける
+	writeIPBlocklist(t, path, "192.0.2.0/24\nnot-an-ip\n")
+	if err := b.reload(); err == nil {
+		t.Error("reload accepted an invalid line")
+	}
+	if !b.Blocked("192.0.2.1") || b.Len() != 1 {
+		t.Error("failed reload discarded the previous list")
+	}
+
+	// 未設定 (nil) なら何も拒否しない
+	var none *IPBlocklist
+	if none.Blocked("203.0.113.7") {
+		t.Error("nil blocklist blocked an address")
+	}
+}
+
+func TestUploadIPBlocklist(t *testing.T) {
+	list := writeIPBlocklist(t, "", "198.51.100.0/24\n")
+	s := newTestServer(t, "UPLOAD_IP_BLOCKLIST="+list, "UPLOAD_IP_BLOCKLIST_RELOAD_SECONDS=0", "TRUSTED_PROXIES=127.0.0.1")
+	token := s.addUser("alice")
+	audio := testMP3(time.Second)
+
+	upload := func(clientIP, title string) int {
+		t.Helper()
+		body, contentType := multipartForm(t, map[string]string{"title": title}, formFile{"file", "a.mp3", audio})
+		req := s.newRequest(t, http.MethodPost, "/api/upload", token, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Forwarded-For", clientIP)
+		resp, _ := s.do(t, req)
+		return resp.StatusCode
+	}
+
+	if status := upload("198.51.100.9", "Blocked"); status != http.StatusForbidden {
+		t.Errorf("upload from a blocklisted IP: status %d, want 403", status)
+	}
+	if status := upload("203.0.113.5", "Allowed"); status != http.StatusOK {
+		t.Errorf("upload from an unlisted IP: status %d, want 200", status)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE title = 'Blocked'"); n != 0 {
+		t.Error("blocklisted upload was stored")
+	}
+	// ブロックリストはアップロードだけに適用する
+	req := s.newRequest(t, http.MethodGet, "/api/tracks", "", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	if resp, _ := s.do(t, req); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/tracks from a blocklisted IP: status %d, want 200", resp.StatusCode)
+	}
+}
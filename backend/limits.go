@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// 入力値の長さ制限のデフォルト値 (文字数。日本語などのマルチバイト文字も1文字と数える)
+const (
+	defaultMaxTitleLength        = 100
+	defaultMaxArtistLength       = 100
+	defaultMaxLyricsLength       = 10000
+	defaultMaxSyncedLyricsLength = 20000
+	defaultMaxCommentLength      = 500
+	defaultMaxDisplayNameLength  = 30
+)
+
+// lengthLimits は入力値の長さ制限 (GET /api/config でクライアントにも公開する)
+type lengthLimits struct {
+	Title        int `json:"title"`
+	Artist       int `json:"artist"`
+	Lyrics       int `json:"lyrics"`
+	SyncedLyrics int `json:"synced_lyrics"`
+	Comment      int `json:"comment"`
+	DisplayName  int `json:"display_name"`
+}
+
+// limits は起動時に loadLengthLimits で環境変数から設定する
+var limits = lengthLimits{
+	Title:        defaultMaxTitleLength,
+	Artist:       defaultMaxArtistLength,
+	Lyrics:       defaultMaxLyricsLength,
+	SyncedLyrics: defaultMaxSyncedLyricsLength,
+	Comment:      defaultMaxCommentLength,
+	DisplayName:  defaultMaxDisplayNameLength,
+}
+
+// loadLengthLimits は MAX_*_LENGTH 環境変数で上書きした長さ制限を返す
+// (envInt と違い、不正な値はデフォルト値で続行せずにエラーとし、設定ミスに起動時に気付けるようにする)
+func loadLengthLimits() (lengthLimits, error) {
+	l := limits
+	for _, v := range []struct {
+		key string
+		dst *int
+	}{
+		{"MAX_TITLE_LENGTH", &l.Title},
+		{"MAX_ARTIST_LENGTH", &l.Artist},
+		{"MAX_LYRICS_LENGTH", &l.Lyrics},
+		{"MAX_SYNCED_LYRICS_LENGTH", &l.SyncedLyrics},
+		{"MAX_COMMENT_LENGTH", &l.Comment},
+		{"MAX_DISPLAY_NAME_LENGTH", &l.DisplayName},
+	} {
+		value := os.Getenv(v.key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return l, fmt.Errorf("invalid value for %s (%q): must be a positive integer", v.key, value)
+		}
+		*v.dst = n
+	}
+	return l, nil
+}
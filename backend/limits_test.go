@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLengthLimits(t *testing.T) {
+	limits = initialLimits
+	t.Setenv("MAX_COMMENT_LENGTH", "20")
+	l, err := loadLengthLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Comment != 20 || l.Title != defaultMaxTitleLength {
+		t.Errorf("limits = %+v, want comment 20 and the default title", l)
+	}
+
+	for _, v := range []string{"0", "-1", "abc", "1.5"} {
+		t.Setenv("MAX_COMMENT_LENGTH", v)
+		if _, err := loadLengthLimits(); err == nil || !strings.Contains(err.Error(), "MAX_COMMENT_LENGTH") {
+			t.Errorf("MAX_COMMENT_LENGTH=%q: error %v", v, err)
+		}
+	}
+}
+
+func TestLengthLimitOverrides(t *testing.T) {
+	s := newTestServer(t, "MAX_COMMENT_LENGTH=10", "MAX_TITLE_LENGTH=5")
+	owner := s.addUser("alice")
+	id := insertTrack(t, "alice", "Song")
+
+	var config struct {
+		Limits lengthLimits `json:"limits"`
+	}
+	s.callJSON(t, http.MethodGet, "/api/config", "", nil, http.StatusOK, &config)
+	if config.Limits.Comment != 10 || config.Limits.Title != 5 || config.Limits.Artist != defaultMaxArtistLength {
+		t.Errorf("GET /api/config limits = %+v", config.Limits)
+	}
+
+	commentPath := fmt.Sprintf("/api/track/%d/comment", id)
+	// マルチバイト文字も1文字と数える
+	s.callJSON(t, http.MethodPost, commentPath, owner, map[string]string{"content": strings.Repeat("あ", 10)}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPost, commentPath, owner, map[string]string{"content": strings.Repeat("a", 11)}, http.StatusBadRequest, nil)
+
+	trackPath := fmt.Sprintf("/api/track/%d", id)
+	s.callJSON(t, http.MethodPatch, trackPath, owner, map[string]string{"title": "Short"}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPatch, trackPath, owner, map[string]string{"title": "Longer"}, http.StatusBadRequest, nil)
+}
+
+func TestLengthLimitsCountRunes(t *testing.T) {
+	limits = initialLimits
+	// 3バイトの「あ」をちょうど上限の文字数まで並べた値は受け付け、1文字でも超えたら拒否する
+	validators := map[string]struct {
+		max      int
+		validate func(string) bool
+	}{
+		"title":        {defaultMaxTitleLength, func(s string) bool { _, err := validateTrackTitle(s, nil); return err == nil }},
+		"artist":       {defaultMaxArtistLength, func(s string) bool { _, err := validateTrackArtist(s, nil); return err == nil }},
+		"lyrics":       {defaultMaxLyricsLength, func(s string) bool { return validateTrackLyrics(s) == nil }},
+		"comment":      {defaultMaxCommentLength, func(s string) bool { _, msg := validateCommentContent(nil, s); return msg == "" }},
+		"display name": {defaultMaxDisplayNameLength, func(s string) bool { _, reason := validateDisplayName(nil, s); return reason == "" }},
+	}
+	for name, v := range validators {
+		if !v.validate(strings.Repeat("あ", v.max)) {
+			t.Errorf("%s: %d multibyte characters were rejected", name, v.max)
+		}
+		if v.validate(strings.Repeat("あ", v.max+1)) {
+			t.Errorf("%s: %d multibyte characters were accepted", name, v.max+1)
+		}
+		// 絵文字 (4バイト) と ASCII の混在も文字数で数える
+		if !v.validate("a" + strings.Repeat("🎵", v.max-1)) {
+			t.Errorf("%s: %d mixed characters were rejected", name, v.max)
+		}
+	}
+}
+
+func TestMultibyteTitleUpload(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	audio := testMP3(time.Second)
+	if status, body := s.uploadTrack(t, token, strings.Repeat("曲", defaultMaxTitleLength), audio); status != http.StatusOK {
+		t.Errorf("upload with a %d-character Japanese title: status %d (%s)", defaultMaxTitleLength, status, body)
+	}
+	if status, _ := s.uploadTrack(t, token, strings.Repeat("曲", defaultMaxTitleLength+1), audio); status != http.StatusBadRequest {
+		t.Errorf("upload with a %d-character Japanese title: status %d, want 400", defaultMaxTitleLength+1, status)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseFrontendURL は FRONTEND_URL を検証し、末尾のスラッシュを除いた正規の形にする
+// スキーム (http/https) とホストがない場合はエラーを返す (メール内のリンクが壊れるのを防ぐ)
+func parseFrontendURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("FRONTEND_URL %q is not a valid URL: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("FRONTEND_URL %q must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("FRONTEND_URL %q must include a host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("FRONTEND_URL %q must not include credentials, a query or a fragment", raw)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// frontendLink はフロントエンドのURLにパスを連結する (各要素はエスケープされる)
+// url.JoinPath は要素に含まれる "/" をそのまま区切りとして扱うため、要素ごとに PathEscape する
+func frontendLink(frontendURL string, elem ...string) string {
+	u, err := url.Parse(frontendURL)
+	if err != nil {
+		// parseFrontendURL で検証済みのため通常は起きない
+		return frontendURL
+	}
+	rawPath := u.EscapedPath()
+	for _, e := range elem {
+		u.Path += "/" + e
+		rawPath += "/" + url.PathEscape(e)
+	}
+	u.RawPath = rawPath
+	return u.String()
+}
+
+// trackPageURL はフロントエンド上のトラックページのURLを返す
+func trackPageURL(frontendURL string, trackID int) string {
+	return frontendLink(frontendURL, "track", strconv.Itoa(trackID))
+}
+
+// userPageURL はフロントエンド上のユーザーページのURLを返す
+func userPageURL(frontendURL, uid string) string {
+	return frontendLink(frontendURL, "user", uid)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// replayGainReference は ReplayGain 2.0 の基準ラウドネス (LUFS)
+// 推奨ゲインはこの値とトラックの統合ラウドネスの差
+const replayGainReference = -18.0
+
+// measureReplayGain は保存した MP3 をデコードして推奨ゲイン (dB) を求める
+// デコードできない場合や無音のトラックは NULL を返す (プレイヤーは音量を補正しない)
+func measureReplayGain(path string) sql.NullFloat64 {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("error opening %s for loudness measurement: %v\n", path, err)
+		return sql.NullFloat64{}
+	}
+	defer f.Close()
+
+	// ファイル全体を先に走査しないよう、Seeker ではない reader としてデコーダーに渡す
+	decoder, err := mp3.NewDecoder(bufio.NewReader(f))
+	if err != nil {
+		log.Printf("Skipping loudness measurement for %s: %v", path, err)
+		return sql.NullFloat64{}
+	}
+	lufs, ok := integratedLoudness(decoder, decoder.SampleRate())
+	if !ok {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: math.Round((replayGainReference-lufs)*100) / 100, Valid: true}
+}
+
+// biquad は K 特性フィルターの1段分 (直接形 II 転置)
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeightingFilters は ITU-R BS.1770 の K 特性 (高域シェルフ + 高域通過) を sampleRate 用に設計する
+// 係数は 48kHz 用に規定されているため、他のサンプルレートでは同じアナログ特性から求め直す
+func kWeightingFilters(sampleRate int) (biquad, biquad) {
+	fs := float64(sampleRate)
+
+	const shelfGain, shelfFreq, shelfQ = 3.999843853973347, 1681.974450955533, 0.7071752369554196
+	k := math.Tan(math.Pi * shelfFreq / fs)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	const highPassFreq, highPassQ = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * highPassFreq / fs)
+	a0 = 1 + k/highPassQ + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highPassQ + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// integratedLoudness は 16bit リトルエンディアンのステレオ PCM (go-mp3 の出力形式) から
+// EBU R128 の統合ラウドネス (LUFS) を求める
+// 400ms のブロックを 100ms ずつずらして測り、-70 LUFS の絶対ゲートと -10 LU の相対ゲートを適用する
+// ゲートを通過するブロックがない (無音など) 場合は ok=false
+func integratedLoudness(pcm io.Reader, sampleRate int) (lufs float64, ok bool) {
+	if sampleRate <= 0 {
+		return 0, false
+	}
+	var filters [2][2]biquad
+	for ch := range filters {
+		filters[ch][0], filters[ch][1] = kWeightingFilters(sampleRate)
+	}
+
+	// 100ms ごとの各チャンネルの二乗和 (4つ合わせると 400ms のブロックになる)
+	stepSamples := sampleRate / 10
+	var steps [][2]float64
+	var current [2]float64
+	n := 0
+	// モノラルの MP3 も両チャンネルに同じ値が出力されるため、左右が常に一致する場合は1チャンネルとして数える
+	mono := true
+
+	buf := make([]byte, 4*4096)
+	var pending []byte
+	for {
+		read, err := pcm.Read(buf)
+		data := append(pending, buf[:read]...)
+		for len(data) >= 4 {
+			left := int16(binary.LittleEndian.Uint16(data[0:2]))
+			right := int16(binary.LittleEndian.Uint16(data[2:4]))
+			data = data[4:]
+			if left != right {
+				mono = false
+			}
+			for ch, sample := range [2]int16{left, right} {
+				y := filters[ch][1].process(filters[ch][0].process(float64(sample) / 32768))
+				current[ch] += y * y
+			}
+			n++
+			if n == stepSamples {
+				steps = append(steps, current)
+				current = [2]float64{}
+				n = 0
+			}
+		}
+		pending = append(pending[:0], data...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 途中で壊れている場合は、そこまでにデコードできた部分で測る
+			log.Printf("Loudness measurement stopped early: %v", err)
+			break
+		}
+	}
+
+	var blocks []float64
+	for i := 3; i < len(steps); i++ {
+		var power float64
+		for ch := 0; ch < 2; ch++ {
+			if mono && ch == 1 {
+				break
+			}
+			power += (steps[i-3][ch] + steps[i-2][ch] + steps[i-1][ch] + steps[i][ch]) / float64(4*stepSamples)
+		}
+		if power > 0 && loudnessOf(power) > -70 {
+			blocks = append(blocks, power)
+		}
+	}
+	if len(blocks) == 0 {
+		return 0, false
+	}
+
+	relativeGate := loudnessOf(mean(blocks)) - 10
+	var gated []float64
+	for _, power := range blocks {
+		if loudnessOf(power) > relativeGate {
+			gated = append(gated, power)
+		}
+	}
+	return loudnessOf(mean(gated)), true
+}
+
+// loudnessOf は K 特性をかけた平均二乗値をラウドネス (LUFS) に変換する
+func loudnessOf(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sinePCM は go-mp3 の出力形式 (16bit リトルエンディアンのステレオ) の 1kHz の正弦波を作る
+// invertRight を指定すると右チャンネルを逆位相にする (左右が一致しないステレオ)
+func sinePCM(sampleRate int, amplitude float64, d time.Duration, invertRight bool) []byte {
+	n := int(d.Seconds() * float64(sampleRate))
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		v := int16(amplitude * 32767 * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate)))
+		right := v
+		if invertRight {
+			right = -v
+		}
+		binary.Write(&buf, binary.LittleEndian, [2]int16{v, right})
+	}
+	return buf.Bytes()
+}
+
+func TestIntegratedLoudness(t *testing.T) {
+	// -20dBFS の 1kHz の正弦波は1チャンネルあたり -23 LUFS (BS.1770 の基準)
+	for _, tc := range []struct {
+		name        string
+		sampleRate  int
+		invertRight bool
+		want        float64
+	}{
+		{"mono 48kHz", 48000, false, -23.0},
+		{"mono 44.1kHz", 44100, false, -23.0},
+		{"stereo 48kHz", 48000, true, -20.0},
+	} {
+		lufs, ok := integratedLoudness(bytes.NewReader(sinePCM(tc.sampleRate, 0.1, 3*time.Second, tc.invertRight)), tc.sampleRate)
+		if !ok || math.Abs(lufs-tc.want) > 0.1 {
+			t.Errorf("%s: loudness = %.2f (ok %v), want about %.1f", tc.name, lufs, ok, tc.want)
+		}
+	}
+
+	// 無音や 400ms に満たない音声は測れない
+	if _, ok := integratedLoudness(bytes.NewReader(make([]byte, 4*48000)), 48000); ok {
+		t.Error("silence was measured")
+	}
+	if _, ok := integratedLoudness(bytes.NewReader(sinePCM(48000, 0.1, 300*time.Millisecond, false)), 48000); ok {
+		t.Error("audio shorter than one block was measured")
+	}
+}
+
+func TestReplayGainIsNullWhenUnmeasurable(t *testing.T) {
+	if gain := measureReplayGain(filepath.Join(t.TempDir(), "missing.mp3")); gain.Valid {
+		t.Errorf("gain for a missing file = %v", gain.Float64)
+	}
+
+	// テスト用の MP3 は無音のフレームのみのため、ゲインは null になる
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	if status, body := s.uploadTrack(t, token, "Quiet", testMP3(2*time.Second)); status != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", status, body)
+	}
+	var tracks []map[string]interface{}
+	s.callJSON(t, http.MethodGet, "/api/tracks", "", nil, http.StatusOK, &tracks)
+	if len(tracks) != 1 {
+		t.Fatalf("got %d tracks", len(tracks))
+	}
+	if gain, ok := tracks[0]["replay_gain_db"]; !ok || gain != nil {
+		t.Errorf("replay_gain_db = %v (present %v), want null", gain, ok)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE replay_gain_db IS NULL"); n != 1 {
+		t.Errorf("replay_gain_db stored for silent audio")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LyricLine は同期歌詞 (LRC) の1行
+type LyricLine struct {
+	TimeMs int    `json:"time_ms"`
+	Line   string `json:"line"`
+}
+
+var (
+	// [mm:ss] / [mm:ss.x] / [mm:ss.xx] / [mm:ss.xxx] 形式のタイムスタンプ
+	lrcTimestampRe = regexp.MustCompile(`^\[(\d{1,3}):([0-5]\d)(?:[.:](\d{1,3}))?\]`)
+	// [ar:Artist] や [offset:+500] などのメタデータタグ
+	lrcTagRe = regexp.MustCompile(`^\[([a-zA-Z#]+):(.*)\]$`)
+)
+
+// parseLRC はLRC形式の歌詞を解析し、時刻順に並べた行の一覧を返す
+// 書式が不正な行がある場合は、その行番号を含むエラーを返す
+func parseLRC(text string) ([]LyricLine, error) {
+	var lines []LyricLine
+	offsetMs := 0
+
+	for i, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+
+		// 1行に複数のタイムスタンプを持てる (例: [00:12.00][00:45.00]サビ)
+		var times []int
+		for {
+			m := lrcTimestampRe.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			minutes, _ := strconv.Atoi(m[1])
+			seconds, _ := strconv.Atoi(m[2])
+			ms := 0
+			if m[3] != "" {
+				// 小数部の桁数に応じてミリ秒に換算する (.5 → 500, .05 → 50, .005 → 5)
+				frac, _ := strconv.Atoi(m[3])
+				for j := len(m[3]); j < 3; j++ {
+					frac *= 10
+				}
+				ms = frac
+			}
+			times = append(times, (minutes*60+seconds)*1000+ms)
+			line = line[len(m[0]):]
+		}
+
+		if len(times) == 0 {
+			tag := lrcTagRe.FindStringSubmatch(line)
+			if tag == nil {
+				return nil, fmt.Errorf("line %d: missing or invalid [mm:ss.xx] timestamp", i+1)
+			}
+			if strings.EqualFold(tag[1], "offset") {
+				offset, err := strconv.Atoi(strings.TrimSpace(tag[2]))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid offset", i+1)
+				}
+				offsetMs = offset
+			}
+			continue
+		}
+
+		text := strings.TrimSpace(line)
+		for _, t := range times {
+			lines = append(lines, LyricLine{TimeMs: t, Line: text})
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no timed lyric lines found")
+	}
+
+	// offset タグは正の値で歌詞を早める (LRCの慣例)
+	for i := range lines {
+		lines[i].TimeMs -= offsetMs
+		if lines[i].TimeMs < 0 {
+			lines[i].TimeMs = 0
+		}
+	}
+	sort.SliceStable(lines, func(a, b int) bool { return lines[a].TimeMs < lines[b].TimeMs })
+	return lines, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLRC(t *testing.T) {
+	lines, err := parseLRC("[ar:Someone]\r\n[00:12.50]Second\n\n[00:01]First\n[00:30.05][01:02.5]Chorus\n[00:02.005]  Padded  \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LyricLine{
+		{1000, "First"},
+		{2005, "Padded"},
+		{12500, "Second"},
+		{30050, "Chorus"},
+		{62500, "Chorus"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("parseLRC = %+v, want %+v", lines, want)
+	}
+
+	// offset は正の値で歌詞を早め、0 未満にはしない
+	lines, err = parseLRC("[offset:+500]\n[00:00.20]Intro\n[00:03.00]Verse\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []LyricLine{{0, "Intro"}, {2500, "Verse"}}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("parseLRC with offset = %+v, want %+v", lines, want)
+	}
+
+	for _, text := range []string{
+		"",
+		"[ar:Only tags]",
+		"[00:01.00]OK\nno timestamp",
+		"[00:61.00]Bad seconds",
+		"[offset:soon]\n[00:01.00]Line",
+	} {
+		if _, err := parseLRC(text); err == nil {
+			t.Errorf("parseLRC(%q) accepted invalid lyrics", text)
+		}
+	}
+}
+
+func TestSyncedLyricsEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+
+	type lyricsResponse struct {
+		Synced bool        `json:"synced"`
+		Lines  []LyricLine `json:"lines"`
+		Lyrics string      `json:"lyrics"`
+	}
+	getLyrics := func(id int) lyricsResponse {
+		t.Helper()
+		var r lyricsResponse
+		s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d/lyrics", id), "", nil, http.StatusOK, &r)
+		return r
+	}
+	upload := func(fields map[string]string) int {
+		t.Helper()
+		body, contentType := multipartForm(t, fields, formFile{"file", "a.mp3", testMP3(time.Second)})
+		req := s.newRequest(t, http.MethodPost, "/api/upload", token, body)
+		req.Header.Set("Content-Type", contentType)
+		resp, _ := s.do(t, req)
+		return resp.StatusCode
+	}
+
+	// 不正なタイムスタンプはアップロード時に拒否する
+	if status := upload(map[string]string{"title": "Bad", "synced_lyrics": "[0:1]Oops"}); status != http.StatusBadRequest {
+		t.Errorf("invalid synced_lyrics on upload: status %d, want 400", status)
+	}
+	if status := upload(map[string]string{"title": "Karaoke", "lyrics": "Hello", "synced_lyrics": "[00:01.00]Hello"}); status != http.StatusOK {
+		t.Fatalf("upload with synced_lyrics: status %d", status)
+	}
+	id := queryInt(t, "SELECT id FROM tracks WHERE title = 'Karaoke'")
+	if r := getLyrics(id); !r.Synced || !reflect.DeepEqual(r.Lines, []LyricLine{{1000, "Hello"}}) || r.Lyrics != "Hello" {
+		t.Errorf("lyrics after upload = %+v", r)
+	}
+
+	// 編集で差し替え、空文字で削除すると通常の歌詞にフォールバックする
+	path := fmt.Sprintf("/api/track/%d", id)
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"synced_lyrics": "[00:02.00]Bye"}, http.StatusOK, nil)
+	if r := getLyrics(id); !reflect.DeepEqual(r.Lines, []LyricLine{{2000, "Bye"}}) {
+		t.Errorf("lyrics after edit = %+v", r)
+	}
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"synced_lyrics": "Bye"}, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"synced_lyrics": ""}, http.StatusOK, nil)
+	if r := getLyrics(id); r.Synced || len(r.Lines) != 0 || r.Lyrics != "Hello" {
+		t.Errorf("lyrics after removing synced lyrics = %+v", r)
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/track/999/lyrics", "", nil, http.StatusNotFound, nil)
+}
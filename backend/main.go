@@ -5,20 +5,30 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"html"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
-	"github.com/google/uuid" // 追加
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,39 +36,149 @@ import (
 
 // Track構造体: データベースのレコードをGoのオブジェクトとして扱うため
 type Track struct {
-	ID           int       `json:"id"`
-	Filename     string    `json:"filename"`
-	Title        string    `json:"title"`
-	Artist       string    `json:"artist"`
-	Lyrics       string    `json:"lyrics"`
-	UploaderUID  string    `json:"uploader_uid"`
-	UploaderName string    `json:"uploader_name"` // 追加
-	CreatedAt    time.Time `json:"created_at"`
-	LikesCount   int       `json:"likes_count"`
-	IsLiked      bool      `json:"is_liked"`
+	ID              int       `json:"id"`
+	Filename        string    `json:"filename"`
+	ExternalURL     string    `json:"external_url,omitempty"` // 外部URLのトラックの音声URL (この場合 filename は空)
+	Title           string    `json:"title"`
+	Artist          string    `json:"artist"`
+	Lyrics          string    `json:"lyrics"`
+	UploaderUID     string    `json:"uploader_uid"`
+	UploaderName    string    `json:"uploader_name"` // 追加
+	CreatedAt       time.Time `json:"created_at"`
+	LikesCount      int       `json:"likes_count"`
+	IsLiked         bool      `json:"is_liked"`
+	HasSyncedLyrics bool      `json:"has_synced_lyrics"` // 同期歌詞 (LRC) があるか (一覧では本文を返さないため、カラオケ表示のアイコン用)
+	ReplayGainDB    *float64  `json:"replay_gain_db"`    // 音量を揃えるための推奨ゲイン (測定できなかったトラックは null)
+}
+
+// TrackDetail はトラック詳細APIのレスポンス (トラックにアップロード者のフォロー情報を加えたもの)
+type TrackDetail struct {
+	Track
+	FollowerCount       int   `json:"follower_count"`                  // アップロード者のフォロワー数
+	IsFollowingUploader *bool `json:"is_following_uploader,omitempty"` // ログインしている場合のみ
+	CommentsEnabled     bool  `json:"comments_enabled"`                // 新しいコメントを投稿できるか
+}
+
+// UserSummary はユーザー一覧APIで返す最小限のユーザー情報
+type UserSummary struct {
+	UID         string `json:"uid"`
+	DisplayName string `json:"display_name"`
+}
+
+// ActiveUser は最近アクティブなユーザー一覧APIで返すユーザー情報
+type ActiveUser struct {
+	UserSummary
+	LastActiveAt time.Time `json:"last_active_at"` // 最後にトラックを投稿、またはコメントした日時
+}
+
+// TrackDayStats は統計APIで返す1日分の集計
+type TrackDayStats struct {
+	Date     string `json:"date"` // YYYY-MM-DD (UTC)
+	Plays    int    `json:"plays"`
+	Likes    int    `json:"likes"`
+	Comments int    `json:"comments"`
+}
+
+// DailyCount は日別の件数の1日分
+type DailyCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD (UTC)
+	Count int    `json:"count"`
 }
 
 // Comment構造体
 type Comment struct {
-	ID        int       `json:"id"`
-	TrackID   int       `json:"track_id"`
-	UserUID   string    `json:"user_uid"`
-	UserName  string    `json:"user_name"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       int    `json:"id"`
+	TrackID  int    `json:"track_id"`
+	UserUID  string `json:"user_uid"`
+	UserName string `json:"user_name"`
+	// UserAvatarURL は投稿者の現在のアバター (Auth の photoURL)。未設定なら空文字
+	UserAvatarURL string    `json:"user_avatar_url"`
+	Content       string    `json:"content"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Hidden は通報が一定数を超えて非表示になっていることを表す (投稿者本人と管理者にのみ返される)
+	Hidden bool `json:"hidden,omitempty"`
+	// ParentID は返信先のコメントID (トップレベルのコメントは null)
+	ParentID *int `json:"parent_id"`
+	// Pinned はトラックのアップロード者がピン留めしたコメントであることを表す (コメント一覧の最初のページの先頭に表示する)
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// CommentReport は管理者向けに返すコメントへの通報 (通報対象のコメントの内容を含む)
+type CommentReport struct {
+	ID             int       `json:"id"`
+	CommentID      int       `json:"comment_id"`
+	TrackID        int       `json:"track_id"`
+	CommentUserUID string    `json:"comment_user_uid"`
+	CommentContent string    `json:"comment_content"`
+	CommentHidden  bool      `json:"comment_hidden"`
+	ReporterUID    string    `json:"reporter_uid"`
+	Reason         string    `json:"reason"`
+	Details        string    `json:"details"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// commentReportReasons は通報の理由として指定できる値
+var commentReportReasons = map[string]bool{
+	"spam":        true,
+	"harassment":  true,
+	"hate_speech": true,
+	"other":       true,
 }
 
 // firebaseAuthMiddleware は、リクエストヘッダーからIDトークンを検証するミドルウェア
+// Firebase Auth に接続できない場合は、無効なトークンと区別できるよう 503 を返す
 func firebaseAuthMiddleware(app *firebase.App) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authClient, err := app.Auth(context.Background())
 			if err != nil {
 				log.Printf("error getting Auth client: %v\n", err)
-				return c.JSON(http.StatusInternalServerError, "Firebase Auth client error")
+				return c.JSON(http.StatusServiceUnavailable, authUnavailableMessage)
 			}
 
 			authHeader := c.Request().Header.Get("Authorization")
+
+			// APIキーによる認証 (Firebase IDトークンを発行できないプログラムからの利用向け)
+			// Authorizationヘッダーがある場合は従来どおり Firebase 認証を優先する
+			if apiKey := c.Request().Header.Get("X-API-Key"); apiKey != "" && authHeader == "" {
+				var keyID int
+				var uid, scopeList string
+				err := db.QueryRow("SELECT id, user_uid, scopes FROM api_keys WHERE key_hash = ?", hashAPIKey(apiKey)).Scan(&keyID, &uid, &scopeList)
+				if err == sql.ErrNoRows {
+					return c.JSON(http.StatusForbidden, "Invalid API key")
+				}
+				if err != nil {
+					log.Printf("error looking up API key: %v\n", err)
+					return c.JSON(http.StatusInternalServerError, "Database error")
+				}
+
+				scopes := strings.Split(scopeList, ",")
+				if !apiKeyAllows(c, scopes) {
+					return c.JSON(http.StatusForbidden, "API key does not have the required scope")
+				}
+
+				// ハンドラーはトークンのクレーム (表示名・メール認証状態) を参照するため、Authの情報から組み立てる
+				// (リクエストごとに Auth を叩かないよう、apiKeyOwnerCacheTTL の間はキャッシュした情報を使う)
+				claims, err := apiKeyOwnerClaims(context.Background(), authClient, uid)
+				if err != nil && isAuthUnavailable(err) {
+					log.Printf("error resolving API key owner %s: auth service unavailable: %v\n", uid, err)
+					return c.JSON(http.StatusServiceUnavailable, authUnavailableMessage)
+				}
+				if err != nil {
+					log.Printf("error resolving API key owner %s: %v\n", uid, err)
+					return c.JSON(http.StatusForbidden, "Invalid API key")
+				}
+				token := &auth.Token{UID: uid, Claims: claims}
+
+				if _, err := db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", keyID); err != nil {
+					log.Printf("warning: failed to update API key usage: %v", err)
+				}
+
+				c.Set("user", token)
+				c.Set("api_key_scopes", scopes)
+				return next(c)
+			}
+
 			if authHeader == "" {
 				return c.JSON(http.StatusUnauthorized, "Authorization header is missing")
 			}
@@ -69,6 +189,10 @@ func firebaseAuthMiddleware(app *firebase.App) echo.MiddlewareFunc {
 			}
 
 			token, err := authClient.VerifyIDToken(context.Background(), idToken)
+			if err != nil && isAuthUnavailable(err) {
+				log.Printf("error verifying ID token: auth service unavailable: %v\n", err)
+				return c.JSON(http.StatusServiceUnavailable, authUnavailableMessage)
+			}
 			if err != nil {
 				log.Printf("error verifying ID token: %v\n", err)
 				return c.JSON(http.StatusForbidden, "Invalid ID token")
@@ -80,8 +204,588 @@ func firebaseAuthMiddleware(app *firebase.App) echo.MiddlewareFunc {
 	}
 }
 
+// optionalUserToken は任意認証のエンドポイント用に、ログインしていれば検証済みのトークンを返す (未ログイン・無効なトークンなら nil)
+// レートリミットとハンドラーの両方から呼ばれるため、検証結果はリクエストのコンテキストに保存して使い回す
+func optionalUserToken(app *firebase.App, c echo.Context) *auth.Token {
+	if token, ok := c.Get("optional_user").(*auth.Token); ok {
+		return token
+	}
+	token := verifyOptionalUserToken(app, c)
+	c.Set("optional_user", token)
+	return token
+}
+
+// verifyOptionalUserToken は Firebase Auth に接続できない場合も含め、検証に失敗したら未ログインとして扱う
+// (Firebase の障害中も公開APIの閲覧は続けられるようにする)
+func verifyOptionalUserToken(app *firebase.App, c echo.Context) *auth.Token {
+	authHeader := c.Request().Header.Get("Authorization")
+	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil
+	}
+	idToken := strings.TrimSpace(strings.Replace(authHeader, "Bearer", "", 1))
+	client, err := app.Auth(context.Background())
+	if err != nil {
+		return nil
+	}
+	token, err := client.VerifyIDToken(context.Background(), idToken)
+	if err != nil {
+		return nil
+	}
+	return token
+}
+
+// rateLimitUserUID はレートリミット用に、有効なIDトークンかAPIキーを持つリクエストのUIDを返す (それ以外は空文字)
+// APIキーはスコープを確認せず、持ち主を特定するだけ (スコープは firebaseAuthMiddleware で確認する)
+func rateLimitUserUID(app *firebase.App, c echo.Context) string {
+	if token := optionalUserToken(app, c); token != nil {
+		return token.UID
+	}
+	apiKey := c.Request().Header.Get("X-API-Key")
+	if apiKey == "" || c.Request().Header.Get("Authorization") != "" {
+		return ""
+	}
+	var uid string
+	if err := db.QueryRow("SELECT user_uid FROM api_keys WHERE key_hash = ?", hashAPIKey(apiKey)).Scan(&uid); err != nil {
+		return ""
+	}
+	return uid
+}
+
+// optionalUserUID は任意認証のエンドポイント用に、ログインしていればUIDを返す (未ログイン・無効なトークンなら空文字)
+func optionalUserUID(app *firebase.App, c echo.Context) string {
+	if token := optionalUserToken(app, c); token != nil {
+		return token.UID
+	}
+	return ""
+}
+
 var db *sql.DB // グローバル変数としてデータベース接続を保持
 
+// dashboardCacheTTL はクリエイターダッシュボードの集計結果をキャッシュする期間
+const dashboardCacheTTL = 60 * time.Second
+
+type cachedDashboard struct {
+	data      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// dashboardCache は画面を開くたびに全トラックを集計し直さないための UID ごとのキャッシュ
+var dashboardCache = struct {
+	sync.Mutex
+	entries map[string]cachedDashboard
+}{entries: make(map[string]cachedDashboard)}
+
+// ensureWritableDir はディレクトリがなければ作成し、書き込めることを確認する
+// (起動後にアップロードやDB書き込みで初めて失敗するのを防ぐ)
+func ensureWritableDir(dir string, perm os.FileMode) error {
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// platformStatsCache は公開の統計API (/api/stats) の結果を1分間キャッシュする
+var platformStatsCache = struct {
+	sync.Mutex
+	data      map[string]int
+	fetchedAt time.Time
+}{}
+
+// activeUsersCache は公開の最近アクティブなユーザー一覧API (/api/users/active) の結果を1分間キャッシュする
+var activeUsersCache = struct {
+	sync.Mutex
+	data      []ActiveUser
+	fetchedAt time.Time
+}{}
+
+// countTrackEventsByDay は指定テーブルの track_id ごとの件数を created_at の日付 (UTC) 単位で集計する
+// table には plays / likes / comments などの固定のテーブル名のみを渡すこと
+// likes はいいね数の表示と同じく、likesCountFilter の条件 (自分のいいねを除く設定) で数える
+func countTrackEventsByDay(table string, trackID int, since string) (map[string]int, error) {
+	filter := ""
+	if table == "likes" {
+		filter = likesCountFilter
+	}
+	rows, err := db.Query("SELECT date(created_at) AS day, COUNT(*) FROM "+table+" WHERE track_id = ? AND date(created_at) >= ?"+filter+" GROUP BY day", trackID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		counts[day] = n
+	}
+	return counts, rows.Err()
+}
+
+// fillDailyCounts は today までの days 日分の件数を古い順に返す (counts にない日は0件として埋める)
+func fillDailyCounts(counts map[string]int, today time.Time, days int) []DailyCount {
+	filled := make([]DailyCount, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format("2006-01-02")
+		filled = append(filled, DailyCount{Date: day, Count: counts[day]})
+	}
+	return filled
+}
+
+// APIドキュメント (OpenAPI 3.0 の仕様書と Swagger UI) はバイナリに埋め込んで配信する
+// 新しいエンドポイントを追加・変更した場合は openapi.json も合わせて更新すること
+//
+//go:embed openapi.json docs.html docs-init.js
+var apiDocsFS embed.FS
+
+// defaultAPICSP はJSONを返すAPI全体に適用するCSP (APIサーバーなので厳格に)
+// 環境変数 CSP_API で上書きできる
+const defaultAPICSP = "default-src 'none'; img-src 'self'; media-src 'self'; style-src 'unsafe-inline';"
+
+// defaultHTMLCSP は Swagger UI などHTMLを返すページ用のCSP (CDNからスクリプトとスタイルを読み込むため)
+// 環境変数 CSP_HTML で上書きできる
+const defaultHTMLCSP = "default-src 'none'; script-src 'self' https://unpkg.com; style-src 'self' 'unsafe-inline' https://unpkg.com; img-src 'self' data: https://unpkg.com; connect-src 'self';"
+
+// overrideCSP は全体の厳格なCSPを、HTMLを返すルートだけ別のポリシーで上書きするミドルウェア
+func overrideCSP(policy string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set("Content-Security-Policy", policy)
+			return next(c)
+		}
+	}
+}
+
+// jsonHTTPErrorHandler はハンドラーが返したエラーやルーティングのエラーを、
+// アプリ共通の {"message": "..."} 形式で返す
+// (存在しないパスは404、既存のパスへの誤ったメソッドは405)
+func jsonHTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	code := http.StatusInternalServerError
+	message := "Internal server error"
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code = he.Code
+		switch code {
+		case http.StatusNotFound:
+			message = "Resource not found"
+		case http.StatusMethodNotAllowed:
+			message = "Method not allowed"
+		default:
+			if m, ok := he.Message.(string); ok {
+				message = m
+			} else {
+				message = http.StatusText(code)
+			}
+		}
+	} else {
+		log.Printf("unhandled error on %s %s: %v", c.Request().Method, c.Request().URL.Path, err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(code)
+	} else {
+		err = c.JSON(code, map[string]string{"message": message})
+	}
+	if err != nil {
+		log.Printf("error writing error response: %v", err)
+	}
+}
+
+// routeGroup は echo.Group と同じ形でルートを登録し、各ルートに middleware を付ける
+// echo.Group.Use はグループ内のどのパスにも該当しないリクエストにもミドルウェアを通すため catch-all のルートを登録し、
+// その結果 /api 以下の存在しないパスや誤ったメソッドが 404/405 ではなく 401 になってしまう
+type routeGroup struct {
+	group      *echo.Group
+	middleware []echo.MiddlewareFunc
+}
+
+func newRouteGroup(e *echo.Echo, prefix string, m ...echo.MiddlewareFunc) *routeGroup {
+	return &routeGroup{group: e.Group(prefix), middleware: m}
+}
+
+func (g *routeGroup) add(method, path string, h echo.HandlerFunc, m []echo.MiddlewareFunc) *echo.Route {
+	return g.group.Add(method, path, h, append(append([]echo.MiddlewareFunc{}, g.middleware...), m...)...)
+}
+
+func (g *routeGroup) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodGet, path, h, m)
+}
+
+func (g *routeGroup) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodPost, path, h, m)
+}
+
+func (g *routeGroup) PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodPut, path, h, m)
+}
+
+func (g *routeGroup) PATCH(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodPatch, path, h, m)
+}
+
+func (g *routeGroup) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.add(http.MethodDelete, path, h, m)
+}
+
+// Group はこのグループのミドルウェアに m を加えたサブグループを返す
+func (g *routeGroup) Group(prefix string, m ...echo.MiddlewareFunc) *routeGroup {
+	return &routeGroup{
+		group:      g.group.Group(prefix),
+		middleware: append(append([]echo.MiddlewareFunc{}, g.middleware...), m...),
+	}
+}
+
+// addColumnIfMissing は既存のテーブルにカラムがなければ追加する (簡易マイグレーション)
+func addColumnIfMissing(table, column, definition string) {
+	var colExists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&colExists); err != nil {
+		log.Printf("Warning: could not check schema for %s.%s: %v", table, column, err)
+		return
+	}
+	if colExists > 0 {
+		return
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		log.Printf("Error adding %s column to %s: %v\n", column, table, err)
+		return
+	}
+	log.Printf("Migrated: Added %s column to %s table.", column, table)
+}
+
+// validateDisplayName は表示名の前後の空白を除いて検証し、保存する表示名と使用できない理由 (問題なければ空文字) を返す
+func validateDisplayName(filter *ProfanityFilter, name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", "Display name cannot be empty"
+	}
+	if utf8.RuneCountInString(name) > limits.DisplayName {
+		return "", fmt.Sprintf("Display name is too long (max %d chars)", limits.DisplayName)
+	}
+	name, ok := filter.Filter(name)
+	if !ok {
+		return "", profanityRejectedMessage
+	}
+	return name, ""
+}
+
+// validateCommentContent はコメント本文を検証し、保存する本文とエラーメッセージを返す (問題なければメッセージは空)
+// 投稿とプレビューで同じ結果になるよう、両方からこの関数を使う
+func validateCommentContent(filter *ProfanityFilter, content string) (string, string) {
+	if len(content) == 0 || utf8.RuneCountInString(content) > limits.Comment {
+		return "", fmt.Sprintf("Comment must be between 1 and %d characters.", limits.Comment)
+	}
+	content, ok := filter.Filter(content)
+	if !ok {
+		return "", profanityRejectedMessage
+	}
+	return content, ""
+}
+
+// displayNameTaken は uid 以外のユーザーが同じ表示名でトラックを公開しているかを返す
+func displayNameTaken(name, uid string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM tracks WHERE uploader_name = ? AND uploader_uid != ?)", name, uid).Scan(&exists)
+	return exists, err
+}
+
+// normalizeArtistName はアーティスト名の前後の空白を除き、連続する空白を1つにまとめる
+func normalizeArtistName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// artistKey は表記ゆれ ("DJ Cat" / "dj cat" / "DJ  Cat") を同一視するための比較キー
+func artistKey(name string) string {
+	return strings.ToLower(normalizeArtistName(name))
+}
+
+// resolveArtist はアーティスト名に対応する正規のアーティストを返す (未登録なら新規作成する)
+func resolveArtist(name string) (int64, string, error) {
+	name = normalizeArtistName(name)
+	key := artistKey(name)
+
+	if _, err := db.Exec("INSERT OR IGNORE INTO artists (name, normalized_name) VALUES (?, ?)", name, key); err != nil {
+		return 0, "", err
+	}
+	var id int64
+	var canonical string
+	if err := db.QueryRow("SELECT id, name FROM artists WHERE normalized_name = ?", key).Scan(&id, &canonical); err != nil {
+		return 0, "", err
+	}
+	return id, canonical, nil
+}
+
+// selfLikeFilter は likes テーブルの集計からアップロード者自身のいいねを除く条件
+// (ALLOW_SELF_LIKE が無効な場合に likesCountFilter として使う)
+const selfLikeFilter = " AND likes.user_uid != (SELECT uploader_uid FROM tracks WHERE tracks.id = likes.track_id)"
+
+// likesCountFilter はいいね数を数えるときに付け加える条件 (起動時に ALLOW_SELF_LIKE から設定)
+var likesCountFilter = selfLikeFilter
+
+// trackColumns はトラック一覧で共通のSELECT句 (テーブル別名は t)
+// FROM 句には trackFrom を使う (is_liked は trackFrom で結合したいいねから判定する)
+// いいね数の条件が設定で変わるため、起動時に buildTrackColumns で組み立て直す
+var trackColumns = buildTrackColumns()
+
+func buildTrackColumns() string {
+	return `
+	t.id, t.filename, t.title, t.artist, t.lyrics, t.uploader_uid, t.uploader_name, t.created_at,
+	(SELECT COUNT(*) FROM likes WHERE track_id = t.id` + likesCountFilter + `) AS likes_count,
+	ul.id IS NOT NULL AS is_liked, t.external_url,
+	COALESCE(t.synced_lyrics, '') != '' AS has_synced_lyrics, t.replay_gain_db`
+}
+
+// trackFrom は trackColumns と組み合わせる FROM 句
+// 最初のプレースホルダーには is_liked 判定用のユーザーUIDを渡す (未ログインなら空文字)
+// 行ごとに EXISTS のサブクエリを実行する代わりに、ユーザーのいいねを UNIQUE(user_uid, track_id) のインデックスで結合する
+// (1つのトラックにつき1人のいいねは最大1件のため、結合しても行は増えない)
+const trackFrom = "tracks t LEFT JOIN likes ul ON ul.track_id = t.id AND ul.user_uid = ?"
+
+// touchTrack は UPDATE tracks の SET 句に加えて updated_at を更新する (一覧の ETag が編集で変わるように)
+// 同じ秒に続けて編集しても区別できるよう、ミリ秒まで記録する
+const touchTrack = "updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now')"
+
+// nullableInt は PATCH のリクエストで、指定されなかったフィールドと null (既定値に戻す) を区別するための整数
+type nullableInt struct {
+	Set   bool // フィールドが指定されたか
+	Valid bool // null ではないか
+	Value int
+}
+
+func (n *nullableInt) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Valid = false
+		return nil
+	}
+	n.Valid = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+// effectiveMaxComments はトラックに適用するコメント数の上限を返す (0 は無制限)
+// トラックごとの上限は COMMENT_MAX_PER_TRACK (defaultMax) を超えられない
+// (以前の仕様で 0 = 無制限が保存されているトラックも、全体の上限に従わせる)
+func effectiveMaxComments(trackMax sql.NullInt64, defaultMax int) int {
+	if !trackMax.Valid || trackMax.Int64 <= 0 {
+		return defaultMax
+	}
+	if defaultMax > 0 && int(trackMax.Int64) > defaultMax {
+		return defaultMax
+	}
+	return int(trackMax.Int64)
+}
+
+// scanTracks は trackColumns で取得した行を Track のスライスに変換する
+func scanTracks(rows *sql.Rows) ([]Track, error) {
+	tracks := make([]Track, 0)
+	for rows.Next() {
+		var track Track
+		// lyricsとartistはNULL許容のため、sql.NullStringで受け取る
+		var artist sql.NullString
+		var lyrics sql.NullString
+		var uploaderName sql.NullString // uploader_nameもNULL許容として扱う
+		var externalURL sql.NullString
+		var replayGain sql.NullFloat64
+		if err := rows.Scan(&track.ID, &track.Filename, &track.Title, &artist, &lyrics, &track.UploaderUID, &uploaderName, &track.CreatedAt, &track.LikesCount, &track.IsLiked, &externalURL, &track.HasSyncedLyrics, &replayGain); err != nil {
+			return nil, err
+		}
+		if replayGain.Valid {
+			track.ReplayGainDB = &replayGain.Float64
+		}
+		// 外部URLのトラックの filename は uploads ディレクトリのファイルを指さないため返さない
+		if externalURL.Valid {
+			track.Filename = ""
+			track.ExternalURL = externalURL.String
+		}
+		track.Artist = artist.String
+		track.Lyrics = lyrics.String
+		track.UploaderName = uploaderName.String // NULLの場合は空文字になる
+		tracks = append(tracks, track)
+	}
+	return tracks, rows.Err()
+}
+
+// maxTracksByIDs は POST /api/tracks/by-ids で一度に指定できるIDの数
+const maxTracksByIDs = 100
+
+// tracksByIDs は ids のトラックを ids と同じ順番で返す (存在しないIDは含めない、重複したIDは最初の1件のみ)
+// currentUserID は is_liked の判定に使う (未ログインなら空文字)
+func tracksByIDs(currentUserID string, ids []int) ([]Track, error) {
+	if len(ids) == 0 {
+		return []Track{}, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, currentUserID)
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	rows, err := db.Query("SELECT "+trackColumns+" FROM "+trackFrom+" WHERE t.id IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found, err := scanTracks(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]Track, len(found))
+	for _, track := range found {
+		byID[track.ID] = track
+	}
+	tracks := make([]Track, 0, len(found))
+	for _, id := range ids {
+		if track, ok := byID[id]; ok {
+			tracks = append(tracks, track)
+			delete(byID, id)
+		}
+	}
+	return tracks, nil
+}
+
+// trackSortOrders は一覧APIの ?sort= で指定できる並び順
+// created_at は秒単位のため、同じ秒にアップロードされたトラックの順番が毎回変わらないよう id で順序を確定させる
+var trackSortOrders = map[string]string{
+	"newest":  "t.created_at DESC, t.id DESC",
+	"oldest":  "t.created_at ASC, t.id ASC",
+	"popular": "likes_count DESC, t.created_at DESC, t.id DESC",
+}
+
+// defaultFeedSort はトラック一覧API (/api/tracks) で ?sort= を省略したときの並び順
+// 起動時に DEFAULT_FEED_SORT から設定する (trackSortOrders のキーのいずれか)
+var defaultFeedSort = "newest"
+
+// trackOrderBy は ?sort= の値を ORDER BY 句に変換する (未指定なら新着順、不正な値なら false)
+func trackOrderBy(sort string) (string, bool) {
+	if sort == "" {
+		sort = "newest"
+	}
+	orderBy, ok := trackSortOrders[sort]
+	return orderBy, ok
+}
+
+// commentSortOrders はコメント一覧APIの ?sort= で指定できる並び順
+// (コメントへのいいね機能がないため、いいね順の "top" はまだ提供しない)
+var commentSortOrders = map[string]commentSortOrder{
+	"oldest": {orderBy: "created_at ASC, id ASC", after: ">"},
+	"newest": {orderBy: "created_at DESC, id DESC", after: "<"},
+}
+
+// commentSortOrder はコメントの並び順と、?after= (カーソルのコメントID) より後ろのコメントを (created_at, id) で絞り込む比較演算子
+// 同じ秒に投稿されたコメントも id で順序が決まるため、ページの間でコメントが重複したり抜けたりしない
+type commentSortOrder struct {
+	orderBy string
+	after   string
+}
+
+// 一覧APIの1ページあたりの件数
+const (
+	defaultPageSize = 50  // 環境変数 PAGE_SIZE で変更できる
+	maxPageSize     = 100 // ?limit= で指定できる上限
+)
+
+// pageSize は ?limit= が指定されていない場合の件数 (起動時に PAGE_SIZE から設定、1〜maxPageSize)
+var pageSize = defaultPageSize
+
+// clampPageSize は件数を 1〜maxPageSize の範囲に収める
+func clampPageSize(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > maxPageSize {
+		return maxPageSize
+	}
+	return n
+}
+
+// parseTrackID はパスパラメータ :id をトラックIDとして読み込む
+// 正の整数でなければ 400 のエラーを返す (レスポンスは jsonHTTPErrorHandler が組み立てる)
+func parseTrackID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid track ID")
+	}
+	return id, nil
+}
+
+// parseFeedScope は ?scope= を読み込み、フォローしているユーザーのトラックだけに絞り込むかを返す (デフォルトは all)
+// scope=following はログインが必要
+func parseFeedScope(c echo.Context, currentUserID string) (following bool, err error) {
+	switch c.QueryParam("scope") {
+	case "", "all":
+		return false, nil
+	case "following":
+		if currentUserID == "" {
+			return false, echo.NewHTTPError(http.StatusUnauthorized, "Login is required for scope=following.")
+		}
+		return true, nil
+	}
+	return false, echo.NewHTTPError(http.StatusBadRequest, "Invalid scope (must be 'all' or 'following')")
+}
+
+// parsePagination は ?limit= と ?offset= を読み込む (limit は 1〜maxPageSize、デフォルトは pageSize)
+func parsePagination(c echo.Context) (limit, offset int, err error) {
+	limit, offset = pageSize, 0
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// listMeta は ?meta=true を指定した一覧APIで、データと一緒に返すページ情報
+type listMeta struct {
+	Total   int  `json:"total"`
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	HasMore bool `json:"has_more"`
+}
+
+// parseListMeta は ?meta= を読み込む (true ならレスポンスを {data, meta} で包む)
+func parseListMeta(c echo.Context) (bool, error) {
+	v := c.QueryParam("meta")
+	if v == "" {
+		return false, nil
+	}
+	withMeta, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("meta must be true or false")
+	}
+	return withMeta, nil
+}
+
+// listResponse は一覧を返す。withMeta が false の場合は従来どおり配列のまま返す
+// count は data の件数 (次のページがあるかの判定に使う)
+func listResponse(c echo.Context, data interface{}, count int, withMeta bool, total, limit, offset int) error {
+	if !withMeta {
+		return c.JSON(http.StatusOK, data)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": data,
+		"meta": listMeta{Total: total, Limit: limit, Offset: offset, HasMore: offset+count < total},
+	})
+}
+
 // loadEnv は.envファイルが存在する場合に読み込んで環境変数をセットする
 func loadEnv() {
 	file, err := os.Open(".env")
@@ -112,17 +816,43 @@ func loadEnv() {
 	}
 }
 
-// sendEmail はSMTPを使用してメールを送信するヘルパー関数
-func sendEmail(to []string, subject, body string) error {
+// brevoEmailAPIURL は Brevo のメール送信APIのURL
+var brevoEmailAPIURL = "https://api.brevo.com/v3/smtp/email"
+
+// emailSendResult は sendEmail の宛先ごとの結果
+type emailSendResult struct {
+	Sent    []string         // 送信できた宛先
+	Failed  map[string]error // 送信できなかった宛先とその理由
+	Skipped bool             // メールの設定がないため送信しなかった
+}
+
+// sendEmail はメールを宛先ごとに1通ずつ送信するヘルパー関数
+// 複数の宛先をまとめて送ると、1つの不正なアドレスで全員分が失敗する (また、宛先同士にアドレスが見えてしまう) ため、
+// 宛先ごとに送信して結果を返す
+func sendEmail(to []string, subject, body string) emailSendResult {
 	apiKey := os.Getenv("BREVO_API_KEY")
 	senderEmail := os.Getenv("BREVO_SENDER_EMAIL")
-	senderName := "SoundLike"
 
 	if apiKey == "" || senderEmail == "" {
 		// 設定がない場合はログを出してスキップ（開発環境などでエラーにならないように）
 		log.Println("Email configuration missing (BREVO_API_KEY or BREVO_SENDER_EMAIL), skipping email sending.")
-		return nil
+		return emailSendResult{Skipped: true}
+	}
+
+	result := emailSendResult{Failed: make(map[string]error)}
+	for _, recipient := range to {
+		if err := sendBrevoEmail(apiKey, senderEmail, recipient, subject, body); err != nil {
+			result.Failed[recipient] = err
+			continue
+		}
+		result.Sent = append(result.Sent, recipient)
 	}
+	return result
+}
+
+// sendBrevoEmail は Brevo のAPIで1つの宛先にメールを送信する
+func sendBrevoEmail(apiKey, senderEmail, recipient, subject, body string) error {
+	senderName := "SoundLike"
 
 	// Brevo APIのリクエストボディを作成
 	type Recipient struct {
@@ -139,14 +869,9 @@ func sendEmail(to []string, subject, body string) error {
 		HtmlContent string      `json:"htmlContent"`
 	}
 
-	var recipients []Recipient
-	for _, email := range to {
-		recipients = append(recipients, Recipient{Email: email})
-	}
-
 	reqBody := EmailRequest{
 		Sender:      Sender{Name: senderName, Email: senderEmail},
-		To:          recipients,
+		To:          []Recipient{{Email: recipient}},
 		Subject:     subject,
 		HtmlContent: body,
 	}
@@ -156,7 +881,7 @@ func sendEmail(to []string, subject, body string) error {
 		return fmt.Errorf("failed to marshal email request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", "https://api.brevo.com/v3/smtp/email", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequest("POST", brevoEmailAPIURL, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -195,48 +920,460 @@ func shouldNotify(uid string) bool {
 	return enabled
 }
 
-func main() {
-	ctx := context.Background()
-	// render.yamlで設定したGOOGLE_APPLICATION_CREDENTIALS環境変数を自動的に読み込むようにするため、
-	// 明示的なファイルパス指定を削除します。
-
-	// .envファイルを読み込む (開発環境用)
-	loadEnv()
-
-	// フロントエンドのURLを取得 (メール通知用リンク)
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000"
+// envInt は環境変数を整数として読み込む (未設定や不正な値の場合はデフォルト値を使う)
+func envInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value for %s (%q), using default %d", key, value, defaultValue)
+		return defaultValue
 	}
+	return n
+}
 
-	// デバッグ用: メール設定の確認
-	log.Printf("Email Configuration: BREVO_SENDER_EMAIL='%s', BREVO_API_KEY set=%v", os.Getenv("BREVO_SENDER_EMAIL"), os.Getenv("BREVO_API_KEY") != "")
+// isAdmin はトークンのカスタムクレーム "admin" によって管理者かどうかを判定する
+// (管理者権限は Firebase Admin SDK の SetCustomUserClaims で付与する想定)
+func isAdmin(user *auth.Token) bool {
+	admin, ok := user.Claims["admin"].(bool)
+	return ok && admin
+}
 
-	app, err := firebase.NewApp(ctx, nil)
-	if err != nil {
-		log.Fatalf("error initializing app: %v\n", err)
+// requireEmailVerification が true の場合、投稿・いいね・フォローなどの書き込みにメール認証を必須にする
+// 社内向け・デモ用の環境では REQUIRE_EMAIL_VERIFICATION=false で無効にできる
+var requireEmailVerification = true
+
+// requireVerified はユーザーが書き込みを行えるか (メール認証済み、または認証を必須にしていない) を返す
+func requireVerified(user *auth.Token) bool {
+	if !requireEmailVerification {
+		return true
 	}
+	verified, ok := user.Claims["email_verified"].(bool)
+	return ok && verified
+}
 
-	// === SQLiteデータベースの初期化 ===
-	dataDir := "./data"
-	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
-		// 0700: 所有者のみが読み書き実行可能 (外部からのアクセスを遮断)
-		if err := os.MkdirAll(dataDir, 0o700); err != nil {
-			log.Fatalf("error creating data directory: %v\n", err)
+// requireAdmin は管理者以外のリクエストを 403 で拒否するミドルウェア (firebaseAuthMiddleware の後に使う)
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := c.Get("user").(*auth.Token)
+		if !ok || !isAdmin(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Admin privileges are required."})
 		}
+		return next(c)
+	}
+}
+
+// deletedUserName は Firebase Auth 上に存在しなくなったユーザーの代替表示名
+const deletedUserName = "[deleted user]"
+
+// displayNameCacheTTL は Auth から取得した表示名とアバターをキャッシュする期間
+const displayNameCacheTTL = 5 * time.Minute
+
+// userProfile は Auth から取得した、一覧表示に使うユーザー情報
+type userProfile struct {
+	name      string
+	avatarURL string
+}
+
+type cachedDisplayName struct {
+	userProfile
+	fetchedAt time.Time
+}
+
+// displayNameCacheMaxEntries は表示名キャッシュに保持するユーザー数の上限
+// 上限を超えた場合は取得が古いものから捨てる
+var displayNameCacheMaxEntries = 10000
+
+// displayNameCache は一覧表示のたびに Firebase Auth を叩かないためのキャッシュ
+var displayNameCache = struct {
+	sync.Mutex
+	entries map[string]cachedDisplayName
+}{entries: make(map[string]cachedDisplayName)}
+
+// pruneDisplayNameCache は期限切れのエントリーを削除し、上限を超えた分を古い順に捨てる
+// 呼び出し側で displayNameCache をロックしておくこと
+func pruneDisplayNameCache() {
+	for uid, e := range displayNameCache.entries {
+		if time.Since(e.fetchedAt) >= displayNameCacheTTL {
+			delete(displayNameCache.entries, uid)
+		}
+	}
+	excess := len(displayNameCache.entries) - displayNameCacheMaxEntries
+	if excess <= 0 {
+		return
+	}
+	uids := make([]string, 0, len(displayNameCache.entries))
+	for uid := range displayNameCache.entries {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(a, b int) bool {
+		return displayNameCache.entries[uids[a]].fetchedAt.Before(displayNameCache.entries[uids[b]].fetchedAt)
+	})
+	for _, uid := range uids[:excess] {
+		delete(displayNameCache.entries, uid)
+	}
+}
+
+// resolveDisplayNames は UID の一覧から Firebase Auth 上の最新の表示名をまとめて取得する
+// 削除済みのユーザーは deletedUserName、表示名が未設定のユーザーは空文字になる
+func resolveDisplayNames(ctx context.Context, authClient *auth.Client, uids []string) (map[string]string, error) {
+	profiles, err := resolveUserProfiles(ctx, authClient, uids)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(profiles))
+	for uid, p := range profiles {
+		names[uid] = p.name
+	}
+	return names, nil
+}
+
+// resolveUserProfiles は UID の一覧から Firebase Auth 上の最新の表示名とアバター (photoURL) をまとめて取得する
+// アバターを削除したユーザーや削除済みのユーザーの avatarURL は空文字になる
+func resolveUserProfiles(ctx context.Context, authClient *auth.Client, uids []string) (map[string]userProfile, error) {
+	profiles := make(map[string]userProfile)
+	var missing []string
+
+	displayNameCache.Lock()
+	for _, uid := range uids {
+		if _, seen := profiles[uid]; seen || uid == "" {
+			continue
+		}
+		if entry, ok := displayNameCache.entries[uid]; ok && time.Since(entry.fetchedAt) < displayNameCacheTTL {
+			profiles[uid] = entry.userProfile
+			continue
+		}
+		profiles[uid] = userProfile{}
+		missing = append(missing, uid)
+	}
+	displayNameCache.Unlock()
+
+	// GetUsers は1回あたり最大100件まで
+	for start := 0; start < len(missing); start += 100 {
+		end := start + 100
+		if end > len(missing) {
+			end = len(missing)
+		}
+		identifiers := make([]auth.UserIdentifier, 0, end-start)
+		for _, uid := range missing[start:end] {
+			identifiers = append(identifiers, auth.UIDIdentifier{UID: uid})
+		}
+		result, err := authClient.GetUsers(ctx, identifiers)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		displayNameCache.Lock()
+		for _, u := range result.Users {
+			p := userProfile{name: u.DisplayName, avatarURL: u.PhotoURL}
+			profiles[u.UID] = p
+			displayNameCache.entries[u.UID] = cachedDisplayName{userProfile: p, fetchedAt: now}
+		}
+		for _, id := range result.NotFound {
+			if uidID, ok := id.(auth.UIDIdentifier); ok {
+				p := userProfile{name: deletedUserName}
+				profiles[uidID.UID] = p
+				displayNameCache.entries[uidID.UID] = cachedDisplayName{userProfile: p, fetchedAt: now}
+			}
+		}
+		pruneDisplayNameCache()
+		displayNameCache.Unlock()
+	}
+	return profiles, nil
+}
+
+// refreshTrackUploaderNames はトラック一覧の uploader_name を Auth 上の最新の表示名で上書きする
+// Auth に問い合わせできない場合は DB に保存されている名前をそのまま使う
+func refreshTrackUploaderNames(authClient *auth.Client, tracks []Track) {
+	uids := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		uids = append(uids, t.UploaderUID)
+	}
+	names, err := resolveDisplayNames(context.Background(), authClient, uids)
+	if err != nil {
+		log.Printf("warning: could not resolve uploader names: %v", err)
+		return
+	}
+	for i := range tracks {
+		if name := names[tracks[i].UploaderUID]; name != "" {
+			tracks[i].UploaderName = name
+		}
+	}
+}
+
+// userSummaries は UID の一覧を表示名付きの UserSummary に変換する
+// Auth に問い合わせできない場合は表示名を空のまま返す
+func userSummaries(authClient *auth.Client, uids []string) []UserSummary {
+	users := make([]UserSummary, 0, len(uids))
+	var names map[string]string
+	if authClient != nil {
+		var err error
+		names, err = resolveDisplayNames(context.Background(), authClient, uids)
+		if err != nil {
+			log.Printf("warning: could not resolve user names: %v", err)
+		}
+	}
+	for _, uid := range uids {
+		users = append(users, UserSummary{UID: uid, DisplayName: names[uid]})
+	}
+	return users
+}
+
+// refreshCommentUserNames はコメント一覧の user_name を Auth 上の最新の表示名で上書きし、アバターを設定する
+// アバターはコメントに保存せず毎回 Auth から取得する (変更・削除がすぐ反映される代わりに、キャッシュ切れ時は Auth への問い合わせが発生する)
+func refreshCommentUserNames(authClient *auth.Client, comments []Comment) {
+	uids := make([]string, 0, len(comments))
+	for _, cm := range comments {
+		uids = append(uids, cm.UserUID)
+	}
+	profiles, err := resolveUserProfiles(context.Background(), authClient, uids)
+	if err != nil {
+		log.Printf("warning: could not resolve comment author names: %v", err)
+		return
+	}
+	for i := range comments {
+		p := profiles[comments[i].UserUID]
+		if p.name != "" {
+			comments[i].UserName = p.name
+		}
+		comments[i].UserAvatarURL = p.avatarURL
+	}
+}
+
+func main() {
+	e := newServer(context.Background())
+	defer db.Close() // サーバー終了時にデータベース接続を閉じる
+
+	// RenderなどのPaaSは環境変数PORTでポートを指定してくるため対応する
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	go func() {
+		if err := e.Start(":" + port); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// SIGINT / SIGTERM を受けたら処理中のリクエストを待ち、送信待ちのメールを送ってから終了する
+	// 最後にWALをデータベースに書き戻す
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down server: %v\n", err)
+	}
+	drainEmailQueue()
+	if err := checkpointWAL(); err != nil {
+		log.Printf("error running WAL checkpoint on shutdown: %v\n", err)
+	}
+}
+
+// newServer は環境変数から設定を読み込み、データベースを初期化して、すべてのルートを登録した Echo を返す
+// (バックグラウンドのワーカーも起動する。データベース接続は呼び出し側で閉じる)
+func newServer(ctx context.Context) *echo.Echo {
+	// render.yamlで設定したGOOGLE_APPLICATION_CREDENTIALS環境変数を自動的に読み込むようにするため、
+	// 明示的なファイルパス指定を削除します。
+
+	// .envファイルを読み込む (開発環境用)
+	loadEnv()
+
+	// フロントエンドのURLを取得 (メール通知用リンク)
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:3000"
+	}
+	// メール内のリンクの土台になるため、起動時に検証して正規化する (末尾のスラッシュは除く)
+	frontendURL, err := parseFrontendURL(frontendURL)
+	if err != nil {
+		log.Fatalf("invalid FRONTEND_URL: %v\n", err)
+	}
+
+	// コメント投稿のレートリミット (ユーザーごと)
+	commentMinInterval := envInt("COMMENT_MIN_INTERVAL_SECONDS", 10) // 連続投稿の最小間隔 (秒)
+	commentMaxPerHour := envInt("COMMENT_MAX_PER_HOUR", 30)          // 1時間あたりの最大投稿数
+	// 1トラックあたりのコメント数の上限 (0 は無制限、トラックごとに max_comments で上書きできる)
+	defaultMaxComments := envInt("COMMENT_MAX_PER_TRACK", 0)
+	// この件数を超える通報を受けたコメントは管理者が確認するまで非表示にする (0 で無効)
+	commentReportHideThreshold := envInt("COMMENT_REPORT_HIDE_THRESHOLD", 5)
+	// 返信をネストできる深さ (トップレベルのコメントへの返信が 1、0 で返信を受け付けない)
+	commentMaxDepth := envInt("COMMENT_MAX_DEPTH", 5)
+
+	// 通知メールの送信レート (プロバイダーの制限を超えないよう、超えた分は行列で待たせる)
+	emailRatePerMinute := envInt("EMAIL_RATE_PER_MINUTE", 60)
+	if emailRatePerMinute < 1 {
+		emailRatePerMinute = 1
+	}
+	// 送信待ち行列に積めるメールの件数 (超えた分は捨ててログに残す)
+	emailQueue.setMax(envInt("EMAIL_QUEUE_MAX", defaultEmailQueueMax))
+	go runEmailWorker(emailRatePerMinute)
+
+	// 一覧APIのデフォルトの件数
+	pageSize = clampPageSize(envInt("PAGE_SIZE", defaultPageSize))
+
+	// 自分のトラックへのいいね (ALLOW_SELF_LIKE=true の場合のみ許可し、いいね数にも含める)
+	allowSelfLike := os.Getenv("ALLOW_SELF_LIKE") == "true"
+	if allowSelfLike {
+		likesCountFilter = ""
+	}
+	trackColumns = buildTrackColumns()
+
+	// 音声ファイルの署名付きURL (REQUIRE_SIGNED_MEDIA=true の場合、ストリーミングAPIと /uploads で署名を必須にする)
+	// 署名付きURLは GET /api/track/:id/stream-url で発行し、MEDIA_URL_TTL_SECONDS 秒 (デフォルト1時間) で期限が切れる
+	requireSignedMedia := os.Getenv("REQUIRE_SIGNED_MEDIA") == "true"
+	mediaSigningSecret := os.Getenv("MEDIA_SIGNING_SECRET")
+	mediaURLTTL := envInt("MEDIA_URL_TTL_SECONDS", 3600)
+	if mediaURLTTL < 1 {
+		log.Fatalf("MEDIA_URL_TTL_SECONDS must be positive\n")
+	}
+	mediaURLs, err := newMediaSigner(mediaSigningSecret, time.Duration(mediaURLTTL)*time.Second)
+	if err != nil {
+		log.Fatalf("error creating media URL signer: %v\n", err)
+	}
+	if requireSignedMedia && mediaSigningSecret == "" {
+		log.Println("Warning: REQUIRE_SIGNED_MEDIA is enabled without MEDIA_SIGNING_SECRET; signed URLs will stop working after a restart")
+	}
+	var mediaMiddleware []echo.MiddlewareFunc
+	if requireSignedMedia {
+		mediaMiddleware = append(mediaMiddleware, mediaURLs.middleware())
+	}
+	// 試聴API (/api/track/:id/preview) で返す長さ (PREVIEW_SECONDS 秒、デフォルト30秒)
+	// 試聴は誰でも聞けるよう、REQUIRE_SIGNED_MEDIA でも署名を必須にしない
+	previewSeconds := envInt("PREVIEW_SECONDS", 30)
+	if previewSeconds < 1 {
+		log.Fatalf("PREVIEW_SECONDS must be positive\n")
+	}
+	previewDuration := time.Duration(previewSeconds) * time.Second
+
+	// アップロードの制限 (小さなインスタンスでメモリやディスクを使い切らないように)
+	maxUploadSizeMB := envInt("MAX_UPLOAD_SIZE_MB", 15) // 音声ファイル1つあたりの最大サイズ
+	if maxUploadSizeMB < 1 {
+		log.Fatalf("MAX_UPLOAD_SIZE_MB must be positive\n")
+	}
+	maxUploadBodyBytes := int64(maxUploadSizeMB+5) << 20 // ファイル + メタデータ分
+	// まとめてアップロードする場合の上限 (ファイル数とリクエスト全体のサイズ)
+	const maxBatchFiles = 10
+	maxBatchUploadSizeMB := envInt("MAX_BATCH_UPLOAD_SIZE_MB", 100)
+	if maxBatchUploadSizeMB < 1 {
+		log.Fatalf("MAX_BATCH_UPLOAD_SIZE_MB must be positive\n")
+	}
+	maxBatchBodyBytes := int64(maxBatchUploadSizeMB+5) << 20
+	maxConcurrentUploads := envInt("MAX_CONCURRENT_UPLOADS", 4)
+	if maxConcurrentUploads < 1 {
+		log.Fatalf("MAX_CONCURRENT_UPLOADS must be positive\n")
+	}
+	uploadSlots := make(chan struct{}, maxConcurrentUploads)
+
+	// 再生数のデバウンス: 同じリスナーが同じトラックを PLAY_DEBOUNCE_SECONDS 秒以内に再生しても1回と数える (0 で無効)
+	// 保持するエントリ数は上限を設け、期限切れのものは1分ごとに削除する
+	playDebounce := newPlayDebouncer(time.Duration(envInt("PLAY_DEBOUNCE_SECONDS", 30))*time.Second, 100000)
+	go playDebounce.runEviction(time.Minute)
+
+	// 不適切な語句のフィルター (PROFANITY_LIST に単語リストのパスを指定した場合のみ有効)
+	// PROFANITY_MODE=strict なら投稿を拒否し、それ以外は該当部分を伏せ字にする
+	var profanityFilter *ProfanityFilter
+	if path := os.Getenv("PROFANITY_LIST"); path != "" {
+		filter, err := loadProfanityFilter(path, os.Getenv("PROFANITY_MODE"))
+		if err != nil {
+			log.Fatalf("error loading profanity list: %v\n", err)
+		}
+		profanityFilter = filter
+	}
+
+	// 使い捨てメールのドメインのブロックリスト (DISPOSABLE_EMAIL_BLOCKLIST にドメインのリストのパスを指定した場合のみ有効)
+	// 該当するユーザーのアップロードとプロフィール更新を拒否する
+	var emailBlocklist *EmailDomainBlocklist
+	if path := os.Getenv("DISPOSABLE_EMAIL_BLOCKLIST"); path != "" {
+		blocklist, err := loadEmailDomainBlocklist(path)
+		if err != nil {
+			log.Fatalf("error loading disposable email blocklist: %v\n", err)
+		}
+		emailBlocklist = blocklist
+		log.Printf("Loaded %d disposable email domains", len(blocklist.domains))
+	}
+
+	// アップロードを拒否する IP アドレス・CIDR のブロックリスト (UPLOAD_IP_BLOCKLIST にリストのパスを指定した場合のみ有効)
+	// SIGHUP を受けたとき、および UPLOAD_IP_BLOCKLIST_RELOAD_SECONDS 秒ごと (デフォルト60、0 で無効) に読み直す
+	var uploadIPBlocklist *IPBlocklist
+	if path := os.Getenv("UPLOAD_IP_BLOCKLIST"); path != "" {
+		blocklist, err := loadIPBlocklist(path)
+		if err != nil {
+			log.Fatalf("error loading upload IP blocklist: %v\n", err)
+		}
+		uploadIPBlocklist = blocklist
+		log.Printf("Loaded %d upload IP blocklist entries", blocklist.Len())
+		go blocklist.watch(time.Duration(envInt("UPLOAD_IP_BLOCKLIST_RELOAD_SECONDS", 60)) * time.Second)
+	}
+
+	// デバッグ用: メール設定の確認
+	log.Printf("Email Configuration: BREVO_SENDER_EMAIL='%s', BREVO_API_KEY set=%v", os.Getenv("BREVO_SENDER_EMAIL"), os.Getenv("BREVO_API_KEY") != "")
+
+	app, err := firebase.NewApp(ctx, nil)
+	if err != nil {
+		log.Fatalf("error initializing app: %v\n", err)
+	}
+
+	// === データ・アップロード用ディレクトリ ===
+	// 永続ディスクを別の場所にマウントする環境向けに、環境変数で変更できるようにする
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+	uploadsDir := os.Getenv("UPLOADS_DIR")
+	if uploadsDir == "" {
+		uploadsDir = "uploads"
+	}
+	// 0700: 所有者のみが読み書き実行可能 (外部からのアクセスを遮断)
+	if err := ensureWritableDir(dataDir, 0o700); err != nil {
+		log.Fatalf("error preparing data directory: %v\n", err)
+	}
+	if err := ensureWritableDir(uploadsDir, 0o755); err != nil {
+		log.Fatalf("error preparing uploads directory: %v\n", err)
+	}
+	// アップロードしたファイルの保存名 (uuid: 従来どおり / date-uuid: 年月ごとのディレクトリに分ける)
+	switch naming := os.Getenv("FILE_NAMING"); naming {
+	case "", fileNamingUUID:
+	case fileNamingDateUUID:
+		uploadFileNaming = naming
+	default:
+		log.Fatalf("invalid FILE_NAMING %q (expected %q or %q)\n", naming, fileNamingUUID, fileNamingDateUUID)
+	}
+	// トラック一覧の既定の並び順 (newest / oldest / popular)
+	if sort := os.Getenv("DEFAULT_FEED_SORT"); sort != "" {
+		if _, ok := trackSortOrders[sort]; !ok {
+			log.Fatalf("invalid DEFAULT_FEED_SORT %q (expected newest, oldest or popular)\n", sort)
+		}
+		defaultFeedSort = sort
+	}
+	// 書き込みにメール認証を必須にするか (デフォルトは必須、REQUIRE_EMAIL_VERIFICATION=false で無効)
+	requireEmailVerification = os.Getenv("REQUIRE_EMAIL_VERIFICATION") != "false"
+	// 内容が同じファイルをユーザーをまたいで1つだけ保存する (参照数は files テーブルで管理する)
+	sharedFileDedup = os.Getenv("SHARED_FILE_DEDUP") == "true"
+	// 入力値の長さ制限 (MAX_TITLE_LENGTH などで上書きできる)
+	if l, err := loadLengthLimits(); err != nil {
+		log.Fatalf("%v\n", err)
+	} else {
+		limits = l
+	}
+
+	// === SQLiteデータベースの初期化 ===
+	// 2. SQLiteのWALモードを有効化 (同時書き込み性能の向上とロックエラー防止)
+	db, err = sql.Open("sqlite3", filepath.Join(dataDir, "soundlike.db?_journal_mode=WAL"))
+	if err != nil {
+		log.Fatalf("error opening database: %v\n", err)
 	}
-	// 2. SQLiteのWALモードを有効化 (同時書き込み性能の向上とロックエラー防止)
-	db, err = sql.Open("sqlite3", filepath.Join(dataDir, "soundlike.db?_journal_mode=WAL"))
-	if err != nil {
-		log.Fatalf("error opening database: %v\n", err)
-	}
-	defer db.Close() // サーバー終了時にデータベース接続を閉じる
 
 	// tracksテーブルを作成（もし存在しなければ）
 	createTableSQL := `
 	CREATE TABLE IF NOT EXISTS tracks (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		filename TEXT NOT NULL UNIQUE,
+		filename TEXT NOT NULL,
 		title TEXT NOT NULL,
 		artist TEXT,
 		lyrics TEXT,
@@ -299,6 +1436,36 @@ func main() {
 		log.Fatalf("error creating user_settings table: %v\n", err)
 	}
 
+	// webhooksテーブルを作成 (アップロード時に通知する外部URL)
+	createWebhooksTableSQL := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_uid TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_uid, url)
+	);`
+	if _, err := db.Exec(createWebhooksTableSQL); err != nil {
+		log.Fatalf("error creating webhooks table: %v\n", err)
+	}
+
+	// api_keysテーブルを作成 (プログラムからの利用向けのAPIキー、キー本体はハッシュのみ保存)
+	createAPIKeysTableSQL := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_uid TEXT NOT NULL,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		key_prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	);`
+	if _, err := db.Exec(createAPIKeysTableSQL); err != nil {
+		log.Fatalf("error creating api_keys table: %v\n", err)
+	}
+
 	// 既存のテーブルに uploader_name カラムがない場合に追加するための処理（簡易マイグレーション）
 	var colExists int
 	// pragma_table_infoを使ってカラムの存在を確認する
@@ -312,27 +1479,242 @@ func main() {
 			log.Println("Migrated: Added uploader_name column to tracks table.")
 		}
 	}
+
+	// artistsテーブルを作成 (表記ゆれを防ぐための正規のアーティスト名)
+	createArtistsTableSQL := `
+	CREATE TABLE IF NOT EXISTS artists (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		normalized_name TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(createArtistsTableSQL); err != nil {
+		log.Fatalf("error creating artists table: %v\n", err)
+	}
+	addColumnIfMissing("tracks", "artist_id", "INTEGER")
+	addColumnIfMissing("tracks", "synced_lyrics", "TEXT") // LRC形式の同期歌詞
+	addColumnIfMissing("tracks", "comments_enabled", "BOOLEAN NOT NULL DEFAULT TRUE")
+	addColumnIfMissing("tracks", "max_comments", "INTEGER")                       // NULL の場合は COMMENT_MAX_PER_TRACK に従う
+	addColumnIfMissing("comments", "hidden", "BOOLEAN NOT NULL DEFAULT FALSE")    // 通報が多く、確認待ちで非表示のコメント
+	addColumnIfMissing("user_settings", "pinned_track_id", "INTEGER")             // プロフィールの先頭に表示するトラック
+	addColumnIfMissing("tracks", "is_featured", "BOOLEAN NOT NULL DEFAULT FALSE") // 管理者が選んだおすすめトラック
+	addColumnIfMissing("tracks", "featured_at", "DATETIME")
+	addColumnIfMissing("comments", "parent_id", "INTEGER")       // 返信先のコメント (トップレベルは NULL)
+	addColumnIfMissing("tracks", "pinned_comment_id", "INTEGER") // アップロード者がピン留めしたコメント
+	addColumnIfMissing("tracks", "external_url", "TEXT")         // 外部URLのトラックの音声URL (ファイルをアップロードしたトラックは NULL)
+	addColumnIfMissing("tracks", "updated_at", "DATETIME")       // 最後に編集された日時 (一覧の ETag に使う、未編集なら NULL)
+	addColumnIfMissing("tracks", "replay_gain_db", "REAL")       // アップロード時に測定した推奨ゲイン (デコードできなかった場合は NULL)
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_id)"); err != nil {
+		log.Fatalf("error creating comments parent index: %v\n", err)
+	}
+	// SHARED_FILE_DEDUP では複数のトラックが同じファイルを参照するため、filename の UNIQUE 制約を外す
+	if err := dropTrackFilenameUnique(); err != nil {
+		log.Fatalf("error migrating tracks.filename: %v\n", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_tracks_filename ON tracks(filename)"); err != nil {
+		log.Fatalf("error creating tracks filename index: %v\n", err)
+	}
+
+	// playsテーブルを作成 (再生履歴、未ログインの再生は user_uid が NULL)
+	createPlaysTableSQL := `
+	CREATE TABLE IF NOT EXISTS plays (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		track_id INTEGER NOT NULL,
+		user_uid TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_plays_track_created ON plays(track_id, created_at);`
+	if _, err := db.Exec(createPlaysTableSQL); err != nil {
+		log.Fatalf("error creating plays table: %v\n", err)
+	}
+
+	// comment_reportsテーブルを作成 (コメントへの通報、1ユーザーにつき1コメント1件まで)
+	createCommentReportsTableSQL := `
+	CREATE TABLE IF NOT EXISTS comment_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		comment_id INTEGER NOT NULL,
+		reporter_uid TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(comment_id, reporter_uid)
+	);`
+	if _, err := db.Exec(createCommentReportsTableSQL); err != nil {
+		log.Fatalf("error creating comment_reports table: %v\n", err)
+	}
+
+	// モデレーションキューで対応済みにした項目の記録 (通報は対応時に削除するため、集計と内容をここに残す)
+	createModerationResolutionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS moderation_resolutions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_type TEXT NOT NULL,
+		item_id INTEGER NOT NULL,
+		track_id INTEGER NOT NULL,
+		author_uid TEXT NOT NULL,
+		content TEXT NOT NULL,
+		report_count INTEGER NOT NULL,
+		reasons TEXT NOT NULL,
+		last_reported_at DATETIME,
+		action TEXT NOT NULL,
+		resolver_uid TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_moderation_resolutions_type ON moderation_resolutions(item_type, created_at);`
+	if _, err := db.Exec(createModerationResolutionsTableSQL); err != nil {
+		log.Fatalf("error creating moderation_resolutions table: %v\n", err)
+	}
+
+	// pending_user_deletionsテーブルを作成 (アカウント削除後に Firebase ユーザーの削除に失敗したもの)
+	createPendingUserDeletionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS pending_user_deletions (
+		user_uid TEXT PRIMARY KEY,
+		attempts INTEGER NOT NULL DEFAULT 1,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(createPendingUserDeletionsTableSQL); err != nil {
+		log.Fatalf("error creating pending_user_deletions table: %v\n", err)
+	}
+
+	// トラックの編集履歴テーブル (タイトル・アーティスト・歌詞の変更をフィールドごとに記録する)
+	createTrackRevisionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS track_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		track_id INTEGER NOT NULL,
+		field TEXT NOT NULL,
+		old_value TEXT,
+		new_value TEXT,
+		editor_uid TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (track_id) REFERENCES tracks(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_track_revisions_track ON track_revisions(track_id, created_at);`
+	if _, err := db.Exec(createTrackRevisionsTableSQL); err != nil {
+		log.Fatalf("error creating track_revisions table: %v\n", err)
+	}
+
+	// 共有ファイルテーブル (SHARED_FILE_DEDUP が有効な場合に、同じ内容のファイルを参照しているトラックの数を記録する)
+	// 無効にした後も、登録済みのファイルは参照数に従って削除する
+	createFilesTableSQL := `
+	CREATE TABLE IF NOT EXISTS files (
+		content_hash TEXT PRIMARY KEY,
+		filename TEXT NOT NULL UNIQUE,
+		ref_count INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(createFilesTableSQL); err != nil {
+		log.Fatalf("error creating files table: %v\n", err)
+	}
+
+	// ユーザーごとの集計値テーブル (follows / tracks のトリガーで更新し、起動時に元のテーブルから作り直す)
+	if _, err := db.Exec(createUserStatsSQL); err != nil {
+		log.Fatalf("error creating user_stats table: %v\n", err)
+	}
+	if err := rebuildUserStats(); err != nil {
+		log.Fatalf("error rebuilding user_stats: %v\n", err)
+	}
+
 	log.Println("Database initialized successfully.")
 
+	// WAL の定期的なチェックポイント (WAL_CHECKPOINT_INTERVAL_SECONDS 秒ごと、0 で無効)
+	if interval := envInt("WAL_CHECKPOINT_INTERVAL_SECONDS", 300); interval > 0 {
+		go runWALCheckpoints(time.Duration(interval) * time.Second)
+	}
+	// 削除に失敗した Firebase ユーザーの再削除 (FIREBASE_DELETE_RETRY_INTERVAL_SECONDS 秒ごと、0 で無効)
+	if interval := envInt("FIREBASE_DELETE_RETRY_INTERVAL_SECONDS", 600); interval > 0 {
+		if authClient, err := app.Auth(context.Background()); err != nil {
+			log.Printf("error getting Auth client for pending user deletions: %v\n", err)
+		} else {
+			go runPendingUserDeletions(authClient, time.Duration(interval)*time.Second)
+		}
+	}
+
 	e := echo.New()
+	e.HTTPErrorHandler = jsonHTTPErrorHandler
+
+	// 末尾のスラッシュは付けない形を正とし、/api/tracks/ などは /api/tracks にリダイレクトする
+	// GET / HEAD は 301、それ以外はメソッドとボディを保ったまま再送されるよう 308 を返す
+	// (/uploads の静的ファイル配信には適用しない)
+	isSafeMethod := func(c echo.Context) bool {
+		m := c.Request().Method
+		return m == http.MethodGet || m == http.MethodHead
+	}
+	skipUploads := func(c echo.Context) bool {
+		return strings.HasPrefix(c.Request().URL.Path, "/uploads")
+	}
+	e.Pre(middleware.RemoveTrailingSlashWithConfig(middleware.TrailingSlashConfig{
+		RedirectCode: http.StatusMovedPermanently,
+		Skipper:      func(c echo.Context) bool { return skipUploads(c) || !isSafeMethod(c) },
+	}))
+	e.Pre(middleware.RemoveTrailingSlashWithConfig(middleware.TrailingSlashConfig{
+		RedirectCode: http.StatusPermanentRedirect,
+		Skipper:      func(c echo.Context) bool { return skipUploads(c) || isSafeMethod(c) },
+	}))
+
+	// リバースプロキシ (Render など) の背後では、信頼するプロキシを指定すると転送ヘッダーからクライアントの IP を求める
+	// レートリミットやアクセスログはこの IP を使う
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v\n", err)
+	}
+	e.IPExtractor = newClientIPExtractor(trustedProxies)
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
+	// CSPはJSON API用 (厳格) とHTMLページ用 (ドキュメントなど) の2種類を使い分ける
+	apiCSP := os.Getenv("CSP_API")
+	if apiCSP == "" {
+		apiCSP = defaultAPICSP
+	}
+	htmlCSP := os.Getenv("CSP_HTML")
+	if htmlCSP == "" {
+		htmlCSP = defaultHTMLCSP
+	}
+
 	// 1. セキュリティヘッダーの追加 (XSS, HSTS, Sniffing対策)
 	// 4. CSPを追加して、万が一のXSSリスクをさらに低減
 	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
 		XSSProtection:         "1; mode=block",
 		ContentTypeNosniff:    "nosniff",
 		XFrameOptions:         "DENY",
-		ContentSecurityPolicy: "default-src 'none'; img-src 'self'; media-src 'self'; style-src 'unsafe-inline';", // APIサーバーなので厳格に
+		ContentSecurityPolicy: apiCSP,
 	}))
 
-	// 2. レートリミット (簡易的なメモリ保存: 1秒あたり20リクエストまで)
-	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(20)))
+	// 2. レートリミット (簡易的なメモリ保存)
+	// 未ログインのリクエストはIPごとに、ログイン済み (有効なIDトークンかAPIキー) はユーザーごとに制限する
+	// スクレイピング対策として、未ログインの方を厳しくする (デフォルトは1秒あたり10リクエストと20リクエスト)
+	// 上限に近づいていることをクライアントが分かるように X-RateLimit-* ヘッダーも返す
+	anonymousRate := envInt("RATE_LIMIT_ANONYMOUS_PER_SECOND", 10)
+	authenticatedRate := envInt("RATE_LIMIT_AUTHENTICATED_PER_SECOND", 20)
+	if anonymousRate < 1 || authenticatedRate < 1 {
+		log.Fatalf("RATE_LIMIT_ANONYMOUS_PER_SECOND and RATE_LIMIT_AUTHENTICATED_PER_SECOND must be positive\n")
+	}
+	e.Use(splitRateLimitMiddleware(
+		newHeaderRateLimiter(float64(anonymousRate), anonymousRate),
+		newHeaderRateLimiter(float64(authenticatedRate), authenticatedRate),
+		func(c echo.Context) string { return rateLimitUserUID(app, c) },
+	))
 
 	// 3. タイムアウト設定 (30秒でタイムアウト) - Slowloris対策
+	// タイムアウトのミドルウェアはレスポンスをバッファーして Flush できないため、少しずつ書き出すCSVエクスポートは除外する
 	e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
 		Timeout: 30 * time.Second,
+		Skipper: func(c echo.Context) bool {
+			return isStreamedExport(c.Request().URL.Path)
+		},
+	}))
+
+	// 5. レスポンス圧縮 (トラック一覧やコメント一覧などのJSONをgzipで返す)
+	// 音声ファイル (MP3) は既に圧縮済みのため、二重圧縮しないように /uploads とストリーミングは除外する
+	// (Rangeリクエストの Content-Length が圧縮でずれるのも防ぐ)
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		Level:     5,
+		MinLength: 1024, // 小さなレスポンスは圧縮のオーバーヘッドの方が大きいので対象外
+		Skipper: func(c echo.Context) bool {
+			path := c.Request().URL.Path
+			return strings.HasPrefix(path, "/uploads") || strings.HasSuffix(path, "/stream") || strings.HasSuffix(path, "/preview") ||
+				isStreamedExport(path)
+		},
 	}))
 
 	// CORS設定: 環境変数 ALLOWED_ORIGINS から許可するオリジンを追加
@@ -346,53 +1728,137 @@ func main() {
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: allowedOrigins,
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "X-API-Key"},
+		// ブラウザのJSから読めるようにするレスポンスヘッダー
+		ExposeHeaders: []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-Comments-Enabled", "X-Track-Title", "X-Track-Artist", "Last-Modified"},
 	}))
 
 	// --- 公開エンドポイント ---
-	e.Static("/uploads", "uploads")
+	// e.Static と同じハンドラーを、HEAD にも応答するよう GET と HEAD で登録する (署名が必須の場合は署名を確認する)
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/uploads*", echo.StaticDirectoryHandler(echo.MustSubFS(e.Filesystem, uploadsDir), false), mediaMiddleware...)
 
 	// Renderのヘルスチェック等に対応するためのルートハンドラ
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "SoundLike Backend API is running")
 	})
 
-	e.GET("/api/tracks", func(c echo.Context) error {
-		// 任意の認証チェック（ログインしていれば is_liked を判定するため）
-		var currentUserID string
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-			idToken := strings.TrimSpace(strings.Replace(authHeader, "Bearer", "", 1))
-			client, err := app.Auth(context.Background())
-			if err == nil {
-				token, err := client.VerifyIDToken(context.Background(), idToken)
-				if err == nil {
-					currentUserID = token.UID
-				}
-			}
+	// APIドキュメント (OpenAPI仕様書と Swagger UI)
+	e.FileFS("/api/openapi.json", "openapi.json", apiDocsFS)
+	e.FileFS("/api/docs", "docs.html", apiDocsFS, overrideCSP(htmlCSP)) // HTMLページなのでCSPを緩める
+	e.FileFS("/api/docs/init.js", "docs-init.js", apiDocsFS)
+
+	// サイトマップ (検索エンジン向けに公開トラックのページ一覧を返す)
+	// 50,000件を超える場合はサイトマップインデックスを返し、/sitemap/:n.xml に分割する
+	e.GET("/sitemap.xml", func(c echo.Context) error {
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM tracks").Scan(&total); err != nil {
+			log.Printf("error counting tracks for sitemap: %v\n", err)
+			return c.String(http.StatusInternalServerError, "Error generating sitemap")
+		}
+
+		var body []byte
+		var err error
+		if total > sitemapMaxURLs {
+			pages := (total + sitemapMaxURLs - 1) / sitemapMaxURLs
+			body, err = buildSitemapIndex(c.Scheme()+"://"+c.Request().Host, pages)
+		} else {
+			body, err = buildTrackSitemap(frontendURL, 0)
+		}
+		if err != nil {
+			log.Printf("error generating sitemap: %v\n", err)
+			return c.String(http.StatusInternalServerError, "Error generating sitemap")
+		}
+		return c.Blob(http.StatusOK, "application/xml; charset=utf-8", body)
+	})
+	e.GET("/sitemap/:page", func(c echo.Context) error {
+		page, err := strconv.Atoi(strings.TrimSuffix(c.Param("page"), ".xml"))
+		if err != nil || page < 1 {
+			return c.String(http.StatusNotFound, "Not found")
+		}
+		body, err := buildTrackSitemap(frontendURL, (page-1)*sitemapMaxURLs)
+		if err != nil {
+			log.Printf("error generating sitemap page %d: %v\n", page, err)
+			return c.String(http.StatusInternalServerError, "Error generating sitemap")
 		}
+		return c.Blob(http.StatusOK, "application/xml; charset=utf-8", body)
+	})
+
+	// トラック一覧API (HEADにも応答する。本文は net/http が破棄する)
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/api/tracks", func(c echo.Context) error {
+		// 任意の認証チェック（ログインしていれば is_liked を判定するため）
+		currentUserID := optionalUserUID(app, c)
 
 		uploaderUID := c.QueryParam("uploader_uid")
 
-		// いいね数と、現在のユーザーがいいねしているかを取得するクエリ
-		baseQuery := `
-		SELECT 
-			t.id, t.filename, t.title, t.artist, t.lyrics, t.uploader_uid, t.uploader_name, t.created_at,
-			(SELECT COUNT(*) FROM likes WHERE track_id = t.id) AS likes_count,
-			EXISTS(SELECT 1 FROM likes WHERE track_id = t.id AND user_uid = ?) AS is_liked
-		FROM tracks t`
+		following, err := parseFeedScope(c, currentUserID)
+		if err != nil {
+			return err
+		}
+
+		sort := c.QueryParam("sort")
+		if sort == "" {
+			sort = defaultFeedSort
+		}
+		orderBy, ok := trackOrderBy(sort)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid sort option"})
+		}
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		withMeta, err := parseListMeta(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		// 条件付きGET (ETag) 対応: ポーリングするクライアントの帯域を削減する
+		// 対象トラックの件数・最新の created_at と、いいねの状態、表示名からWeak ETagを計算する (計算は一覧の取得後)
+		var conditions []string
+		var filterArgs []interface{}
+		if uploaderUID != "" {
+			conditions = append(conditions, "uploader_uid = ?")
+			filterArgs = append(filterArgs, uploaderUID)
+		}
+		if following {
+			// フォロー・フォロー解除で件数が変わるため、ETagにも反映される
+			conditions = append(conditions, "uploader_uid IN (SELECT following_uid FROM follows WHERE follower_uid = ?)")
+			filterArgs = append(filterArgs, currentUserID)
+		}
+		var whereClause string
+		if len(conditions) > 0 {
+			whereClause = " WHERE " + strings.Join(conditions, " AND ")
+		}
+		var trackCount, likesTotal int
+		var maxCreatedAt, maxUpdatedAt sql.NullString
+		var maxLikeID sql.NullInt64
+		// タイトルの編集やおすすめの選出は件数や作成日時を変えないため、updated_at も含める
+		etagQuery := `SELECT COUNT(*), MAX(created_at), MAX(updated_at),
+			(SELECT COUNT(*) FROM likes), (SELECT MAX(id) FROM likes)
+			FROM tracks` + whereClause
+		// trackCount は ?meta=true の総件数にも使う
+		countErr := db.QueryRow(etagQuery, filterArgs...).Scan(&trackCount, &maxCreatedAt, &maxUpdatedAt, &likesTotal, &maxLikeID)
+		if countErr != nil {
+			log.Printf("error computing tracks etag: %v\n", countErr)
+			if withMeta {
+				return c.JSON(http.StatusInternalServerError, "Error retrieving tracks")
+			}
+		}
 
+		// いいね数と、現在のユーザーがいいねしているかを取得するクエリ
 		args := []interface{}{currentUserID}
 		var queryBuilder strings.Builder
-		queryBuilder.WriteString(baseQuery)
+		queryBuilder.WriteString("SELECT " + trackColumns + " FROM " + trackFrom)
 
-		if uploaderUID != "" {
-			queryBuilder.WriteString(" WHERE t.uploader_uid = ?")
-			args = append(args, uploaderUID)
+		if len(conditions) > 0 {
+			// 条件はETag用と同じもの (trackFrom で結合する likes には uploader_uid がないため、そのまま使える)
+			queryBuilder.WriteString(whereClause)
+			args = append(args, filterArgs...)
 		}
 
 		// 1. 全件取得によるサーバークラッシュ防止 (LIMIT制限)
-		queryBuilder.WriteString(" ORDER BY t.created_at DESC LIMIT 50")
+		queryBuilder.WriteString(" ORDER BY " + orderBy + " LIMIT ? OFFSET ?")
+		args = append(args, limit, offset)
 
 		rows, err := db.Query(queryBuilder.String(), args...)
 		if err != nil {
@@ -401,648 +1867,3423 @@ func main() {
 		}
 		defer rows.Close()
 
-		tracks := make([]Track, 0)
-		for rows.Next() {
-			var track Track
-			// lyricsとartistはNULL許容のため、sql.NullStringで受け取る
-			var artist sql.NullString
-			var lyrics sql.NullString
-			var uploaderName sql.NullString // uploader_nameもNULL許容として扱う
-			if err := rows.Scan(&track.ID, &track.Filename, &track.Title, &artist, &lyrics, &track.UploaderUID, &uploaderName, &track.CreatedAt, &track.LikesCount, &track.IsLiked); err != nil {
-				log.Printf("error scanning track row: %v\n", err)
-				return c.JSON(http.StatusInternalServerError, "Error processing tracks")
-			}
-			track.Artist = artist.String
-			track.Lyrics = lyrics.String
-			track.UploaderName = uploaderName.String // NULLの場合は空文字になる
-			tracks = append(tracks, track)
+		tracks, err := scanTracks(rows)
+		if err != nil {
+			log.Printf("error scanning track row: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error processing tracks")
 		}
 
-		return c.JSON(http.StatusOK, tracks)
-	})
-
-	// トラックのコメント一覧を取得するAPI
-	e.GET("/api/track/:id/comments", func(c echo.Context) error {
-		trackID, err := strconv.Atoi(c.Param("id"))
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid track ID")
+		// 削除済みユーザーや表示名の変更を反映する
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
 		}
 
-		rows, err := db.Query("SELECT id, track_id, user_uid, user_name, content, created_at FROM comments WHERE track_id = ? ORDER BY created_at ASC", trackID)
-		if err != nil {
+		if countErr == nil {
+			// クエリパラメータ (uploader_uid/sort/filter) とログインユーザーによって結果が変わるため、ETagにも含める
+			// (DEFAULT_FEED_SORT を変更して再起動した場合に備え、実際に使う並び順も含める)
+			h := fnv.New64a()
+			fmt.Fprintf(h, "%s|%s|%s|%d|%s|%s|%d|%d", c.QueryString(), sort, currentUserID, trackCount, maxCreatedAt.String, maxUpdatedAt.String, likesTotal, maxLikeID.Int64)
+			// Auth 上の表示名の変更は DB に現れないため、解決後の名前も含める
+			for _, t := range tracks {
+				fmt.Fprintf(h, "|%s", t.UploaderName)
+			}
+			etag := fmt.Sprintf(`W/"%x"`, h.Sum64())
+			c.Response().Header().Set("ETag", etag)
+			c.Response().Header().Set("Vary", "Authorization")
+			if match := c.Request().Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+				return c.NoContent(http.StatusNotModified)
+			}
+		}
+
+		return listResponse(c, tracks, len(tracks), withMeta, trackCount, limit, offset)
+	})
+
+	// 新着トラック数API (ポーリングするクライアントが「N件の新しいトラック」を表示するため)
+	// since_id より後に追加されたトラックの件数を返す。絞り込み (uploader_uid / scope) はトラック一覧APIと同じ
+	// id は主キーなので、範囲検索で新しい分だけを数える
+	e.GET("/api/tracks/new-count", func(c echo.Context) error {
+		sinceID, err := strconv.Atoi(c.QueryParam("since_id"))
+		if err != nil || sinceID < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "since_id must be a non-negative integer"})
+		}
+		currentUserID := optionalUserUID(app, c)
+		following, err := parseFeedScope(c, currentUserID)
+		if err != nil {
+			return err
+		}
+
+		query := "SELECT COUNT(*) FROM tracks WHERE id > ?"
+		args := []interface{}{sinceID}
+		if uploaderUID := c.QueryParam("uploader_uid"); uploaderUID != "" {
+			query += " AND uploader_uid = ?"
+			args = append(args, uploaderUID)
+		}
+		if following {
+			query += " AND uploader_uid IN (SELECT following_uid FROM follows WHERE follower_uid = ?)"
+			args = append(args, currentUserID)
+		}
+		var count int
+		if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+			log.Printf("error counting new tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		return c.JSON(http.StatusOK, map[string]int{"count": count})
+	})
+
+	// アーティスト名の候補API (アップロードフォームの入力補完用)
+	// 大文字小文字・空白の違いを無視して、入力に一致する既存のアーティスト名を返す
+	e.GET("/api/artists/suggest", func(c echo.Context) error {
+		q := artistKey(c.QueryParam("q"))
+		if q == "" {
+			return c.JSON(http.StatusOK, []string{})
+		}
+		if len(q) > 100 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Query is too long"})
+		}
+
+		// LIKEのワイルドカード文字をエスケープし、前方一致を優先して並べる
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q)
+		rows, err := db.Query(`
+			SELECT name FROM artists
+			WHERE normalized_name LIKE ? ESCAPE '\'
+			ORDER BY (normalized_name = ?) DESC, (normalized_name LIKE ? ESCAPE '\') DESC, name
+			LIMIT 10`, "%"+escaped+"%", q, escaped+"%")
+		if err != nil {
+			log.Printf("error querying artist suggestions: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving artists")
+		}
+		defer rows.Close()
+
+		names := make([]string, 0)
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err == nil {
+				names = append(names, name)
+			}
+		}
+		return c.JSON(http.StatusOK, names)
+	})
+
+	// おすすめ (編集部選出) トラック一覧API: 選出された日時の新しい順
+	e.GET("/api/tracks/featured", func(c echo.Context) error {
+		currentUserID := optionalUserUID(app, c)
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		withMeta, err := parseListMeta(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		var total int
+		if withMeta {
+			if err := db.QueryRow("SELECT COUNT(*) FROM tracks WHERE is_featured").Scan(&total); err != nil {
+				log.Printf("error counting featured tracks: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Error retrieving tracks")
+			}
+		}
+
+		rows, err := db.Query("SELECT "+trackColumns+" FROM "+trackFrom+" WHERE t.is_featured ORDER BY t.featured_at DESC, t.id DESC LIMIT ? OFFSET ?", currentUserID, limit, offset)
+		if err != nil {
+			log.Printf("error querying featured tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving tracks")
+		}
+		defer rows.Close()
+
+		tracks, err := scanTracks(rows)
+		if err != nil {
+			log.Printf("error scanning featured track row: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error processing tracks")
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
+		}
+		return listResponse(c, tracks, len(tracks), withMeta, total, limit, offset)
+	})
+
+	// IDを指定したトラックの一括取得API (プレイリストや外部サービスとの連携用)
+	// 指定した順番で返し、存在しないIDは結果に含めない (ログインしていれば is_liked も判定する)
+	type TracksByIDsRequest struct {
+		IDs []int `json:"ids"`
+	}
+	e.POST("/api/tracks/by-ids", func(c echo.Context) error {
+		currentUserID := optionalUserUID(app, c)
+
+		var req TracksByIDsRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		}
+		if len(req.IDs) > maxTracksByIDs {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("At most %d ids can be requested at once.", maxTracksByIDs)})
+		}
+
+		tracks, err := tracksByIDs(currentUserID, req.IDs)
+		if err != nil {
+			log.Printf("error querying tracks by ids: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving tracks")
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
+		}
+		return c.JSON(http.StatusOK, tracks)
+	})
+
+	// ユーザーごとのトラック一覧API (プロフィールページ用、ページネーションと総件数付き)
+	e.GET("/api/user/:uid/tracks", func(c echo.Context) error {
+		currentUserID := optionalUserUID(app, c)
+		uploaderUID := c.Param("uid")
+
+		orderBy, ok := trackOrderBy(c.QueryParam("sort"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid sort option"})
+		}
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		stats, err := loadUserStats(uploaderUID)
+		if err != nil {
+			log.Printf("error counting user tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving tracks")
+		}
+		total := stats.TrackCount
+
+		rows, err := db.Query("SELECT "+trackColumns+" FROM "+trackFrom+" WHERE t.uploader_uid = ? ORDER BY "+orderBy+" LIMIT ? OFFSET ?",
+			currentUserID, uploaderUID, limit, offset)
+		if err != nil {
+			log.Printf("error querying user tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving tracks")
+		}
+		defer rows.Close()
+
+		tracks, err := scanTracks(rows)
+		if err != nil {
+			log.Printf("error scanning user track row: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error processing tracks")
+		}
+
+		// ピン留めされたトラックはページに関係なく別フィールドで返す
+		var pinnedTrack *Track
+		var pinnedTrackID sql.NullInt64
+		db.QueryRow("SELECT pinned_track_id FROM user_settings WHERE user_uid = ?", uploaderUID).Scan(&pinnedTrackID)
+		if pinnedTrackID.Valid {
+			pinnedRows, err := db.Query("SELECT "+trackColumns+" FROM "+trackFrom+" WHERE t.id = ? AND t.uploader_uid = ?", currentUserID, pinnedTrackID.Int64, uploaderUID)
+			if err == nil {
+				if pinned, err := scanTracks(pinnedRows); err == nil && len(pinned) > 0 {
+					pinnedTrack = &pinned[0]
+				}
+				pinnedRows.Close()
+			}
+		}
+
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
+			if pinnedTrack != nil {
+				refreshTrackUploaderNames(authClient, []Track{*pinnedTrack})
+			}
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"tracks":       tracks,
+			"pinned_track": pinnedTrack,
+			"total":        total,
+			"limit":        limit,
+			"offset":       offset,
+		})
+	})
+
+	// ユーザーの集計値API (フォロワー数・フォロー数・トラック数、プロフィール表示用)
+	e.GET("/api/user/:uid/stats", func(c echo.Context) error {
+		stats, err := loadUserStats(c.Param("uid"))
+		if err != nil {
+			log.Printf("error loading user stats: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		return c.JSON(http.StatusOK, stats)
+	})
+
+	// 相互フォロー一覧API: 指定ユーザーがフォローしていて、かつフォローし返しているユーザー
+	e.GET("/api/user/:uid/mutuals", func(c echo.Context) error {
+		targetUID := c.Param("uid")
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		// follows の自己結合: a が相手をフォローし、相手も a をフォローしている組
+		const mutualsFrom = `
+			FROM follows a
+			JOIN follows b ON b.follower_uid = a.following_uid AND b.following_uid = a.follower_uid
+			WHERE a.follower_uid = ?`
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) "+mutualsFrom, targetUID).Scan(&total); err != nil {
+			log.Printf("error counting mutuals: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving mutuals")
+		}
+
+		rows, err := db.Query("SELECT a.following_uid "+mutualsFrom+" ORDER BY a.created_at DESC, a.following_uid LIMIT ? OFFSET ?", targetUID, limit, offset)
+		if err != nil {
+			log.Printf("error querying mutuals: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving mutuals")
+		}
+		defer rows.Close()
+		uids := make([]string, 0)
+		for rows.Next() {
+			var uid string
+			if err := rows.Scan(&uid); err == nil {
+				uids = append(uids, uid)
+			}
+		}
+
+		authClient, _ := app.Auth(context.Background())
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"users":  userSummaries(authClient, uids),
+			"total":  total,
+			"limit":  limit,
+			"offset": offset,
+		})
+	})
+
+	// ユーザーごとのコメント一覧API (アクティビティページ用、新しい順、コメント先のトラック情報付き)
+	e.GET("/api/user/:uid/comments", func(c echo.Context) error {
+		targetUID := c.Param("uid")
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		authClient, err := app.Auth(context.Background())
+		if err != nil {
+			log.Printf("error getting Auth client: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Internal server error")
+		}
+		userRecord, err := authClient.GetUser(context.Background(), targetUID)
+		if auth.IsUserNotFound(err) {
+			return c.JSON(http.StatusNotFound, "User not found")
+		}
+		if err != nil {
+			log.Printf("error getting user %s: %v\n", targetUID, err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving user")
+		}
+
+		// 非表示のコメントは本人と管理者にだけ返す
+		includeHidden := false
+		if viewer := optionalUserToken(app, c); viewer != nil {
+			includeHidden = viewer.UID == targetUID || isAdmin(viewer)
+		}
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM comments c JOIN tracks t ON t.id = c.track_id WHERE c.user_uid = ? AND (NOT c.hidden OR ?)", targetUID, includeHidden).Scan(&total); err != nil {
+			log.Printf("error counting user comments: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
+		}
+
+		rows, err := db.Query(`
+			SELECT c.id, c.track_id, c.user_uid, c.user_name, c.content, c.created_at, c.hidden, c.parent_id, t.title
+			FROM comments c JOIN tracks t ON t.id = c.track_id
+			WHERE c.user_uid = ? AND (NOT c.hidden OR ?)
+			ORDER BY c.created_at DESC, c.id DESC
+			LIMIT ? OFFSET ?`, targetUID, includeHidden, limit, offset)
+		if err != nil {
+			log.Printf("error querying user comments: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
+		}
+		defer rows.Close()
+
+		type userComment struct {
+			Comment
+			TrackTitle string `json:"track_title"`
+		}
+		comments := make([]userComment, 0)
+		for rows.Next() {
+			var uc userComment
+			if err := rows.Scan(&uc.ID, &uc.TrackID, &uc.UserUID, &uc.UserName, &uc.Content, &uc.CreatedAt, &uc.Hidden, &uc.ParentID, &uc.TrackTitle); err != nil {
+				log.Printf("error scanning user comment row: %v\n", err)
+				continue
+			}
+			// 投稿時の名前ではなく、現在の表示名とアバターを返す
+			if userRecord.DisplayName != "" {
+				uc.UserName = userRecord.DisplayName
+			}
+			uc.UserAvatarURL = userRecord.PhotoURL
+			comments = append(comments, uc)
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"comments": comments,
+			"total":    total,
+			"limit":    limit,
+			"offset":   offset,
+		})
+	})
+
+	// ユーザーのアクティビティAPI (プロフィールページ用): アップロード・コメント・いいねを1つにまとめて新しい順に返す
+	// 非表示のコメントといいねは本人と管理者にだけ返す
+	e.GET("/api/user/:uid/activity", func(c echo.Context) error {
+		targetUID := c.Param("uid")
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		authClient, err := app.Auth(context.Background())
+		if err != nil {
+			log.Printf("error getting Auth client: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Internal server error")
+		}
+		if _, err := authClient.GetUser(context.Background(), targetUID); auth.IsUserNotFound(err) {
+			return c.JSON(http.StatusNotFound, "User not found")
+		} else if err != nil {
+			log.Printf("error getting user %s: %v\n", targetUID, err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving user")
+		}
+
+		includePrivate := false
+		if viewer := optionalUserToken(app, c); viewer != nil {
+			includePrivate = viewer.UID == targetUID || isAdmin(viewer)
+		}
+
+		events, total, err := queryUserActivity(targetUID, includePrivate, limit, offset)
+		if err != nil {
+			log.Printf("error querying user activity: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving activity")
+		}
+
+		return listResponse(c, events, len(events), true, total, limit, offset)
+	})
+
+	// トラック詳細API (アップロード者のフォロワー数と、ログインしていればフォロー中かどうかを含む)
+	e.GET("/api/track/:id", func(c echo.Context) error {
+		currentUserID := optionalUserUID(app, c)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		rows, err := db.Query("SELECT "+trackColumns+" FROM "+trackFrom+" WHERE t.id = ?", currentUserID, trackID)
+		if err != nil {
+			log.Printf("error querying track %d: %v\n", trackID, err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving track")
+		}
+		tracks, err := scanTracks(rows)
+		rows.Close()
+		if err != nil {
+			log.Printf("error scanning track row: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error processing track")
+		}
+		if len(tracks) == 0 {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
+		}
+
+		detail := TrackDetail{Track: tracks[0]}
+		var isFollowing bool
+		err = db.QueryRow(`
+			SELECT
+				COALESCE((SELECT follower_count FROM user_stats WHERE user_uid = ?), 0),
+				EXISTS(SELECT 1 FROM follows WHERE follower_uid = ? AND following_uid = ?),
+				(SELECT comments_enabled FROM tracks WHERE id = ?)`,
+			detail.UploaderUID, currentUserID, detail.UploaderUID, trackID).Scan(&detail.FollowerCount, &isFollowing, &detail.CommentsEnabled)
+		if err != nil {
+			log.Printf("error querying uploader follow counts: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if currentUserID != "" {
+			detail.IsFollowingUploader = &isFollowing
+		}
+		return c.JSON(http.StatusOK, detail)
+	})
+
+	// トラックの存在確認API (カタログを同期する外部サービス向け、本文なし)
+	// タイトルとアーティストはヘッダーに入れられるよう UTF-8 をパーセントエンコードする
+	// Last-Modified は最後に編集された日時 (編集されていなければアップロード日時)
+	e.HEAD("/api/track/:id", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var title string
+		var artist sql.NullString
+		var createdAt time.Time
+		err = db.QueryRow("SELECT title, artist, created_at FROM tracks WHERE id = ?", trackID).Scan(&title, &artist, &createdAt)
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		if err != nil {
+			log.Printf("error querying track %d: %v\n", trackID, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		lastModified, err := trackLastModified(trackID, createdAt)
+		if err != nil {
+			log.Printf("error querying track revisions for %d: %v\n", trackID, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+
+		h := c.Response().Header()
+		h.Set("X-Track-Title", url.PathEscape(title))
+		h.Set("X-Track-Artist", url.PathEscape(artist.String))
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		return c.NoContent(http.StatusOK)
+	})
+
+	// トラックのコメント一覧を取得するAPI
+	e.GET("/api/track/:id/comments", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		// 並び順 (未指定なら従来どおり古い順)
+		sort := c.QueryParam("sort")
+		if sort == "" {
+			sort = "oldest"
+		}
+		order, ok := commentSortOrders[sort]
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid sort option"})
+		}
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		withMeta, err := parseListMeta(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		// カーソル (?after=<前のページの最後のコメントID>) による取得。?offset= と違い、途中で投稿・削除があってもずれない
+		var afterID int
+		if v := c.QueryParam("after"); v != "" {
+			afterID, err = strconv.Atoi(v)
+			if err != nil || afterID < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "after must be a comment ID"})
+			}
+			if c.QueryParam("offset") != "" {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "after and offset cannot be used together"})
+			}
+			var exists bool
+			if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM comments WHERE id = ? AND track_id = ?)", afterID, trackID).Scan(&exists); err != nil {
+				return c.JSON(http.StatusInternalServerError, "Database error")
+			}
+			if !exists {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "Cursor comment not found on this track"})
+			}
+		}
+		// 従来のクライアントは全件を前提にしているため、?limit= も ?meta=true も指定がなければ件数を制限しない
+		queryLimit := limit
+		if !withMeta && c.QueryParam("limit") == "" {
+			queryLimit = -1
+		}
+
+		// コメントが無効化されていても既存のコメントは表示し、投稿できないことを知らせる
+		// (?meta=true の場合は comments_enabled に、それ以外は配列のまま返すためヘッダーに入れる)
+		var commentsEnabled bool
+		if err := db.QueryRow("SELECT comments_enabled FROM tracks WHERE id = ?", trackID).Scan(&commentsEnabled); err == nil {
+			c.Response().Header().Set("X-Comments-Enabled", strconv.FormatBool(commentsEnabled))
+		}
+
+		// 非表示のコメントは投稿者本人と管理者にだけ返す
+		var viewerUID string
+		var viewerIsAdmin bool
+		if viewer := optionalUserToken(app, c); viewer != nil {
+			viewerUID, viewerIsAdmin = viewer.UID, isAdmin(viewer)
+		}
+
+		const commentsWhere = " FROM comments WHERE track_id = ? AND (NOT hidden OR user_uid = ? OR ?)"
+		const commentColumns = "SELECT id, track_id, user_uid, user_name, content, created_at, hidden, parent_id"
+
+		// アップロード者がピン留めしたコメントは最初のページの先頭に表示し、通常の一覧からは除く
+		// (総件数には含めるため、2ページ目以降は offset を1つ前にずらす)
+		var pinned *Comment
+		var pc Comment
+		err = db.QueryRow(commentColumns+commentsWhere+" AND id = (SELECT pinned_comment_id FROM tracks WHERE id = ?)", trackID, viewerUID, viewerIsAdmin, trackID).
+			Scan(&pc.ID, &pc.TrackID, &pc.UserUID, &pc.UserName, &pc.Content, &pc.CreatedAt, &pc.Hidden, &pc.ParentID)
+		if err == nil {
+			pc.Pinned = true
+			pinned = &pc
+		} else if err != sql.ErrNoRows {
+			log.Printf("error querying pinned comment: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
+		}
+
+		pageWhere := commentsWhere
+		args := []interface{}{trackID, viewerUID, viewerIsAdmin}
+		queryOffset := offset
+		if pinned != nil {
+			pageWhere += " AND id != ?"
+			args = append(args, pinned.ID)
+			switch {
+			case afterID == pinned.ID:
+				// 先頭のピン留めしたコメントをカーソルにした場合は、通常の一覧の最初から返す
+				afterID = 0
+				offset = 1
+				pinned = nil
+			case afterID > 0:
+				pinned = nil
+			case offset == 0:
+				if queryLimit > 0 {
+					queryLimit--
+				}
+			default:
+				queryOffset = offset - 1
+				pinned = nil
+			}
+		}
+		if afterID > 0 {
+			// created_at は保存された値のまま比較するため、カーソルのコメントからサブクエリで取得する
+			pageWhere += " AND (created_at, id) " + order.after + " (SELECT created_at, id FROM comments WHERE id = ?)"
+			args = append(args, afterID)
+		}
+		var total int
+		if withMeta {
+			if err := db.QueryRow("SELECT COUNT(*)"+commentsWhere, trackID, viewerUID, viewerIsAdmin).Scan(&total); err != nil {
+				log.Printf("error counting comments: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
+			}
+			// カーソルを使った場合は、カーソルより前にあるコメントの数を offset として返す
+			if afterID > 0 {
+				var remaining int
+				if err := db.QueryRow("SELECT COUNT(*)"+pageWhere, args...).Scan(&remaining); err != nil {
+					log.Printf("error counting comments: %v\n", err)
+					return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
+				}
+				offset = total - remaining
+			}
+		}
+
+		rows, err := db.Query(commentColumns+pageWhere+" ORDER BY "+order.orderBy+" LIMIT ? OFFSET ?",
+			append(args, queryLimit, queryOffset)...)
+		if err != nil {
 			log.Printf("error querying comments: %v\n", err)
 			return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
 		}
-		defer rows.Close()
+		defer rows.Close()
+
+		comments := make([]Comment, 0)
+		if pinned != nil {
+			comments = append(comments, *pinned)
+		}
+		for rows.Next() {
+			var cm Comment
+			if err := rows.Scan(&cm.ID, &cm.TrackID, &cm.UserUID, &cm.UserName, &cm.Content, &cm.CreatedAt, &cm.Hidden, &cm.ParentID); err == nil {
+				comments = append(comments, cm)
+			}
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshCommentUserNames(authClient, comments)
+		}
+		if withMeta {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"data":             comments,
+				"meta":             listMeta{Total: total, Limit: limit, Offset: offset, HasMore: offset+len(comments) < total},
+				"comments_enabled": commentsEnabled,
+			})
+		}
+		return c.JSON(http.StatusOK, comments)
+	})
+
+	// コメントのスレッド取得API: 指定したコメントと、その返信を木構造で返す
+	// 返信は古い順で、深さは COMMENT_MAX_DEPTH までに制限する
+	e.GET("/api/comment/:id/thread", func(c echo.Context) error {
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || commentID < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid comment ID"})
+		}
+
+		// 非表示のコメント (とその返信) は投稿者本人と管理者にだけ返す
+		var viewerUID string
+		var viewerIsAdmin bool
+		if viewer := optionalUserToken(app, c); viewer != nil {
+			viewerUID, viewerIsAdmin = viewer.UID, isAdmin(viewer)
+		}
+
+		// 再帰CTEで部分木を1回のクエリで取得する
+		rows, err := db.Query(`
+			WITH RECURSIVE thread(id, depth) AS (
+				SELECT id, 0 FROM comments WHERE id = ? AND (NOT hidden OR user_uid = ? OR ?)
+				UNION ALL
+				SELECT c.id, t.depth + 1 FROM comments c JOIN thread t ON c.parent_id = t.id
+				WHERE t.depth < ? AND (NOT c.hidden OR c.user_uid = ? OR ?)
+			)
+			SELECT c.id, c.track_id, c.user_uid, c.user_name, c.content, c.created_at, c.hidden, c.parent_id
+			FROM thread t JOIN comments c ON c.id = t.id
+			ORDER BY c.created_at ASC, c.id ASC`,
+			commentID, viewerUID, viewerIsAdmin, commentMaxDepth, viewerUID, viewerIsAdmin)
+		if err != nil {
+			log.Printf("error querying comment thread: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving comments")
+		}
+		defer rows.Close()
+
+		comments := make([]Comment, 0)
+		for rows.Next() {
+			var cm Comment
+			if err := rows.Scan(&cm.ID, &cm.TrackID, &cm.UserUID, &cm.UserName, &cm.Content, &cm.CreatedAt, &cm.Hidden, &cm.ParentID); err == nil {
+				comments = append(comments, cm)
+			}
+		}
+		if len(comments) == 0 {
+			return c.JSON(http.StatusNotFound, "Comment not found")
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshCommentUserNames(authClient, comments)
+		}
+		return c.JSON(http.StatusOK, buildCommentTree(commentID, comments))
+	})
+
+	// 歌詞取得API (同期歌詞があれば時刻付きの行一覧、なければ通常の歌詞を返す)
+	e.GET("/api/track/:id/lyrics", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var lyrics, syncedLyrics sql.NullString
+		err = db.QueryRow("SELECT lyrics, synced_lyrics FROM tracks WHERE id = ?", trackID).Scan(&lyrics, &syncedLyrics)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			log.Printf("error querying lyrics: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving lyrics")
+		}
+
+		response := map[string]interface{}{
+			"track_id": trackID,
+			"synced":   false,
+			"lines":    []LyricLine{},
+			"lyrics":   lyrics.String,
+		}
+		if syncedLyrics.Valid && syncedLyrics.String != "" {
+			lines, err := parseLRC(syncedLyrics.String)
+			if err != nil {
+				// 保存時に検証済みのため通常は起きないが、念のため通常の歌詞にフォールバックする
+				log.Printf("warning: stored synced lyrics for track %d are invalid: %v", trackID, err)
+			} else {
+				response["synced"] = true
+				response["lines"] = lines
+			}
+		}
+		return c.JSON(http.StatusOK, response)
+	})
+
+	// バージョン情報API (サポート対応や、トークン期限切れ時の時刻ずれの調査用)
+	e.GET("/api/version", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"version":     version,
+			"commit":      buildCommit(),
+			"build_time":  buildTime,
+			"server_time": time.Now().UTC().Format(time.RFC3339),
+		})
+	})
+
+	// クライアント設定API: 入力フォームの文字数制限などを、サーバーの検証と同じ値で表示できるようにする
+	e.GET("/api/config", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"limits": limits,
+		})
+	})
+
+	// サービス全体の統計API (ランディングページ用)
+	// ユーザーは Firebase Auth 側にしかいないため、何らかの操作をしたことのあるユーザー数を数える
+	e.GET("/api/stats", func(c echo.Context) error {
+		// 集計中に他のリクエストを待たせないよう、ロックはキャッシュの読み書きの間だけ取る
+		platformStatsCache.Lock()
+		cached, fetchedAt := platformStatsCache.data, platformStatsCache.fetchedAt
+		platformStatsCache.Unlock()
+		if cached != nil && time.Since(fetchedAt) < time.Minute {
+			return c.JSON(http.StatusOK, cached)
+		}
+
+		var totalTracks, totalUsers, totalLikes, totalComments int
+		err := db.QueryRow(`
+			SELECT
+				(SELECT COUNT(*) FROM tracks),
+				(SELECT COUNT(*) FROM (
+					SELECT uploader_uid FROM tracks
+					UNION SELECT user_uid FROM likes
+					UNION SELECT user_uid FROM comments
+					UNION SELECT follower_uid FROM follows
+					UNION SELECT user_uid FROM user_settings
+				)),
+				(SELECT COUNT(*) FROM likes),
+				(SELECT COUNT(*) FROM comments)`).Scan(&totalTracks, &totalUsers, &totalLikes, &totalComments)
+		if err != nil {
+			log.Printf("error computing platform stats: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving stats")
+		}
+
+		stats := map[string]int{
+			"total_tracks":   totalTracks,
+			"total_users":    totalUsers,
+			"total_likes":    totalLikes,
+			"total_comments": totalComments,
+		}
+		platformStatsCache.Lock()
+		platformStatsCache.data = stats
+		platformStatsCache.fetchedAt = time.Now()
+		platformStatsCache.Unlock()
+		return c.JSON(http.StatusOK, stats)
+	})
+
+	// 最近アクティブなユーザー一覧API (コミュニティのサイドバー用)
+	// 直近7日間にトラックを投稿、またはコメントしたユーザーを、最後の活動が新しい順に最大20人返す
+	e.GET("/api/users/active", func(c echo.Context) error {
+		// DBと Auth への問い合わせ中に他のリクエストを待たせないよう、ロックはキャッシュの読み書きの間だけ取る
+		activeUsersCache.Lock()
+		cached, fetchedAt := activeUsersCache.data, activeUsersCache.fetchedAt
+		activeUsersCache.Unlock()
+		if cached != nil && time.Since(fetchedAt) < time.Minute {
+			return c.JSON(http.StatusOK, map[string]interface{}{"users": cached})
+		}
+
+		// 非表示のコメント (通報による確認待ち) は活動に数えない
+		rows, err := db.Query(`
+			SELECT uid, CAST(strftime('%s', MAX(created_at)) AS INTEGER) AS last_active
+			FROM (
+				SELECT uploader_uid AS uid, created_at FROM tracks WHERE created_at > datetime('now', '-7 days')
+				UNION ALL
+				SELECT user_uid, created_at FROM comments WHERE hidden = FALSE AND created_at > datetime('now', '-7 days')
+			)
+			GROUP BY uid
+			ORDER BY last_active DESC, uid
+			LIMIT 20`)
+		if err != nil {
+			log.Printf("error querying active users: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving active users")
+		}
+		defer rows.Close()
+		var uids []string
+		lastActive := make(map[string]time.Time)
+		for rows.Next() {
+			var uid string
+			var unix int64
+			if err := rows.Scan(&uid, &unix); err != nil {
+				log.Printf("error scanning active user: %v\n", err)
+				continue
+			}
+			uids = append(uids, uid)
+			lastActive[uid] = time.Unix(unix, 0).UTC()
+		}
+
+		if err := rows.Err(); err != nil {
+			log.Printf("error querying active users: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving active users")
+		}
+		rows.Close()
+
+		// Auth に問い合わせできない場合は表示名を空のまま返し、キャッシュはしない (次のリクエストで取得し直す)
+		var names map[string]string
+		authClient, err := app.Auth(context.Background())
+		if err == nil {
+			names, err = resolveDisplayNames(context.Background(), authClient, uids)
+		}
+		if err != nil {
+			log.Printf("warning: could not resolve active user names: %v", err)
+		}
+		users := make([]ActiveUser, 0, len(uids))
+		for _, uid := range uids {
+			users = append(users, ActiveUser{UserSummary: UserSummary{UID: uid, DisplayName: names[uid]}, LastActiveAt: lastActive[uid]})
+		}
+		if err == nil {
+			activeUsersCache.Lock()
+			activeUsersCache.data = users
+			activeUsersCache.fetchedAt = time.Now()
+			activeUsersCache.Unlock()
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"users": users})
+	})
+
+	// 署名付きURLの発行API: ストリーミングAPIと /uploads の音声ファイルの、期限付きのURLを返す
+	// REQUIRE_SIGNED_MEDIA=true の場合、音声の取得にはこのURLを使う必要がある (無効な場合もそのまま使える)
+	e.GET("/api/track/:id/stream-url", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var filename string
+		var externalURL sql.NullString
+		err = db.QueryRow("SELECT filename, external_url FROM tracks WHERE id = ?", trackID).Scan(&filename, &externalURL)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+
+		now := time.Now()
+		streamURL, expiresAt := mediaURLs.signURL(fmt.Sprintf("/api/track/%d/stream", trackID), now)
+		// 外部URLのトラックは、外部のURLをそのまま返す (署名はできない)
+		fileURL := externalURL.String
+		if !externalURL.Valid {
+			fileURL, _ = mediaURLs.signURL("/uploads/"+filename, now)
+		}
+		// 期限付きのURLのため、共有キャッシュには保存させない
+		c.Response().Header().Set("Cache-Control", "private, no-store")
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"stream_url": streamURL,
+			"file_url":   fileURL,
+			"expires_at": expiresAt.UTC(),
+			"required":   requireSignedMedia,
+		})
+	})
+
+	// ストリーミングAPI: Rangeリクエストに対応して音声ファイルを返す
+	// プレーヤーやリンクチェッカーが事前に長さと種類を確認できるよう、HEADにも応答する
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/api/track/:id/stream", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var filename string
+		var externalURL sql.NullString
+		err = db.QueryRow("SELECT filename, external_url FROM tracks WHERE id = ?", trackID).Scan(&filename, &externalURL)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		// 外部URLのトラックは、音声を置いている外部のURLにリダイレクトする
+		if externalURL.Valid {
+			return c.Redirect(http.StatusFound, externalURL.String)
+		}
+
+		f, err := os.Open(uploadFilePath(uploadsDir, filename))
+		if err != nil {
+			log.Printf("error opening audio file for track %d: %v\n", trackID, err)
+			return c.JSON(http.StatusNotFound, "Audio file not found")
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error reading audio file")
+		}
+
+		// 音声ファイルは保存後に書き換えないため、ファイル名・サイズ・更新日時から強いETagを作る
+		// ServeContent が Last-Modified (ファイルの更新日時) を付け、Content-Length / Accept-Ranges / Range / HEAD と
+		// 条件付きリクエスト (If-None-Match / If-Modified-Since / If-Range) を処理して、変更がなければ 304 を返す
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s:%d:%d", filename, info.Size(), info.ModTime().UnixNano())
+		c.Response().Header().Set("ETag", fmt.Sprintf(`"%x"`, h.Sum64()))
+		c.Response().Header().Set("Content-Type", "audio/mpeg")
+		c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+		http.ServeContent(c.Response(), c.Request(), filename, info.ModTime(), f)
+		return nil
+	}, mediaMiddleware...)
+
+	// 試聴API: 音声の先頭 previewDuration 分だけを返す (ログインしていない、または無料のリスナー向け)
+	// MP3のフレーム境界で切り出すため、そのまま再生できる。Rangeリクエスト・HEAD・条件付きリクエストにも対応する
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/api/track/:id/preview", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var filename string
+		var externalURL sql.NullString
+		err = db.QueryRow("SELECT filename, external_url FROM tracks WHERE id = ?", trackID).Scan(&filename, &externalURL)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		// 外部URLのトラックは音声を持っていないため切り出せない
+		if externalURL.Valid {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Previews are not available for externally hosted tracks."})
+		}
+
+		f, err := os.Open(uploadFilePath(uploadsDir, filename))
+		if err != nil {
+			log.Printf("error opening audio file for track %d: %v\n", trackID, err)
+			return c.JSON(http.StatusNotFound, "Audio file not found")
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error reading audio file")
+		}
+
+		// ファイル全体ではなく、切り出すのに必要な先頭部分だけを読み込む
+		header := make([]byte, 10)
+		n, _ := f.ReadAt(header, 0)
+		data, err := io.ReadAll(io.LimitReader(f, mp3ClipReadLimit(header[:n], previewDuration)))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error reading audio file")
+		}
+		clip, err := clipMP3(data, previewDuration)
+		if err != nil {
+			log.Printf("error clipping preview for track %d: %v\n", trackID, err)
+			return c.JSON(http.StatusInternalServerError, "Error generating preview")
+		}
+
+		// 切り出す長さが変わった場合に古い試聴が使われないよう、ETagには長さも含める
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s:%d:%d:%d", filename, info.Size(), info.ModTime().UnixNano(), previewDuration)
+		c.Response().Header().Set("ETag", fmt.Sprintf(`"%x"`, h.Sum64()))
+		c.Response().Header().Set("Content-Type", "audio/mpeg")
+		c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+		http.ServeContent(c.Response(), c.Request(), filename, info.ModTime(), bytes.NewReader(clip))
+		return nil
+	})
+
+	// カバー画像API: カバー画像がないトラックには、トラックIDから決まる代替画像を返す
+	// (クライアントごとに代替画像を用意しなくてよいように、ここで一元的に扱う)
+	e.GET("/api/track/:id/cover", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var title string
+		err = db.QueryRow("SELECT title FROM tracks WHERE id = ?", trackID).Scan(&title)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+
+		cover := placeholderCoverSVG(trackID, title)
+		h := fnv.New64a()
+		h.Write(cover)
+		etag := fmt.Sprintf(`W/"%x"`, h.Sum64())
+		c.Response().Header().Set("ETag", etag)
+		c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+		if match := c.Request().Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.Blob(http.StatusOK, "image/svg+xml", cover)
+	})
+
+	// 再生記録リクエスト構造体 (本文は省略できる)
+	// listener_id はログインしていないリスナーをデバウンスで区別するため、クライアントが生成して保存しておく UUID
+	type PlayRequest struct {
+		ListenerID string `json:"listener_id"`
+	}
+
+	// 再生記録API (ログインしていなくても記録する)
+	e.POST("/api/track/:id/play", func(c echo.Context) error {
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var req PlayRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		}
+		var listenerID string
+		if req.ListenerID != "" {
+			id, err := uuid.Parse(req.ListenerID)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "listener_id must be a UUID"})
+			}
+			listenerID = id.String()
+		}
+
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM tracks WHERE id = ?)", trackID).Scan(&exists); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if !exists {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+
+		// 短時間の再生の繰り返し (リロードや連打) は数えない
+		// ログインしている場合はUID、していない場合はIPアドレスと listener_id の両方で判定する
+		// (listener_id だけでは値を変えて連打でき、IPアドレスだけではネットワークが切り替わると別のリスナーになるため)
+		var userUID sql.NullString
+		now := time.Now()
+		var counted bool
+		if uid := optionalUserUID(app, c); uid != "" {
+			userUID = sql.NullString{String: uid, Valid: true}
+			counted = playDebounce.allow(fmt.Sprintf("%d|uid:%s", trackID, uid), now)
+		} else {
+			// listener_id が指定されなかった場合は新しく発行し、以降のリクエストで送ってもらう
+			if listenerID == "" {
+				listenerID = uuid.New().String()
+			}
+			counted = playDebounce.allow(fmt.Sprintf("%d|listener:%s", trackID, listenerID), now) &&
+				playDebounce.allow(fmt.Sprintf("%d|ip:%s", trackID, c.RealIP()), now)
+		}
+		if counted {
+			if _, err := db.Exec("INSERT INTO plays (track_id, user_uid) VALUES (?, ?)", trackID, userUID); err != nil {
+				log.Printf("error recording play: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Failed to record play")
+			}
+		}
+
+		var playsCount int
+		db.QueryRow("SELECT COUNT(*) FROM plays WHERE track_id = ?", trackID).Scan(&playsCount)
+		response := map[string]interface{}{"plays_count": playsCount, "counted": counted}
+		if !userUID.Valid {
+			response["listener_id"] = listenerID
+		}
+		return c.JSON(http.StatusOK, response)
+	})
+
+	// --- 認証が必要な保護されたルートグループ ---
+	apiGroup := newRouteGroup(e, "/api", firebaseAuthMiddleware(app))
+
+	// announceUpload はアップロードされたトラックを Webhook とフォロワーへのメールで知らせる (非同期)
+	// まとめてアップロードされた場合も、フォロワーへのメールは1通にまとめる
+	announceUpload := func(tracks []Track) {
+		if len(tracks) == 0 {
+			return
+		}
+		// --- Webhook配信 (非同期、失敗時は再試行) ---
+		for _, track := range tracks {
+			go dispatchUploadWebhooks(track)
+		}
+
+		// --- フォロワーへのメール通知処理 (非同期) ---
+		go func(uploaderUID, uploaderName string, tracks []Track, frontendURL string) {
+			// アップロード者自身の通知設定は関係ないが、フォロワーへの通知なのでループ内でチェックする
+
+			// フォロワーのUIDを取得
+			rows, err := db.Query("SELECT follower_uid FROM follows WHERE following_uid = ?", uploaderUID)
+			if err != nil {
+				log.Printf("Error getting followers for notification: %v", err)
+				return
+			}
+			defer rows.Close()
+
+			authClient, err := app.Auth(context.Background())
+			if err != nil {
+				log.Printf("Error getting Auth client for notification: %v", err)
+				return
+			}
+
+			var subject, body string
+			if len(tracks) == 1 {
+				subject = fmt.Sprintf("New track from %s! 🎵", uploaderName)
+				body = fmt.Sprintf(`
+							<h2>New track from %s! 🎵</h2>
+							<p>Hello!</p>
+							<p><strong>%s</strong> has uploaded a new track: "<strong>%s</strong>".</p>
+							<p><a href="%s">Check it out on SoundLike!</a></p>
+							<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
+							<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
+						`, uploaderName, uploaderName, tracks[0].Title, trackPageURL(frontendURL, tracks[0].ID), frontendURL)
+			} else {
+				var list strings.Builder
+				for _, track := range tracks {
+					fmt.Fprintf(&list, `<li><a href="%s">%s</a></li>`, trackPageURL(frontendURL, track.ID), html.EscapeString(track.Title))
+				}
+				subject = fmt.Sprintf("%d new tracks from %s! 🎵", len(tracks), uploaderName)
+				body = fmt.Sprintf(`
+							<h2>New tracks from %s! 🎵</h2>
+							<p>Hello!</p>
+							<p><strong>%s</strong> has uploaded %d new tracks:</p>
+							<ul>%s</ul>
+							<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
+							<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
+						`, uploaderName, uploaderName, len(tracks), list.String(), frontendURL)
+			}
+
+			for rows.Next() {
+				var followerUID string
+				if err := rows.Scan(&followerUID); err == nil {
+					// 通知設定を確認
+					if !shouldNotify(followerUID) {
+						continue
+					}
+
+					// Firebase Authからメールアドレスを取得
+					userRecord, err := authClient.GetUser(context.Background(), followerUID)
+					if err == nil && userRecord.Email != "" {
+						log.Printf("Queueing upload notification to: %s", userRecord.Email)
+						queueEmail([]string{userRecord.Email}, subject, body)
+					}
+				}
+			}
+		}(tracks[0].UploaderUID, tracks[0].UploaderName, tracks, frontendURL)
+	}
+
+	// usesDisposableEmail はユーザーのメールアドレスが使い捨てメールのドメインかを返す (ブロックリストが無効なら常に false)
+	// メールアドレスはトークンのクレームから取得し、含まれていなければ Auth に問い合わせる
+	usesDisposableEmail := func(user *auth.Token) bool {
+		if emailBlocklist == nil {
+			return false
+		}
+		email, _ := user.Claims["email"].(string)
+		if email == "" {
+			authClient, err := app.Auth(context.Background())
+			if err != nil {
+				log.Printf("error getting Auth client for email check: %v\n", err)
+				return false
+			}
+			userRecord, err := authClient.GetUser(context.Background(), user.UID)
+			if err != nil {
+				log.Printf("error getting user %s for email check: %v\n", user.UID, err)
+				return false
+			}
+			email = userRecord.Email
+		}
+		return emailBlocklist.Blocked(email)
+	}
+
+	// checkUploader はアップロードできるユーザーかを確認し、表示名を返す
+	checkUploader := func(user *auth.Token) (string, *uploadError) {
+		// 1. セキュリティ強化: メール未認証のユーザーによる書き込みをバックエンドでも拒否
+		if !requireVerified(user) {
+			return "", &uploadError{status: http.StatusForbidden, message: "Email verification is required to upload."}
+		}
+		if usesDisposableEmail(user) {
+			return "", &uploadError{status: http.StatusForbidden, message: disposableEmailMessage}
+		}
+		// トークンから表示名を取得し、設定されているか確認する
+		uploaderName, ok := user.Claims["name"].(string)
+		if !ok || uploaderName == "" {
+			return "", &uploadError{status: http.StatusForbidden, message: "You must set a display name before uploading."}
+		}
+		return uploaderName, nil
+	}
+
+	// ブロックリストの IP アドレスからのアップロードは、アップロード枠の確保や本文の読み込みより先に拒否する
+	// (クライアントの IP は TRUSTED_PROXIES に従って c.RealIP() で求める)
+	uploadIPFilter := uploadIPBlocklist.middleware()
+
+	apiGroup.POST("/upload", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		log.Printf("File upload attempt by user: %s", user.UID)
+
+		// 同時に処理するアップロード数を制限し、上限に達している場合は少し待ってから再試行してもらう
+		select {
+		case uploadSlots <- struct{}{}:
+			defer func() { <-uploadSlots }()
+		default:
+			c.Response().Header().Set("Retry-After", "10")
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": "Too many uploads in progress. Please try again shortly."})
+		}
+
+		// リクエストボディのサイズ制限 (ファイル + メタデータ分を考慮)
+		// Content-Length で上限を超えていることが分かる場合は、本文を読む前に拒否する
+		if c.Request().ContentLength > maxUploadBodyBytes {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": fmt.Sprintf("File is too large (max %dMB)", maxUploadSizeMB)})
+		}
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxUploadBodyBytes)
+
+		uploaderName, uerr := checkUploader(user)
+		if uerr != nil {
+			return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+		}
+
+		// フォームからメタデータを取得
+		meta := trackMetadata{
+			Title:        c.FormValue("title"),
+			Artist:       c.FormValue("artist"),
+			Lyrics:       c.FormValue("lyrics"),
+			SyncedLyrics: c.FormValue("synced_lyrics"),
+		}
+		if uerr := meta.validate(profanityFilter); uerr != nil {
+			return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+		}
+
+		// 音声はファイルか外部URL (external_url) のどちらか一方で指定する
+		externalURL := strings.TrimSpace(c.FormValue("external_url"))
+		file, err := c.FormFile("file")
+		if err != nil && !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Error retrieving the file"})
+		}
+		if (file != nil) == (externalURL != "") {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Provide either a file or an external_url, but not both"})
+		}
+
+		var uniqueFileName string
+		var external sql.NullString
+		var replayGain sql.NullFloat64
+		if externalURL != "" {
+			if uerr := validateExternalAudioURL(externalURL); uerr != nil {
+				return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+			}
+			uniqueFileName = externalTrackFilename()
+			external = sql.NullString{String: externalURL, Valid: true}
+		} else {
+			src, uerr := openMP3Upload(file, maxUploadSizeMB)
+			if uerr != nil {
+				return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+			}
+			defer src.Close()
+
+			// 3. ファイル名の安全性確保: ディスク上ではUUIDのみを使用する
+			uniqueFileName, err = storeUploadFile(uploadsDir, src)
+			if err != nil {
+				log.Printf("error saving upload: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Error saving the file")
+			}
+			// 保存したファイルをデコードして、音量を揃えるための推奨ゲインを求める
+			replayGain = measureReplayGain(uploadFilePath(uploadsDir, uniqueFileName))
+		}
+
+		// データベースにメタデータを保存
+		// filenameカラムには uniqueFileName (uuid.mp3 または 2024/01/uuid.mp3) が入るため、フロントエンドからのアクセスURLも安全になる
+		// アーティスト名は既存の正規名に寄せる (大文字小文字・空白の違いによる表記ゆれを防ぐ)
+		var artistID sql.NullInt64
+		if meta.Artist != "" {
+			id, canonical, err := resolveArtist(meta.Artist)
+			if err != nil {
+				log.Printf("error resolving artist %q: %v\n", meta.Artist, err)
+			} else {
+				artistID = sql.NullInt64{Int64: id, Valid: true}
+				meta.Artist = canonical
+			}
+		}
+
+		insertSQL := `INSERT INTO tracks (filename, title, artist, lyrics, uploader_uid, uploader_name, artist_id, synced_lyrics, external_url, replay_gain_db) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		result, err := db.Exec(insertSQL, uniqueFileName, meta.Title, meta.Artist, meta.Lyrics, user.UID, uploaderName, artistID, sql.NullString{String: meta.SyncedLyrics, Valid: meta.SyncedLyrics != ""}, external, replayGain)
+		if err != nil {
+			log.Printf("error inserting track metadata: %v\n", err)
+			// 4. ゴミファイル対策: DB保存失敗時はファイルを削除する
+			releaseUploadFile(uploadsDir, uniqueFileName)
+			// 5. 情報漏洩対策: 内部エラー詳細(err.Error())をクライアントに返さない
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error during metadata saving."})
+		}
+		trackID, _ := result.LastInsertId()
+
+		// 外部URLのトラックは uploads ディレクトリにファイルがないため、filename は返さない
+		announcedFilename := uniqueFileName
+		if external.Valid {
+			announcedFilename = ""
+		}
+		announceUpload([]Track{{
+			ID:           int(trackID),
+			Filename:     announcedFilename,
+			ExternalURL:  external.String,
+			Title:        meta.Title,
+			Artist:       meta.Artist,
+			Lyrics:       meta.Lyrics,
+			UploaderUID:  user.UID,
+			UploaderName: uploaderName,
+			CreatedAt:    time.Now().UTC(),
+		}})
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "File uploaded successfully!"})
+	}, uploadIPFilter)
+
+	// まとめてアップロードする際の1ファイルごとの結果
+	type BatchUploadResult struct {
+		Index    int    `json:"index"`
+		Filename string `json:"filename"`
+		ID       int    `json:"id,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}
+
+	// 複数トラックのアップロードAPI (EPなど)
+	// multipart の files に最大 maxBatchFiles 件のファイル、metadata に同じ順番のメタデータの JSON 配列を指定する
+	// 入力に問題のあるファイルだけを失敗として残りは登録するが、保存やDBのエラーが起きた場合は全件を取り消す
+	apiGroup.POST("/upload/batch", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		log.Printf("Batch upload attempt by user: %s", user.UID)
+
+		select {
+		case uploadSlots <- struct{}{}:
+			defer func() { <-uploadSlots }()
+		default:
+			c.Response().Header().Set("Retry-After", "10")
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": "Too many uploads in progress. Please try again shortly."})
+		}
+
+		// バッチ全体のサイズ制限
+		if c.Request().ContentLength > maxBatchBodyBytes {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": fmt.Sprintf("Batch is too large (max %dMB in total)", maxBatchUploadSizeMB)})
+		}
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxBatchBodyBytes)
+
+		uploaderName, uerr := checkUploader(user)
+		if uerr != nil {
+			return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": fmt.Sprintf("Batch is too large (max %dMB in total)", maxBatchUploadSizeMB)})
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid multipart form"})
+		}
+		files := form.File["files"]
+		if len(files) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "No files were uploaded"})
+		}
+		if len(files) > maxBatchFiles {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("Too many files (max %d per batch)", maxBatchFiles)})
+		}
+		var metas []trackMetadata
+		if values := form.Value["metadata"]; len(values) == 1 {
+			if err := json.Unmarshal([]byte(values[0]), &metas); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "metadata must be a JSON array"})
+			}
+		}
+		if len(metas) != len(files) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "metadata must contain one entry per file"})
+		}
+
+		// 1. ファイルごとに検証して保存する (問題のあるものは結果にエラーを記録して除外する)
+		// バッチ全体をメモリに保持しないよう1ファイルずつ保存し、途中で失敗した場合は保存済みのファイルも削除する
+		results := make([]BatchUploadResult, len(files))
+		saved := make(map[int]string)
+		removeSaved := func() {
+			for _, name := range saved {
+				releaseUploadFile(uploadsDir, name)
+			}
+		}
+		artistIDs := make(map[int]sql.NullInt64)
+		replayGains := make(map[int]sql.NullFloat64)
+		for i, file := range files {
+			results[i] = BatchUploadResult{Index: i, Filename: file.Filename}
+			if uerr := metas[i].validate(profanityFilter); uerr != nil {
+				results[i].Error = uerr.message
+				continue
+			}
+			src, uerr := openMP3Upload(file, maxUploadSizeMB)
+			if uerr != nil {
+				if uerr.status >= http.StatusInternalServerError {
+					log.Printf("error reading batch upload file %q: %v\n", file.Filename, uerr)
+					removeSaved()
+					return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+				}
+				results[i].Error = uerr.message
+				continue
+			}
+			name, err := storeUploadFile(uploadsDir, src)
+			src.Close()
+			if err != nil {
+				log.Printf("error saving batch upload: %v\n", err)
+				removeSaved()
+				return c.JSON(http.StatusInternalServerError, "Error saving the file")
+			}
+			saved[i] = name
+			replayGains[i] = measureReplayGain(uploadFilePath(uploadsDir, name))
+			// トランザクション外で先にアーティストを解決しておく (SQLiteの書き込みロックと競合させない)
+			if metas[i].Artist != "" {
+				id, canonical, err := resolveArtist(metas[i].Artist)
+				if err != nil {
+					log.Printf("error resolving artist %q: %v\n", metas[i].Artist, err)
+				} else {
+					artistIDs[i] = sql.NullInt64{Int64: id, Valid: true}
+					metas[i].Artist = canonical
+				}
+			}
+		}
+		if len(saved) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"message": "No files could be uploaded", "results": results})
+		}
+
+		// 3. メタデータは1つのトランザクションでまとめて登録する
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("error starting batch upload transaction: %v\n", err)
+			removeSaved()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error during metadata saving."})
+		}
+		now := time.Now().UTC()
+		var created []Track
+		for i := range files {
+			name, ok := saved[i]
+			if !ok {
+				continue
+			}
+			m := metas[i]
+			result, err := tx.Exec(`INSERT INTO tracks (filename, title, artist, lyrics, uploader_uid, uploader_name, artist_id, synced_lyrics, replay_gain_db) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				name, m.Title, m.Artist, m.Lyrics, user.UID, uploaderName, artistIDs[i], sql.NullString{String: m.SyncedLyrics, Valid: m.SyncedLyrics != ""}, replayGains[i])
+			if err != nil {
+				log.Printf("error inserting batch track metadata: %v\n", err)
+				tx.Rollback()
+				removeSaved()
+				return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error during metadata saving."})
+			}
+			trackID, _ := result.LastInsertId()
+			results[i].ID = int(trackID)
+			created = append(created, Track{
+				ID:           int(trackID),
+				Filename:     name,
+				Title:        m.Title,
+				Artist:       m.Artist,
+				Lyrics:       m.Lyrics,
+				UploaderUID:  user.UID,
+				UploaderName: uploaderName,
+				CreatedAt:    now,
+			})
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("error committing batch upload: %v\n", err)
+			removeSaved()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error during metadata saving."})
+		}
+
+		announceUpload(created)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"created": len(created),
+			"failed":  len(files) - len(created),
+			"results": results,
+		})
+	}, uploadIPFilter)
+
+	// ProfileUpdateRequest defines the structure for the profile update request
+	type ProfileUpdateRequest struct {
+		DisplayName string `json:"display_name"`
+	}
+
+	// プロフィール更新API (表示名の重複チェックを含む)
+	apiGroup.POST("/profile", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+
+		var req ProfileUpdateRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		}
+
+		// メール未認証ならプロフィール更新も禁止
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to update profile."})
+		}
+		if usesDisposableEmail(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": disposableEmailMessage})
+		}
+
+		newDisplayName, reason := validateDisplayName(profanityFilter, req.DisplayName)
+		if reason != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": reason})
+		}
+
+		// 表示名の重複をチェック (自分以外のユーザーが使っていないか)
+		taken, err := displayNameTaken(newDisplayName, user.UID)
+		if err != nil {
+			log.Printf("error checking display name uniqueness: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error checking display name."})
+		}
+		if taken {
+			return c.JSON(http.StatusConflict, map[string]string{"message": "Display name '" + newDisplayName + "' is already taken."})
+		}
+
+		// Firebase Authの表示名を更新
+		authClient, err := app.Auth(context.Background())
+		if err != nil {
+			log.Printf("error getting Auth client for profile update: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error."})
+		}
+		params := (&auth.UserToUpdate{}).DisplayName(newDisplayName)
+		if _, err := authClient.UpdateUser(context.Background(), user.UID, params); err != nil {
+			log.Printf("error updating firebase auth display name for user %s: %v\n", user.UID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update authentication profile."})
+		}
+
+		// 既存のトラックのuploader_nameをすべて更新
+		// この処理はAuthの更新が成功してから行う
+		if _, err := db.Exec("UPDATE tracks SET uploader_name = ?, "+touchTrack+" WHERE uploader_uid = ?", newDisplayName, user.UID); err != nil {
+			// ここで失敗した場合、Authの更新とDBの更新に不整合が起きるが、
+			// 次回のアップロードやプロフィール更新で修正される可能性が高い。
+			log.Printf("error updating uploader_name in tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error updating track information."})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{"message": "Profile updated successfully!"})
+	})
+
+	// 表示名の使用可否API (プロフィール編集フォームでの入力中のチェック用)
+	// プロフィール更新と同じ検証を行い、使えない場合は理由を返す
+	apiGroup.GET("/profile/name-available", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+
+		name, reason := validateDisplayName(profanityFilter, c.QueryParam("name"))
+		if reason != "" {
+			return c.JSON(http.StatusOK, map[string]interface{}{"available": false, "reason": reason})
+		}
+		taken, err := displayNameTaken(name, user.UID)
+		if err != nil {
+			log.Printf("error checking display name availability: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error checking display name."})
+		}
+		if taken {
+			return c.JSON(http.StatusOK, map[string]interface{}{"available": false, "name": name, "reason": "Display name '" + name + "' is already taken."})
+		}
+		// name は伏せ字などを適用した後の、実際に保存される表示名
+		return c.JSON(http.StatusOK, map[string]interface{}{"available": true, "name": name})
+	})
+
+	// ログイン中のユーザー情報API (トークンのクレームと設定をまとめて返す)
+	apiGroup.GET("/me", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		displayName, _ := user.Claims["name"].(string)
+		email, _ := user.Claims["email"].(string)
+		emailVerified, _ := user.Claims["email_verified"].(bool)
+
+		// 設定が未保存の場合はデフォルト値 (通知ON、ピン留めなし)
+		emailNotifications := true
+		var pinnedTrackID sql.NullInt64
+		err := db.QueryRow("SELECT email_notifications, pinned_track_id FROM user_settings WHERE user_uid = ?", user.UID).Scan(&emailNotifications, &pinnedTrackID)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("error querying settings for %s: %v\n", user.UID, err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		settings := map[string]interface{}{
+			"email_notifications": emailNotifications,
+			"pinned_track_id":     nil,
+		}
+		if pinnedTrackID.Valid {
+			settings["pinned_track_id"] = pinnedTrackID.Int64
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"uid":            user.UID,
+			"display_name":   displayName,
+			"email":          email,
+			"email_verified": emailVerified,
+			"settings":       settings,
+		})
+	})
+
+	// 通知設定の取得API
+	apiGroup.GET("/settings", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		var enabled bool
+		var updatedAt sql.NullTime
+		err := db.QueryRow("SELECT email_notifications, updated_at FROM user_settings WHERE user_uid = ?", user.UID).Scan(&enabled, &updatedAt)
+		if err == sql.ErrNoRows {
+			// デフォルトはON (一度も保存していないため updated_at は null)
+			return c.JSON(http.StatusOK, map[string]interface{}{"email_notifications": true, "updated_at": nil})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		// updated_at は CURRENT_TIMESTAMP (UTC) で保存しているため、UTC の RFC3339 で返す (クライアントのキャッシュ無効化用)
+		response := map[string]interface{}{"email_notifications": enabled, "updated_at": nil}
+		if updatedAt.Valid {
+			response["updated_at"] = updatedAt.Time.UTC().Format(time.RFC3339)
+		}
+		return c.JSON(http.StatusOK, response)
+	})
+
+	// 通知設定の更新API
+	type SettingsUpdateRequest struct {
+		EmailNotifications bool `json:"email_notifications"`
+	}
+	apiGroup.POST("/settings", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		var req SettingsUpdateRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid request")
+		}
+
+		// UPSERT (存在すれば更新、なければ挿入)
+		// SQLite 3.24.0+ であれば INSERT ... ON CONFLICT が使えるが、
+		// 互換性のため REPLACE INTO を使用するか、INSERT OR REPLACE を使用する
+		_, err := db.Exec(`
+			INSERT INTO user_settings (user_uid, email_notifications, updated_at) 
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(user_uid) DO UPDATE SET 
+			email_notifications = excluded.email_notifications,
+			updated_at = CURRENT_TIMESTAMP`, user.UID, req.EmailNotifications)
+		if err != nil {
+			log.Printf("Error updating settings: %v", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to update settings")
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "Settings updated."})
+	})
+
+	// いいねしたトラック一覧を取得するAPI
+	apiGroup.GET("/tracks/favorites", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		withMeta, err := parseListMeta(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		var total int
+		if withMeta {
+			if err := db.QueryRow("SELECT COUNT(*) FROM likes l INNER JOIN tracks t ON t.id = l.track_id WHERE l.user_uid = ?", user.UID).Scan(&total); err != nil {
+				log.Printf("error counting favorite tracks: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Error retrieving favorite tracks")
+			}
+		}
+
+		// ユーザーがいいねしたトラックを取得するクエリ
+		// JOINを使って、likesテーブルとtracksテーブルを結合する
+		query := `
+		SELECT ` + trackColumns + `
+		FROM ` + trackFrom + `
+		INNER JOIN likes l ON t.id = l.track_id
+		WHERE l.user_uid = ?
+		ORDER BY l.created_at DESC, l.id DESC
+		LIMIT ? OFFSET ?` // お気に入り一覧もLIMITで保護
+
+		rows, err := db.Query(query, user.UID, user.UID, limit, offset)
+		if err != nil {
+			log.Printf("error querying favorite tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving favorite tracks")
+		}
+		defer rows.Close()
+
+		tracks, err := scanTracks(rows)
+		if err != nil {
+			log.Printf("error scanning favorite track row: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error processing favorite tracks")
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
+		}
+		return listResponse(c, tracks, len(tracks), withMeta, total, limit, offset)
+	})
+
+	// 自分がコメントしたトラック一覧を取得するAPI (最後にコメントした日時の新しい順)
+	// 新しいAPIのため、配列ではなく常に {data, meta} で返す
+	apiGroup.GET("/account/commented", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(DISTINCT cm.track_id) FROM comments cm INNER JOIN tracks t ON t.id = cm.track_id WHERE cm.user_uid = ?", user.UID).Scan(&total); err != nil {
+			log.Printf("error counting commented tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving commented tracks")
+		}
+
+		// 同じトラックに複数コメントしていても1件になるよう、トラックごとに集計してから結合する
+		query := `
+		SELECT ` + trackColumns + `
+		FROM ` + trackFrom + `
+		INNER JOIN (
+			SELECT track_id, MAX(created_at) AS last_commented_at, MAX(id) AS last_comment_id
+			FROM comments
+			WHERE user_uid = ?
+			GROUP BY track_id
+		) uc ON uc.track_id = t.id
+		ORDER BY uc.last_commented_at DESC, uc.last_comment_id DESC
+		LIMIT ? OFFSET ?`
+
+		rows, err := db.Query(query, user.UID, user.UID, limit, offset)
+		if err != nil {
+			log.Printf("error querying commented tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving commented tracks")
+		}
+		defer rows.Close()
+
+		tracks, err := scanTracks(rows)
+		if err != nil {
+			log.Printf("error scanning commented track row: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error processing commented tracks")
+		}
+		if authClient, err := app.Auth(context.Background()); err == nil {
+			refreshTrackUploaderNames(authClient, tracks)
+		}
+		return listResponse(c, tracks, len(tracks), true, total, limit, offset)
+	})
+
+	// いいね通知処理 (非同期)
+	notifyNewLike := func(user *auth.Token, trackID int) {
+		likerName, _ := user.Claims["name"].(string)
+		if likerName == "" {
+			likerName = "Someone"
+		}
+
+		go func(trackID int, likerName, likerUID, frontendURL string) {
+			var uploaderUID, trackTitle string
+			err := db.QueryRow("SELECT uploader_uid, title FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID, &trackTitle)
+			if err != nil {
+				return
+			}
+
+			// 自分の投稿へのいいねなら通知しない
+			if uploaderUID == likerUID {
+				return
+			}
+
+			// 通知設定を確認
+			if !shouldNotify(uploaderUID) {
+				return
+			}
+
+			authClient, err := app.Auth(context.Background())
+			if err != nil {
+				return
+			}
+
+			userRecord, err := authClient.GetUser(context.Background(), uploaderUID)
+			if err == nil && userRecord.Email != "" {
+				subject := fmt.Sprintf("New like on \"%s\" 💖", trackTitle)
+				body := fmt.Sprintf(`
+						<h2>New like on "%s" 💖</h2>
+						<p>Hello!</p>
+						<p><strong>%s</strong> liked your track "<strong>%s</strong>".</p>
+						<p><a href="%s">Check it out on SoundLike!</a></p>
+						<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
+						<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
+					`, trackTitle, likerName, trackTitle, trackPageURL(frontendURL, trackID), frontendURL)
+				log.Printf("Queueing like notification to: %s", userRecord.Email)
+				queueEmail([]string{userRecord.Email}, subject, body)
+			}
+		}(trackID, likerName, user.UID, frontendURL)
+	}
+
+	// likeStateResponse は更新後のいいね数と状態を返す
+	// changed はこのリクエストで実際にいいねが追加・削除されたかを表す (既に同じ状態だった場合は false)
+	likeStateResponse := func(c echo.Context, trackID int, liked, changed bool) error {
+		var newCount int
+		db.QueryRow("SELECT COUNT(*) FROM likes WHERE track_id = ?"+likesCountFilter, trackID).Scan(&newCount)
+		return c.JSON(http.StatusOK, map[string]interface{}{"likes_count": newCount, "is_liked": liked, "changed": changed})
+	}
+
+	// いいね機能のAPI
+	apiGroup.POST("/track/:id/like", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		// メール未認証ならいいねも禁止
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to like tracks."})
+		}
+
+		var trackUploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&trackUploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+
+		// 2. DB整合性強化: トランザクションを開始
+		tx, err := db.Begin()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database transaction error")
+		}
+		defer tx.Rollback() // エラー時はロールバック
+
+		// トランザクション内でチェック
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM likes WHERE user_uid = ? AND track_id = ?)", user.UID, trackID).Scan(&exists)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+
+		if exists {
+			_, err = tx.Exec("DELETE FROM likes WHERE user_uid = ? AND track_id = ?", user.UID, trackID)
+		} else if !allowSelfLike && trackUploaderUID == user.UID {
+			// 自分のトラックへのいいねによる水増しを防ぐ (既存の自己いいねの取り消しは許可する)
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You cannot like your own track."})
+		} else {
+			_, err = tx.Exec("INSERT INTO likes (user_uid, track_id) VALUES (?, ?)", user.UID, trackID)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Failed to update likes")
+		}
+		if err := tx.Commit(); err != nil { // コミット実行
+			return c.JSON(http.StatusInternalServerError, "Failed to commit transaction")
+		}
+
+		// --- いいね通知処理 (非同期) ---
+		// 新規いいねの場合のみ通知
+		if !exists {
+			notifyNewLike(user, trackID)
+		}
+
+		// 更新後のカウントと状態を返す (トグルのため常に changed)
+		return likeStateResponse(c, trackID, !exists, true)
+	})
+
+	// いいねAPI (冪等): 既にいいねしていても同じ結果を返すため、リトライしても安全
+	// changed で今回のリクエストでいいねが追加されたかが分かる (クライアントのアニメーション用)
+	apiGroup.PUT("/track/:id/like", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to like tracks."})
+		}
+
+		var trackUploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&trackUploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if !allowSelfLike && trackUploaderUID == user.UID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You cannot like your own track."})
+		}
+
+		result, err := db.Exec("INSERT OR IGNORE INTO likes (user_uid, track_id) VALUES (?, ?)", user.UID, trackID)
+		if err != nil {
+			log.Printf("error liking track: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to update likes")
+		}
+		n, _ := result.RowsAffected()
+		if n > 0 {
+			notifyNewLike(user, trackID)
+		}
+		return likeStateResponse(c, trackID, true, n > 0)
+	})
+
+	// いいね取り消しAPI (冪等)
+	apiGroup.DELETE("/track/:id/like", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		result, err := db.Exec("DELETE FROM likes WHERE user_uid = ? AND track_id = ?", user.UID, trackID)
+		if err != nil {
+			log.Printf("error unliking track: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to update likes")
+		}
+		n, _ := result.RowsAffected()
+		return likeStateResponse(c, trackID, false, n > 0)
+	})
+
+	// フォロー通知処理 (非同期)
+	notifyNewFollower := func(user *auth.Token, targetUID string) {
+		followerName, _ := user.Claims["name"].(string)
+		if followerName == "" {
+			followerName = "Someone"
+		}
+
+		go func(targetUID, followerName, followerURL, frontendURL string) {
+			// 通知設定を確認
+			if !shouldNotify(targetUID) {
+				log.Printf("Follow notification skipped: User %s has disabled notifications.", targetUID)
+				return
+			}
+
+			authClient, err := app.Auth(context.Background())
+			if err != nil {
+				log.Printf("Follow notification error: Failed to get Auth client: %v", err)
+				return
+			}
+
+			userRecord, err := authClient.GetUser(context.Background(), targetUID)
+			if err != nil {
+				log.Printf("Follow notification error: Failed to get user %s from Firebase: %v", targetUID, err)
+				return
+			}
+
+			if userRecord.Email != "" {
+				subject := "New follower! 🌟"
+				body := fmt.Sprintf(`
+					<h2>You have a new follower! 🌟</h2>
+					<p>Hello!</p>
+					<p><strong>%s</strong> is now following you.</p>
+					<p><a href="%s">Check out their profile on SoundLike!</a></p>
+					<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
+					<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
+				`, followerName, followerURL, frontendURL)
+				log.Printf("Queueing follow notification to: %s", userRecord.Email)
+				queueEmail([]string{userRecord.Email}, subject, body)
+			} else {
+				log.Printf("Follow notification skipped: User %s has no email address.", targetUID)
+			}
+		}(targetUID, followerName, userPageURL(frontendURL, user.UID), frontendURL)
+	}
+
+	// ユーザーフォロー機能 (トグル)
+	apiGroup.POST("/user/:uid/follow", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		targetUID := c.Param("uid")
+
+		if user.UID == targetUID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You cannot follow yourself."})
+		}
+
+		// メール未認証ならフォロー禁止
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to follow users."})
+		}
+
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM follows WHERE follower_uid = ? AND following_uid = ?)", user.UID, targetUID).Scan(&exists)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+
+		if exists {
+			_, err = db.Exec("DELETE FROM follows WHERE follower_uid = ? AND following_uid = ?", user.UID, targetUID)
+			return c.JSON(http.StatusOK, map[string]interface{}{"is_following": false, "message": "Unfollowed successfully."})
+		} else {
+			_, err = db.Exec("INSERT INTO follows (follower_uid, following_uid) VALUES (?, ?)", user.UID, targetUID)
+			notifyNewFollower(user, targetUID)
+			return c.JSON(http.StatusOK, map[string]interface{}{"is_following": true, "message": "Followed successfully."})
+		}
+	})
+
+	// フォロー状態を返す共通処理 (PUT / DELETE 用)
+	followStateResponse := func(c echo.Context, targetUID string, following bool) error {
+		stats, err := loadUserStats(targetUID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"is_following": following, "follower_count": stats.FollowerCount})
+	}
+
+	// フォローAPI (冪等): 既にフォローしていても同じ結果を返すため、リトライしても安全
+	// POST のトグルは後方互換のために残しているが、新しいクライアントは PUT / DELETE を使うこと
+	apiGroup.PUT("/user/:uid/follow", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		targetUID := c.Param("uid")
+
+		if user.UID == targetUID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You cannot follow yourself."})
+		}
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to follow users."})
+		}
+
+		result, err := db.Exec("INSERT OR IGNORE INTO follows (follower_uid, following_uid) VALUES (?, ?)", user.UID, targetUID)
+		if err != nil {
+			log.Printf("error following user: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to follow user")
+		}
+		// 新しくフォローした場合のみ通知する
+		if n, _ := result.RowsAffected(); n > 0 {
+			notifyNewFollower(user, targetUID)
+		}
+		return followStateResponse(c, targetUID, true)
+	})
+
+	// フォロー解除API (冪等)
+	apiGroup.DELETE("/user/:uid/follow", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		targetUID := c.Param("uid")
+
+		if _, err := db.Exec("DELETE FROM follows WHERE follower_uid = ? AND following_uid = ?", user.UID, targetUID); err != nil {
+			log.Printf("error unfollowing user: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to unfollow user")
+		}
+		return followStateResponse(c, targetUID, false)
+	})
+
+	// フォロー状態確認API
+	apiGroup.GET("/user/:uid/follow/status", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		targetUID := c.Param("uid")
+
+		var exists, followsBack bool
+		err := db.QueryRow(`
+			SELECT
+				EXISTS(SELECT 1 FROM follows WHERE follower_uid = ? AND following_uid = ?),
+				EXISTS(SELECT 1 FROM follows WHERE follower_uid = ? AND following_uid = ?)`,
+			user.UID, targetUID, targetUID, user.UID).Scan(&exists, &followsBack)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		return c.JSON(http.StatusOK, map[string]bool{"is_following": exists, "is_mutual": exists && followsBack})
+	})
+
+	// フォローのおすすめAPI: いいねしたトラックのアップロード者と、フォロー中のユーザーがフォローしているユーザー
+	// いいね1件につき2点、フォロー中のユーザー1人からのフォローにつき1点として、上位20人を返す
+	apiGroup.GET("/recommendations/users", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+
+		rows, err := db.Query(`
+			SELECT uid, SUM(score) AS total_score FROM (
+				SELECT t.uploader_uid AS uid, 2 AS score
+				FROM likes l JOIN tracks t ON t.id = l.track_id
+				WHERE l.user_uid = ?
+				UNION ALL
+				SELECT f2.following_uid AS uid, 1 AS score
+				FROM follows f1 JOIN follows f2 ON f2.follower_uid = f1.following_uid
+				WHERE f1.follower_uid = ?
+			)
+			WHERE uid != ? AND uid NOT IN (SELECT following_uid FROM follows WHERE follower_uid = ?)
+			GROUP BY uid
+			ORDER BY total_score DESC, uid
+			LIMIT 20`, user.UID, user.UID, user.UID, user.UID)
+		if err != nil {
+			log.Printf("error querying user recommendations: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving recommendations")
+		}
+		defer rows.Close()
+
+		var uids []string
+		scores := make(map[string]int)
+		for rows.Next() {
+			var uid string
+			var score int
+			if err := rows.Scan(&uid, &score); err == nil {
+				uids = append(uids, uid)
+				scores[uid] = score
+			}
+		}
+
+		type recommendedUser struct {
+			UserSummary
+			Score int `json:"score"`
+		}
+		authClient, _ := app.Auth(context.Background())
+		recommendations := make([]recommendedUser, 0, len(uids))
+		for _, u := range userSummaries(authClient, uids) {
+			// Auth から削除済みのユーザーはおすすめしない
+			if u.DisplayName == deletedUserName {
+				continue
+			}
+			recommendations = append(recommendations, recommendedUser{UserSummary: u, Score: scores[u.UID]})
+		}
+		return c.JSON(http.StatusOK, recommendations)
+	})
+
+	// Webhook登録リクエスト構造体
+	type WebhookRequest struct {
+		URL string `json:"url"`
+	}
+
+	// Webhook一覧API (シークレットは返さない)
+	apiGroup.GET("/webhooks", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		rows, err := db.Query("SELECT id, url, created_at FROM webhooks WHERE user_uid = ? ORDER BY id", user.UID)
+		if err != nil {
+			log.Printf("error querying webhooks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving webhooks")
+		}
+		defer rows.Close()
+
+		webhooks := make([]Webhook, 0)
+		for rows.Next() {
+			var w Webhook
+			if err := rows.Scan(&w.ID, &w.URL, &w.CreatedAt); err == nil {
+				webhooks = append(webhooks, w)
+			}
+		}
+		return c.JSON(http.StatusOK, webhooks)
+	})
+
+	// Webhook登録API (署名用シークレットはこのレスポンスでのみ返す)
+	apiGroup.POST("/webhooks", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to register webhooks."})
+		}
+
+		var req WebhookRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		}
+		webhookURL := strings.TrimSpace(req.URL)
+		if len(webhookURL) > 500 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "URL is too long (max 500 chars)"})
+		}
+		if err := validatePublicURL(webhookURL); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid webhook URL: " + err.Error()})
+		}
+
+		// 1ユーザーあたりの登録数を制限
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM webhooks WHERE user_uid = ?", user.UID).Scan(&count); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if count >= 10 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You can register up to 10 webhooks."})
+		}
+
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			log.Printf("error generating webhook secret: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error."})
+		}
+		result, err := db.Exec("INSERT OR IGNORE INTO webhooks (user_uid, url, secret) VALUES (?, ?, ?)", user.UID, webhookURL, secret)
+		if err != nil {
+			log.Printf("error inserting webhook: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to register webhook")
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusConflict, map[string]string{"message": "This URL is already registered."})
+		}
+		id, _ := result.LastInsertId()
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"id":     id,
+			"url":    webhookURL,
+			"secret": secret,
+		})
+	})
+
+	// Webhook削除API
+	apiGroup.DELETE("/webhooks/:id", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		webhookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid webhook ID")
+		}
+
+		result, err := db.Exec("DELETE FROM webhooks WHERE id = ? AND user_uid = ?", webhookID, user.UID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, "Webhook not found")
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "Webhook deleted."})
+	})
+
+	// APIキー発行リクエスト構造体
+	type APIKeyRequest struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	// APIキー一覧API
+	apiGroup.GET("/account/api-keys", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		rows, err := db.Query("SELECT id, name, key_prefix, scopes, created_at, last_used_at FROM api_keys WHERE user_uid = ? ORDER BY id", user.UID)
+		if err != nil {
+			log.Printf("error querying api keys: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving API keys")
+		}
+		defer rows.Close()
+
+		keys := make([]APIKey, 0)
+		for rows.Next() {
+			var k APIKey
+			var scopeList string
+			var lastUsed sql.NullTime
+			if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &scopeList, &k.CreatedAt, &lastUsed); err != nil {
+				continue
+			}
+			k.Scopes = strings.Split(scopeList, ",")
+			if lastUsed.Valid {
+				k.LastUsedAt = &lastUsed.Time
+			}
+			keys = append(keys, k)
+		}
+		return c.JSON(http.StatusOK, keys)
+	})
+
+	// APIキー発行API (キー本体はこのレスポンスでのみ返す)
+	apiGroup.POST("/account/api-keys", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to create API keys."})
+		}
+
+		var req APIKeyRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" || len(name) > 50 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Name must be between 1 and 50 characters."})
+		}
+		scopes, ok := normalizeAPIKeyScopes(req.Scopes)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Scopes must be one or more of: read, upload"})
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE user_uid = ?", user.UID).Scan(&count); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if count >= 10 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You can create up to 10 API keys."})
+		}
+
+		key, err := generateAPIKey()
+		if err != nil {
+			log.Printf("error generating api key: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error."})
+		}
+		prefix := key[:11] // "sl_" + 先頭8文字 (一覧でキーを見分けるため)
+		result, err := db.Exec("INSERT INTO api_keys (user_uid, name, key_hash, key_prefix, scopes) VALUES (?, ?, ?, ?, ?)",
+			user.UID, name, hashAPIKey(key), prefix, strings.Join(scopes, ","))
+		if err != nil {
+			log.Printf("error inserting api key: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to create API key")
+		}
+		id, _ := result.LastInsertId()
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"id":     id,
+			"name":   name,
+			"prefix": prefix,
+			"scopes": scopes,
+			"key":    key,
+		})
+	})
+
+	// APIキー失効API
+	apiGroup.DELETE("/account/api-keys/:id", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		keyID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid API key ID")
+		}
+
+		result, err := db.Exec("DELETE FROM api_keys WHERE id = ? AND user_uid = ?", keyID, user.UID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, "API key not found")
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "API key revoked."})
+	})
+
+	// コメント投稿リクエスト構造体
+	type CommentRequest struct {
+		Content  string `json:"content"`
+		ParentID *int   `json:"parent_id"` // 返信の場合は返信先のコメントID
+	}
+
+	// コメント投稿API
+	apiGroup.POST("/track/:id/comment", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to comment."})
+		}
+
+		uploaderName, ok := user.Claims["name"].(string)
+		if !ok || uploaderName == "" {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Display name is required to comment."})
+		}
+
+		// トラックの存在とコメント受付状態を確認
+		var commentsEnabled bool
+		var trackMaxComments sql.NullInt64
+		err = db.QueryRow("SELECT comments_enabled, max_comments FROM tracks WHERE id = ?", trackID).Scan(&commentsEnabled, &trackMaxComments)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			log.Printf("error checking track for comment: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+		}
+		if !commentsEnabled {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Comments are disabled for this track."})
+		}
+
+		var req CommentRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid request body")
+		}
+		var reason string
+		if req.Content, reason = validateCommentContent(profanityFilter, req.Content); reason != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": reason})
+		}
+
+		// 返信の場合は、返信先が同じトラックのコメントで、深さが上限を超えないことを確認する
+		if req.ParentID != nil {
+			parentTrackID, depth, err := commentReplyDepth(*req.ParentID)
+			if err == sql.ErrNoRows || (err == nil && parentTrackID != trackID) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "Parent comment not found on this track."})
+			}
+			if err != nil {
+				log.Printf("error checking parent comment %d: %v\n", *req.ParentID, err)
+				return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+			}
+			if depth > commentMaxDepth {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("Replies can be nested at most %d levels deep.", commentMaxDepth)})
+			}
+		}
+
+		// 二重投稿の抑止: ダブルクリックなどで同じ内容が直近30秒以内に投稿済みなら、既存のコメントを返す
+		// (レートリミットより先に判定し、再送信を 429 ではなく成功として扱う。新規の投稿と同じく 200 でコメントを返す)
+		var existing Comment
+		err = db.QueryRow(`
+			SELECT id, track_id, user_uid, user_name, content, created_at, parent_id FROM comments
+			WHERE track_id = ? AND user_uid = ? AND content = ? AND parent_id IS ? AND created_at > datetime('now', '-30 seconds')
+			ORDER BY id DESC LIMIT 1`, trackID, user.UID, req.Content, req.ParentID).Scan(&existing.ID, &existing.TrackID, &existing.UserUID, &existing.UserName, &existing.Content, &existing.CreatedAt, &existing.ParentID)
+		if err == nil {
+			return c.JSON(http.StatusOK, existing)
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("error checking duplicate comment: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+		}
+
+		// コメント数の上限 (削除されたコメントは行ごと消えるため数に含まれない)
+		if maxComments := effectiveMaxComments(trackMaxComments, defaultMaxComments); maxComments > 0 {
+			var commentCount int
+			if err := db.QueryRow("SELECT COUNT(*) FROM comments WHERE track_id = ?", trackID).Scan(&commentCount); err != nil {
+				log.Printf("error counting comments for track %d: %v\n", trackID, err)
+				return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+			}
+			if commentCount >= maxComments {
+				return c.JSON(http.StatusForbidden, map[string]string{"message": fmt.Sprintf("This track has reached its limit of %d comments.", maxComments)})
+			}
+		}
+
+		// ユーザーごとのレートリミット (直近1時間のコメント数と最後の投稿時刻から判定)
+		var recentCount int
+		var oldestUnix, latestUnix sql.NullInt64
+		err = db.QueryRow(`
+			SELECT COUNT(*),
+				CAST(strftime('%s', MIN(created_at)) AS INTEGER),
+				CAST(strftime('%s', MAX(created_at)) AS INTEGER)
+			FROM comments
+			WHERE user_uid = ? AND created_at > datetime('now', '-1 hour')`, user.UID).Scan(&recentCount, &oldestUnix, &latestUnix)
+		if err != nil {
+			log.Printf("error checking comment rate limit: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+		}
+		now := time.Now().Unix()
+		retryAfter := int64(0)
+		if latestUnix.Valid && now-latestUnix.Int64 < int64(commentMinInterval) {
+			retryAfter = int64(commentMinInterval) - (now - latestUnix.Int64)
+		}
+		if recentCount >= commentMaxPerHour && oldestUnix.Valid {
+			if wait := oldestUnix.Int64 + 3600 - now; wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+		if retryAfter > 0 {
+			c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+				"message":     "You are commenting too fast. Please wait before posting again.",
+				"retry_after": retryAfter,
+			})
+		}
+
+		result, err := db.Exec("INSERT INTO comments (track_id, user_uid, user_name, content, parent_id) VALUES (?, ?, ?, ?, ?)", trackID, user.UID, uploaderName, req.Content, req.ParentID)
+		if err != nil {
+			log.Printf("error inserting comment: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+		}
+		// 二重投稿の場合と同じく、投稿したコメントを返す
+		commentID, _ := result.LastInsertId()
+		var posted Comment
+		err = db.QueryRow("SELECT id, track_id, user_uid, user_name, content, created_at, parent_id FROM comments WHERE id = ?", commentID).
+			Scan(&posted.ID, &posted.TrackID, &posted.UserUID, &posted.UserName, &posted.Content, &posted.CreatedAt, &posted.ParentID)
+		if err != nil {
+			log.Printf("error reading posted comment %d: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+		}
+
+		// --- コメント通知処理 (非同期) ---
+		go func(trackID int, commenterName, commentContent, commenterUID, frontendURL string) {
+			// トラックの投稿者を取得
+			var uploaderUID, trackTitle string
+			err := db.QueryRow("SELECT uploader_uid, title FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID, &trackTitle)
+			if err != nil {
+				return
+			}
+
+			// 自分の投稿へのコメントなら通知しない
+			if uploaderUID == commenterUID {
+				return
+			}
+
+			// 通知設定を確認
+			if !shouldNotify(uploaderUID) {
+				return
+			}
+
+			authClient, err := app.Auth(context.Background())
+			if err != nil {
+				return
+			}
+
+			// 投稿者のメールアドレスを取得して送信
+			userRecord, err := authClient.GetUser(context.Background(), uploaderUID)
+			if err == nil && userRecord.Email != "" {
+				subject := fmt.Sprintf("New comment on \"%s\" 💬", trackTitle)
+				body := fmt.Sprintf(`
+					<h2>New comment on "%s" 💬</h2>
+					<p>Hello!</p>
+					<p><strong>%s</strong> commented on your track "<strong>%s</strong>":</p>
+					<blockquote style="border-left: 4px solid #ccc; padding-left: 10px; color: #555;">%s</blockquote>
+					<p><a href="%s">Check it out on SoundLike!</a></p>
+					<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
+					<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
+				`, trackTitle, commenterName, trackTitle, commentContent, trackPageURL(frontendURL, trackID), frontendURL)
+				log.Printf("Queueing comment notification to: %s", userRecord.Email)
+				queueEmail([]string{userRecord.Email}, subject, body)
+			}
+		}(trackID, uploaderName, req.Content, user.UID, frontendURL)
+
+		return c.JSON(http.StatusOK, posted)
+	})
+
+	// コメントのプレビューAPI: 投稿と同じ検証・フィルタを行い、表示される本文を返す (保存はしない)
+	// html はコメントをHTMLに埋め込むときの形 (エスケープ済み)
+	apiGroup.POST("/comment/preview", func(c echo.Context) error {
+		var req CommentRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid request body")
+		}
+		content, reason := validateCommentContent(profanityFilter, req.Content)
+		if reason != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": reason})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"content": content, "html": html.EscapeString(content)})
+	})
+
+	type CommentReportRequest struct {
+		Reason  string `json:"reason"`  // spam / harassment / hate_speech / other
+		Details string `json:"details"` // 任意の補足 (最大500文字)
+	}
+
+	// コメント通報API (1ユーザーにつき1コメント1件まで)
+	apiGroup.POST("/comment/:id/report", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || commentID < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid comment ID"})
+		}
+
+		if !requireVerified(user) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to report comments."})
+		}
+
+		var req CommentReportRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		}
+		if !commentReportReasons[req.Reason] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "reason must be one of spam, harassment, hate_speech, other"})
+		}
+		req.Details = strings.TrimSpace(req.Details)
+		if utf8.RuneCountInString(req.Details) > 500 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Details are too long (max 500 chars)"})
+		}
+
+		var authorUID string
+		err = db.QueryRow("SELECT user_uid FROM comments WHERE id = ?", commentID).Scan(&authorUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Comment not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if authorUID == user.UID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You cannot report your own comment."})
+		}
+
+		result, err := db.Exec("INSERT OR IGNORE INTO comment_reports (comment_id, reporter_uid, reason, details) VALUES (?, ?, ?, ?)",
+			commentID, user.UID, req.Reason, req.Details)
+		if err != nil {
+			log.Printf("error saving comment report: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to report comment")
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusConflict, map[string]string{"message": "You have already reported this comment."})
+		}
+
+		// 通報が一定数を超えたら、管理者が確認するまで非表示にする
+		if commentReportHideThreshold > 0 {
+			var reportCount int
+			if err := db.QueryRow("SELECT COUNT(*) FROM comment_reports WHERE comment_id = ?", commentID).Scan(&reportCount); err != nil {
+				log.Printf("error counting reports for comment %d: %v\n", commentID, err)
+			} else if reportCount > commentReportHideThreshold {
+				result, err := db.Exec("UPDATE comments SET hidden = TRUE WHERE id = ? AND NOT hidden", commentID)
+				if err != nil {
+					log.Printf("error hiding comment %d: %v\n", commentID, err)
+				} else if n, _ := result.RowsAffected(); n > 0 {
+					log.Printf("Comment %d hidden pending review after %d reports", commentID, reportCount)
+				}
+			}
+		}
+		return c.JSON(http.StatusCreated, map[string]string{"message": "Comment reported. Thank you for helping keep SoundLike safe."})
+	})
+
+	// notifyCommentRemoved はコメントが管理者によって削除されたことを投稿者にメールで通知する (goroutine で呼ぶ)
+	// トラックのタイトルは利用者が入力した値のため、本文ではエスケープし、件名には含めない
+	notifyCommentRemoved := func(authorUID string, trackID int, trackTitle, content string) {
+		if !shouldNotify(authorUID) {
+			return
+		}
+
+		authClient, err := app.Auth(context.Background())
+		if err != nil {
+			log.Printf("Comment removal notification error: Failed to get Auth client: %v", err)
+			return
+		}
+
+		userRecord, err := authClient.GetUser(context.Background(), authorUID)
+		if err == nil && userRecord.Email != "" {
+			subject := "Your comment on SoundLike was removed"
+			body := fmt.Sprintf(`
+				<h2>Your comment was removed</h2>
+				<p>Hello!</p>
+				<p>Your comment on the track "<strong>%s</strong>" was removed by a moderator because it did not follow the community guidelines.</p>
+				<blockquote style="border-left: 4px solid #ccc; padding-left: 10px; color: #555;">%s</blockquote>
+				<p><a href="%s">View the track</a></p>
+				<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
+				<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
+			`, html.EscapeString(trackTitle), html.EscapeString(content), trackPageURL(frontendURL, trackID), frontendURL)
+			log.Printf("Queueing comment removal notification to: %s", userRecord.Email)
+			queueEmail([]string{userRecord.Email}, subject, body)
+		}
+	}
+
+	// コメント削除API
+	apiGroup.DELETE("/comment/:id", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid comment ID")
+		}
+
+		// 削除対象のコメントと、通知用にトラックのタイトルを取得
+		var authorUID, content string
+		var trackID int
+		var trackTitle sql.NullString
+		var parentID sql.NullInt64
+		err = db.QueryRow(`
+			SELECT cm.user_uid, cm.content, cm.track_id, t.title, cm.parent_id
+			FROM comments cm LEFT JOIN tracks t ON t.id = cm.track_id
+			WHERE cm.id = ?`, commentID).Scan(&authorUID, &content, &trackID, &trackTitle, &parentID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusForbidden, "Cannot delete comment (not found or not yours)")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+
+		// 自分のコメントのみ削除可能 (管理者は他人のコメントも削除できる)
+		if authorUID != user.UID && !isAdmin(user) {
+			return c.JSON(http.StatusForbidden, "Cannot delete comment (not found or not yours)")
+		}
+
+		// コメントの削除と、関連する通報・返信・ピン留めの更新は1つのトランザクションで行う
+		// (途中で失敗した場合に、通報や返信が削除済みのコメントを指したまま残らないように)
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("error starting comment deletion transaction: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		defer tx.Rollback()
 
-		comments := make([]Comment, 0)
-		for rows.Next() {
-			var cm Comment
-			if err := rows.Scan(&cm.ID, &cm.TrackID, &cm.UserUID, &cm.UserName, &cm.Content, &cm.CreatedAt); err == nil {
-				comments = append(comments, cm)
-			}
+		result, err := tx.Exec("DELETE FROM comments WHERE id = ?", commentID)
+		if err != nil {
+			log.Printf("error deleting comment %d: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			return c.JSON(http.StatusForbidden, "Cannot delete comment (not found or not yours)")
+		}
+		// 削除したコメントへの通報は不要になるため削除する
+		if _, err := tx.Exec("DELETE FROM comment_reports WHERE comment_id = ?", commentID); err != nil {
+			log.Printf("error deleting reports for comment %d: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		// 削除したコメントへの返信は、削除したコメントの返信先に付け替える (スレッドが途切れないように)
+		if _, err := tx.Exec("UPDATE comments SET parent_id = ? WHERE parent_id = ?", parentID, commentID); err != nil {
+			log.Printf("error reattaching replies to comment %d: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		// ピン留めされていれば解除
+		if _, err := tx.Exec("UPDATE tracks SET pinned_comment_id = NULL WHERE pinned_comment_id = ?", commentID); err != nil {
+			log.Printf("error unpinning comment %d: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("error committing comment %d deletion: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		return c.JSON(http.StatusOK, comments)
-	})
 
-	// --- 認証が必要な保護されたルートグループ ---
-	apiGroup := e.Group("/api")
-	apiGroup.Use(firebaseAuthMiddleware(app))
+		// 本人以外 (管理者) によって削除された場合のみ、投稿者に理由を通知する
+		if authorUID != user.UID {
+			go notifyCommentRemoved(authorUID, trackID, trackTitle.String, content)
+		}
 
-	apiGroup.POST("/upload", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"message": "Comment deleted."})
+	})
+
+	// クリエイターダッシュボードAPI: 自分の全トラックの合計値と、いいね数の多いトラック上位5件
+	apiGroup.GET("/account/dashboard", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
-		log.Printf("File upload attempt by user: %s", user.UID)
 
-		// リクエストボディのサイズ制限 (例: 20MB)
-		// ファイル(15MB) + メタデータ分を考慮
-		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, 20<<20)
+		dashboardCache.Lock()
+		entry, ok := dashboardCache.entries[user.UID]
+		dashboardCache.Unlock()
+		if ok && time.Since(entry.fetchedAt) < dashboardCacheTTL {
+			return c.JSON(http.StatusOK, entry.data)
+		}
+
+		var trackCount, totalPlays, totalLikes, totalComments, followerCount int
+		err := db.QueryRow(`
+			SELECT
+				COALESCE((SELECT track_count FROM user_stats WHERE user_uid = ?), 0),
+				(SELECT COUNT(*) FROM plays WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?)),
+				(SELECT COUNT(*) FROM likes WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?)`+likesCountFilter+`),
+				(SELECT COUNT(*) FROM comments WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?)),
+				COALESCE((SELECT follower_count FROM user_stats WHERE user_uid = ?), 0)`,
+			user.UID, user.UID, user.UID, user.UID, user.UID,
+		).Scan(&trackCount, &totalPlays, &totalLikes, &totalComments, &followerCount)
+		if err != nil {
+			log.Printf("error aggregating dashboard: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to load dashboard")
+		}
 
-		// 1. セキュリティ強化: メール未認証のユーザーによる書き込みをバックエンドでも拒否
-		if verified, ok := user.Claims["email_verified"].(bool); !ok || !verified {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to upload."})
+		rows, err := db.Query("SELECT "+trackColumns+" FROM "+trackFrom+" WHERE t.uploader_uid = ? ORDER BY likes_count DESC, t.created_at DESC, t.id DESC LIMIT 5", user.UID, user.UID)
+		if err != nil {
+			log.Printf("error querying dashboard top tracks: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to load dashboard")
+		}
+		defer rows.Close()
+		topTracks, err := scanTracks(rows)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Failed to load dashboard")
 		}
 
-		// トークンから表示名を取得し、設定されているか確認する
-		uploaderName, ok := user.Claims["name"].(string)
-		if !ok || uploaderName == "" {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "You must set a display name before uploading."})
+		data := map[string]interface{}{
+			"track_count":    trackCount,
+			"total_plays":    totalPlays,
+			"total_likes":    totalLikes,
+			"total_comments": totalComments,
+			"follower_count": followerCount,
+			"top_tracks":     topTracks,
 		}
+		dashboardCache.Lock()
+		dashboardCache.entries[user.UID] = cachedDashboard{data: data, fetchedAt: time.Now()}
+		dashboardCache.Unlock()
 
-		// フォームからメタデータを取得
-		title := c.FormValue("title")
-		artist := c.FormValue("artist")
-		lyrics := c.FormValue("lyrics")
+		return c.JSON(http.StatusOK, data)
+	})
+
+	// トラックのピン留めAPI (アップロードした本人のみ、1ユーザーにつき1件まで)
+	apiGroup.POST("/track/:id/pin", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
 
-		if title == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Title is required"})
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
 		}
-		// 入力値の長さ制限
-		if len(title) > 100 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Title is too long (max 100 chars)"})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		if len(artist) > 100 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Artist name is too long (max 100 chars)"})
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You can only pin your own tracks")
 		}
-		if len(lyrics) > 10000 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Lyrics are too long (max 10000 chars)"})
+
+		// 既にピン留めがあれば置き換える
+		_, err = db.Exec(`
+			INSERT INTO user_settings (user_uid, pinned_track_id, updated_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(user_uid) DO UPDATE SET
+			pinned_track_id = excluded.pinned_track_id,
+			updated_at = CURRENT_TIMESTAMP`, user.UID, trackID)
+		if err != nil {
+			log.Printf("Error pinning track: %v", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to pin track")
 		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"pinned_track_id": trackID})
+	})
 
-		file, err := c.FormFile("file")
+	// トラックのピン留め解除API
+	apiGroup.DELETE("/track/:id/pin", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Error retrieving the file"})
+			return err
 		}
 
-		// ファイルサイズチェック (例: 15MB)
-		if file.Size > 15*1024*1024 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "File is too large (max 15MB)"})
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You can only unpin your own tracks")
 		}
 
-		// 拡張子チェック
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		if ext != ".mp3" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Only .mp3 files are allowed"})
+		if _, err := db.Exec("UPDATE user_settings SET pinned_track_id = NULL, updated_at = CURRENT_TIMESTAMP WHERE user_uid = ? AND pinned_track_id = ?", user.UID, trackID); err != nil {
+			log.Printf("Error unpinning track: %v", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to unpin track")
 		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"pinned_track_id": nil})
+	})
+
+	// コメントのピン留めリクエスト構造体
+	type PinCommentRequest struct {
+		CommentID int `json:"comment_id"`
+	}
 
-		src, err := file.Open()
+	// コメントのピン留めAPI (トラックのアップロード者のみ、1トラックにつき1件まで)
+	apiGroup.POST("/track/:id/pinned-comment", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error opening the file"})
+			return err
+		}
+		var req PinCommentRequest
+		if err := c.Bind(&req); err != nil || req.CommentID < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "comment_id is required"})
 		}
-		defer src.Close()
 
-		// MIMEタイプチェック (簡易的なマジックナンバーチェック)
-		// 先頭の512バイトを読み込んで判定する
-		buffer := make([]byte, 512)
-		_, err = src.Read(buffer)
-		if err != nil && err != io.EOF {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error checking file type"})
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		// ファイルポインタを先頭に戻す
-		if _, err := src.Seek(0, 0); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error processing file"})
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You can only pin comments on your own tracks")
 		}
 
-		contentType := http.DetectContentType(buffer)
-		// 明らかに危険なタイプ（HTML, JS, XMLなど）を拒否する
-		// MP3は "application/octet-stream" や "audio/mpeg" と判定されることが多い
-		if strings.Contains(contentType, "text/") || strings.Contains(contentType, "application/javascript") || strings.Contains(contentType, "application/json") || strings.Contains(contentType, "application/xml") {
-			log.Printf("Rejected file type: %s", contentType)
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid file type detected"})
+		var hidden bool
+		err = db.QueryRow("SELECT hidden FROM comments WHERE id = ? AND track_id = ?", req.CommentID, trackID).Scan(&hidden)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Comment not found on this track."})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if hidden {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Hidden comments cannot be pinned."})
 		}
 
-		// 3. ファイル名の安全性確保: ディスク上ではUUIDのみを使用し、元のファイル名に依存しない
-		// (元のファイル名に含まれる特殊文字や長さによるファイルシステムエラーを防止)
-		uniqueFileName := uuid.New().String() + ".mp3"
+		// 既にピン留めがあれば置き換える
+		if _, err := db.Exec("UPDATE tracks SET pinned_comment_id = ? WHERE id = ?", req.CommentID, trackID); err != nil {
+			log.Printf("Error pinning comment: %v", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to pin comment")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"pinned_comment_id": req.CommentID})
+	})
 
-		dstPath := filepath.Join("uploads", uniqueFileName)
+	// コメントのピン留め解除API
+	apiGroup.DELETE("/track/:id/pinned-comment", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
 
-		dst, err := os.Create(dstPath)
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "Error creating the destination file")
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You can only unpin comments on your own tracks")
 		}
-		defer dst.Close()
 
-		if _, err = io.Copy(dst, src); err != nil {
-			return c.JSON(http.StatusInternalServerError, "Error saving the file")
+		if _, err := db.Exec("UPDATE tracks SET pinned_comment_id = NULL WHERE id = ?", trackID); err != nil {
+			log.Printf("Error unpinning comment: %v", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to unpin comment")
 		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"pinned_comment_id": nil})
+	})
 
-		// データベースにメタデータを保存
-		// filenameカラムには uniqueFileName (uuid.mp3) が入るため、フロントエンドからのアクセスURLも安全になる
-		insertSQL := `INSERT INTO tracks (filename, title, artist, lyrics, uploader_uid, uploader_name) VALUES (?, ?, ?, ?, ?, ?)`
-		_, err = db.Exec(insertSQL, uniqueFileName, title, artist, lyrics, user.UID, uploaderName)
+	// トラック統計API (アップロードした本人のみ): 直近30日間の日別の再生・いいね・コメント数
+	apiGroup.GET("/track/:id/stats", func(c echo.Context) error {
+		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
 		if err != nil {
-			log.Printf("error inserting track metadata: %v\n", err)
-			// 4. ゴミファイル対策: DB保存失敗時はファイルを削除する
-			os.Remove(dstPath)
-			// 5. 情報漏洩対策: 内部エラー詳細(err.Error())をクライアントに返さない
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error during metadata saving."})
+			return err
 		}
 
-		// --- フォロワーへのメール通知処理 (非同期) ---
-		go func(uploaderUID, uploaderName, trackTitle, frontendURL string) {
-			// アップロード者自身の通知設定は関係ないが、フォロワーへの通知なのでループ内でチェックする
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You are not authorized to view stats for this track")
+		}
 
-			// フォロワーのUIDを取得
-			rows, err := db.Query("SELECT follower_uid FROM follows WHERE following_uid = ?", uploaderUID)
-			if err != nil {
-				log.Printf("Error getting followers for notification: %v", err)
-				return
-			}
-			defer rows.Close()
+		// 今日を含む30日分。SQLiteの CURRENT_TIMESTAMP はUTCなので日付もUTCで区切る
+		const statsDays = 30
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, -(statsDays - 1)).Format("2006-01-02")
 
-			authClient, err := app.Auth(context.Background())
+		counts := make(map[string]map[string]int)
+		for _, table := range []string{"plays", "likes", "comments"} {
+			byDay, err := countTrackEventsByDay(table, trackID, since)
 			if err != nil {
-				log.Printf("Error getting Auth client for notification: %v", err)
-				return
+				log.Printf("error aggregating %s for track %d: %v\n", table, trackID, err)
+				return c.JSON(http.StatusInternalServerError, "Failed to aggregate stats")
 			}
+			counts[table] = byDay
+		}
 
-			for rows.Next() {
-				var followerUID string
-				if err := rows.Scan(&followerUID); err == nil {
-					// 通知設定を確認
-					if !shouldNotify(followerUID) {
-						continue
-					}
-
-					// Firebase Authからメールアドレスを取得
-					userRecord, err := authClient.GetUser(context.Background(), followerUID)
-					if err == nil && userRecord.Email != "" {
-						subject := fmt.Sprintf("New track from %s! 🎵", uploaderName)
-						body := fmt.Sprintf(`
-							<h2>New track from %s! 🎵</h2>
-							<p>Hello!</p>
-							<p><strong>%s</strong> has uploaded a new track: "<strong>%s</strong>".</p>
-							<p><a href="%s">Check it out on SoundLike!</a></p>
-							<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
-							<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
-						`, uploaderName, uploaderName, trackTitle, frontendURL)
-						log.Printf("Sending upload notification to: %s", userRecord.Email)
-						if err := sendEmail([]string{userRecord.Email}, subject, body); err != nil {
-							log.Printf("Failed to send email to %s: %v", userRecord.Email, err)
-						}
-					}
-				}
+		// データのない日も0件として埋め、グラフでそのまま使えるようにする
+		days := make([]TrackDayStats, 0, statsDays)
+		totals := TrackDayStats{}
+		for i := statsDays - 1; i >= 0; i-- {
+			day := today.AddDate(0, 0, -i).Format("2006-01-02")
+			d := TrackDayStats{
+				Date:     day,
+				Plays:    counts["plays"][day],
+				Likes:    counts["likes"][day],
+				Comments: counts["comments"][day],
 			}
-		}(user.UID, uploaderName, title, frontendURL)
+			totals.Plays += d.Plays
+			totals.Likes += d.Likes
+			totals.Comments += d.Comments
+			days = append(days, d)
+		}
 
-		return c.JSON(http.StatusOK, map[string]string{"message": "File uploaded successfully!"})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"track_id": trackID,
+			"from":     since,
+			"to":       today.Format("2006-01-02"),
+			"days":     days,
+			"totals": map[string]int{
+				"plays":    totals.Plays,
+				"likes":    totals.Likes,
+				"comments": totals.Comments,
+			},
+		})
 	})
 
-	// ProfileUpdateRequest defines the structure for the profile update request
-	type ProfileUpdateRequest struct {
-		DisplayName string `json:"display_name"`
-	}
-
-	// プロフィール更新API (表示名の重複チェックを含む)
-	apiGroup.POST("/profile", func(c echo.Context) error {
+	// いいねの推移API (アップロードした本人のみ): 直近 days 日間 (1〜365、デフォルト30) の日別のいいね数
+	apiGroup.GET("/track/:id/likes/timeline", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
-
-		var req ProfileUpdateRequest
-		if err := c.Bind(&req); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
 		}
 
-		// メール未認証ならプロフィール更新も禁止
-		if verified, ok := user.Claims["email_verified"].(bool); !ok || !verified {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to update profile."})
+		days := 30
+		if v := c.QueryParam("days"); v != "" {
+			days, err = strconv.Atoi(v)
+			if err != nil || days < 1 || days > 365 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid 'days' parameter (must be between 1 and 365)"})
+			}
 		}
 
-		newDisplayName := strings.TrimSpace(req.DisplayName)
-		if newDisplayName == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Display name cannot be empty"})
-		}
-		if len(newDisplayName) > 30 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Display name is too long (max 30 chars)"})
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
 		}
-
-		// 表示名の重複をチェック (自分以外のユーザーが使っていないか)
-		var existingUID string
-		err := db.QueryRow("SELECT uploader_uid FROM tracks WHERE uploader_name = ? AND uploader_uid != ? LIMIT 1", newDisplayName, user.UID).Scan(&existingUID)
-		if err == nil { // errがnilということは、レコードが見つかったということ
-			return c.JSON(http.StatusConflict, map[string]string{"message": "Display name '" + newDisplayName + "' is already taken."})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		if err != sql.ErrNoRows {
-			log.Printf("error checking display name uniqueness: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error checking display name."})
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You are not authorized to view stats for this track")
 		}
 
-		// Firebase Authの表示名を更新
-		authClient, err := app.Auth(context.Background())
+		// 統計APIと同じく、日付はUTCで区切る
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		since := today.AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+		counts, err := countTrackEventsByDay("likes", trackID, since)
 		if err != nil {
-			log.Printf("error getting Auth client for profile update: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Internal server error."})
-		}
-		params := (&auth.UserToUpdate{}).DisplayName(newDisplayName)
-		if _, err := authClient.UpdateUser(context.Background(), user.UID, params); err != nil {
-			log.Printf("error updating firebase auth display name for user %s: %v\n", user.UID, err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update authentication profile."})
+			log.Printf("error aggregating likes timeline for track %d: %v\n", trackID, err)
+			return c.JSON(http.StatusInternalServerError, "Failed to aggregate likes")
 		}
 
-		// 既存のトラックのuploader_nameをすべて更新
-		// この処理はAuthの更新が成功してから行う
-		if _, err := db.Exec("UPDATE tracks SET uploader_name = ? WHERE uploader_uid = ?", newDisplayName, user.UID); err != nil {
-			// ここで失敗した場合、Authの更新とDBの更新に不整合が起きるが、
-			// 次回のアップロードやプロフィール更新で修正される可能性が高い。
-			log.Printf("error updating uploader_name in tracks: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Error updating track information."})
+		timeline := fillDailyCounts(counts, today, days)
+		total := 0
+		for _, d := range timeline {
+			total += d.Count
 		}
-
-		return c.JSON(http.StatusOK, map[string]string{"message": "Profile updated successfully!"})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"track_id": trackID,
+			"from":     since,
+			"to":       today.Format("2006-01-02"),
+			"timeline": timeline,
+			"total":    total,
+		})
 	})
 
-	// 通知設定の取得API
-	apiGroup.GET("/settings", func(c echo.Context) error {
+	// トラックのイベントのCSVエクスポートAPI (アップロードした本人と管理者のみ)
+	// ?from=YYYY-MM-DD&to=YYYY-MM-DD (UTC、省略時は今日までの30日間、最大 maxTrackEventsDays 日)
+	apiGroup.GET("/track/:id/events.csv", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
-		var enabled bool
-		err := db.QueryRow("SELECT email_notifications FROM user_settings WHERE user_uid = ?", user.UID).Scan(&enabled)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
 		if err == sql.ErrNoRows {
-			// デフォルトはON
-			return c.JSON(http.StatusOK, map[string]bool{"email_notifications": true})
+			return c.JSON(http.StatusNotFound, "Track not found")
 		}
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		return c.JSON(http.StatusOK, map[string]bool{"email_notifications": enabled})
+		if uploaderUID != user.UID && !isAdmin(user) {
+			return c.JSON(http.StatusForbidden, "You are not authorized to export events for this track")
+		}
+
+		to := time.Now().UTC().Truncate(24 * time.Hour)
+		if v := c.QueryParam("to"); v != "" {
+			if to, err = time.Parse("2006-01-02", v); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid 'to' date (expected YYYY-MM-DD)"})
+			}
+		}
+		from := to.AddDate(0, 0, -29)
+		if v := c.QueryParam("from"); v != "" {
+			if from, err = time.Parse("2006-01-02", v); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid 'from' date (expected YYYY-MM-DD)"})
+			}
+		}
+		if from.After(to) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "'from' must not be after 'to'"})
+		}
+		if days := int(to.Sub(from).Hours()/24) + 1; days > maxTrackEventsDays {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("The date range can be at most %d days.", maxTrackEventsDays)})
+		}
+
+		fromDate, toDate := from.Format("2006-01-02"), to.Format("2006-01-02")
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="track-%d-events-%s-%s.csv"`, trackID, fromDate, toDate))
+		res.WriteHeader(http.StatusOK)
+		// ヘッダーを送信した後はステータスを変更できないため、エラーはログに残すだけにする
+		if err := writeTrackEventsCSV(res, res.Flush, trackID, fromDate, toDate); err != nil {
+			log.Printf("error exporting events for track %d: %v\n", trackID, err)
+		}
+		return nil
 	})
 
-	// 通知設定の更新API
-	type SettingsUpdateRequest struct {
-		EmailNotifications bool `json:"email_notifications"`
+	// トラック編集リクエスト構造体 (PATCHのため、指定されたフィールドのみ更新する)
+	// nil のフィールドは変更しない。artist / lyrics / synced_lyrics に空文字を指定すると削除 (NULL) する
+	type TrackUpdateRequest struct {
+		Title           *string     `json:"title"`
+		Artist          *string     `json:"artist"`
+		Lyrics          *string     `json:"lyrics"`
+		SyncedLyrics    *string     `json:"synced_lyrics"`
+		CommentsEnabled *bool       `json:"comments_enabled"`
+		MaxComments     nullableInt `json:"max_comments"` // 1以上 (COMMENT_MAX_PER_TRACK まで)、null で既定値に戻す
 	}
-	apiGroup.POST("/settings", func(c echo.Context) error {
+
+	// トラック編集API (アップロードした本人のみ)
+	apiGroup.PATCH("/track/:id", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
-		var req SettingsUpdateRequest
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+
+		var req TrackUpdateRequest
 		if err := c.Bind(&req); err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid request")
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
 		}
 
-		// UPSERT (存在すれば更新、なければ挿入)
-		// SQLite 3.24.0+ であれば INSERT ... ON CONFLICT が使えるが、
-		// 互換性のため REPLACE INTO を使用するか、INSERT OR REPLACE を使用する
-		_, err := db.Exec(`
-			INSERT INTO user_settings (user_uid, email_notifications, updated_at) 
-			VALUES (?, ?, CURRENT_TIMESTAMP)
-			ON CONFLICT(user_uid) DO UPDATE SET 
-			email_notifications = excluded.email_notifications,
-			updated_at = CURRENT_TIMESTAMP`, user.UID, req.EmailNotifications)
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
 		if err != nil {
-			log.Printf("Error updating settings: %v", err)
-			return c.JSON(http.StatusInternalServerError, "Failed to update settings")
+			log.Printf("error querying track for update: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving track info")
+		}
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You are not authorized to edit this track")
+		}
+
+		// 指定されたフィールドだけを1つのUPDATE文にまとめる (検証はアップロード時と同じ)
+		// changes は編集履歴に記録するフィールドの新しい値
+		var sets []string
+		var args []interface{}
+		changes := make(map[string]sql.NullString)
+		if req.Title != nil {
+			title, uerr := validateTrackTitle(*req.Title, profanityFilter)
+			if uerr != nil {
+				return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+			}
+			sets = append(sets, "title = ?")
+			args = append(args, title)
+			changes["title"] = sql.NullString{String: title, Valid: true}
+		}
+		if req.Artist != nil {
+			artist, uerr := validateTrackArtist(*req.Artist, profanityFilter)
+			if uerr != nil {
+				return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+			}
+			var artistID sql.NullInt64
+			if artist != "" {
+				id, canonical, err := resolveArtist(artist)
+				if err != nil {
+					log.Printf("error resolving artist %q: %v\n", artist, err)
+				} else {
+					artistID = sql.NullInt64{Int64: id, Valid: true}
+					artist = canonical
+				}
+			}
+			sets = append(sets, "artist = ?", "artist_id = ?")
+			args = append(args, sql.NullString{String: artist, Valid: artist != ""}, artistID)
+			changes["artist"] = sql.NullString{String: artist, Valid: artist != ""}
+		}
+		if req.Lyrics != nil {
+			if uerr := validateTrackLyrics(*req.Lyrics); uerr != nil {
+				return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+			}
+			sets = append(sets, "lyrics = ?")
+			args = append(args, sql.NullString{String: *req.Lyrics, Valid: *req.Lyrics != ""})
+			changes["lyrics"] = sql.NullString{String: *req.Lyrics, Valid: *req.Lyrics != ""}
+		}
+		if req.SyncedLyrics != nil {
+			syncedLyrics, uerr := validateSyncedLyrics(*req.SyncedLyrics)
+			if uerr != nil {
+				return c.JSON(uerr.status, map[string]string{"message": uerr.message})
+			}
+			sets = append(sets, "synced_lyrics = ?")
+			args = append(args, sql.NullString{String: syncedLyrics, Valid: syncedLyrics != ""})
+			changes["synced_lyrics"] = sql.NullString{String: syncedLyrics, Valid: syncedLyrics != ""}
+		}
+		if req.CommentsEnabled != nil {
+			sets = append(sets, "comments_enabled = ?")
+			args = append(args, *req.CommentsEnabled)
+		}
+		if req.MaxComments.Set {
+			maxComments := sql.NullInt64{Int64: int64(req.MaxComments.Value), Valid: req.MaxComments.Valid}
+			if maxComments.Valid && maxComments.Int64 < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"message": "max_comments must be 1 or greater, or null to use the default."})
+			}
+			// アップロード者はサーバー全体の上限を超えて緩めることはできない
+			if maxComments.Valid && defaultMaxComments > 0 && maxComments.Int64 > int64(defaultMaxComments) {
+				maxComments.Int64 = int64(defaultMaxComments)
+			}
+			sets = append(sets, "max_comments = ?")
+			args = append(args, maxComments)
+		}
+
+		if len(sets) > 0 {
+			// 変更前の値の取得・更新・編集履歴の記録を1つのトランザクションで行う
+			tx, err := db.Begin()
+			if err != nil {
+				log.Printf("error beginning transaction: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Failed to update track")
+			}
+			defer tx.Rollback()
+
+			old, err := loadTrackContent(tx, trackID)
+			if err != nil {
+				log.Printf("error querying track content for revisions: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Failed to update track")
+			}
+			sets = append(sets, touchTrack)
+			args = append(args, trackID)
+			if _, err := tx.Exec("UPDATE tracks SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+				log.Printf("error updating track: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Failed to update track")
+			}
+			if err := recordTrackRevisions(tx, trackID, user.UID, old, changes); err != nil {
+				log.Printf("error recording track revisions: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Failed to update track")
+			}
+			if err := tx.Commit(); err != nil {
+				log.Printf("error committing track update: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Failed to update track")
+			}
+		}
+
+		// 削除されたフィールドは null として返す (アップロード時に空文字で保存されたものも同様)
+		var title string
+		var artist, lyrics, syncedLyrics sql.NullString
+		var commentsEnabled bool
+		var maxComments sql.NullInt64
+		err = db.QueryRow("SELECT title, artist, lyrics, synced_lyrics, comments_enabled, max_comments FROM tracks WHERE id = ?", trackID).
+			Scan(&title, &artist, &lyrics, &syncedLyrics, &commentsEnabled, &maxComments)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		return c.JSON(http.StatusOK, map[string]string{"message": "Settings updated."})
+		response := map[string]interface{}{
+			"id":               trackID,
+			"title":            title,
+			"artist":           nil,
+			"lyrics":           nil,
+			"synced_lyrics":    nil,
+			"comments_enabled": commentsEnabled,
+			"max_comments":     effectiveMaxComments(maxComments, defaultMaxComments),
+		}
+		if artist.Valid && artist.String != "" {
+			response["artist"] = artist.String
+		}
+		if lyrics.Valid && lyrics.String != "" {
+			response["lyrics"] = lyrics.String
+		}
+		if syncedLyrics.Valid {
+			response["synced_lyrics"] = syncedLyrics.String
+		}
+		return c.JSON(http.StatusOK, response)
 	})
 
-	// いいねしたトラック一覧を取得するAPI
-	apiGroup.GET("/tracks/favorites", func(c echo.Context) error {
+	// トラックの編集履歴API (アップロードした本人のみ、新しい順、ページネーション付き)
+	apiGroup.GET("/track/:id/history", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
+		trackID, err := parseTrackID(c)
+		if err != nil {
+			return err
+		}
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
 
-		// ユーザーがいいねしたトラックを取得するクエリ
-		// JOINを使って、likesテーブルとtracksテーブルを結合する
-		query := `
-		SELECT 
-			t.id, t.filename, t.title, t.artist, t.lyrics, t.uploader_uid, t.uploader_name, t.created_at,
-			(SELECT COUNT(*) FROM likes WHERE track_id = t.id) AS likes_count,
-			1 AS is_liked
-		FROM tracks t
-		INNER JOIN likes l ON t.id = l.track_id
-		WHERE l.user_uid = ?
-		ORDER BY l.created_at DESC
-		LIMIT 50` // お気に入り一覧もLIMITで保護
+		var uploaderUID string
+		err = db.QueryRow("SELECT uploader_uid FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
+		if uploaderUID != user.UID {
+			return c.JSON(http.StatusForbidden, "You are not authorized to view the history of this track")
+		}
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM track_revisions WHERE track_id = ?", trackID).Scan(&total); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
 
-		rows, err := db.Query(query, user.UID)
+		rows, err := db.Query(`
+			SELECT id, track_id, field, old_value, new_value, editor_uid, created_at
+			FROM track_revisions
+			WHERE track_id = ?
+			ORDER BY created_at DESC, id DESC
+			LIMIT ? OFFSET ?`, trackID, limit, offset)
 		if err != nil {
-			log.Printf("error querying favorite tracks: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, "Error retrieving favorite tracks")
+			log.Printf("error querying track revisions: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving track history")
 		}
 		defer rows.Close()
 
-		tracks := make([]Track, 0)
+		revisions := make([]TrackRevision, 0)
 		for rows.Next() {
-			var track Track
-			var artist sql.NullString
-			var lyrics sql.NullString
-			var uploaderName sql.NullString
-			if err := rows.Scan(&track.ID, &track.Filename, &track.Title, &artist, &lyrics, &track.UploaderUID, &uploaderName, &track.CreatedAt, &track.LikesCount, &track.IsLiked); err != nil {
-				log.Printf("error scanning favorite track row: %v\n", err)
-				return c.JSON(http.StatusInternalServerError, "Error processing favorite tracks")
-			}
-			track.Artist = artist.String
-			track.Lyrics = lyrics.String
-			track.UploaderName = uploaderName.String
-			tracks = append(tracks, track)
+			var r TrackRevision
+			var oldValue, newValue sql.NullString
+			if err := rows.Scan(&r.ID, &r.TrackID, &r.Field, &oldValue, &newValue, &r.EditorUID, &r.CreatedAt); err != nil {
+				log.Printf("error scanning track revision: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Error processing track history")
+			}
+			if oldValue.Valid {
+				r.OldValue = &oldValue.String
+			}
+			if newValue.Valid {
+				r.NewValue = &newValue.String
+			}
+			revisions = append(revisions, r)
 		}
-		return c.JSON(http.StatusOK, tracks)
+
+		return listResponse(c, revisions, len(revisions), true, total, limit, offset)
 	})
 
-	// いいね機能のAPI
-	apiGroup.POST("/track/:id/like", func(c echo.Context) error {
-		user := c.Get("user").(*auth.Token)
-		trackID, err := strconv.Atoi(c.Param("id"))
+	// --- 管理者用API ---
+	adminGroup := apiGroup.Group("/admin", requireAdmin)
+
+	// トラックをおすすめに選出する / 選出を取り消す
+	setTrackFeatured := func(c echo.Context, featured bool) error {
+		trackID, err := parseTrackID(c)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid track ID")
+			return err
 		}
+		var result sql.Result
+		if featured {
+			result, err = db.Exec("UPDATE tracks SET is_featured = TRUE, featured_at = CURRENT_TIMESTAMP, "+touchTrack+" WHERE id = ?", trackID)
+		} else {
+			result, err = db.Exec("UPDATE tracks SET is_featured = FALSE, featured_at = NULL, "+touchTrack+" WHERE id = ?", trackID)
+		}
+		if err != nil {
+			log.Printf("error updating featured flag: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Failed to update track")
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, "Track not found")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"id": trackID, "is_featured": featured})
+	}
+	adminGroup.POST("/track/:id/feature", func(c echo.Context) error {
+		return setTrackFeatured(c, true)
+	})
+	adminGroup.DELETE("/track/:id/feature", func(c echo.Context) error {
+		return setTrackFeatured(c, false)
+	})
 
-		// メール未認証ならいいねも禁止
-		if verified, ok := user.Claims["email_verified"].(bool); !ok || !verified {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to like tracks."})
+	// メール送信待ち行列の状態 (送信が詰まっていないかの確認用)
+	adminGroup.GET("/email-queue", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]int{
+			"depth":           emailQueueDepth(),
+			"dropped":         emailQueue.droppedCount(),
+			"rate_per_minute": emailRatePerMinute,
+		})
+	})
+
+	// 非表示になったコメントを確認済みとして再表示する (通報はリセットする)
+	// 削除する場合は DELETE /api/comment/:id を使う
+	adminGroup.POST("/comment/:id/unhide", func(c echo.Context) error {
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || commentID < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid comment ID"})
 		}
 
-		// 2. DB整合性強化: トランザクションを開始
 		tx, err := db.Begin()
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, "Database transaction error")
 		}
-		defer tx.Rollback() // エラー時はロールバック
+		defer tx.Rollback()
 
-		// トランザクション内でチェック
-		var exists bool
-		err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM likes WHERE user_uid = ? AND track_id = ?)", user.UID, trackID).Scan(&exists)
+		result, err := tx.Exec("UPDATE comments SET hidden = FALSE WHERE id = ?", commentID)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-
-		if exists {
-			_, err = tx.Exec("DELETE FROM likes WHERE user_uid = ? AND track_id = ?", user.UID, trackID)
-		} else {
-			_, err = tx.Exec("INSERT INTO likes (user_uid, track_id) VALUES (?, ?)", user.UID, trackID)
+		if n, _ := result.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Comment not found"})
 		}
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "Failed to update likes")
+		if _, err := tx.Exec("DELETE FROM comment_reports WHERE comment_id = ?", commentID); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
 		}
-		if err := tx.Commit(); err != nil { // コミット実行
+		if err := tx.Commit(); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Failed to commit transaction")
 		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"id": commentID, "hidden": false})
+	})
 
-		// --- いいね通知処理 (非同期) ---
-		// 新規いいねの場合のみ通知
-		if !exists {
-			likerName, _ := user.Claims["name"].(string)
-			if likerName == "" {
-				likerName = "Someone"
-			}
-
-			go func(trackID int, likerName, likerUID, frontendURL string) {
-				var uploaderUID, trackTitle string
-				err := db.QueryRow("SELECT uploader_uid, title FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID, &trackTitle)
-				if err != nil {
-					return
-				}
-
-				// 自分の投稿へのいいねなら通知しない
-				if uploaderUID == likerUID {
-					return
-				}
+	// コメントへの通報一覧 (新しい順、ページネーション付き)
+	adminGroup.GET("/comment-reports", func(c echo.Context) error {
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
 
-				// 通知設定を確認
-				if !shouldNotify(uploaderUID) {
-					return
-				}
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM comment_reports").Scan(&total); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Database error")
+		}
 
-				authClient, err := app.Auth(context.Background())
-				if err != nil {
-					return
-				}
+		rows, err := db.Query(`
+			SELECT r.id, r.comment_id, cm.track_id, cm.user_uid, cm.content, cm.hidden, r.reporter_uid, r.reason, r.details, r.created_at
+			FROM comment_reports r
+			INNER JOIN comments cm ON cm.id = r.comment_id
+			ORDER BY r.created_at DESC, r.id DESC
+			LIMIT ? OFFSET ?`, limit, offset)
+		if err != nil {
+			log.Printf("error querying comment reports: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving reports")
+		}
+		defer rows.Close()
 
-				userRecord, err := authClient.GetUser(context.Background(), uploaderUID)
-				if err == nil && userRecord.Email != "" {
-					subject := fmt.Sprintf("New like on \"%s\" 💖", trackTitle)
-					body := fmt.Sprintf(`
-						<h2>New like on "%s" 💖</h2>
-						<p>Hello!</p>
-						<p><strong>%s</strong> liked your track "<strong>%s</strong>".</p>
-						<p><a href="%s">Check it out on SoundLike!</a></p>
-						<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
-						<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
-					`, trackTitle, likerName, trackTitle, frontendURL, frontendURL)
-					log.Printf("Sending like notification to: %s", userRecord.Email)
-					if err := sendEmail([]string{userRecord.Email}, subject, body); err != nil {
-						log.Printf("Failed to send like notification email: %v", err)
-					}
-				}
-			}(trackID, likerName, user.UID, frontendURL)
+		reports := make([]CommentReport, 0)
+		for rows.Next() {
+			var r CommentReport
+			if err := rows.Scan(&r.ID, &r.CommentID, &r.TrackID, &r.CommentUserUID, &r.CommentContent, &r.CommentHidden, &r.ReporterUID, &r.Reason, &r.Details, &r.CreatedAt); err != nil {
+				log.Printf("error scanning comment report: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, "Error processing reports")
+			}
+			reports = append(reports, r)
 		}
 
-		// 更新後のカウントと状態を返す
-		var newCount int
-		db.QueryRow("SELECT COUNT(*) FROM likes WHERE track_id = ?", trackID).Scan(&newCount)
-		return c.JSON(http.StatusOK, map[string]interface{}{"likes_count": newCount, "is_liked": !exists})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"reports": reports,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		})
 	})
 
-	// ユーザーフォロー機能 (トグル)
-	apiGroup.POST("/user/:uid/follow", func(c echo.Context) error {
-		user := c.Get("user").(*auth.Token)
-		targetUID := c.Param("uid")
-
-		if user.UID == targetUID {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "You cannot follow yourself."})
+	// モデレーションキュー: 通報された項目を、未対応 (pending、通報数の多い順) または対応済み (resolved、新しい順) で返す
+	// 現在通報できるのはコメントのみのため、type は comment のみ (省略時も comment)
+	adminGroup.GET("/moderation", func(c echo.Context) error {
+		if itemType := c.QueryParam("type"); itemType != "" && itemType != "comment" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid type (only 'comment' reports are supported)"})
 		}
-
-		// メール未認証ならフォロー禁止
-		if verified, ok := user.Claims["email_verified"].(bool); !ok || !verified {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to follow users."})
+		status := c.QueryParam("status")
+		if status == "" {
+			status = "pending"
 		}
-
-		var exists bool
-		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM follows WHERE follower_uid = ? AND following_uid = ?)", user.UID, targetUID).Scan(&exists)
+		if status != "pending" && status != "resolved" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid status (must be 'pending' or 'resolved')"})
+		}
+		limit, offset, err := parsePagination(c)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "Database error")
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
 		}
 
-		if exists {
-			_, err = db.Exec("DELETE FROM follows WHERE follower_uid = ? AND following_uid = ?", user.UID, targetUID)
-			return c.JSON(http.StatusOK, map[string]interface{}{"is_following": false, "message": "Unfollowed successfully."})
+		var items []ModerationItem
+		var total int
+		if status == "pending" {
+			items, total, err = queryPendingCommentReports(limit, offset)
 		} else {
-			_, err = db.Exec("INSERT INTO follows (follower_uid, following_uid) VALUES (?, ?)", user.UID, targetUID)
-
-			// --- フォロー通知処理 (非同期) ---
-			followerName, _ := user.Claims["name"].(string)
-			if followerName == "" {
-				followerName = "Someone"
-			}
-
-			go func(targetUID, followerName, frontendURL string) {
-				// 通知設定を確認
-				if !shouldNotify(targetUID) {
-					log.Printf("Follow notification skipped: User %s has disabled notifications.", targetUID)
-					return
-				}
-
-				authClient, err := app.Auth(context.Background())
-				if err != nil {
-					log.Printf("Follow notification error: Failed to get Auth client: %v", err)
-					return
-				}
-
-				userRecord, err := authClient.GetUser(context.Background(), targetUID)
-				if err != nil {
-					log.Printf("Follow notification error: Failed to get user %s from Firebase: %v", targetUID, err)
-					return
-				}
-
-				if userRecord.Email != "" {
-					subject := "New follower! 🌟"
-					body := fmt.Sprintf(`
-						<h2>You have a new follower! 🌟</h2>
-						<p>Hello!</p>
-						<p><strong>%s</strong> is now following you.</p>
-						<p><a href="%s">Check out their profile on SoundLike!</a></p>
-						<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
-						<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
-					`, followerName, frontendURL, frontendURL)
-					log.Printf("Sending follow notification to: %s", userRecord.Email)
-					if err := sendEmail([]string{userRecord.Email}, subject, body); err != nil {
-						log.Printf("Failed to send follow notification email: %v", err)
-					}
-				} else {
-					log.Printf("Follow notification skipped: User %s has no email address.", targetUID)
-				}
-			}(targetUID, followerName, frontendURL)
-
-			return c.JSON(http.StatusOK, map[string]interface{}{"is_following": true, "message": "Followed successfully."})
+			items, total, err = queryResolvedModeration("comment", limit, offset)
 		}
-	})
-
-	// フォロー状態確認API
-	apiGroup.GET("/user/:uid/follow/status", func(c echo.Context) error {
-		user := c.Get("user").(*auth.Token)
-		targetUID := c.Param("uid")
-
-		var exists bool
-		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM follows WHERE follower_uid = ? AND following_uid = ?)", user.UID, targetUID).Scan(&exists)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "Database error")
+			log.Printf("error querying moderation queue: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, "Error retrieving moderation queue")
 		}
-		return c.JSON(http.StatusOK, map[string]bool{"is_following": exists})
+		return listResponse(c, items, len(items), true, total, limit, offset)
 	})
 
-	// コメント投稿リクエスト構造体
-	type CommentRequest struct {
-		Content string `json:"content"`
+	// モデレーション対応リクエスト構造体
+	type ModerationResolveRequest struct {
+		Action string `json:"action"` // keep / remove
 	}
 
-	// コメント投稿API
-	apiGroup.POST("/track/:id/comment", func(c echo.Context) error {
+	// モデレーションキューの項目を対応済みにする (keep: 再表示して残す / remove: 削除する)
+	adminGroup.POST("/moderation/:type/:id/resolve", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
-		trackID, err := strconv.Atoi(c.Param("id"))
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid track ID")
-		}
-
-		if verified, ok := user.Claims["email_verified"].(bool); !ok || !verified {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "Email verification is required to comment."})
+		if c.Param("type") != "comment" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid type (only 'comment' reports are supported)"})
 		}
-
-		uploaderName, ok := user.Claims["name"].(string)
-		if !ok || uploaderName == "" {
-			return c.JSON(http.StatusForbidden, map[string]string{"message": "Display name is required to comment."})
+		commentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || commentID < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid comment ID"})
 		}
-
-		var req CommentRequest
+		var req ModerationResolveRequest
 		if err := c.Bind(&req); err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid request body")
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request body"})
 		}
-		if len(req.Content) == 0 || len(req.Content) > 500 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Comment must be between 1 and 500 characters."})
+		if req.Action != moderationActionKeep && req.Action != moderationActionRemove {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "action must be 'keep' or 'remove'"})
 		}
 
-		_, err = db.Exec("INSERT INTO comments (track_id, user_uid, user_name, content) VALUES (?, ?, ?, ?)", trackID, user.UID, uploaderName, req.Content)
-		if err != nil {
-			log.Printf("error inserting comment: %v\n", err)
-			return c.JSON(http.StatusInternalServerError, "Failed to post comment")
+		item, err := resolveCommentReports(commentID, req.Action, user.UID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "No pending reports for this comment"})
 		}
-
-		// --- コメント通知処理 (非同期) ---
-		go func(trackID int, commenterName, commentContent, commenterUID, frontendURL string) {
-			// トラックの投稿者を取得
-			var uploaderUID, trackTitle string
-			err := db.QueryRow("SELECT uploader_uid, title FROM tracks WHERE id = ?", trackID).Scan(&uploaderUID, &trackTitle)
-			if err != nil {
-				return
-			}
-
-			// 自分の投稿へのコメントなら通知しない
-			if uploaderUID == commenterUID {
-				return
-			}
-
-			// 通知設定を確認
-			if !shouldNotify(uploaderUID) {
-				return
-			}
-
-			authClient, err := app.Auth(context.Background())
-			if err != nil {
-				return
-			}
-
-			// 投稿者のメールアドレスを取得して送信
-			userRecord, err := authClient.GetUser(context.Background(), uploaderUID)
-			if err == nil && userRecord.Email != "" {
-				subject := fmt.Sprintf("New comment on \"%s\" 💬", trackTitle)
-				body := fmt.Sprintf(`
-					<h2>New comment on "%s" 💬</h2>
-					<p>Hello!</p>
-					<p><strong>%s</strong> commented on your track "<strong>%s</strong>":</p>
-					<blockquote style="border-left: 4px solid #ccc; padding-left: 10px; color: #555;">%s</blockquote>
-					<p><a href="%s">Check it out on SoundLike!</a></p>
-					<hr style="border: 0; border-top: 1px solid #eee; margin: 20px 0;">
-					<p style="font-size: 12px; color: #888;">Don't want these emails? <a href="%s" style="color: #888;">Unsubscribe</a> in your profile settings.</p>
-				`, trackTitle, commenterName, trackTitle, commentContent, frontendURL, frontendURL)
-				log.Printf("Sending comment notification to: %s", userRecord.Email)
-				if err := sendEmail([]string{userRecord.Email}, subject, body); err != nil {
-					log.Printf("Failed to send comment notification email: %v", err)
-				}
-			}
-		}(trackID, uploaderName, req.Content, user.UID, frontendURL)
-
-		return c.JSON(http.StatusOK, map[string]string{"message": "Comment posted successfully!"})
-	})
-
-	// コメント削除API
-	apiGroup.DELETE("/comment/:id", func(c echo.Context) error {
-		user := c.Get("user").(*auth.Token)
-		commentID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid comment ID")
+			log.Printf("error resolving reports for comment %d: %v\n", commentID, err)
+			return c.JSON(http.StatusInternalServerError, "Failed to resolve reports")
 		}
 
-		// 自分のコメントのみ削除可能
-		result, err := db.Exec("DELETE FROM comments WHERE id = ? AND user_uid = ?", commentID, user.UID)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "Database error")
-		}
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			return c.JSON(http.StatusForbidden, "Cannot delete comment (not found or not yours)")
+		if req.Action == moderationActionRemove && item.AuthorUID != user.UID {
+			var trackTitle string
+			if err := db.QueryRow("SELECT title FROM tracks WHERE id = ?", item.TrackID).Scan(&trackTitle); err != nil {
+				log.Printf("error getting track title for comment removal notification: %v\n", err)
+			}
+			go notifyCommentRemoved(item.AuthorUID, item.TrackID, trackTitle, item.Content)
 		}
-		return c.JSON(http.StatusOK, map[string]string{"message": "Comment deleted."})
+		return c.JSON(http.StatusOK, item)
 	})
 
 	// 曲の削除API
 	apiGroup.DELETE("/track/:id", func(c echo.Context) error {
 		user := c.Get("user").(*auth.Token)
-		trackID, err := strconv.Atoi(c.Param("id"))
+		trackID, err := parseTrackID(c)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, "Invalid track ID")
+			return err
 		}
 
 		// DBからトラック情報を取得し、アップロードユーザーが一致するか確認
@@ -1071,10 +5312,25 @@ func main() {
 		if _, err := tx.Exec("DELETE FROM likes WHERE track_id = ?", trackID); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting likes")
 		}
-		// 関連するコメントを削除
+		// 関連するコメントとその通報を削除
+		if _, err := tx.Exec("DELETE FROM comment_reports WHERE comment_id IN (SELECT id FROM comments WHERE track_id = ?)", trackID); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting comment reports")
+		}
 		if _, err := tx.Exec("DELETE FROM comments WHERE track_id = ?", trackID); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting comments")
 		}
+		// 再生履歴を削除
+		if _, err := tx.Exec("DELETE FROM plays WHERE track_id = ?", trackID); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting plays")
+		}
+		// 編集履歴を削除
+		if _, err := tx.Exec("DELETE FROM track_revisions WHERE track_id = ?", trackID); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting track revisions")
+		}
+		// ピン留めされていれば解除
+		if _, err := tx.Exec("UPDATE user_settings SET pinned_track_id = NULL WHERE pinned_track_id = ?", trackID); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error unpinning track")
+		}
 		if _, err := tx.Exec("DELETE FROM tracks WHERE id = ?", trackID); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting track metadata")
 		}
@@ -1085,8 +5341,8 @@ func main() {
 		}
 
 		// DB削除が確定した後にファイルを削除 (不整合防止)
-		filePath := filepath.Join("uploads", track.Filename)
-		if err := os.Remove(filePath); err != nil {
+		filePath := uploadFilePath(uploadsDir, track.Filename)
+		if err := releaseUploadFile(uploadsDir, track.Filename); err != nil {
 			// ファイル削除に失敗してもDBからは消えているため、システムとしての整合性は保たれる
 			// (ゴミファイルは残るが、ユーザーには影響しない)
 			log.Printf("warning: failed to delete file %s after db deletion: %v\n", filePath, err)
@@ -1132,51 +5388,107 @@ func main() {
 			return c.JSON(http.StatusInternalServerError, "Error deleting likes on user tracks")
 		}
 
-		// 4. ユーザーのコメントを削除
+		// 4. コメントへの通報を削除 (削除されるコメントへの通報と、ユーザー自身による通報)
+		if _, err := tx.Exec(`
+			DELETE FROM comment_reports
+			WHERE reporter_uid = ?
+			   OR comment_id IN (SELECT id FROM comments WHERE user_uid = ? OR track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?))`,
+			uid, uid, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting comment reports")
+		}
+
+		// 5. ユーザーのコメントを削除 (他のユーザーからの返信はトップレベルのコメントとして残す)
+		if _, err := tx.Exec("UPDATE comments SET parent_id = NULL WHERE parent_id IN (SELECT id FROM comments WHERE user_uid = ?)", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error detaching replies to user comments")
+		}
+		if _, err := tx.Exec("UPDATE tracks SET pinned_comment_id = NULL WHERE pinned_comment_id IN (SELECT id FROM comments WHERE user_uid = ?)", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error unpinning user comments")
+		}
 		if _, err := tx.Exec("DELETE FROM comments WHERE user_uid = ?", uid); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting user comments")
 		}
 
-		// 5. ユーザーのトラックについたコメントを削除
+		// 6. ユーザーのトラックについたコメントを削除
 		if _, err := tx.Exec("DELETE FROM comments WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?)", uid); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting comments on user tracks")
 		}
 
-		// 6. フォロー情報を削除 (フォローしている、されている両方)
+		// 7. フォロー情報を削除 (フォローしている、されている両方)
 		if _, err := tx.Exec("DELETE FROM follows WHERE follower_uid = ? OR following_uid = ?", uid, uid); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting user follows")
 		}
 
-		// 7. ユーザー設定を削除
+		// 8. ユーザー設定を削除
 		if _, err := tx.Exec("DELETE FROM user_settings WHERE user_uid = ?", uid); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting user settings")
 		}
 
-		// 4. トラック情報を削除
+		// 9. 登録済みのWebhookを削除
+		if _, err := tx.Exec("DELETE FROM webhooks WHERE user_uid = ?", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting user webhooks")
+		}
+
+		// 10. 発行済みのAPIキーを削除
+		if _, err := tx.Exec("DELETE FROM api_keys WHERE user_uid = ?", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting user API keys")
+		}
+
+		// 11. ユーザーのトラックの再生履歴を削除し、ユーザー自身の再生履歴は匿名化する
+		if _, err := tx.Exec("DELETE FROM plays WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?)", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting plays on user tracks")
+		}
+		if _, err := tx.Exec("UPDATE plays SET user_uid = NULL WHERE user_uid = ?", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error anonymizing user plays")
+		}
+
+		// 12. ユーザーのトラックの編集履歴を削除
+		if _, err := tx.Exec("DELETE FROM track_revisions WHERE track_id IN (SELECT id FROM tracks WHERE uploader_uid = ?) OR editor_uid = ?", uid, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting track revisions")
+		}
+
+		// 13. トラック情報を削除
 		if _, err := tx.Exec("DELETE FROM tracks WHERE uploader_uid = ?", uid); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Error deleting user tracks")
 		}
 
+		// 14. ユーザーの集計値を削除 (フォロー・トラックの削除後に行う)
+		if _, err := tx.Exec("DELETE FROM user_stats WHERE user_uid = ?", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting user stats")
+		}
+
+		// 15. モデレーションの記録に残したユーザーのコメントを削除
+		if _, err := tx.Exec("DELETE FROM moderation_resolutions WHERE author_uid = ?", uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, "Error deleting moderation records")
+		}
+
 		// コミット
 		if err := tx.Commit(); err != nil {
 			return c.JSON(http.StatusInternalServerError, "Failed to commit account deletion")
 		}
 
-		// 5. 物理ファイルを削除 (DB削除成功後)
+		// 16. 物理ファイルを削除 (DB削除成功後)
 		for _, fname := range filenames {
-			filePath := filepath.Join("uploads", fname)
-			if err := os.Remove(filePath); err != nil {
+			filePath := uploadFilePath(uploadsDir, fname)
+			if err := releaseUploadFile(uploadsDir, fname); err != nil {
 				log.Printf("warning: failed to delete file %s: %v", filePath, err)
 			}
 		}
 
+		// 17. Firebase Auth のユーザーを削除 (DB削除成功後)
+		// 削除できなかった場合は記録しておき、runPendingUserDeletions が後で再試行する
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		authClient, err := app.Auth(ctx)
+		if err == nil {
+			err = deleteFirebaseUser(ctx, authClient, uid)
+		}
+		if err != nil {
+			log.Printf("error deleting Firebase user %s, will retry later: %v\n", uid, err)
+			markPendingUserDeletion(uid, err)
+		}
+
 		return c.JSON(http.StatusOK, map[string]string{"message": "Account data deleted successfully."})
 	})
 
-	// RenderなどのPaaSは環境変数PORTでポートを指定してくるため対応する
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	e.Logger.Fatal(e.Start(":" + port))
+	return e
 }
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testProjectID はテスト用の Firebase プロジェクトID (ID トークンの aud / iss に使う)
+const testProjectID = "soundlike-test"
+
+// fakeAuthUser は fakeAuth に登録するユーザー
+type fakeAuthUser struct {
+	UID           string
+	DisplayName   string
+	Email         string
+	EmailVerified bool
+	PhotoURL      string
+	Admin         bool
+}
+
+// fakeAuth は Firebase Auth エミュレーターの REST API のうち、サーバーが使う部分 (lookup / update / delete) を真似る
+// FIREBASE_AUTH_EMULATOR_HOST にこのサーバーを指定すると、Admin SDK は署名のない ID トークンを受け付ける
+type fakeAuth struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	users   map[string]*fakeAuthUser
+	deleted []string
+	// failDeletes が true の間は accounts:delete がエラーを返す
+	// (503 だと Admin SDK 自身が時間をかけて再試行するため、再試行されない 400 にする)
+	failDeletes bool
+	// unavailable が true の間は全てのリクエストに 502 を返す (Firebase の障害を真似る、再試行はされない)
+	unavailable bool
+}
+
+func newFakeAuth(t testing.TB) *fakeAuth {
+	f := &fakeAuth{users: make(map[string]*fakeAuthUser)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAuth) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LocalID     json.RawMessage `json:"localId"`
+		DisplayName *string         `json:"displayName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// lookup は UID の配列、update / delete は UID 1つ
+	var uids []string
+	if err := json.Unmarshal(req.LocalID, &uids); err != nil {
+		var uid string
+		json.Unmarshal(req.LocalID, &uid)
+		uids = []string{uid}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if f.unavailable {
+		writeFakeAuthError(w, http.StatusBadGateway, "BACKEND_UNAVAILABLE")
+		return
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/accounts:lookup"):
+		users := make([]map[string]interface{}, 0)
+		for _, uid := range uids {
+			if u, ok := f.users[uid]; ok {
+				users = append(users, map[string]interface{}{
+					"localId":       u.UID,
+					"displayName":   u.DisplayName,
+					"email":         u.Email,
+					"emailVerified": u.EmailVerified,
+					"photoUrl":      u.PhotoURL,
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": users})
+	case strings.HasSuffix(r.URL.Path, "/accounts:update"):
+		u, ok := f.users[uids[0]]
+		if !ok {
+			writeFakeAuthError(w, http.StatusBadRequest, "USER_NOT_FOUND")
+			return
+		}
+		if req.DisplayName != nil {
+			u.DisplayName = *req.DisplayName
+		}
+		json.NewEncoder(w).Encode(map[string]string{"localId": u.UID})
+	case strings.HasSuffix(r.URL.Path, "/accounts:delete"):
+		if f.failDeletes {
+			writeFakeAuthError(w, http.StatusBadRequest, "OPERATION_NOT_ALLOWED")
+			return
+		}
+		if _, ok := f.users[uids[0]]; !ok {
+			writeFakeAuthError(w, http.StatusBadRequest, "USER_NOT_FOUND")
+			return
+		}
+		delete(f.users, uids[0])
+		f.deleted = append(f.deleted, uids[0])
+		json.NewEncoder(w).Encode(map[string]string{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeFakeAuthError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": message}})
+}
+
+// addUser はユーザーを登録し、そのユーザーの ID トークンを返す
+func (f *fakeAuth) addUser(u fakeAuthUser) string {
+	if u.Email == "" {
+		u.Email = u.UID + "@example.com"
+	}
+	f.mu.Lock()
+	f.users[u.UID] = &u
+	f.mu.Unlock()
+	return f.token(u)
+}
+
+// token はエミュレーター用の署名のない ID トークンを作る
+func (f *fakeAuth) token(u fakeAuthUser) string {
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"aud":            testProjectID,
+		"iss":            "https://securetoken.google.com/" + testProjectID,
+		"sub":            u.UID,
+		"iat":            now - 10,
+		"auth_time":      now - 10,
+		"exp":            now + 3600,
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+	}
+	if u.DisplayName != "" {
+		claims["name"] = u.DisplayName
+	}
+	if u.Admin {
+		claims["admin"] = true
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(header) + "." + enc.EncodeToString(payload) + "."
+}
+
+func (f *fakeAuth) deletedUIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deleted...)
+}
+
+// testServer は newServer で組み立てたサーバーを一時ディレクトリの SQLite で動かす
+type testServer struct {
+	*httptest.Server
+	auth       *fakeAuth
+	uploadsDir string
+	client     *http.Client
+}
+
+// newTestServer はテスト用のサーバーを起動する。env は "KEY=value" の形で環境変数を上書きする
+// サーバーはグローバルなデータベース接続を使うため、テストは並列に実行しない
+func newTestServer(t testing.TB, env ...string) *testServer {
+	t.Helper()
+	fa := newFakeAuth(t)
+	dir := t.TempDir()
+	uploadsDir := filepath.Join(dir, "uploads")
+
+	defaults := map[string]string{
+		"DATA_DIR":                            filepath.Join(dir, "data"),
+		"UPLOADS_DIR":                         uploadsDir,
+		"GOOGLE_CLOUD_PROJECT":                testProjectID,
+		"FIREBASE_AUTH_EMULATOR_HOST":         strings.TrimPrefix(fa.URL, "http://"),
+		"RATE_LIMIT_ANONYMOUS_PER_SECOND":     "10000",
+		"RATE_LIMIT_AUTHENTICATED_PER_SECOND": "10000",
+		"COMMENT_MIN_INTERVAL_SECONDS":        "0",
+		"WAL_CHECKPOINT_INTERVAL_SECONDS":     "0",
+		"BREVO_API_KEY":                       "",
+		"BREVO_SENDER_EMAIL":                  "",
+	}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		defaults[k] = v
+	}
+	for k, v := range defaults {
+		t.Setenv(k, v)
+	}
+	resetServerGlobals()
+
+	e := newServer(context.Background())
+	srv := httptest.NewServer(e)
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return &testServer{
+		Server:     srv,
+		auth:       fa,
+		uploadsDir: uploadsDir,
+		client: &http.Client{
+			// リダイレクトそのものを確認できるよう、追いかけない
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// initialLimits は環境変数で上書きする前の長さ制限 (loadLengthLimits は現在の limits を土台にするため、テストごとに戻す)
+var initialLimits = limits
+
+// resetServerGlobals は前のテストのサーバーが変更したグローバルな設定とキャッシュを初期状態に戻す
+func resetServerGlobals() {
+	limits = initialLimits
+	likesCountFilter = selfLikeFilter
+	trackColumns = buildTrackColumns()
+	defaultFeedSort = "newest"
+	uploadFileNaming = fileNamingUUID
+	dashboardCache.Lock()
+	dashboardCache.entries = make(map[string]cachedDashboard)
+	dashboardCache.Unlock()
+	platformStatsCache.Lock()
+	platformStatsCache.data = nil
+	platformStatsCache.Unlock()
+	activeUsersCache.Lock()
+	activeUsersCache.data = nil
+	activeUsersCache.Unlock()
+	displayNameCache.Lock()
+	displayNameCache.entries = make(map[string]cachedDisplayName)
+	displayNameCache.Unlock()
+	apiKeyOwnerCache.Lock()
+	apiKeyOwnerCache.entries = make(map[string]cachedAPIKeyOwner)
+	apiKeyOwnerCache.Unlock()
+}
+
+// addUser はメール認証済みで表示名のあるユーザーを登録し、ID トークンを返す
+func (s *testServer) addUser(uid string) string {
+	return s.auth.addUser(fakeAuthUser{UID: uid, DisplayName: "User " + uid, EmailVerified: true})
+}
+
+// newRequest はリクエストを作る。body が string / []byte 以外なら JSON にする
+func (s *testServer) newRequest(t *testing.T, method, path, token string, body interface{}) *http.Request {
+	t.Helper()
+	var r io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	req, err := http.NewRequest(method, s.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// do はリクエストを送り、レスポンスと本文を返す
+func (s *testServer) do(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// call はリクエストを作って送る
+func (s *testServer) call(t *testing.T, method, path, token string, body interface{}) (*http.Response, []byte) {
+	t.Helper()
+	return s.do(t, s.newRequest(t, method, path, token, body))
+}
+
+// callJSON はリクエストを送り、ステータスを確認してから本文を out にデコードする (out が nil なら確認だけ)
+func (s *testServer) callJSON(t *testing.T, method, path, token string, body interface{}, wantStatus int, out interface{}) *http.Response {
+	t.Helper()
+	resp, data := s.call(t, method, path, token, body)
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: status %d, want %d (body: %s)", method, path, resp.StatusCode, wantStatus, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: decoding %s: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+// insertTrack はトラックを直接データベースに登録し、IDを返す (音声ファイルは作らない)
+func insertTrack(t *testing.T, uploaderUID, title string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO tracks (filename, title, uploader_uid, uploader_name) VALUES (?, ?, ?, ?)",
+		uuid.New().String()+".mp3", title, uploaderUID, "User "+uploaderUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// insertComment はコメントを直接データベースに登録し、IDを返す
+func insertComment(t *testing.T, trackID int, userUID, content string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO comments (track_id, user_uid, user_name, content) VALUES (?, ?, ?, ?)",
+		trackID, userUID, "User "+userUID, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// testMP3 は MPEG1 Layer III・128kbps・44.1kHz の無音のフレームを並べた、d 以上の長さ (1フレーム分まで長い) の MP3 を返す
+func testMP3(d time.Duration) []byte {
+	const frameSize = 417 // 144 * 128000 / 44100
+	frameDuration := time.Second * 1152 / 44100
+	frames := int((d + frameDuration - 1) / frameDuration)
+	var buf bytes.Buffer
+	for i := 0; i < frames; i++ {
+		frame := make([]byte, frameSize)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// insertTrackWithAudio は音声ファイルを uploads ディレクトリに置いてトラックを登録し、IDを返す
+func (s *testServer) insertTrackWithAudio(t *testing.T, uploaderUID, title string, audio []byte) int {
+	t.Helper()
+	filename := uuid.New().String() + ".mp3"
+	if err := os.WriteFile(filepath.Join(s.uploadsDir, filename), audio, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Exec("INSERT INTO tracks (filename, title, uploader_uid, uploader_name) VALUES (?, ?, ?, ?)",
+		filename, title, uploaderUID, "User "+uploaderUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// queryInt は1つの整数を返すクエリを実行する
+func queryInt(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n sql.NullInt64
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return int(n.Int64)
+}
+
+func TestServerServesHealthCheck(t *testing.T) {
+	s := newTestServer(t)
+	resp, body := s.call(t, http.MethodGet, "/", "", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "running") {
+		t.Fatalf("GET /: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestAuthenticatedRequestUsesEmulatorToken(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	var me map[string]interface{}
+	s.callJSON(t, http.MethodGet, "/api/me", token, nil, http.StatusOK, &me)
+	if me["uid"] != "alice" || me["email_verified"] != true {
+		t.Fatalf("unexpected /api/me: %v", me)
+	}
+	s.callJSON(t, http.MethodGet, "/api/me", "", nil, http.StatusUnauthorized, nil)
+	s.callJSON(t, http.MethodGet, "/api/me", "not-a-token", nil, http.StatusForbidden, nil)
+}
+
+// addAdmin は管理者のユーザーを登録し、ID トークンを返す
+func (s *testServer) addAdmin(uid string) string {
+	return s.auth.addUser(fakeAuthUser{UID: uid, DisplayName: "User " + uid, EmailVerified: true, Admin: true})
+}
+
+// formFile は multipartForm で送るファイル
+type formFile struct {
+	field, name string
+	data        []byte
+}
+
+// multipartForm は multipart/form-data の本文と Content-Type を作る
+func multipartForm(t *testing.T, fields map[string]string, files ...formFile) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range files {
+		fw, err := w.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(f.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), w.FormDataContentType()
+}
+
+// createAPIKey は token のユーザーで API キーを発行し、平文のキーを返す
+func (s *testServer) createAPIKey(t *testing.T, token string, scopes ...string) string {
+	t.Helper()
+	var created struct {
+		Key string `json:"key"`
+	}
+	s.callJSON(t, http.MethodPost, "/api/account/api-keys", token, map[string]interface{}{"name": "test", "scopes": scopes}, http.StatusCreated, &created)
+	return created.Key
+}
+
+// fakeBrevo は Brevo のメール送信APIの代わりに、送信されたメールを記録する
+// 宛先に "invalid" を含むメールは 400 で拒否する
+type fakeBrevo struct {
+	mu   sync.Mutex
+	sent []fakeBrevoEmail
+}
+
+type fakeBrevoEmail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// newFakeBrevo は brevoEmailAPIURL と送信に必要な環境変数を fakeBrevo に向ける
+func newFakeBrevo(t *testing.T) *fakeBrevo {
+	f := &fakeBrevo{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			To []struct {
+				Email string `json:"email"`
+			} `json:"to"`
+			Subject     string `json:"subject"`
+			HtmlContent string `json:"htmlContent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.To) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(req.To[0].Email, "invalid") {
+			http.Error(w, `{"code":"invalid_parameter"}`, http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.sent = append(f.sent, fakeBrevoEmail{To: req.To[0].Email, Subject: req.Subject, Body: req.HtmlContent})
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := brevoEmailAPIURL
+	brevoEmailAPIURL = srv.URL
+	t.Cleanup(func() { brevoEmailAPIURL = prev })
+	t.Setenv("BREVO_API_KEY", "test-key")
+	t.Setenv("BREVO_SENDER_EMAIL", "noreply@example.com")
+	return f
+}
+
+// emails は送信されたメールを返す
+func (f *fakeBrevo) emails() []fakeBrevoEmail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeBrevoEmail(nil), f.sent...)
+}
+
+// waitForEmails は n 通以上のメールが送信されるまで待つ (送信は非同期のワーカーが行う)
+func (f *fakeBrevo) waitForEmails(t *testing.T, n int) []fakeBrevoEmail {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sent := f.emails(); len(sent) >= n || time.Now().After(deadline) {
+			if len(sent) < n {
+				t.Fatalf("got %d emails, want %d", len(sent), n)
+			}
+			return sent
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// listEnvelope は listResponse の {data, meta} の形のレスポンス
+type listEnvelope[T any] struct {
+	Data []T      `json:"data"`
+	Meta listMeta `json:"meta"`
+}
+
+// mustExec はテストデータを直接データベースに書き込む
+func mustExec(t testing.TB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func TestJSONErrorResponses(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		method, path string
+		status       int
+		message      string
+	}{
+		{http.MethodGet, "/api/no-such-endpoint", http.StatusNotFound, "Resource not found"},
+		{http.MethodGet, "/no/such/path", http.StatusNotFound, "Resource not found"},
+		{http.MethodDelete, "/api/tracks", http.StatusMethodNotAllowed, "Method not allowed"},
+		{http.MethodPut, "/api/me", http.StatusMethodNotAllowed, "Method not allowed"}, // 認証が必要なルートも 401 にしない
+		{http.MethodGet, "/api/admin/no-such-endpoint", http.StatusNotFound, "Resource not found"},
+		{http.MethodGet, "/uploads/missing.mp3", http.StatusNotFound, "Resource not found"},
+	}
+	for _, tt := range tests {
+		resp, body := s.call(t, tt.method, tt.path, "", nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s %s: Content-Type = %q", tt.method, tt.path, ct)
+		}
+		var res map[string]string
+		if err := json.Unmarshal(body, &res); err != nil || res["message"] != tt.message {
+			t.Errorf("%s %s: body %s, want message %q", tt.method, tt.path, body, tt.message)
+		}
+	}
+
+	// 既存のアップロードファイルは従来どおり配信される
+	id := s.insertTrackWithAudio(t, "alice", "Song", testMP3(2*time.Second))
+	var filename string
+	if err := db.QueryRow("SELECT filename FROM tracks WHERE id = ?", id).Scan(&filename); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := s.call(t, http.MethodGet, "/uploads/"+filename, "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET existing upload: status %d", resp.StatusCode)
+	}
+}
+
+func TestTrailingSlashRedirects(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		method, path string
+		status       int
+		location     string
+	}{
+		{http.MethodGet, "/api/tracks", http.StatusOK, ""},
+		{http.MethodGet, "/api/tracks/", http.StatusMovedPermanently, "/api/tracks"},
+		{http.MethodGet, "/api/tracks/?sort=oldest", http.StatusMovedPermanently, "/api/tracks?sort=oldest"},
+		{http.MethodHead, "/api/tracks/", http.StatusMovedPermanently, "/api/tracks"},
+		// GET / HEAD 以外はメソッドとボディを保ったまま再送されるよう 308
+		{http.MethodPost, "/api/track/1/like/", http.StatusPermanentRedirect, "/api/track/1/like"},
+		{http.MethodGet, "/", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		resp, _ := s.call(t, tt.method, tt.path, "", nil)
+		if resp.StatusCode != tt.status || resp.Header.Get("Location") != tt.location {
+			t.Errorf("%s %s: %d Location %q; want %d %q", tt.method, tt.path, resp.StatusCode, resp.Header.Get("Location"), tt.status, tt.location)
+		}
+	}
+
+	// リダイレクト先をたどると一覧が返る
+	client := &http.Client{}
+	resp, err := client.Get(s.URL + "/api/tracks/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("following the redirect: status %d", resp.StatusCode)
+	}
+
+	// /uploads は静的ファイルの配信に任せ、リダイレクトしない
+	if resp, _ := s.call(t, http.MethodGet, "/uploads/", "", nil); resp.StatusCode == http.StatusMovedPermanently {
+		t.Error("/uploads/ was redirected")
+	}
+}
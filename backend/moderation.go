@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// モデレーションキューの対応方法
+const (
+	moderationActionKeep   = "keep"   // 問題なしとして残す (非表示なら再表示する)
+	moderationActionRemove = "remove" // 削除する
+)
+
+// ModerationItem はモデレーションキューの1件 (通報された項目と、通報の集計)
+// 現在通報できるのはコメントのみのため、type は常に comment
+type ModerationItem struct {
+	Type           string         `json:"type"`
+	ID             int            `json:"id"`
+	TrackID        int            `json:"track_id"`
+	AuthorUID      string         `json:"author_uid"`
+	Content        string         `json:"content"`
+	Hidden         bool           `json:"hidden"`
+	ReportCount    int            `json:"report_count"`
+	Reasons        map[string]int `json:"reasons"` // 理由ごとの通報数
+	LastReportedAt time.Time      `json:"last_reported_at"`
+	Status         string         `json:"status"`                // pending / resolved
+	Action         string         `json:"action,omitempty"`      // 対応済みの場合のみ (keep / remove)
+	ResolvedBy     string         `json:"resolved_by,omitempty"` // 対応した管理者のUID
+	ResolvedAt     *time.Time     `json:"resolved_at,omitempty"`
+}
+
+// sqliteTimeLayout は集計関数 (MAX など) の結果として文字列で返る DATETIME の形式
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// pendingCommentReportsSQL は未対応の通報をコメントごとに集計するクエリ (条件は呼び出し側で WHERE に付け加える)
+const pendingCommentReportsSQL = `
+	SELECT cm.id, cm.track_id, cm.user_uid, cm.content, cm.hidden, COUNT(*) AS report_count, MAX(r.created_at) AS last_reported_at
+	FROM comment_reports r
+	INNER JOIN comments cm ON cm.id = r.comment_id`
+
+func scanPendingCommentReport(row interface{ Scan(...interface{}) error }) (ModerationItem, error) {
+	item := ModerationItem{Type: "comment", Status: "pending"}
+	var lastReportedAt string
+	if err := row.Scan(&item.ID, &item.TrackID, &item.AuthorUID, &item.Content, &item.Hidden, &item.ReportCount, &lastReportedAt); err != nil {
+		return item, err
+	}
+	item.LastReportedAt, _ = time.Parse(sqliteTimeLayout, lastReportedAt)
+	return item, nil
+}
+
+// queryPendingCommentReports は未対応の通報があるコメントを、通報数の多い順に返す (総件数も返す)
+func queryPendingCommentReports(limit, offset int) ([]ModerationItem, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(DISTINCT r.comment_id) FROM comment_reports r INNER JOIN comments cm ON cm.id = r.comment_id").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(pendingCommentReportsSQL+`
+		GROUP BY cm.id
+		ORDER BY report_count DESC, last_reported_at DESC, cm.id DESC
+		LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := make([]ModerationItem, 0)
+	byID := make(map[int]*ModerationItem)
+	for rows.Next() {
+		item, err := scanPendingCommentReport(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(items) == 0 {
+		return items, total, nil
+	}
+
+	// 理由ごとの通報数は、このページのコメント分だけまとめて取得する
+	placeholders := make([]string, len(items))
+	args := make([]interface{}, len(items))
+	for i := range items {
+		items[i].Reasons = make(map[string]int)
+		byID[items[i].ID] = &items[i]
+		placeholders[i] = "?"
+		args[i] = items[i].ID
+	}
+	reasonRows, err := db.Query("SELECT comment_id, reason, COUNT(*) FROM comment_reports WHERE comment_id IN ("+strings.Join(placeholders, ",")+") GROUP BY comment_id, reason", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reasonRows.Close()
+	for reasonRows.Next() {
+		var commentID, n int
+		var reason string
+		if err := reasonRows.Scan(&commentID, &reason, &n); err != nil {
+			return nil, 0, err
+		}
+		byID[commentID].Reasons[reason] = n
+	}
+	return items, total, reasonRows.Err()
+}
+
+// resolveCommentReports はコメントへの未対応の通報を action で対応済みにし、記録した内容を返す
+// keep の場合はコメントを再表示し、remove の場合はコメントを削除する。どちらの場合も通報は削除する
+// 未対応の通報がない場合は sql.ErrNoRows を返す
+func resolveCommentReports(commentID int, action, resolverUID string) (ModerationItem, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return ModerationItem{}, err
+	}
+	defer tx.Rollback()
+
+	item, err := scanPendingCommentReport(tx.QueryRow(pendingCommentReportsSQL+" WHERE cm.id = ? GROUP BY cm.id", commentID))
+	if err != nil {
+		return item, err
+	}
+	item.Reasons = make(map[string]int)
+	rows, err := tx.Query("SELECT reason, COUNT(*) FROM comment_reports WHERE comment_id = ? GROUP BY reason", commentID)
+	if err != nil {
+		return item, err
+	}
+	for rows.Next() {
+		var reason string
+		var n int
+		if err := rows.Scan(&reason, &n); err != nil {
+			rows.Close()
+			return item, err
+		}
+		item.Reasons[reason] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return item, err
+	}
+
+	switch action {
+	case moderationActionKeep:
+		if _, err := tx.Exec("UPDATE comments SET hidden = FALSE WHERE id = ?", commentID); err != nil {
+			return item, err
+		}
+		item.Hidden = false
+	case moderationActionRemove:
+		// DELETE /api/comment/:id と同じく、返信は削除したコメントの返信先に付け替え、ピン留めは解除する
+		if _, err := tx.Exec("UPDATE comments SET parent_id = (SELECT parent_id FROM comments WHERE id = ?) WHERE parent_id = ?", commentID, commentID); err != nil {
+			return item, err
+		}
+		if _, err := tx.Exec("UPDATE tracks SET pinned_comment_id = NULL WHERE pinned_comment_id = ?", commentID); err != nil {
+			return item, err
+		}
+		if _, err := tx.Exec("DELETE FROM comments WHERE id = ?", commentID); err != nil {
+			return item, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM comment_reports WHERE comment_id = ?", commentID); err != nil {
+		return item, err
+	}
+
+	reasons, err := json.Marshal(item.Reasons)
+	if err != nil {
+		return item, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := tx.Exec(`
+		INSERT INTO moderation_resolutions (item_type, item_id, track_id, author_uid, content, report_count, reasons, last_reported_at, action, resolver_uid, created_at)
+		VALUES ('comment', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.ID, item.TrackID, item.AuthorUID, item.Content, item.ReportCount, string(reasons), item.LastReportedAt, action, resolverUID, now); err != nil {
+		return item, err
+	}
+	if err := tx.Commit(); err != nil {
+		return item, err
+	}
+
+	item.Status = "resolved"
+	item.Action = action
+	item.ResolvedBy = resolverUID
+	item.ResolvedAt = &now
+	return item, nil
+}
+
+// queryResolvedModeration は対応済みの項目を新しい順に返す (総件数も返す)
+// hidden は対応後の状態 (keep なら再表示済み、remove なら削除済みのため常に false)
+func queryResolvedModeration(itemType string, limit, offset int) ([]ModerationItem, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM moderation_resolutions WHERE item_type = ?", itemType).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT item_id, track_id, author_uid, content, report_count, reasons, last_reported_at, action, resolver_uid, created_at
+		FROM moderation_resolutions
+		WHERE item_type = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?`, itemType, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := make([]ModerationItem, 0)
+	for rows.Next() {
+		item := ModerationItem{Type: itemType, Status: "resolved"}
+		var reasons string
+		var lastReportedAt sql.NullTime
+		var resolvedAt time.Time
+		if err := rows.Scan(&item.ID, &item.TrackID, &item.AuthorUID, &item.Content, &item.ReportCount, &reasons, &lastReportedAt, &item.Action, &item.ResolvedBy, &resolvedAt); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal([]byte(reasons), &item.Reasons); err != nil {
+			return nil, 0, err
+		}
+		item.LastReportedAt = lastReportedAt.Time
+		item.ResolvedAt = &resolvedAt
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestModerationQueue(t *testing.T) {
+	// 2件の通報を受けたコメントが非表示になるよう、しきい値は1にする
+	s := newTestServer(t, "COMMENT_REPORT_HIDE_THRESHOLD=1")
+	admin := s.addAdmin("root")
+	alice := s.addUser("alice")
+	bob := s.addUser("bob")
+	carol := s.addUser("carol")
+	track := insertTrack(t, "alice", "Song")
+	kept := insertComment(t, track, "alice", "borderline")
+	removed := insertComment(t, track, "alice", "spam spam")
+
+	report := func(token string, commentID int, reason string) {
+		s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/comment/%d/report", commentID), token, map[string]string{"reason": reason}, http.StatusCreated, nil)
+	}
+	report(bob, kept, "harassment")
+	report(carol, kept, "other")
+	report(bob, removed, "spam")
+
+	var queue listEnvelope[ModerationItem]
+	s.callJSON(t, http.MethodGet, "/api/admin/moderation", admin, nil, http.StatusOK, &queue)
+	if queue.Meta.Total != 2 || len(queue.Data) != 2 {
+		t.Fatalf("pending queue: %d items (total %d), want 2", len(queue.Data), queue.Meta.Total)
+	}
+	first := queue.Data[0]
+	if first.ID != kept || first.ReportCount != 2 || first.Reasons["harassment"] != 1 || first.Reasons["other"] != 1 || !first.Hidden || first.Status != "pending" {
+		t.Errorf("most reported item = %+v", first)
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/admin/moderation", alice, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodGet, "/api/admin/moderation?type=track", admin, nil, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodGet, "/api/admin/moderation?status=open", admin, nil, http.StatusBadRequest, nil)
+
+	resolve := func(commentID int, action string, want int) ModerationItem {
+		var item ModerationItem
+		s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/admin/moderation/comment/%d/resolve", commentID), admin, map[string]string{"action": action}, want, &item)
+		return item
+	}
+	if item := resolve(kept, "keep", http.StatusOK); item.Status != "resolved" || item.Action != "keep" || item.ResolvedBy != "root" {
+		t.Errorf("kept item = %+v", item)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE id = ? AND NOT hidden", kept); n != 1 {
+		t.Error("kept comment is still hidden")
+	}
+	resolve(removed, "remove", http.StatusOK)
+	if n := queryInt(t, "SELECT COUNT(*) FROM comments WHERE id = ?", removed); n != 0 {
+		t.Error("removed comment still exists")
+	}
+	var msg map[string]string
+	resolve(kept, "keep", http.StatusNotFound)
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/admin/moderation/comment/%d/resolve", kept), admin, map[string]string{"action": "ban"}, http.StatusBadRequest, &msg)
+
+	s.callJSON(t, http.MethodGet, "/api/admin/moderation", admin, nil, http.StatusOK, &queue)
+	if len(queue.Data) != 0 {
+		t.Errorf("pending queue after resolving: %+v", queue.Data)
+	}
+	s.callJSON(t, http.MethodGet, "/api/admin/moderation?status=resolved", admin, nil, http.StatusOK, &queue)
+	if len(queue.Data) != 2 || queue.Meta.Total != 2 {
+		t.Fatalf("resolved queue: %d items (total %d), want 2", len(queue.Data), queue.Meta.Total)
+	}
+	actions := map[int]string{}
+	for _, item := range queue.Data {
+		actions[item.ID] = item.Action
+	}
+	if actions[kept] != "keep" || actions[removed] != "remove" {
+		t.Errorf("resolved actions = %v", actions)
+	}
+}
+
+func TestCommentReports(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.addAdmin("root")
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+	unverified := s.auth.addUser(fakeAuthUser{UID: "carol", DisplayName: "Carol"})
+	track := insertTrack(t, "alice", "Song")
+	id := insertComment(t, track, "alice", "questionable")
+	other := insertComment(t, track, "alice", "also questionable")
+	reportPath := fmt.Sprintf("/api/comment/%d/report", id)
+
+	// 理由は決められた値のみ
+	for _, reason := range []string{"", "boring", "SPAM"} {
+		s.callJSON(t, http.MethodPost, reportPath, bob, map[string]string{"reason": reason}, http.StatusBadRequest, nil)
+	}
+	s.callJSON(t, http.MethodPost, reportPath, bob, map[string]string{"reason": "other", "details": strings.Repeat("x", 501)}, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPost, reportPath, bob, map[string]string{"reason": "other", "details": strings.Repeat("あ", 501)}, http.StatusBadRequest, nil)
+	// 詳細の長さはバイト数ではなく文字数で数える
+	dave := s.addUser("dave")
+	s.callJSON(t, http.MethodPost, reportPath, dave, map[string]string{"reason": "other", "details": strings.Repeat("あ", 500)}, http.StatusCreated, nil)
+	mustExec(t, "DELETE FROM comment_reports WHERE reporter_uid = 'dave'")
+	// 自分のコメントや、存在しないコメントは通報できない
+	s.callJSON(t, http.MethodPost, reportPath, alice, map[string]string{"reason": "spam"}, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPost, "/api/comment/999999/report", bob, map[string]string{"reason": "spam"}, http.StatusNotFound, nil)
+	// 認証とメール認証が必要
+	s.callJSON(t, http.MethodPost, reportPath, "", map[string]string{"reason": "spam"}, http.StatusUnauthorized, nil)
+	s.callJSON(t, http.MethodPost, reportPath, unverified, map[string]string{"reason": "spam"}, http.StatusForbidden, nil)
+
+	s.callJSON(t, http.MethodPost, reportPath, bob, map[string]string{"reason": "harassment", "details": "  mean  "}, http.StatusCreated, nil)
+	s.callJSON(t, http.MethodPost, reportPath, bob, map[string]string{"reason": "spam"}, http.StatusConflict, nil)
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/comment/%d/report", other), bob, map[string]string{"reason": "spam"}, http.StatusCreated, nil)
+	mustExec(t, "UPDATE comment_reports SET created_at = '2026-01-01 00:00:00' WHERE comment_id = ?", id)
+
+	// 管理者には新しい順に、通報対象のコメントの内容とともに返す
+	var page struct {
+		Reports []CommentReport `json:"reports"`
+		Total   int             `json:"total"`
+	}
+	s.callJSON(t, http.MethodGet, "/api/admin/comment-reports", admin, nil, http.StatusOK, &page)
+	if page.Total != 2 || len(page.Reports) != 2 || page.Reports[0].CommentID != other {
+		t.Fatalf("comment reports = %+v", page)
+	}
+	r := page.Reports[1]
+	if r.CommentID != id || r.TrackID != track || r.CommentUserUID != "alice" || r.CommentContent != "questionable" ||
+		r.ReporterUID != "bob" || r.Reason != "harassment" || r.Details != "mean" {
+		t.Errorf("report = %+v", r)
+	}
+	s.callJSON(t, http.MethodGet, "/api/admin/comment-reports?limit=1&offset=1", admin, nil, http.StatusOK, &page)
+	if page.Total != 2 || len(page.Reports) != 1 || page.Reports[0].CommentID != id {
+		t.Errorf("second page of comment reports = %+v", page)
+	}
+	s.callJSON(t, http.MethodGet, "/api/admin/comment-reports", bob, nil, http.StatusForbidden, nil)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// undocumentedRoutes は API ではないため仕様書に載せないルート
+var undocumentedRoutes = map[string]bool{
+	"GET /":                 true,
+	"GET /api/docs/init.js": true,
+	"GET /uploads*":         true,
+	"HEAD /uploads*":        true,
+}
+
+type openAPISpec struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	s := newTestServer(t)
+	var spec openAPISpec
+	s.callJSON(t, http.MethodGet, "/api/openapi.json", "", nil, http.StatusOK, &spec)
+	if !strings.HasPrefix(spec.OpenAPI, "3.0.") {
+		t.Errorf("openapi = %q, want 3.0.x", spec.OpenAPI)
+	}
+
+	// 登録されているルートはすべて仕様書にあり、仕様書のパスはすべて実在する
+	param := regexp.MustCompile(`:(\w+)`)
+	registered := make(map[string]bool)
+	for _, r := range s.Server.Config.Handler.(*echo.Echo).Routes() {
+		if r.Method == echo.RouteNotFound {
+			continue
+		}
+		path := param.ReplaceAllString(r.Path, "{$1}")
+		key := r.Method + " " + path
+		registered[key] = true
+		if undocumentedRoutes[key] {
+			continue
+		}
+		if _, ok := spec.Paths[path][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("%s is not documented in openapi.json", key)
+		}
+	}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if key := strings.ToUpper(method) + " " + path; !registered[key] {
+				t.Errorf("openapi.json documents %s, which is not a registered route", key)
+			}
+		}
+	}
+
+	// 主なスキーマは構造体の JSON フィールドをすべて含む
+	for name, v := range map[string]interface{}{"Track": Track{}, "Comment": Comment{}} {
+		properties := spec.Components.Schemas[name].Properties
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			field, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if field == "" || field == "-" {
+				continue
+			}
+			if _, ok := properties[field]; !ok {
+				t.Errorf("schema %s is missing the %q property", name, field)
+			}
+		}
+	}
+	if _, ok := spec.Components.Schemas["Error"]; !ok {
+		t.Error("openapi.json has no Error schema")
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	s := newTestServer(t)
+	resp, body := s.call(t, http.MethodGet, "/api/docs", "", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET /api/docs: status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "/api/docs/init.js") {
+		t.Error("Swagger UI page does not load its init script")
+	}
+	if resp, _ := s.call(t, http.MethodGet, "/api/docs/init.js", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/docs/init.js: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// playDebounceShards は再生デバウンスのマップを分割する数 (ロックの競合を減らすため)
+const playDebounceShards = 16
+
+// playDebouncer は同じリスナーによる同じトラックの連続した再生を、window の間1回として数える
+// キーごとの最終記録時刻をシャードに分けたマップで保持し、期限切れのエントリは定期的に削除する
+type playDebouncer struct {
+	window      time.Duration
+	maxPerShard int
+	shards      [playDebounceShards]playDebounceShard
+}
+
+type playDebounceShard struct {
+	sync.Mutex
+	seen map[string]time.Time
+}
+
+// newPlayDebouncer は最大 maxEntries 件までを保持するデバウンサーを作る
+func newPlayDebouncer(window time.Duration, maxEntries int) *playDebouncer {
+	d := &playDebouncer{window: window, maxPerShard: maxEntries / playDebounceShards}
+	if d.maxPerShard < 1 {
+		d.maxPerShard = 1
+	}
+	for i := range d.shards {
+		d.shards[i].seen = make(map[string]time.Time)
+	}
+	return d
+}
+
+func (d *playDebouncer) shard(key string) *playDebounceShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &d.shards[h.Sum32()%playDebounceShards]
+}
+
+// allow は key の再生を数えてよいかを返し、数える場合は時刻を記録する
+// シャードが上限に達していて空きも作れない場合は、メモリを増やさないよう記録せずに数える
+func (d *playDebouncer) allow(key string, now time.Time) bool {
+	if d.window <= 0 {
+		return true
+	}
+	s := d.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if last, ok := s.seen[key]; ok {
+		if now.Sub(last) < d.window {
+			return false
+		}
+		s.seen[key] = now
+		return true
+	}
+	if len(s.seen) >= d.maxPerShard {
+		s.evictBefore(now.Add(-d.window))
+		if len(s.seen) >= d.maxPerShard {
+			return true
+		}
+	}
+	s.seen[key] = now
+	return true
+}
+
+// evictBefore は cutoff より前に記録されたエントリを削除する (呼び出し側でロックすること)
+func (s *playDebounceShard) evictBefore(cutoff time.Time) {
+	for key, last := range s.seen {
+		if last.Before(cutoff) {
+			delete(s.seen, key)
+		}
+	}
+}
+
+// evictExpired は全シャードから期限切れのエントリを削除する
+func (d *playDebouncer) evictExpired(now time.Time) {
+	cutoff := now.Add(-d.window)
+	for i := range d.shards {
+		s := &d.shards[i]
+		s.Lock()
+		s.evictBefore(cutoff)
+		s.Unlock()
+	}
+}
+
+// runEviction は interval ごとに期限切れのエントリを削除し続ける (goroutine で起動する)
+func (d *playDebouncer) runEviction(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		d.evictExpired(now)
+	}
+}
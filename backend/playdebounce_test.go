@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// entries は保持しているエントリの数を返す
+func (d *playDebouncer) entries() int {
+	n := 0
+	for i := range d.shards {
+		s := &d.shards[i]
+		s.Lock()
+		n += len(s.seen)
+		s.Unlock()
+	}
+	return n
+}
+
+func TestPlayDebouncer(t *testing.T) {
+	d := newPlayDebouncer(30*time.Second, 1000)
+	now := time.Now()
+	if !d.allow("1|uid:a", now) {
+		t.Fatal("first play was not counted")
+	}
+	if d.allow("1|uid:a", now.Add(10*time.Second)) {
+		t.Error("repeated play within the window was counted")
+	}
+	if !d.allow("2|uid:a", now) || !d.allow("1|uid:b", now) {
+		t.Error("plays of other tracks or listeners were debounced")
+	}
+	if !d.allow("1|uid:a", now.Add(31*time.Second)) {
+		t.Error("play after the window was not counted")
+	}
+
+	d.evictExpired(now.Add(time.Minute))
+	if n := d.entries(); n != 1 {
+		t.Errorf("%d entries after eviction, want 1 (only the latest play)", n)
+	}
+
+	// window が 0 なら全て数える
+	off := newPlayDebouncer(0, 1000)
+	if !off.allow("k", now) || !off.allow("k", now) {
+		t.Error("disabled debouncer suppressed a play")
+	}
+}
+
+func TestPlayDebouncerConcurrentAndBounded(t *testing.T) {
+	const maxEntries = 256
+	shared := newPlayDebouncer(time.Hour, maxEntries)
+	bounded := newPlayDebouncer(time.Hour, maxEntries)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counted := map[string]int{}
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				// 同じキーへの同時アクセスは1回だけ数える
+				key := fmt.Sprintf("shared-%d", i%8)
+				if shared.allow(key, now) {
+					mu.Lock()
+					counted[key]++
+					mu.Unlock()
+				}
+				// 上限を超える数のキーが来てもメモリは増え続けない
+				bounded.allow(fmt.Sprintf("unique-%d-%d", g, i), now)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if len(counted) != 8 {
+		t.Errorf("%d shared keys counted, want 8", len(counted))
+	}
+	for key, n := range counted {
+		if n != 1 {
+			t.Errorf("%s counted %d times, want 1", key, n)
+		}
+	}
+	if n := bounded.entries(); n > maxEntries {
+		t.Errorf("debouncer holds %d entries, want at most %d", n, maxEntries)
+	}
+}
+
+func TestConcurrentPlaysAreCountedOnce(t *testing.T) {
+	s := newTestServer(t)
+	id := insertTrack(t, "alice", "Song")
+	listener := "6f1c2a56-1f0e-4c4e-9d61-7d9b7a0e5a11"
+
+	const requests = 50
+	var wg sync.WaitGroup
+	results := make(chan bool, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.client.Do(s.newRequest(t, http.MethodPost, fmt.Sprintf("/api/track/%d/play", id), "", map[string]string{"listener_id": listener}))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			var res struct {
+				Counted bool `json:"counted"`
+			}
+			if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&res) != nil {
+				t.Errorf("play: status %d", resp.StatusCode)
+				return
+			}
+			results <- res.Counted
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	counted := 0
+	for c := range results {
+		if c {
+			counted++
+		}
+	}
+	if counted != 1 {
+		t.Errorf("%d of %d concurrent plays were counted, want 1", counted, requests)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM plays WHERE track_id = ?", id); n != 1 {
+		t.Errorf("plays rows = %d, want 1", n)
+	}
+}
+
+func TestAnonymousListenerIDDebounce(t *testing.T) {
+	s := newTestServer(t)
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/play", id)
+	listener := "6f1c2a56-1f0e-4c4e-9d61-7d9b7a0e5a11"
+
+	type playResponse struct {
+		PlaysCount int    `json:"plays_count"`
+		Counted    bool   `json:"counted"`
+		ListenerID string `json:"listener_id"`
+	}
+	play := func(token string, body interface{}) playResponse {
+		t.Helper()
+		var res playResponse
+		s.callJSON(t, http.MethodPost, path, token, body, http.StatusOK, &res)
+		return res
+	}
+
+	// 同じ listener_id からの繰り返しの再生は1回だけ数える
+	if res := play("", map[string]string{"listener_id": listener}); !res.Counted || res.ListenerID != listener {
+		t.Errorf("first play: %+v, want counted with the same listener_id", res)
+	}
+	if res := play("", map[string]string{"listener_id": listener}); res.Counted || res.PlaysCount != 1 {
+		t.Errorf("repeated play: %+v, want not counted", res)
+	}
+	// listener_id を変えても同じIPアドレスからは数えない
+	if res := play("", map[string]string{"listener_id": "0b5d3f1e-8a47-4c2b-a1f9-2d6e7c8b9a01"}); res.Counted {
+		t.Errorf("play with a new listener_id from the same IP was counted")
+	}
+	// ログインしているユーザーは UID で判定し、listener_id は返さない
+	if res := play(s.addUser("bob"), nil); !res.Counted || res.ListenerID != "" || res.PlaysCount != 2 {
+		t.Errorf("authenticated play: %+v, want counted without listener_id", res)
+	}
+
+	s.callJSON(t, http.MethodPost, path, "", map[string]string{"listener_id": "not-a-uuid"}, http.StatusBadRequest, nil)
+
+	// listener_id を省略すると新しく発行される
+	other := insertTrack(t, "alice", "Other")
+	var res playResponse
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/play", other), "", nil, http.StatusOK, &res)
+	if _, err := uuid.Parse(res.ListenerID); err != nil || !res.Counted {
+		t.Errorf("play without listener_id: %+v, want counted with a generated UUID", res)
+	}
+}
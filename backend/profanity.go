@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// 不適切な語句を見つけたときの扱い
+const (
+	profanityModeStrict = "strict" // 投稿自体を拒否する
+	profanityModeMask   = "mask"   // 該当部分を * に置き換えて受け付ける
+)
+
+// profanityRejectedMessage は strict モードで投稿を拒否したときのメッセージ
+const profanityRejectedMessage = "Your text contains language that is not allowed."
+
+// ProfanityFilter はタイトル・コメント・表示名に含まれる不適切な語句を検出する
+// nil の場合は何もしない (PROFANITY_LIST が未設定のとき)
+type ProfanityFilter struct {
+	pattern *regexp.Regexp
+	strict  bool
+}
+
+// loadProfanityFilter は1行1語の単語リストを読み込む (空行と # で始まる行は無視)
+// 英数字のみの語は単語単位で、それ以外 (日本語など) は部分一致で判定する
+func loadProfanityFilter(path, mode string) (*ProfanityFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var alternatives []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		quoted := regexp.QuoteMeta(word)
+		if isASCIIWord(word) {
+			quoted = `\b` + quoted + `\b`
+		}
+		alternatives = append(alternatives, quoted)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+
+	pattern, err := regexp.Compile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+	if err != nil {
+		return nil, err
+	}
+	return &ProfanityFilter{pattern: pattern, strict: mode == profanityModeStrict}, nil
+}
+
+func isASCIIWord(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter は text を検査し、使用する文字列と受け付けてよいかを返す
+// strict モードで不適切な語句を含む場合は ok=false、mask モードでは該当部分を伏せた文字列を返す
+func (p *ProfanityFilter) Filter(text string) (string, bool) {
+	if p == nil || !p.pattern.MatchString(text) {
+		return text, true
+	}
+	if p.strict {
+		return text, false
+	}
+	return p.pattern.ReplaceAllStringFunc(text, func(m string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(m))
+	}), true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeProfanityList はテスト用の単語リストを書き出してそのパスを返す
+func writeProfanityList(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "profanity.txt")
+	list := "# sample list\n\ndarn\nheck\n糞\n"
+	if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProfanityFilter(t *testing.T) {
+	path := writeProfanityList(t)
+	tests := []struct {
+		mode, in, want string
+		ok             bool
+	}{
+		{profanityModeMask, "well DARN it", "well **** it", true},
+		{profanityModeMask, "darning socks", "darning socks", true}, // 英数字の語は単語単位
+		{profanityModeMask, "これは糞だ", "これは*だ", true},                 // それ以外は部分一致
+		{profanityModeMask, "clean", "clean", true},
+		{profanityModeStrict, "oh heck", "oh heck", false},
+		{profanityModeStrict, "clean", "clean", true},
+	}
+	for _, tt := range tests {
+		filter, err := loadProfanityFilter(path, tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := filter.Filter(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s Filter(%q) = %q, %v; want %q, %v", tt.mode, tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// 未設定 (nil) なら何もしない
+	var none *ProfanityFilter
+	if got, ok := none.Filter("darn"); got != "darn" || !ok {
+		t.Errorf("nil Filter = %q, %v", got, ok)
+	}
+}
+
+func TestProfanityFilterComments(t *testing.T) {
+	list := writeProfanityList(t)
+
+	t.Run("strict", func(t *testing.T) {
+		s := newTestServer(t, "PROFANITY_LIST="+list, "PROFANITY_MODE=strict")
+		token := s.addUser("bob")
+		id := insertTrack(t, "alice", "Song")
+		var res struct {
+			Message string `json:"message"`
+		}
+		s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/comment", id), token, map[string]string{"content": "darn"}, http.StatusBadRequest, &res)
+		if res.Message != profanityRejectedMessage {
+			t.Errorf("message = %q, want %q", res.Message, profanityRejectedMessage)
+		}
+		if n := queryInt(t, "SELECT COUNT(*) FROM comments"); n != 0 {
+			t.Errorf("comments = %d, want 0", n)
+		}
+	})
+
+	t.Run("mask", func(t *testing.T) {
+		s := newTestServer(t, "PROFANITY_LIST="+list, "PROFANITY_MODE=mask")
+		token := s.addUser("bob")
+		id := insertTrack(t, "alice", "Song")
+		s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/comment", id), token, map[string]string{"content": "darn good"}, http.StatusOK, nil)
+		var content string
+		if err := db.QueryRow("SELECT content FROM comments").Scan(&content); err != nil {
+			t.Fatal(err)
+		}
+		if content != "**** good" {
+			t.Errorf("stored content = %q, want masked", content)
+		}
+	})
+}
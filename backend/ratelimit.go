@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// rateLimitVisitor はクライアント (IP) ごとのトークンバケット
+type rateLimitVisitor struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// headerRateLimiter は echo の RateLimiter と同じトークンバケット方式のレートリミットに加えて、
+// 残りのリクエスト数をクライアントが把握できるよう X-RateLimit-* ヘッダーを返す
+type headerRateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // 1秒あたりに回復するトークン数
+	burst    int     // バケットの容量 (X-RateLimit-Limit)
+	expires  time.Duration
+	visitors map[string]*rateLimitVisitor
+	lastGC   time.Time
+}
+
+func newHeaderRateLimiter(ratePerSecond float64, burst int) *headerRateLimiter {
+	return &headerRateLimiter{
+		rate:     ratePerSecond,
+		burst:    burst,
+		expires:  3 * time.Minute,
+		visitors: make(map[string]*rateLimitVisitor),
+		lastGC:   time.Now(),
+	}
+}
+
+// take はトークンを1つ消費し、許可されたかと消費後の残りトークン数を返す
+func (l *headerRateLimiter) take(identifier string, now time.Time) (bool, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// しばらくアクセスのないクライアントは破棄してメモリを解放する
+	if now.Sub(l.lastGC) > l.expires {
+		for id, v := range l.visitors {
+			if now.Sub(v.lastSeen) > l.expires {
+				delete(l.visitors, id)
+			}
+		}
+		l.lastGC = now
+	}
+
+	v, ok := l.visitors[identifier]
+	if !ok {
+		v = &rateLimitVisitor{tokens: float64(l.burst), lastSeen: now}
+		l.visitors[identifier] = v
+	}
+	v.tokens = math.Min(float64(l.burst), v.tokens+now.Sub(v.lastSeen).Seconds()*l.rate)
+	v.lastSeen = now
+
+	if v.tokens < 1 {
+		return false, v.tokens
+	}
+	v.tokens--
+	return true, v.tokens
+}
+
+// limit は identifier のトークンを1つ消費してレートリミットを適用し、全てのレスポンスに以下のヘッダーを付ける
+//   - X-RateLimit-Limit: 連続して送れるリクエスト数
+//   - X-RateLimit-Remaining: 現在送れる残りのリクエスト数
+//   - X-RateLimit-Reset: 上限まで回復するまでの秒数
+//
+// 上限を超えた場合は 429 と、次のリクエストが送れるまでの秒数を Retry-After で返す
+func (l *headerRateLimiter) limit(c echo.Context, identifier string, next echo.HandlerFunc) error {
+	allowed, tokens := l.take(identifier, time.Now())
+
+	h := c.Response().Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(l.burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(l.burst)-tokens)/l.rate))))
+
+	if !allowed {
+		retryAfter := int(math.Ceil((1 - tokens) / l.rate))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		h.Set("Retry-After", strconv.Itoa(retryAfter))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	return next(c)
+}
+
+// splitRateLimitMiddleware は認証済みのリクエストをユーザー (UID) ごとに authenticated で、
+// それ以外 (未ログイン・無効なトークン) をIPごとに anonymous で制限する
+// userUID は認証済みならUIDを、そうでなければ空文字を返す
+func splitRateLimitMiddleware(anonymous, authenticated *headerRateLimiter, userUID func(echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if uid := userUID(c); uid != "" {
+				return authenticated.limit(c, uid, next)
+			}
+			return anonymous.limit(c, c.RealIP(), next)
+		}
+	}
+}
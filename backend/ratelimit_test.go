@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHeaderRateLimiterTake(t *testing.T) {
+	l := newHeaderRateLimiter(2, 2)
+	now := time.Now()
+	for i, want := range []float64{1, 0} {
+		ok, tokens := l.take("a", now)
+		if !ok || tokens != want {
+			t.Fatalf("take #%d = %v, %v; want true, %v", i+1, ok, tokens, want)
+		}
+	}
+	if ok, _ := l.take("a", now); ok {
+		t.Fatal("take allowed beyond burst")
+	}
+	// 別のクライアントは別のバケット
+	if ok, _ := l.take("b", now); !ok {
+		t.Fatal("other identifier was limited")
+	}
+	// 0.5秒で1トークン回復する
+	if ok, tokens := l.take("a", now.Add(500*time.Millisecond)); !ok || tokens != 0 {
+		t.Fatalf("take after refill = %v, %v; want true, 0", ok, tokens)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	s := newTestServer(t, "RATE_LIMIT_ANONYMOUS_PER_SECOND=3")
+	for _, want := range []int{2, 1, 0} {
+		resp, _ := s.call(t, http.MethodGet, "/", "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("X-RateLimit-Limit = %q, want 3", got)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != strconv.Itoa(want) {
+			t.Errorf("X-RateLimit-Remaining = %q, want %d", got, want)
+		}
+		if reset, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Reset")); err != nil || reset < 1 {
+			t.Errorf("X-RateLimit-Reset = %q", resp.Header.Get("X-RateLimit-Reset"))
+		}
+	}
+
+	resp, _ := s.call(t, http.MethodGet, "/", "", nil)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining on 429 = %q, want 0", got)
+	}
+
+	// ログイン済みのリクエストはユーザーごとの別の上限で数える
+	token := s.addUser("alice")
+	resp, _ = s.call(t, http.MethodGet, "/api/me", token, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Limit") != "10000" {
+		t.Errorf("authenticated request: %d, limit %q", resp.StatusCode, resp.Header.Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestAnonymousRequestsThrottleSooner(t *testing.T) {
+	s := newTestServer(t, "RATE_LIMIT_ANONYMOUS_PER_SECOND=2", "RATE_LIMIT_AUTHENTICATED_PER_SECOND=5")
+	token := s.addUser("alice")
+	// allowed は連続して何件目まで受け付けられるかを返す
+	allowed := func(token string) int {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			resp, _ := s.call(t, http.MethodGet, "/api/tracks", token, nil)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				return i
+			}
+		}
+		return 10
+	}
+
+	if n := allowed(""); n != 2 {
+		t.Errorf("anonymous requests allowed = %d, want 2", n)
+	}
+	// ログインしていれば同じIPからでもユーザーごとの上限になる
+	if n := allowed(token); n != 5 {
+		t.Errorf("authenticated requests allowed = %d, want 5", n)
+	}
+	// 無効なトークンは未ログインとして数える (使い切ったIPの枠が適用される)
+	if n := allowed("not-a-token"); n != 0 {
+		t.Errorf("requests with an invalid token allowed = %d, want 0", n)
+	}
+}
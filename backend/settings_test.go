@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSettingsUpdatedAt(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+
+	var settings struct {
+		EmailNotifications bool    `json:"email_notifications"`
+		UpdatedAt          *string `json:"updated_at"`
+	}
+	// 一度も保存していなければ null
+	s.callJSON(t, http.MethodGet, "/api/settings", token, nil, http.StatusOK, &settings)
+	if !settings.EmailNotifications || settings.UpdatedAt != nil {
+		t.Errorf("default settings = %+v, want notifications on and updated_at null", settings)
+	}
+
+	before := time.Now().UTC().Truncate(time.Second)
+	s.callJSON(t, http.MethodPost, "/api/settings", token, map[string]bool{"email_notifications": false}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodGet, "/api/settings", token, nil, http.StatusOK, &settings)
+	if settings.EmailNotifications || settings.UpdatedAt == nil {
+		t.Fatalf("saved settings = %+v", settings)
+	}
+	updatedAt, err := time.Parse(time.RFC3339, *settings.UpdatedAt)
+	if err != nil || !strings.HasSuffix(*settings.UpdatedAt, "Z") {
+		t.Fatalf("updated_at = %q (%v), want RFC3339 in UTC", *settings.UpdatedAt, err)
+	}
+	if updatedAt.Before(before) || updatedAt.After(time.Now().Add(time.Second)) {
+		t.Errorf("updated_at = %s, want the time of the update (%s)", updatedAt, before)
+	}
+}
+
+func TestMe(t *testing.T) {
+	s := newTestServer(t)
+	token := s.auth.addUser(fakeAuthUser{UID: "bob", DisplayName: "Bob", Email: "bob@example.com"})
+
+	type me struct {
+		UID           string `json:"uid"`
+		DisplayName   string `json:"display_name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Settings      struct {
+			EmailNotifications bool `json:"email_notifications"`
+			PinnedTrackID      *int `json:"pinned_track_id"`
+		} `json:"settings"`
+	}
+	var got me
+	// 設定が未保存ならデフォルト値
+	s.callJSON(t, http.MethodGet, "/api/me", token, nil, http.StatusOK, &got)
+	if got.UID != "bob" || got.DisplayName != "Bob" || got.Email != "bob@example.com" || got.EmailVerified ||
+		!got.Settings.EmailNotifications || got.Settings.PinnedTrackID != nil {
+		t.Errorf("/api/me for a new user = %+v", got)
+	}
+
+	id := insertTrack(t, "bob", "Song")
+	s.callJSON(t, http.MethodPost, "/api/settings", token, map[string]bool{"email_notifications": false}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/pin", id), token, nil, http.StatusOK, nil)
+	token = s.auth.addUser(fakeAuthUser{UID: "bob", DisplayName: "Bob", Email: "bob@example.com", EmailVerified: true})
+	got = me{}
+	s.callJSON(t, http.MethodGet, "/api/me", token, nil, http.StatusOK, &got)
+	if !got.EmailVerified || got.Settings.EmailNotifications || got.Settings.PinnedTrackID == nil || *got.Settings.PinnedTrackID != id {
+		t.Errorf("/api/me after saving settings = %+v", got)
+	}
+}
+
+func TestDisplayNameAvailability(t *testing.T) {
+	s := newTestServer(t, "PROFANITY_LIST="+writeProfanityList(t), "PROFANITY_MODE=mask")
+	token := s.addUser("bob")
+	// 表示名はトラックを公開したときの名前で使用中とみなす
+	insertTrack(t, "alice", "Song")
+	insertTrack(t, "bob", "Mine")
+
+	type availability struct {
+		Available bool   `json:"available"`
+		Name      string `json:"name"`
+		Reason    string `json:"reason"`
+	}
+	check := func(name string) availability {
+		t.Helper()
+		var a availability
+		s.callJSON(t, http.MethodGet, "/api/profile/name-available?name="+url.QueryEscape(name), token, nil, http.StatusOK, &a)
+		return a
+	}
+
+	for name, want := range map[string]availability{
+		"Fresh Name":   {Available: true, Name: "Fresh Name"},
+		"  Padded  ":   {Available: true, Name: "Padded"},
+		"User bob":     {Available: true, Name: "User bob"}, // 自分の現在の名前
+		"darn fine":    {Available: true, Name: "**** fine"},
+		"  User alice": {Available: false, Name: "User alice", Reason: "Display name 'User alice' is already taken."},
+	} {
+		if got := check(name); got != want {
+			t.Errorf("name-available(%q) = %+v, want %+v", name, got, want)
+		}
+	}
+	for _, name := range []string{"", "   ", strings.Repeat("あ", limits.DisplayName+1)} {
+		if got := check(name); got.Available || got.Reason == "" {
+			t.Errorf("name-available(%q) = %+v, want unavailable with a reason", name, got)
+		}
+	}
+	s.callJSON(t, http.MethodGet, "/api/profile/name-available?name=x", "", nil, http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// mediaSigner は音声ファイルのURL (ストリーミングAPIと /uploads) に付ける期限付きの署名を作成・検証する
+// 署名は URL のパスと有効期限に対する HMAC-SHA256 で、?expires=<unix秒>&sig=<署名> として付ける
+// (他サイトからの直リンクで帯域を消費されるのを防ぐため、REQUIRE_SIGNED_MEDIA=true のとき署名を必須にする)
+type mediaSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+// newMediaSigner は secret を鍵にした mediaSigner を作る
+// secret が空の場合はランダムな鍵を使う (再起動すると発行済みのURLは無効になり、複数台構成では台ごとに鍵が異なる)
+func newMediaSigner(secret string, ttl time.Duration) (*mediaSigner, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &mediaSigner{key: key, ttl: ttl}, nil
+}
+
+func (s *mediaSigner) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signURL は path に署名を付けた URL と、その有効期限を返す
+func (s *mediaSigner) signURL(path string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(s.ttl).Truncate(time.Second)
+	expires := expiresAt.Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", s.signature(path, expires))
+	return path + "?" + q.Encode(), expiresAt
+}
+
+// valid は path に付けられた expires と sig が正しく、期限が切れていないかを返す
+func (s *mediaSigner) valid(path, expires, sig string, now time.Time) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.signature(path, exp)))
+}
+
+// middleware は署名のない、または期限切れのリクエストを 403 で拒否する
+func (s *mediaSigner) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !s.valid(c.Request().URL.Path, c.QueryParam("expires"), c.QueryParam("sig"), time.Now()) {
+				return echo.NewHTTPError(http.StatusForbidden, "Invalid or expired media URL")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaSigner(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer, err := newMediaSigner("secret", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, expiresAt := signer.signURL("/uploads/a.mp3", now)
+	if !expiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("expires at %s, want %s", expiresAt, now.Add(time.Minute))
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires, sig := u.Query().Get("expires"), u.Query().Get("sig")
+
+	if !signer.valid("/uploads/a.mp3", expires, sig, now.Add(time.Minute)) {
+		t.Error("signed URL is not valid until it expires")
+	}
+	other, _ := newMediaSigner("other secret", time.Minute)
+	for name, ok := range map[string]bool{
+		"after expiry":      signer.valid("/uploads/a.mp3", expires, sig, now.Add(time.Minute+time.Second)),
+		"another path":      signer.valid("/uploads/b.mp3", expires, sig, now),
+		"extended expiry":   signer.valid("/uploads/a.mp3", fmt.Sprint(now.Add(time.Hour).Unix()), sig, now),
+		"another key":       other.valid("/uploads/a.mp3", expires, sig, now),
+		"missing expiry":    signer.valid("/uploads/a.mp3", "", sig, now),
+		"missing signature": signer.valid("/uploads/a.mp3", expires, "", now),
+	} {
+		if ok {
+			t.Errorf("%s: signature accepted", name)
+		}
+	}
+
+	// 鍵を指定しなければランダムな鍵を使う
+	a, _ := newMediaSigner("", time.Minute)
+	b, _ := newMediaSigner("", time.Minute)
+	if a.signature("/x", 1) == b.signature("/x", 1) {
+		t.Error("signers without a secret share a key")
+	}
+}
+
+func TestSignedMediaURLs(t *testing.T) {
+	s := newTestServer(t, "REQUIRE_SIGNED_MEDIA=true", "MEDIA_SIGNING_SECRET=secret")
+	id := s.insertTrackWithAudio(t, "alice", "Song", testMP3(3*time.Second))
+	streamPath := fmt.Sprintf("/api/track/%d/stream", id)
+	status := func(path string) int {
+		t.Helper()
+		resp, _ := s.call(t, http.MethodGet, path, "", nil)
+		return resp.StatusCode
+	}
+
+	var urls struct {
+		StreamURL string    `json:"stream_url"`
+		FileURL   string    `json:"file_url"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Required  bool      `json:"required"`
+	}
+	resp, body := s.call(t, http.MethodGet, fmt.Sprintf("/api/track/%d/stream-url", id), "", nil)
+	if err := json.Unmarshal(body, &urls); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream-url: status %d, %s", resp.StatusCode, body)
+	}
+	// 期限付きのURLのため、共有キャッシュには保存させない
+	if resp.Header.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("stream-url Cache-Control = %q", resp.Header.Get("Cache-Control"))
+	}
+	if !urls.Required || !strings.HasPrefix(urls.StreamURL, streamPath+"?") || !strings.HasPrefix(urls.FileURL, "/uploads/") {
+		t.Fatalf("stream-url = %+v", urls)
+	}
+	if d := time.Until(urls.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expires_at = %s, want about an hour from now", urls.ExpiresAt)
+	}
+
+	// 署名付きURLなら取得でき、署名がない・改ざんされたURLは 403
+	for _, path := range []string{urls.StreamURL, urls.FileURL} {
+		if got := status(path); got != http.StatusOK {
+			t.Errorf("GET %s: status %d, want 200", path, got)
+		}
+		unsigned, _, _ := strings.Cut(path, "?")
+		if got := status(unsigned); got != http.StatusForbidden {
+			t.Errorf("GET %s without a signature: status %d, want 403", unsigned, got)
+		}
+		if got := status(strings.Replace(path, "sig=", "sig=x", 1)); got != http.StatusForbidden {
+			t.Errorf("GET %s with a tampered signature: status %d, want 403", unsigned, got)
+		}
+	}
+	// 別のトラックの署名は使えない
+	other := s.insertTrackWithAudio(t, "alice", "Other", testMP3(3*time.Second))
+	_, query, _ := strings.Cut(urls.StreamURL, "?")
+	if got := status(fmt.Sprintf("/api/track/%d/stream?%s", other, query)); got != http.StatusForbidden {
+		t.Errorf("signature reused for another track: status %d, want 403", got)
+	}
+	// 試聴は署名なしで聞ける
+	if got := status(fmt.Sprintf("/api/track/%d/preview", id)); got != http.StatusOK {
+		t.Errorf("GET preview: status %d, want 200", got)
+	}
+	s.callJSON(t, http.MethodGet, "/api/track/999999/stream-url", "", nil, http.StatusNotFound, nil)
+}
+
+func TestSignedMediaIsOptional(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertTrackWithAudio(t, "alice", "Song", testMP3(time.Second))
+	if resp, _ := s.call(t, http.MethodGet, fmt.Sprintf("/api/track/%d/stream", id), "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("unsigned stream with signing disabled: status %d, want 200", resp.StatusCode)
+	}
+	var urls struct {
+		StreamURL string `json:"stream_url"`
+		Required  bool   `json:"required"`
+	}
+	s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d/stream-url", id), "", nil, http.StatusOK, &urls)
+	if urls.Required {
+		t.Error("stream-url reports signing as required although it is disabled")
+	}
+	// 無効な場合も、発行したURLはそのまま使える
+	if resp, _ := s.call(t, http.MethodGet, urls.StreamURL, "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("signed stream with signing disabled: status %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// sitemapMaxURLs は1つのサイトマップに含められるURLの上限 (sitemaps.org の仕様)
+const sitemapMaxURLs = 50000
+
+const sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapURLSet は <urlset> 形式のサイトマップ
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapIndex は件数が上限を超えた場合に返す <sitemapindex> 形式のサイトマップ
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	XMLNS    string         `xml:"xmlns,attr"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc string `xml:"loc"`
+}
+
+// buildTrackSitemap は offset 件目から最大 sitemapMaxURLs 件のトラックページを <urlset> にまとめる
+func buildTrackSitemap(frontendURL string, offset int) ([]byte, error) {
+	rows, err := db.Query("SELECT id, created_at FROM tracks ORDER BY id LIMIT ? OFFSET ?", sitemapMaxURLs, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	set := sitemapURLSet{XMLNS: sitemapXMLNS, URLs: make([]sitemapURL, 0)}
+	for rows.Next() {
+		var id int
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return nil, err
+		}
+		set.URLs = append(set.URLs, sitemapURL{Loc: trackPageURL(frontendURL, id), LastMod: createdAt.UTC().Format("2006-01-02")})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return marshalSitemap(set)
+}
+
+// buildSitemapIndex は分割したサイトマップ (baseURL/sitemap/1.xml ...) の一覧を返す
+func buildSitemapIndex(baseURL string, pages int) ([]byte, error) {
+	index := sitemapIndex{XMLNS: sitemapXMLNS}
+	for i := 1; i <= pages; i++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapEntry{Loc: fmt.Sprintf("%s/sitemap/%d.xml", baseURL, i)})
+	}
+	return marshalSitemap(index)
+}
+
+func marshalSitemap(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
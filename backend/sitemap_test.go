@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSitemap(t *testing.T) {
+	s := newTestServer(t, "FRONTEND_URL=https://soundlike.example")
+	first := insertTrack(t, "alice", "First")
+	second := insertTrack(t, "bob", "Second")
+	mustExec(t, "UPDATE tracks SET created_at = '2026-03-04 23:30:00' WHERE id = ?", first)
+	mustExec(t, "UPDATE tracks SET created_at = '2026-05-06 01:00:00' WHERE id = ?", second)
+
+	resp, body := s.call(t, http.MethodGet, "/sitemap.xml", "", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/xml; charset=utf-8" {
+		t.Fatalf("GET /sitemap.xml: status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var set sitemapURLSet
+	if err := xml.Unmarshal(body, &set); err != nil {
+		t.Fatalf("sitemap is not valid XML: %v\n%s", err, body)
+	}
+	want := []sitemapURL{
+		{Loc: "https://soundlike.example/track/1", LastMod: "2026-03-04"},
+		{Loc: "https://soundlike.example/track/2", LastMod: "2026-05-06"},
+	}
+	if set.XMLNS != sitemapXMLNS || !reflect.DeepEqual(set.URLs, want) {
+		t.Errorf("sitemap = %+v, want %+v", set, want)
+	}
+}
+
+func TestSitemapIndex(t *testing.T) {
+	s := newTestServer(t, "FRONTEND_URL=https://soundlike.example")
+	// 上限を1件だけ超えるとインデックスに切り替わる
+	mustExec(t, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i <= ?)
+		INSERT INTO tracks (filename, title, uploader_uid) SELECT i || '.mp3', 'Track ' || i, 'alice' FROM n`, sitemapMaxURLs)
+
+	resp, body := s.call(t, http.MethodGet, "/sitemap.xml", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /sitemap.xml: status %d", resp.StatusCode)
+	}
+	var index sitemapIndex
+	if err := xml.Unmarshal(body, &index); err != nil {
+		t.Fatalf("sitemap index is not valid XML: %v\n%s", err, body)
+	}
+	if len(index.Sitemaps) != 2 || index.Sitemaps[1].Loc != s.URL+"/sitemap/2.xml" {
+		t.Fatalf("sitemap index = %+v", index)
+	}
+
+	_, body = s.call(t, http.MethodGet, "/sitemap/2.xml", "", nil)
+	var set sitemapURLSet
+	if err := xml.Unmarshal(body, &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.URLs) != 1 || set.URLs[0].Loc != "https://soundlike.example/track/50001" {
+		t.Errorf("second sitemap page = %+v", set.URLs)
+	}
+	for _, page := range []string{"0.xml", "abc"} {
+		if resp, _ := s.call(t, http.MethodGet, "/sitemap/"+page, "", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET /sitemap/%s: status %d, want 404", page, resp.StatusCode)
+		}
+	}
+}
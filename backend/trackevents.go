@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxTrackEventsDays はイベントのCSVエクスポートで一度に指定できる期間の上限 (日数)
+const maxTrackEventsDays = 90
+
+// trackEventsCSVHeader はイベントのCSVエクスポートの列
+// signed_in はログインしたユーザーによるイベントかどうか (個人を特定できないよう、ユーザーIDは出力しない)
+var trackEventsCSVHeader = []string{"timestamp", "event", "signed_in"}
+
+// trackEventsFlushRows は何行ごとにレスポンスをフラッシュするか
+const trackEventsFlushRows = 500
+
+// isStreamedExport は GET /api/track/:id/events.csv のように、Flush しながら書き出すエクスポートのパスかを判定する
+// レスポンスをバッファーするミドルウェア (タイムアウト・gzip) は、これらのパスには適用しない
+func isStreamedExport(path string) bool {
+	return strings.HasPrefix(path, "/api/track/") && strings.HasSuffix(path, "/events.csv")
+}
+
+// writeTrackEventsCSV はトラックの再生・いいね・コメントを時刻順に CSV で w に書き出す
+// from / to は YYYY-MM-DD (UTC、両端を含む)。flush は trackEventsFlushRows 行ごとに呼ぶ
+func writeTrackEventsCSV(w io.Writer, flush func(), trackID int, from, to string) error {
+	rows, err := db.Query(`
+		SELECT strftime('%Y-%m-%dT%H:%M:%SZ', created_at) AS ts, event, signed_in FROM (
+			SELECT created_at, 'play' AS event, user_uid IS NOT NULL AS signed_in, id FROM plays
+			WHERE track_id = ? AND date(created_at) BETWEEN ? AND ?
+			UNION ALL
+			SELECT created_at, 'like', 1, id FROM likes
+			WHERE track_id = ? AND date(created_at) BETWEEN ? AND ?
+			UNION ALL
+			SELECT created_at, 'comment', 1, id FROM comments
+			WHERE track_id = ? AND date(created_at) BETWEEN ? AND ?
+		)
+		ORDER BY created_at, event, id`,
+		trackID, from, to, trackID, from, to, trackID, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(trackEventsCSVHeader); err != nil {
+		return err
+	}
+	n := 0
+	for rows.Next() {
+		var ts, event string
+		var signedIn bool
+		if err := rows.Scan(&ts, &event, &signedIn); err != nil {
+			return err
+		}
+		if err := cw.Write([]string{ts, event, strconv.FormatBool(signedIn)}); err != nil {
+			return err
+		}
+		if n++; n%trackEventsFlushRows == 0 {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
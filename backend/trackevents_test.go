@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackEventsCSV(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.addUser("alice")
+	other := s.addUser("bob")
+	admin := s.addAdmin("root")
+	id := insertTrack(t, "alice", "Song")
+
+	// trackEventsFlushRows を超える件数にして、途中で Flush しても最後まで書き出せることを確認する
+	const plays = trackEventsFlushRows + 100
+	for i := 0; i < plays; i++ {
+		uid := interface{}(nil)
+		if i%2 == 0 {
+			uid = "bob"
+		}
+		if _, err := db.Exec("INSERT INTO plays (track_id, user_uid) VALUES (?, ?)", id, uid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", id); err != nil {
+		t.Fatal(err)
+	}
+	insertComment(t, id, "bob", "nice")
+
+	path := fmt.Sprintf("/api/track/%d/events.csv", id)
+	req := s.newRequest(t, http.MethodGet, path, owner, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, body := s.do(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("owner export: status %d (%s)", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q, want none", enc)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV: %v", err)
+	}
+	if strings.Join(records[0], ",") != "timestamp,event,signed_in" {
+		t.Errorf("header = %v", records[0])
+	}
+	if got := len(records) - 1; got != plays+2 {
+		t.Fatalf("got %d rows, want %d", got, plays+2)
+	}
+	events := map[string]int{}
+	for _, r := range records[1:] {
+		if len(r) != 3 || !strings.HasSuffix(r[0], "Z") || (r[2] != "true" && r[2] != "false") {
+			t.Fatalf("unexpected row %v", r)
+		}
+		events[r[1]]++
+	}
+	if events["play"] != plays || events["like"] != 1 || events["comment"] != 1 {
+		t.Errorf("event counts = %v", events)
+	}
+
+	s.callJSON(t, http.MethodGet, path, admin, nil, http.StatusOK, nil)
+	s.callJSON(t, http.MethodGet, path, other, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodGet, "/api/track/9999/events.csv", owner, nil, http.StatusNotFound, nil)
+	s.callJSON(t, http.MethodGet, path+"?from=2024-01-01&to=2024-12-31", owner, nil, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodGet, path+"?from=2024-02-01&to=2024-01-01", owner, nil, http.StatusBadRequest, nil)
+}
+
+func TestLikesTimelineZeroFill(t *testing.T) {
+	s := newTestServer(t)
+	owner, bob := s.addUser("alice"), s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	day := func(daysAgo int) string { return today.AddDate(0, 0, -daysAgo).Format("2006-01-02") }
+
+	for i, like := range []struct {
+		daysAgo int
+		uid     string
+	}{{0, "bob"}, {0, "carol"}, {2, "dave"}, {6, "erin"}, {7, "frank"}} {
+		mustExec(t, "INSERT INTO likes (user_uid, track_id, created_at) VALUES (?, ?, ?)",
+			like.uid, id, fmt.Sprintf("%s %02d:00:00", day(like.daysAgo), i+1))
+	}
+	// 別のトラックのいいねと、アップロード者自身のいいねは数えない
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", insertTrack(t, "alice", "Other"))
+	mustExec(t, "INSERT INTO likes (user_uid, track_id, created_at) VALUES ('alice', ?, ?)", id, day(0)+" 09:00:00")
+
+	var res struct {
+		From     string       `json:"from"`
+		To       string       `json:"to"`
+		Timeline []DailyCount `json:"timeline"`
+		Total    int          `json:"total"`
+	}
+	path := fmt.Sprintf("/api/track/%d/likes/timeline", id)
+	s.callJSON(t, http.MethodGet, path+"?days=7", owner, nil, http.StatusOK, &res)
+	want := []DailyCount{
+		{day(6), 1}, {day(5), 0}, {day(4), 0}, {day(3), 0}, {day(2), 1}, {day(1), 0}, {day(0), 2},
+	}
+	if fmt.Sprint(res.Timeline) != fmt.Sprint(want) || res.Total != 4 || res.From != day(6) || res.To != day(0) {
+		t.Errorf("timeline = %+v", res)
+	}
+
+	s.callJSON(t, http.MethodGet, path, owner, nil, http.StatusOK, &res)
+	if len(res.Timeline) != 30 || res.Total != 5 {
+		t.Errorf("default timeline: %d days, total %d; want 30 days, total 5", len(res.Timeline), res.Total)
+	}
+
+	for _, days := range []string{"0", "366", "abc"} {
+		s.callJSON(t, http.MethodGet, path+"?days="+days, owner, nil, http.StatusBadRequest, nil)
+	}
+	s.callJSON(t, http.MethodGet, path, bob, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodGet, "/api/track/999999/likes/timeline", owner, nil, http.StatusNotFound, nil)
+
+	// ALLOW_SELF_LIKE が有効な場合は、いいね数と同じく自分のいいねも数える
+	s = newTestServer(t, "ALLOW_SELF_LIKE=true")
+	owner = s.addUser("alice")
+	id = insertTrack(t, "alice", "Song")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('alice', ?), ('bob', ?)", id, id)
+	s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d/likes/timeline?days=1", id), owner, nil, http.StatusOK, &res)
+	if res.Total != 2 {
+		t.Errorf("timeline total with ALLOW_SELF_LIKE = %d, want 2", res.Total)
+	}
+}
+
+func TestTrackDailyStats(t *testing.T) {
+	s := newTestServer(t)
+	owner, bob := s.addUser("alice"), s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	day := func(daysAgo int) string { return today.AddDate(0, 0, -daysAgo).Format("2006-01-02") }
+	at := func(daysAgo int) string { return day(daysAgo) + " 00:30:00" }
+
+	mustExec(t, "INSERT INTO plays (track_id, user_uid, created_at) VALUES (?, 'bob', ?), (?, NULL, ?), (?, 'bob', ?)",
+		id, at(0), id, at(0), id, at(29))
+	mustExec(t, "INSERT INTO likes (user_uid, track_id, created_at) VALUES ('bob', ?, ?)", id, at(3))
+	// アップロード者自身のいいねは、いいね数と同じく数えない
+	mustExec(t, "INSERT INTO likes (user_uid, track_id, created_at) VALUES ('alice', ?, ?)", id, at(3))
+	mustExec(t, "INSERT INTO comments (track_id, user_uid, user_name, content, created_at) VALUES (?, 'bob', 'User bob', 'nice', ?)", id, at(0))
+	// 30日より前のものは含めない
+	mustExec(t, "INSERT INTO plays (track_id, user_uid, created_at) VALUES (?, 'bob', ?)", id, at(30))
+
+	var res struct {
+		From   string          `json:"from"`
+		To     string          `json:"to"`
+		Days   []TrackDayStats `json:"days"`
+		Totals map[string]int  `json:"totals"`
+	}
+	path := fmt.Sprintf("/api/track/%d/stats", id)
+	s.callJSON(t, http.MethodGet, path, owner, nil, http.StatusOK, &res)
+	if len(res.Days) != 30 || res.From != day(29) || res.To != day(0) {
+		t.Fatalf("stats cover %d days from %s to %s, want 30 days from %s to %s", len(res.Days), res.From, res.To, day(29), day(0))
+	}
+	for i, want := range map[int]TrackDayStats{
+		0:  {Date: day(29), Plays: 1},
+		1:  {Date: day(28)},
+		26: {Date: day(3), Likes: 1},
+		29: {Date: day(0), Plays: 2, Comments: 1},
+	} {
+		if res.Days[i] != want {
+			t.Errorf("days[%d] = %+v, want %+v", i, res.Days[i], want)
+		}
+	}
+	if res.Totals["plays"] != 3 || res.Totals["likes"] != 1 || res.Totals["comments"] != 1 {
+		t.Errorf("totals = %v", res.Totals)
+	}
+
+	s.callJSON(t, http.MethodGet, path, bob, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodGet, path, "", nil, http.StatusUnauthorized, nil)
+	s.callJSON(t, http.MethodGet, "/api/track/999999/stats", owner, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TrackRevision はトラックの内容 (タイトル・アーティスト・歌詞) の編集履歴の1件
+// 削除されたフィールドの値は null になる
+type TrackRevision struct {
+	ID        int       `json:"id"`
+	TrackID   int       `json:"track_id"`
+	Field     string    `json:"field"` // title / artist / lyrics / synced_lyrics
+	OldValue  *string   `json:"old_value"`
+	NewValue  *string   `json:"new_value"`
+	EditorUID string    `json:"editor_uid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// trackRevisionFields は編集履歴を記録するフィールド (tracks のカラム名)
+// コメントの受付設定などの内容以外の設定は記録しない
+var trackRevisionFields = []string{"title", "artist", "lyrics", "synced_lyrics"}
+
+// loadTrackContent はトラックの編集履歴を記録するフィールドの現在の値を返す (空文字は NULL として扱う)
+func loadTrackContent(tx *sql.Tx, trackID int) (map[string]sql.NullString, error) {
+	values := make([]sql.NullString, len(trackRevisionFields))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := tx.QueryRow("SELECT title, artist, lyrics, synced_lyrics FROM tracks WHERE id = ?", trackID).Scan(dest...); err != nil {
+		return nil, err
+	}
+	content := make(map[string]sql.NullString, len(values))
+	for i, field := range trackRevisionFields {
+		content[field] = nullIfEmpty(values[i])
+	}
+	return content, nil
+}
+
+func nullIfEmpty(s sql.NullString) sql.NullString {
+	if s.String == "" {
+		return sql.NullString{}
+	}
+	return s
+}
+
+// recordTrackRevisions は changes (フィールドごとの新しい値) のうち、old から値が変わったものを編集履歴に追加する
+func recordTrackRevisions(tx *sql.Tx, trackID int, editorUID string, old, changes map[string]sql.NullString) error {
+	for _, field := range trackRevisionFields {
+		newValue, ok := changes[field]
+		if !ok {
+			continue
+		}
+		newValue = nullIfEmpty(newValue)
+		if newValue == old[field] {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO track_revisions (track_id, field, old_value, new_value, editor_uid) VALUES (?, ?, ?, ?, ?)",
+			trackID, field, old[field], newValue, editorUID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackLastModified はトラックの内容が最後に変更された日時を返す (編集履歴がなければ createdAt)
+func trackLastModified(trackID int, createdAt time.Time) (time.Time, error) {
+	var editedAt time.Time
+	err := db.QueryRow("SELECT created_at FROM track_revisions WHERE track_id = ? ORDER BY created_at DESC, id DESC LIMIT 1", trackID).Scan(&editedAt)
+	if err == sql.ErrNoRows || (err == nil && editedAt.Before(createdAt)) {
+		return createdAt, nil
+	}
+	return editedAt, err
+}
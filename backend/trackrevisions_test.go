@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestTrackEditHistory(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.addUser("alice")
+	other := s.addUser("bob")
+	id := insertTrack(t, "alice", "First")
+	path := fmt.Sprintf("/api/track/%d", id)
+
+	s.callJSON(t, http.MethodPatch, path, owner, map[string]string{"title": "Second", "lyrics": "words"}, http.StatusOK, nil)
+	s.callJSON(t, http.MethodPatch, path, owner, map[string]string{"title": "Third"}, http.StatusOK, nil)
+	// 値が変わらない編集は記録しない
+	s.callJSON(t, http.MethodPatch, path, owner, map[string]string{"title": "Third"}, http.StatusOK, nil)
+
+	var history listEnvelope[TrackRevision]
+	s.callJSON(t, http.MethodGet, path+"/history", owner, nil, http.StatusOK, &history)
+	if history.Meta.Total != 3 || len(history.Data) != 3 {
+		t.Fatalf("got %d revisions (total %d), want 3", len(history.Data), history.Meta.Total)
+	}
+	latest := history.Data[0]
+	if latest.Field != "title" || latest.OldValue == nil || *latest.OldValue != "Second" || *latest.NewValue != "Third" || latest.EditorUID != "alice" {
+		t.Errorf("latest revision = %+v", latest)
+	}
+	var lyrics *TrackRevision
+	for i := range history.Data {
+		if history.Data[i].Field == "lyrics" {
+			lyrics = &history.Data[i]
+		}
+	}
+	if lyrics == nil || lyrics.OldValue != nil || *lyrics.NewValue != "words" {
+		t.Errorf("lyrics revision = %+v, want null -> words", lyrics)
+	}
+
+	s.callJSON(t, http.MethodGet, path+"/history?limit=1&offset=1", owner, nil, http.StatusOK, &history)
+	if len(history.Data) != 1 || !history.Meta.HasMore || history.Meta.Limit != 1 || history.Meta.Offset != 1 {
+		t.Errorf("paged history: %d revisions, meta %+v", len(history.Data), history.Meta)
+	}
+
+	s.callJSON(t, http.MethodGet, path+"/history", other, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodGet, "/api/track/9999/history", owner, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,1001 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tracksETag は GET /api/tracks の ETag を返す
+func (s *testServer) tracksETag(t *testing.T) string {
+	t.Helper()
+	resp := s.callJSON(t, http.MethodGet, "/api/tracks", "", nil, http.StatusOK, nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("GET /api/tracks returned no ETag")
+	}
+	return etag
+}
+
+func TestTracksETagChangesWhenTrackIsEdited(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	admin := s.addAdmin("root")
+	id := insertTrack(t, "alice", "Before")
+
+	etag := s.tracksETag(t)
+	req := s.newRequest(t, http.MethodGet, "/api/tracks", "", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, _ := s.do(t, req); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("unchanged list: status %d, want 304", resp.StatusCode)
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   interface{}
+	}{
+		{"edit title", http.MethodPatch, fmt.Sprintf("/api/track/%d", id), token, map[string]string{"title": "After"}},
+		{"edit title again", http.MethodPatch, fmt.Sprintf("/api/track/%d", id), token, map[string]string{"title": "After again"}},
+		{"feature", http.MethodPost, fmt.Sprintf("/api/admin/track/%d/feature", id), admin, nil},
+		{"unfeature", http.MethodDelete, fmt.Sprintf("/api/admin/track/%d/feature", id), admin, nil},
+	}
+	for _, step := range steps {
+		s.callJSON(t, step.method, step.path, step.token, step.body, http.StatusOK, nil)
+		next := s.tracksETag(t)
+		if next == etag {
+			t.Errorf("%s: ETag did not change", step.name)
+		}
+		etag = next
+	}
+}
+
+func TestTracksETagChangesWhenDisplayNameChanges(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("alice")
+	insertTrack(t, "alice", "Song")
+	etag := s.tracksETag(t)
+
+	// Auth 上で表示名を変えても DB は変わらないが、キャッシュが切れた後の一覧の ETag は変わる
+	s.auth.addUser(fakeAuthUser{UID: "alice", DisplayName: "Alice Renamed", EmailVerified: true})
+	displayNameCache.Lock()
+	displayNameCache.entries = make(map[string]cachedDisplayName)
+	displayNameCache.Unlock()
+
+	req := s.newRequest(t, http.MethodGet, "/api/tracks", "", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, body := s.do(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("after rename: status %d, want 200", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "Alice Renamed") {
+		t.Errorf("body does not contain the new name: %s", body)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("ETag did not change after the display name changed")
+	}
+}
+
+func TestEditTrackAbsentVersusEmptyFields(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d", id)
+
+	var edited map[string]interface{}
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"artist": "Band", "lyrics": "la la"}, http.StatusOK, &edited)
+	if edited["artist"] != "Band" || edited["lyrics"] != "la la" {
+		t.Fatalf("after setting fields: %v", edited)
+	}
+
+	// 指定しなかったフィールドは変わらない
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"title": "  Renamed  "}, http.StatusOK, &edited)
+	if edited["title"] != "Renamed" || edited["artist"] != "Band" || edited["lyrics"] != "la la" {
+		t.Fatalf("after editing the title: %v", edited)
+	}
+
+	// 空文字を指定したフィールドは削除 (NULL) する
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"artist": ""}, http.StatusOK, &edited)
+	if edited["artist"] != nil || edited["lyrics"] != "la la" {
+		t.Fatalf("after clearing the artist: %v", edited)
+	}
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"lyrics": ""}, http.StatusOK, &edited)
+	if edited["lyrics"] != nil {
+		t.Fatalf("after clearing the lyrics: %v", edited)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE id = ? AND artist IS NULL AND lyrics IS NULL", id); n != 1 {
+		t.Error("cleared fields are not NULL in the database")
+	}
+
+	// タイトルは削除できず、空白だけのタイトルも受け付けない (アップロードと同じ検証)
+	for _, title := range []string{"", "   ", strings.Repeat("a", limits.Title+1)} {
+		s.callJSON(t, http.MethodPatch, path, token, map[string]string{"title": title}, http.StatusBadRequest, nil)
+	}
+	s.callJSON(t, http.MethodPatch, path, token, map[string]string{"synced_lyrics": "not lrc"}, http.StatusBadRequest, nil)
+}
+
+func TestFeedScope(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	s.addUser("bob")
+	s.addUser("carol")
+	insertTrack(t, "bob", "Followed")
+	insertTrack(t, "carol", "Not followed")
+	s.callJSON(t, http.MethodPost, "/api/user/bob/follow", alice, nil, http.StatusOK, nil)
+
+	var tracks []Track
+	s.callJSON(t, http.MethodGet, "/api/tracks", alice, nil, http.StatusOK, &tracks)
+	if len(tracks) != 2 {
+		t.Errorf("default scope: got %d tracks, want 2", len(tracks))
+	}
+	s.callJSON(t, http.MethodGet, "/api/tracks?scope=all", "", nil, http.StatusOK, &tracks)
+	if len(tracks) != 2 {
+		t.Errorf("scope=all: got %d tracks, want 2", len(tracks))
+	}
+	s.callJSON(t, http.MethodGet, "/api/tracks?scope=following", alice, nil, http.StatusOK, &tracks)
+	if len(tracks) != 1 || tracks[0].UploaderUID != "bob" {
+		t.Errorf("scope=following: %+v", tracks)
+	}
+	s.callJSON(t, http.MethodGet, "/api/tracks?scope=following", "", nil, http.StatusUnauthorized, nil)
+	s.callJSON(t, http.MethodGet, "/api/tracks?scope=friends", alice, nil, http.StatusBadRequest, nil)
+
+	// 新着トラック数も同じ scope で絞り込む
+	insertTrack(t, "bob", "New followed")
+	insertTrack(t, "carol", "New not followed")
+	var count struct {
+		Count int `json:"count"`
+	}
+	s.callJSON(t, http.MethodGet, "/api/tracks/new-count?since_id=2", alice, nil, http.StatusOK, &count)
+	if count.Count != 2 {
+		t.Errorf("new-count: %d, want 2", count.Count)
+	}
+	s.callJSON(t, http.MethodGet, "/api/tracks/new-count?since_id=2&scope=following", alice, nil, http.StatusOK, &count)
+	if count.Count != 1 {
+		t.Errorf("new-count with scope=following: %d, want 1", count.Count)
+	}
+	s.callJSON(t, http.MethodGet, "/api/tracks/new-count?since_id=2&scope=following", "", nil, http.StatusUnauthorized, nil)
+	s.callJSON(t, http.MethodGet, "/api/tracks/new-count?since_id=2&scope=friends", alice, nil, http.StatusBadRequest, nil)
+}
+
+func TestHeadRequests(t *testing.T) {
+	s := newTestServer(t)
+	audio := testMP3(2 * time.Second)
+	id := s.insertTrackWithAudio(t, "alice", "Song", audio)
+	var filename string
+	if err := db.QueryRow("SELECT filename FROM tracks WHERE id = ?", id).Scan(&filename); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{fmt.Sprintf("/api/track/%d/stream", id), "/uploads/" + filename} {
+		resp, body := s.call(t, http.MethodHead, path, "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("HEAD %s: status %d", path, resp.StatusCode)
+		}
+		if len(body) != 0 {
+			t.Errorf("HEAD %s returned %d body bytes", path, len(body))
+		}
+		if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(audio)) {
+			t.Errorf("HEAD %s: Content-Length = %q, want %d", path, got, len(audio))
+		}
+		if got := resp.Header.Get("Content-Type"); got != "audio/mpeg" {
+			t.Errorf("HEAD %s: Content-Type = %q", path, got)
+		}
+		if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("HEAD %s: Accept-Ranges = %q", path, got)
+		}
+	}
+
+	// GET と同じ長さの本文が返ることも確認する
+	resp, body := s.call(t, http.MethodGet, fmt.Sprintf("/api/track/%d/stream", id), "", nil)
+	if resp.StatusCode != http.StatusOK || len(body) != len(audio) {
+		t.Errorf("GET stream: status %d, %d bytes; want 200, %d", resp.StatusCode, len(body), len(audio))
+	}
+
+	resp, body = s.call(t, http.MethodHead, "/api/tracks", "", nil)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Errorf("HEAD /api/tracks: status %d, %d body bytes", resp.StatusCode, len(body))
+	}
+	resp, _ = s.call(t, http.MethodHead, "/api/track/999999/stream", "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD missing track stream: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	for _, tt := range []struct{ in, want int }{{0, 1}, {-5, 1}, {20, 20}, {maxPageSize, maxPageSize}, {500, maxPageSize}} {
+		if got := clampPageSize(tt.in); got != tt.want {
+			t.Errorf("clampPageSize(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	s := newTestServer(t, "PAGE_SIZE=2")
+	token := s.addUser("bob")
+	for i := 0; i < 3; i++ {
+		id := insertTrack(t, "alice", fmt.Sprintf("Song %d", i))
+		mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES (?, ?)", "bob", id)
+	}
+
+	for _, path := range []string{"/api/tracks?meta=true", "/api/tracks/favorites?meta=true"} {
+		var page listEnvelope[Track]
+		s.callJSON(t, http.MethodGet, path, token, nil, http.StatusOK, &page)
+		if len(page.Data) != 2 || page.Meta.Limit != 2 || !page.Meta.HasMore {
+			t.Errorf("GET %s: %d items, meta %+v; want 2 items with limit 2", path, len(page.Data), page.Meta)
+		}
+	}
+	// ?limit= は PAGE_SIZE より優先する
+	var tracks []Track
+	s.callJSON(t, http.MethodGet, "/api/tracks?limit=3", "", nil, http.StatusOK, &tracks)
+	if len(tracks) != 3 {
+		t.Errorf("GET /api/tracks?limit=3 returned %d tracks", len(tracks))
+	}
+
+	// 範囲外の PAGE_SIZE は上限に収める
+	s = newTestServer(t, "PAGE_SIZE=500")
+	var page listEnvelope[Track]
+	s.callJSON(t, http.MethodGet, "/api/tracks?meta=true", "", nil, http.StatusOK, &page)
+	if page.Meta.Limit != maxPageSize {
+		t.Errorf("PAGE_SIZE=500: limit %d, want %d", page.Meta.Limit, maxPageSize)
+	}
+}
+
+func TestSelfLike(t *testing.T) {
+	likesCount := func(s *testServer, id int) int {
+		t.Helper()
+		var tracks []Track
+		s.callJSON(t, http.MethodGet, "/api/tracks", "", nil, http.StatusOK, &tracks)
+		for _, tr := range tracks {
+			if tr.ID == id {
+				return tr.LikesCount
+			}
+		}
+		t.Fatalf("track %d not listed", id)
+		return 0
+	}
+
+	s := newTestServer(t)
+	owner := s.addUser("alice")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/like", id)
+	s.callJSON(t, http.MethodPost, path, owner, nil, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPut, path, owner, nil, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodPut, path, s.addUser("bob"), nil, http.StatusOK, nil)
+	// 以前からある自己いいねはいいね数に含めない
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('alice', ?)", id)
+	if n := likesCount(s, id); n != 1 {
+		t.Errorf("likes_count = %d, want 1 (self-like excluded)", n)
+	}
+
+	s = newTestServer(t, "ALLOW_SELF_LIKE=true")
+	owner = s.addUser("alice")
+	id = insertTrack(t, "alice", "Song")
+	s.callJSON(t, http.MethodPut, fmt.Sprintf("/api/track/%d/like", id), owner, nil, http.StatusOK, nil)
+	if n := likesCount(s, id); n != 1 {
+		t.Errorf("ALLOW_SELF_LIKE=true: likes_count = %d, want 1", n)
+	}
+}
+
+func TestInvalidTrackID(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	routes := []struct{ method, format string }{
+		{http.MethodGet, "/api/track/%s"},
+		{http.MethodGet, "/api/track/%s/comments"},
+		{http.MethodPost, "/api/track/%s/comment"},
+		{http.MethodPost, "/api/track/%s/like"},
+		{http.MethodPut, "/api/track/%s/like"},
+		{http.MethodDelete, "/api/track/%s"},
+	}
+	for _, id := range []string{"abc", "-1", "0", "1.5"} {
+		for _, r := range routes {
+			path := fmt.Sprintf(r.format, id)
+			var res map[string]string
+			s.callJSON(t, r.method, path, token, map[string]string{"content": "hi"}, http.StatusBadRequest, &res)
+			if res["message"] != "Invalid track ID" {
+				t.Errorf("%s %s: message %q", r.method, path, res["message"])
+			}
+		}
+	}
+}
+
+func TestLikeChangedSignal(t *testing.T) {
+	s := newTestServer(t)
+	bob := s.addUser("bob")
+	id := insertTrack(t, "alice", "Song")
+	path := fmt.Sprintf("/api/track/%d/like", id)
+
+	type likeState struct {
+		LikesCount int  `json:"likes_count"`
+		IsLiked    bool `json:"is_liked"`
+		Changed    bool `json:"changed"`
+	}
+	steps := []struct {
+		method string
+		want   likeState
+	}{
+		{http.MethodPut, likeState{1, true, true}},
+		{http.MethodPut, likeState{1, true, false}}, // 既にいいね済み
+		{http.MethodDelete, likeState{0, false, true}},
+		{http.MethodDelete, likeState{0, false, false}}, // 既に取り消し済み
+		{http.MethodPost, likeState{1, true, true}},     // トグルは常に changed
+		{http.MethodPost, likeState{0, false, true}},
+	}
+	for i, step := range steps {
+		var got likeState
+		s.callJSON(t, step.method, path, bob, nil, http.StatusOK, &got)
+		if got != step.want {
+			t.Errorf("step %d %s like = %+v, want %+v", i+1, step.method, got, step.want)
+		}
+	}
+}
+
+func TestSameSecondTracksHaveStableOrder(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+	var ids []int
+	for i := 0; i < 5; i++ {
+		id := insertTrack(t, "alice", fmt.Sprintf("Song %d", i))
+		mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", id)
+		ids = append(ids, id)
+	}
+	mustExec(t, "UPDATE tracks SET created_at = '2024-01-01 00:00:00'")
+	mustExec(t, "UPDATE likes SET created_at = '2024-01-01 00:00:00'")
+	newest := []int{ids[4], ids[3], ids[2], ids[1], ids[0]}
+
+	// trackIDs は一覧のトラックIDを返す (ユーザーのトラック一覧は {tracks: [...]} で返る)
+	trackIDs := func(path string) []int {
+		t.Helper()
+		var tracks []Track
+		resp, data := s.call(t, http.MethodGet, path, token, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		if strings.HasPrefix(path, "/api/user/") {
+			var page struct {
+				Tracks []Track `json:"tracks"`
+			}
+			err := json.Unmarshal(data, &page)
+			tracks = page.Tracks
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if err := json.Unmarshal(data, &tracks); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]int, len(tracks))
+		for i, tr := range tracks {
+			got[i] = tr.ID
+		}
+		return got
+	}
+
+	// ページを順にたどっても、重複も抜けもなく同じ順番になる
+	for path, want := range map[string][]int{
+		"/api/tracks?sort=newest": newest,
+		"/api/tracks?sort=oldest": ids,
+		"/api/user/alice/tracks?": newest,
+		"/api/tracks/favorites?":  newest,
+	} {
+		var got []int
+		for offset := 0; offset < len(ids); offset += 2 {
+			got = append(got, trackIDs(fmt.Sprintf("%s&limit=2&offset=%d", path, offset))...)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("paging %s = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestStreamConditionalRequests(t *testing.T) {
+	s := newTestServer(t)
+	audio := testMP3(2 * time.Second)
+	id := s.insertTrackWithAudio(t, "alice", "Song", audio)
+	path := fmt.Sprintf("/api/track/%d/stream", id)
+
+	resp, _ := s.call(t, http.MethodGet, path, "", nil)
+	lastModified, etag := resp.Header.Get("Last-Modified"), resp.Header.Get("ETag")
+	modTime, err := http.ParseTime(lastModified)
+	if err != nil || etag == "" {
+		t.Fatalf("Last-Modified %q (%v), ETag %q", lastModified, err, etag)
+	}
+
+	get := func(headers map[string]string) (int, int) {
+		t.Helper()
+		req := s.newRequest(t, http.MethodGet, path, "", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, body := s.do(t, req)
+		return resp.StatusCode, len(body)
+	}
+	for _, tt := range []struct {
+		name    string
+		headers map[string]string
+		status  int
+		size    int
+	}{
+		{"If-Modified-Since equal", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified, 0},
+		{"If-Modified-Since later", map[string]string{"If-Modified-Since": modTime.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified, 0},
+		{"If-Modified-Since earlier", map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK, len(audio)},
+		{"If-None-Match", map[string]string{"If-None-Match": etag}, http.StatusNotModified, 0},
+		// If-None-Match がある場合は If-Modified-Since より優先する
+		{"If-None-Match mismatch", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}, http.StatusOK, len(audio)},
+		{"If-Range with Range", map[string]string{"If-Range": lastModified, "Range": "bytes=0-99"}, http.StatusPartialContent, 100},
+	} {
+		if status, size := get(tt.headers); status != tt.status || size != tt.size {
+			t.Errorf("%s: status %d, %d bytes; want %d, %d", tt.name, status, size, tt.status, tt.size)
+		}
+	}
+}
+
+func TestListMetaEnvelope(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, insertTrack(t, "alice", fmt.Sprintf("Song %d", i)))
+	}
+	// お気に入り・おすすめ・コメントしたトラックは3曲だけにして、total が絞り込みに従うことを確かめる
+	for _, id := range ids[:3] {
+		mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", id)
+		mustExec(t, "UPDATE tracks SET is_featured = TRUE, featured_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		insertComment(t, id, "bob", "nice")
+	}
+	insertComment(t, ids[0], "carol", "one")
+	insertComment(t, ids[0], "carol", "two")
+
+	tests := []struct {
+		path  string
+		total int
+	}{
+		{"/api/tracks", 5},
+		{"/api/tracks/featured", 3},
+		{"/api/tracks/favorites", 3},
+		{fmt.Sprintf("/api/track/%d/comments", ids[0]), 3},
+	}
+	for _, tt := range tests {
+		// 既定は従来どおり配列
+		var bare []json.RawMessage
+		s.callJSON(t, http.MethodGet, tt.path+"?limit=2", token, nil, http.StatusOK, &bare)
+		if len(bare) != 2 {
+			t.Errorf("GET %s?limit=2: %d items, want 2", tt.path, len(bare))
+		}
+
+		for _, page := range []struct {
+			offset  int
+			items   int
+			hasMore bool
+		}{{0, 2, true}, {2, min(2, tt.total-2), tt.total > 4}} {
+			var env listEnvelope[json.RawMessage]
+			s.callJSON(t, http.MethodGet, fmt.Sprintf("%s?meta=true&limit=2&offset=%d", tt.path, page.offset), token, nil, http.StatusOK, &env)
+			want := listMeta{Total: tt.total, Limit: 2, Offset: page.offset, HasMore: page.hasMore}
+			if len(env.Data) != page.items || env.Meta != want {
+				t.Errorf("GET %s offset %d: %d items, meta %+v; want %d items, %+v", tt.path, page.offset, len(env.Data), env.Meta, page.items, want)
+			}
+		}
+		s.callJSON(t, http.MethodGet, tt.path+"?meta=maybe", token, nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestNewTracksCount(t *testing.T) {
+	s := newTestServer(t)
+	insertTrack(t, "alice", "Old")
+	marker := insertTrack(t, "alice", "Seen")
+	insertTrack(t, "alice", "New 1")
+	insertTrack(t, "bob", "New 2")
+	latest := insertTrack(t, "alice", "New 3")
+
+	newCount := func(query string) int {
+		t.Helper()
+		var res struct {
+			Count int `json:"count"`
+		}
+		s.callJSON(t, http.MethodGet, "/api/tracks/new-count?"+query, "", nil, http.StatusOK, &res)
+		return res.Count
+	}
+	for query, want := range map[string]int{
+		fmt.Sprintf("since_id=%d", marker):                     3,
+		fmt.Sprintf("since_id=%d", latest):                     0,
+		"since_id=0":                                           5,
+		fmt.Sprintf("since_id=%d&uploader_uid=alice", marker):  2,
+		fmt.Sprintf("since_id=%d&uploader_uid=nobody", marker): 0,
+	} {
+		if got := newCount(query); got != want {
+			t.Errorf("new-count?%s = %d, want %d", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"", "since_id=abc", "since_id=-1"} {
+		s.callJSON(t, http.MethodGet, "/api/tracks/new-count?"+query, "", nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestDefaultFeedSort(t *testing.T) {
+	ids := func(s *testServer, path string) string {
+		t.Helper()
+		var tracks []Track
+		s.callJSON(t, http.MethodGet, path, "", nil, http.StatusOK, &tracks)
+		got := make([]int, len(tracks))
+		for i, tr := range tracks {
+			got[i] = tr.ID
+		}
+		return fmt.Sprint(got)
+	}
+	setup := func(env ...string) (*testServer, []int) {
+		s := newTestServer(t, env...)
+		first, second, third := insertTrack(t, "alice", "First"), insertTrack(t, "alice", "Second"), insertTrack(t, "alice", "Third")
+		mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", second)
+		return s, []int{first, second, third}
+	}
+
+	s, tr := setup()
+	if got, want := ids(s, "/api/tracks"), fmt.Sprint([]int{tr[2], tr[1], tr[0]}); got != want {
+		t.Errorf("default order = %s, want newest first %s", got, want)
+	}
+
+	s, tr = setup("DEFAULT_FEED_SORT=oldest")
+	if got, want := ids(s, "/api/tracks"), fmt.Sprint(tr); got != want {
+		t.Errorf("DEFAULT_FEED_SORT=oldest: order = %s, want %s", got, want)
+	}
+	// ?sort= を指定した場合はそちらが優先される
+	if got, want := ids(s, "/api/tracks?sort=newest"), fmt.Sprint([]int{tr[2], tr[1], tr[0]}); got != want {
+		t.Errorf("explicit ?sort=newest: order = %s, want %s", got, want)
+	}
+
+	s, tr = setup("DEFAULT_FEED_SORT=popular")
+	if got, want := ids(s, "/api/tracks"), fmt.Sprint([]int{tr[1], tr[2], tr[0]}); got != want {
+		t.Errorf("DEFAULT_FEED_SORT=popular: order = %s, want %s", got, want)
+	}
+}
+
+// likedByExistsSQL は is_liked をトラックごとの EXISTS サブクエリで判定していた以前のクエリ (比較用)
+var likedByExistsSQL = "SELECT " + strings.Replace(trackColumns, "ul.id IS NOT NULL AS is_liked",
+	"EXISTS(SELECT 1 FROM likes WHERE track_id = t.id AND user_uid = ?) AS is_liked", 1) + " FROM tracks t"
+
+// populateLikes はトラックとさまざまなユーザーのいいねをまとめて登録する
+func populateLikes(t testing.TB, tracks int) {
+	t.Helper()
+	mustExec(t, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?)
+		INSERT INTO tracks (filename, title, uploader_uid, uploader_name) SELECT i || '.mp3', 'Track ' || i, 'alice', 'User alice' FROM n`, tracks)
+	// user{n} は ID が n+1 で割り切れるトラックにいいねする
+	mustExec(t, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 20)
+		INSERT INTO likes (user_uid, track_id) SELECT 'user' || n.i, t.id FROM tracks t, n WHERE t.id % (n.i + 1) = 0`)
+}
+
+func TestLikeJoinMatchesExistsSubquery(t *testing.T) {
+	newTestServer(t)
+	populateLikes(t, 200)
+	const orderBy = " ORDER BY t.created_at DESC, t.id DESC"
+
+	for _, uid := range []string{"", "user1", "user7", "nobody"} {
+		query := func(q string) []Track {
+			t.Helper()
+			rows, err := db.Query(q+orderBy, uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			tracks, err := scanTracks(rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tracks
+		}
+		joined, exists := query("SELECT "+trackColumns+" FROM "+trackFrom), query(likedByExistsSQL)
+		if len(joined) != 200 {
+			t.Errorf("uid %q: join returned %d rows, want 200", uid, len(joined))
+		}
+		if !reflect.DeepEqual(joined, exists) {
+			t.Errorf("uid %q: join and EXISTS results differ", uid)
+		}
+	}
+}
+
+func BenchmarkTrackListingLikeStatus(b *testing.B) {
+	newTestServer(b)
+	populateLikes(b, 1000)
+	const page = " ORDER BY t.created_at DESC, t.id DESC LIMIT 50"
+
+	for name, query := range map[string]string{
+		"join":   "SELECT " + trackColumns + " FROM " + trackFrom + page,
+		"exists": likedByExistsSQL + page,
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rows, err := db.Query(query, "user3")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := scanTracks(rows); err != nil {
+					b.Fatal(err)
+				}
+				rows.Close()
+			}
+		})
+	}
+}
+
+func TestTrackMetadataHead(t *testing.T) {
+	s := newTestServer(t)
+	id := insertTrack(t, "alice", "夜明け前 / Before Dawn")
+	mustExec(t, "UPDATE tracks SET artist = ?, created_at = '2024-03-01 12:00:00' WHERE id = ?", "Ünïcode & Co.", id)
+
+	resp, body := s.call(t, http.MethodHead, fmt.Sprintf("/api/track/%d", id), "", nil)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Fatalf("HEAD: status %d with %d body bytes", resp.StatusCode, len(body))
+	}
+	for header, want := range map[string]string{"X-Track-Title": "夜明け前 / Before Dawn", "X-Track-Artist": "Ünïcode & Co."} {
+		raw := resp.Header.Get(header)
+		got, err := url.PathUnescape(raw)
+		if err != nil || got != want {
+			t.Errorf("%s = %q (decoded %q), want %q", header, raw, got, want)
+		}
+		if strings.ContainsFunc(raw, func(r rune) bool { return r > 0x7e }) {
+			t.Errorf("%s = %q contains non-ASCII characters", header, raw)
+		}
+	}
+	if got := resp.Header.Get("Last-Modified"); got != "Fri, 01 Mar 2024 12:00:00 GMT" {
+		t.Errorf("Last-Modified = %q", got)
+	}
+
+	if resp, _ := s.call(t, http.MethodHead, "/api/track/999999", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD missing track: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestHasSyncedLyrics(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.addUser("alice")
+	const lrc = "[00:01.00]first line\n[00:05.50]second line"
+	synced := insertTrack(t, "alice", "Synced")
+	empty := insertTrack(t, "alice", "Empty")
+	plain := insertTrack(t, "alice", "Plain")
+	mustExec(t, "UPDATE tracks SET synced_lyrics = ? WHERE id = ?", lrc, synced)
+	mustExec(t, "UPDATE tracks SET synced_lyrics = '', lyrics = 'just words' WHERE id = ?", empty)
+
+	flags := func() map[int]bool {
+		t.Helper()
+		resp, body := s.call(t, http.MethodGet, "/api/tracks", "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /api/tracks: status %d", resp.StatusCode)
+		}
+		// 一覧には同期歌詞の本文を含めない
+		if strings.Contains(string(body), "first line") || strings.Contains(string(body), `"synced_lyrics"`) {
+			t.Errorf("track list includes the synced lyrics payload: %s", body)
+		}
+		var tracks []Track
+		if err := json.Unmarshal(body, &tracks); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[int]bool)
+		for _, tr := range tracks {
+			got[tr.ID] = tr.HasSyncedLyrics
+		}
+		return got
+	}
+
+	if got, want := flags(), map[int]bool{synced: true, empty: false, plain: false}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("has_synced_lyrics = %v, want %v", got, want)
+	}
+
+	// 同期歌詞を削除するとフラグも外れる
+	s.callJSON(t, http.MethodPatch, fmt.Sprintf("/api/track/%d", synced), owner, map[string]string{"synced_lyrics": ""}, http.StatusOK, nil)
+	if flags()[synced] {
+		t.Error("has_synced_lyrics still true after removing the synced lyrics")
+	}
+}
+
+func TestTracksByIDs(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+	a, b, c := insertTrack(t, "alice", "A"), insertTrack(t, "alice", "B"), insertTrack(t, "alice", "C")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?), ('carol', ?)", b, b)
+
+	fetch := func(token string, ids []int) []Track {
+		t.Helper()
+		var tracks []Track
+		s.callJSON(t, http.MethodPost, "/api/tracks/by-ids", token, map[string][]int{"ids": ids}, http.StatusOK, &tracks)
+		return tracks
+	}
+	idsOf := func(tracks []Track) string {
+		got := make([]int, len(tracks))
+		for i, tr := range tracks {
+			got[i] = tr.ID
+		}
+		return fmt.Sprint(got)
+	}
+
+	// 指定した順番で返し、存在しないIDと重複は除く
+	tracks := fetch(token, []int{c, 999999, a, b, a})
+	if got, want := idsOf(tracks), fmt.Sprint([]int{c, a, b}); got != want {
+		t.Errorf("by-ids = %s, want %s", got, want)
+	}
+	if liked := tracks[2]; !liked.IsLiked || liked.LikesCount != 2 || tracks[0].IsLiked {
+		t.Errorf("likes: %+v", tracks)
+	}
+	// 未ログインでも取得でき、is_liked は false
+	if tracks := fetch("", []int{b}); len(tracks) != 1 || tracks[0].IsLiked || tracks[0].LikesCount != 2 {
+		t.Errorf("anonymous by-ids = %+v", tracks)
+	}
+	if tracks := fetch("", []int{}); len(tracks) != 0 {
+		t.Errorf("empty ids returned %d tracks", len(tracks))
+	}
+
+	tooMany := make([]int, maxTracksByIDs+1)
+	for i := range tooMany {
+		tooMany[i] = i + 1
+	}
+	s.callJSON(t, http.MethodPost, "/api/tracks/by-ids", "", map[string][]int{"ids": tooMany}, http.StatusBadRequest, nil)
+	if tracks := fetch("", tooMany[:maxTracksByIDs]); len(tracks) != 3 {
+		t.Errorf("%d ids returned %d tracks, want 3", maxTracksByIDs, len(tracks))
+	}
+}
+
+func TestCommentedTracksAreDistinct(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("bob")
+	a, b, c := insertTrack(t, "alice", "A"), insertTrack(t, "alice", "B"), insertTrack(t, "alice", "C")
+	insertTrack(t, "alice", "Not commented")
+	comment := func(trackID int, uid, at string) {
+		t.Helper()
+		mustExec(t, "INSERT INTO comments (track_id, user_uid, user_name, content, created_at) VALUES (?, ?, ?, 'hi', ?)", trackID, uid, "User "+uid, at)
+	}
+	// 同じトラックへの複数のコメントは1件にまとめ、最後にコメントした順に並べる
+	comment(a, "bob", "2024-01-01 10:00:00")
+	comment(b, "bob", "2024-01-02 10:00:00")
+	comment(a, "bob", "2024-01-03 10:00:00")
+	comment(a, "bob", "2024-01-03 11:00:00")
+	comment(c, "bob", "2024-01-02 12:00:00")
+	// 他のユーザーのコメントは含めない
+	comment(b, "carol", "2024-01-05 10:00:00")
+	comment(insertTrack(t, "alice", "Carol only"), "carol", "2024-01-05 10:00:00")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?)", c)
+
+	// 常に {data, meta} で返す
+	var meta listMeta
+	ids := func(path string) (string, []Track) {
+		t.Helper()
+		var env listEnvelope[Track]
+		s.callJSON(t, http.MethodGet, path, token, nil, http.StatusOK, &env)
+		got := make([]int, len(env.Data))
+		for i, tr := range env.Data {
+			got[i] = tr.ID
+		}
+		meta = env.Meta
+		return fmt.Sprint(got), env.Data
+	}
+	got, tracks := ids("/api/account/commented")
+	if want := fmt.Sprint([]int{a, c, b}); got != want {
+		t.Errorf("commented tracks = %s, want %s", got, want)
+	}
+	if want := (listMeta{Total: 3, Limit: 50, Offset: 0}); meta != want {
+		t.Errorf("meta = %+v, want %+v", meta, want)
+	}
+	if len(tracks) == 3 && (!tracks[1].IsLiked || tracks[1].LikesCount != 1 || tracks[0].IsLiked) {
+		t.Errorf("likes: %+v", tracks)
+	}
+	if got, _ := ids("/api/account/commented?limit=2&offset=1"); got != fmt.Sprint([]int{c, b}) || meta.Total != 3 || meta.HasMore {
+		t.Errorf("second page = %s (meta %+v), want %v", got, meta, []int{c, b})
+	}
+	s.callJSON(t, http.MethodGet, "/api/account/commented?limit=0", token, nil, http.StatusBadRequest, nil)
+	s.callJSON(t, http.MethodGet, "/api/account/commented", "", nil, http.StatusUnauthorized, nil)
+}
+
+func TestPinnedProfileTrack(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+	first, second := insertTrack(t, "alice", "First"), insertTrack(t, "alice", "Second")
+	bobs := insertTrack(t, "bob", "Bob's")
+
+	pinned := func() int {
+		t.Helper()
+		var page struct {
+			PinnedTrack *Track `json:"pinned_track"`
+		}
+		s.callJSON(t, http.MethodGet, "/api/user/alice/tracks", "", nil, http.StatusOK, &page)
+		if page.PinnedTrack == nil {
+			return 0
+		}
+		return page.PinnedTrack.ID
+	}
+
+	if id := pinned(); id != 0 {
+		t.Errorf("pinned track before pinning = %d", id)
+	}
+	// 他人のトラックはピン留めも解除もできない
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/pin", bobs), alice, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/pin", first), bob, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodPost, "/api/track/999999/pin", alice, nil, http.StatusNotFound, nil)
+
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/pin", first), alice, nil, http.StatusOK, nil)
+	if id := pinned(); id != first {
+		t.Errorf("pinned track = %d, want %d", id, first)
+	}
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d/pin", first), bob, nil, http.StatusForbidden, nil)
+
+	// ピン留めは1曲だけで、新しくピン留めすると置き換わる
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/track/%d/pin", second), alice, nil, http.StatusOK, nil)
+	if id := pinned(); id != second {
+		t.Errorf("pinned track after re-pinning = %d, want %d", id, second)
+	}
+	// ピン留めしていないトラックの解除は何もしない
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d/pin", first), alice, nil, http.StatusOK, nil)
+	if id := pinned(); id != second {
+		t.Errorf("unpinning another track changed the pinned track to %d", id)
+	}
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d/pin", second), alice, nil, http.StatusOK, nil)
+	if id := pinned(); id != 0 {
+		t.Errorf("pinned track after unpinning = %d", id)
+	}
+}
+
+func TestDisplayNamesResolvedFromAuth(t *testing.T) {
+	s := newTestServer(t)
+	// DB には古い表示名が保存されている
+	s.auth.addUser(fakeAuthUser{UID: "alice", DisplayName: "Alice Renamed", EmailVerified: true})
+	aliceTrack := insertTrack(t, "alice", "Alice's")
+	ghostTrack := insertTrack(t, "ghost", "Ghost's")
+	insertComment(t, aliceTrack, "ghost", "left before")
+	insertComment(t, aliceTrack, "alice", "thanks")
+
+	var tracks []Track
+	s.callJSON(t, http.MethodGet, "/api/tracks", "", nil, http.StatusOK, &tracks)
+	names := make(map[int]string)
+	for _, tr := range tracks {
+		names[tr.ID] = tr.UploaderName
+	}
+	if names[aliceTrack] != "Alice Renamed" || names[ghostTrack] != deletedUserName {
+		t.Errorf("uploader names = %v, want the current name and %q for the deleted user", names, deletedUserName)
+	}
+
+	var comments []Comment
+	s.callJSON(t, http.MethodGet, fmt.Sprintf("/api/track/%d/comments", aliceTrack), "", nil, http.StatusOK, &comments)
+	if len(comments) != 2 || comments[0].UserName != deletedUserName || comments[1].UserName != "Alice Renamed" {
+		t.Errorf("comment authors = %+v", comments)
+	}
+
+	// Auth に問い合わせできない場合は DB に保存されている名前を使う
+	displayNameCache.Lock()
+	displayNameCache.entries = make(map[string]cachedDisplayName)
+	displayNameCache.Unlock()
+	s.auth.setUnavailable(true)
+	s.callJSON(t, http.MethodGet, "/api/tracks", "", nil, http.StatusOK, &tracks)
+	for _, tr := range tracks {
+		if tr.UploaderName != "User "+tr.UploaderUID {
+			t.Errorf("uploader name with Auth unavailable = %q, want the stored name", tr.UploaderName)
+		}
+	}
+}
+
+func TestDisplayNameCacheIsBounded(t *testing.T) {
+	s := newTestServer(t)
+	prevMax := displayNameCacheMaxEntries
+	displayNameCacheMaxEntries = 2
+	t.Cleanup(func() { displayNameCacheMaxEntries = prevMax })
+
+	// 期限切れのエントリーは次に Auth から取得したときに削除される
+	displayNameCache.Lock()
+	displayNameCache.entries["stale"] = cachedDisplayName{userProfile: userProfile{name: "Stale"}, fetchedAt: time.Now().Add(-displayNameCacheTTL)}
+	displayNameCache.Unlock()
+
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		s.addUser(uid)
+		insertTrack(t, uid, "Song by "+uid)
+		// 取得時刻が同じにならないよう、1件ずつ一覧を取得してキャッシュさせる
+		s.callJSON(t, http.MethodGet, "/api/tracks?uploader_uid="+uid, "", nil, http.StatusOK, nil)
+		time.Sleep(time.Millisecond)
+	}
+
+	displayNameCache.Lock()
+	defer displayNameCache.Unlock()
+	var cached []string
+	for uid := range displayNameCache.entries {
+		cached = append(cached, uid)
+	}
+	sort.Strings(cached)
+	if !reflect.DeepEqual(cached, []string{"bob", "carol"}) {
+		t.Errorf("cached users = %v, want the two most recently fetched", cached)
+	}
+}
+
+func TestUserTracksPagination(t *testing.T) {
+	s := newTestServer(t)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, insertTrack(t, "alice", fmt.Sprintf("Song %d", i)))
+	}
+	insertTrack(t, "bob", "Not alice's")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?), ('carol', ?), ('bob', ?)", ids[1], ids[1], ids[3])
+
+	type page struct {
+		Tracks []Track `json:"tracks"`
+		Total  int     `json:"total"`
+		Limit  int     `json:"limit"`
+		Offset int     `json:"offset"`
+	}
+	get := func(query string) (page, string) {
+		t.Helper()
+		var p page
+		s.callJSON(t, http.MethodGet, "/api/user/alice/tracks"+query, "", nil, http.StatusOK, &p)
+		got := make([]int, len(p.Tracks))
+		for i, tr := range p.Tracks {
+			got[i] = tr.ID
+		}
+		return p, fmt.Sprint(got)
+	}
+
+	p, got := get("?limit=2&offset=1")
+	if want := fmt.Sprint([]int{ids[3], ids[2]}); got != want || p.Total != 5 || p.Limit != 2 || p.Offset != 1 {
+		t.Errorf("page = %s total %d limit %d offset %d; want %s total 5 limit 2 offset 1", got, p.Total, p.Limit, p.Offset, want)
+	}
+	if _, got := get("?sort=oldest&limit=3"); got != fmt.Sprint(ids[:3]) {
+		t.Errorf("oldest first = %s, want %v", got, ids[:3])
+	}
+	if _, got := get("?sort=popular&limit=2"); got != fmt.Sprint([]int{ids[1], ids[3]}) {
+		t.Errorf("popular = %s, want %v", got, []int{ids[1], ids[3]})
+	}
+	if p, got := get("?offset=10"); got != "[]" || p.Total != 5 {
+		t.Errorf("past the end = %s total %d", got, p.Total)
+	}
+	if p, _ := get(""); p.Total != 5 || len(p.Tracks) != 5 {
+		t.Errorf("default page: %d tracks, total %d", len(p.Tracks), p.Total)
+	}
+
+	for _, query := range []string{"?sort=random", "?limit=0", "?limit=abc", "?offset=-1"} {
+		s.callJSON(t, http.MethodGet, "/api/user/alice/tracks"+query, "", nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestFeaturedTracks(t *testing.T) {
+	s := newTestServer(t)
+	admin, alice := s.addAdmin("admin"), s.addUser("alice")
+	first, second := insertTrack(t, "alice", "First"), insertTrack(t, "alice", "Second")
+	insertTrack(t, "alice", "Not featured")
+
+	featured := func() []string {
+		t.Helper()
+		var tracks []Track
+		s.callJSON(t, http.MethodGet, "/api/tracks/featured", "", nil, http.StatusOK, &tracks)
+		titles := make([]string, 0, len(tracks))
+		for _, track := range tracks {
+			titles = append(titles, track.Title)
+		}
+		return titles
+	}
+	if got := featured(); len(got) != 0 {
+		t.Errorf("featured tracks before curation = %v", got)
+	}
+
+	// 管理者以外は選出できない
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/admin/track/%d/feature", first), alice, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/admin/track/%d/feature", first), "", nil, http.StatusUnauthorized, nil)
+
+	// 選出された日時の新しい順
+	for _, id := range []int{second, first} {
+		s.callJSON(t, http.MethodPost, fmt.Sprintf("/api/admin/track/%d/feature", id), admin, nil, http.StatusOK, nil)
+	}
+	mustExec(t, "UPDATE tracks SET featured_at = '2026-01-01 00:00:00' WHERE id = ?", second)
+	if got := fmt.Sprint(featured()); got != "[First Second]" {
+		t.Errorf("featured tracks = %s, want [First Second]", got)
+	}
+	var page listEnvelope[Track]
+	s.callJSON(t, http.MethodGet, "/api/tracks/featured?meta=true&limit=1", "", nil, http.StatusOK, &page)
+	if len(page.Data) != 1 || page.Meta.Total != 2 || !page.Meta.HasMore {
+		t.Errorf("featured page with meta = %+v", page)
+	}
+
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/admin/track/%d/feature", first), alice, nil, http.StatusForbidden, nil)
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/admin/track/%d/feature", first), admin, nil, http.StatusOK, nil)
+	if got := fmt.Sprint(featured()); got != "[Second]" {
+		t.Errorf("featured tracks after unfeaturing = %s, want [Second]", got)
+	}
+	s.callJSON(t, http.MethodPost, "/api/admin/track/999999/feature", admin, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// uploadError はアップロード処理の失敗を、クライアントに返すステータスとメッセージで表す
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e *uploadError) Error() string { return e.message }
+
+func badUpload(message string) *uploadError {
+	return &uploadError{status: http.StatusBadRequest, message: message}
+}
+
+// trackMetadata はアップロード時に指定するトラックの情報
+type trackMetadata struct {
+	Title        string `json:"title"`
+	Artist       string `json:"artist"`
+	Lyrics       string `json:"lyrics"`
+	SyncedLyrics string `json:"synced_lyrics"`
+}
+
+// validate は入力を正規化して検証する (不適切な語句は filter の設定に応じて伏せ字にする)
+func (m *trackMetadata) validate(filter *ProfanityFilter) *uploadError {
+	var uerr *uploadError
+	if m.Title, uerr = validateTrackTitle(m.Title, filter); uerr != nil {
+		return uerr
+	}
+	if m.Artist, uerr = validateTrackArtist(m.Artist, filter); uerr != nil {
+		return uerr
+	}
+	if uerr = validateTrackLyrics(m.Lyrics); uerr != nil {
+		return uerr
+	}
+	if m.SyncedLyrics, uerr = validateSyncedLyrics(m.SyncedLyrics); uerr != nil {
+		return uerr
+	}
+	return nil
+}
+
+// 以下はフィールドごとの検証で、アップロードとトラック編集 (PATCH /api/track/:id) の両方で使う
+// 正規化した値を返す
+
+// validateTrackTitle はタイトルを検証する (前後の空白は除き、空白だけのタイトルは受け付けない)
+func validateTrackTitle(title string, filter *ProfanityFilter) (string, *uploadError) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", badUpload("Title is required")
+	}
+	if utf8.RuneCountInString(title) > limits.Title {
+		return "", badUpload(fmt.Sprintf("Title is too long (max %d chars)", limits.Title))
+	}
+	title, ok := filter.Filter(title)
+	if !ok {
+		return "", badUpload(profanityRejectedMessage)
+	}
+	return title, nil
+}
+
+// validateTrackArtist はアーティスト名を検証する (空文字はアーティストなし)
+func validateTrackArtist(artist string, filter *ProfanityFilter) (string, *uploadError) {
+	artist = normalizeArtistName(artist)
+	if utf8.RuneCountInString(artist) > limits.Artist {
+		return "", badUpload(fmt.Sprintf("Artist name is too long (max %d chars)", limits.Artist))
+	}
+	artist, ok := filter.Filter(artist)
+	if !ok {
+		return "", badUpload(profanityRejectedMessage)
+	}
+	return artist, nil
+}
+
+// validateTrackLyrics は歌詞の長さを検証する
+func validateTrackLyrics(lyrics string) *uploadError {
+	if utf8.RuneCountInString(lyrics) > limits.Lyrics {
+		return badUpload(fmt.Sprintf("Lyrics are too long (max %d chars)", limits.Lyrics))
+	}
+	return nil
+}
+
+// validateSyncedLyrics は同期歌詞 (LRC、任意) を検証する。指定された場合はタイムスタンプの書式も確認する
+func validateSyncedLyrics(syncedLyrics string) (string, *uploadError) {
+	syncedLyrics = strings.TrimSpace(syncedLyrics)
+	if utf8.RuneCountInString(syncedLyrics) > limits.SyncedLyrics {
+		return "", badUpload(fmt.Sprintf("Synced lyrics are too long (max %d chars)", limits.SyncedLyrics))
+	}
+	if syncedLyrics != "" {
+		if _, err := parseLRC(syncedLyrics); err != nil {
+			return "", badUpload("Invalid synced lyrics: " + err.Error())
+		}
+	}
+	return syncedLyrics, nil
+}
+
+// openMP3Upload はアップロードされたファイルを開き、MP3として受け付けられるかを検証する
+// 検証後は先頭に戻したファイルを返すため、呼び出し側で storeUploadFile に渡し、Close する
+func openMP3Upload(file *multipart.FileHeader, maxSizeMB int) (multipart.File, *uploadError) {
+	// ファイルサイズチェック
+	if file.Size > int64(maxSizeMB)<<20 {
+		return nil, badUpload(fmt.Sprintf("File is too large (max %dMB)", maxSizeMB))
+	}
+
+	// 拡張子チェック
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext != ".mp3" {
+		return nil, badUpload("Only .mp3 files are allowed")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, &uploadError{status: http.StatusInternalServerError, message: "Error opening the file"}
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			src.Close()
+		}
+	}()
+
+	// MIMEタイプチェック (簡易的なマジックナンバーチェック)
+	// 先頭の512バイトを読み込んで判定する
+	buffer := make([]byte, 512)
+	_, err = src.Read(buffer)
+	if err != nil && err != io.EOF {
+		return nil, &uploadError{status: http.StatusInternalServerError, message: "Error checking file type"}
+	}
+	// ファイルポインタを先頭に戻す
+	if _, err := src.Seek(0, 0); err != nil {
+		return nil, &uploadError{status: http.StatusInternalServerError, message: "Error processing file"}
+	}
+
+	contentType := http.DetectContentType(buffer)
+	// 明らかに危険なタイプ（HTML, JS, XMLなど）を拒否する
+	// MP3は "application/octet-stream" や "audio/mpeg" と判定されることが多い
+	if strings.Contains(contentType, "text/") || strings.Contains(contentType, "application/javascript") || strings.Contains(contentType, "application/json") || strings.Contains(contentType, "application/xml") {
+		log.Printf("Rejected file type: %s", contentType)
+		return nil, badUpload("Invalid file type detected")
+	}
+
+	// 音声データの検証: MP3フレームを辿り、再生できる長さの音声が含まれているかを確認する
+	// (拡張子やMIMEタイプのチェックを通過しても、中身が空・壊れているファイルを弾く)
+	// ファイル全体をメモリに読み込まないよう、先頭から順に読みながらフレームを辿る
+	if _, err := inspectMP3Reader(src); err != nil {
+		if err != errCorruptAudio {
+			log.Printf("error inspecting upload %q: %v\n", file.Filename, err)
+			return nil, &uploadError{status: http.StatusInternalServerError, message: "Error processing file"}
+		}
+		log.Printf("Rejected corrupt audio %q: %v", file.Filename, err)
+		return nil, badUpload("File appears to be corrupt or empty")
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, &uploadError{status: http.StatusInternalServerError, message: "Error processing file"}
+	}
+	ok = true
+	return src, nil
+}
+
+// アップロードしたファイルの保存名の付け方 (FILE_NAMING)
+const (
+	fileNamingUUID     = "uuid"      // <uuid>.mp3
+	fileNamingDateUUID = "date-uuid" // 2024/01/<uuid>.mp3 (年月ごとのディレクトリに分ける)
+)
+
+// uploadFileNaming は起動時に FILE_NAMING から設定する
+var uploadFileNaming = fileNamingUUID
+
+// uploadFileName は新しいファイルの uploads ディレクトリからの相対パスを返す (区切りは常に "/")
+func uploadFileName(naming string, now time.Time) string {
+	name := uuid.New().String() + ".mp3"
+	if naming == fileNamingDateUUID {
+		return now.UTC().Format("2006/01") + "/" + name
+	}
+	return name
+}
+
+// saveUploadFile は r から読み込んだ音声データを uploads ディレクトリに保存し、保存したファイルの相対パスを返す
+// ディスク上ではUUIDのみを使用し、元のファイル名に依存しない
+// (元のファイル名に含まれる特殊文字や長さによるファイルシステムエラーを防止)
+func saveUploadFile(dir string, r io.Reader) (string, error) {
+	uniqueFileName := uploadFileName(uploadFileNaming, time.Now())
+	dstPath := uploadFilePath(dir, uniqueFileName)
+
+	dst, err := os.Create(dstPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// 日付のディレクトリがまだない場合や、起動後に uploads ディレクトリが削除された場合は作成する
+		if mkErr := os.MkdirAll(filepath.Dir(dstPath), 0o755); mkErr != nil {
+			log.Printf("error creating upload directory for %s: %v\n", dstPath, mkErr)
+		} else {
+			dst, err = os.Create(dstPath)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("creating %s: %w", dstPath, err)
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return "", fmt.Errorf("writing %s: %w", dstPath, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dstPath)
+		return "", fmt.Errorf("writing %s: %w", dstPath, err)
+	}
+	return uniqueFileName, nil
+}
+
+// uploadFilePath は tracks.filename (uploads ディレクトリからの相対パス) をディスク上のパスに変換する
+func uploadFilePath(dir, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// removeUploadFile はファイルを削除し、空になった日付のディレクトリも削除する
+func removeUploadFile(dir, name string) error {
+	if err := os.Remove(uploadFilePath(dir, name)); err != nil {
+		return err
+	}
+	// 中身の残っているディレクトリは os.Remove が失敗するため、そこで止める
+	for parent := path.Dir(name); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		if os.Remove(uploadFilePath(dir, parent)) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrentUploadsOverLimitGet503(t *testing.T) {
+	s := newTestServer(t, "MAX_CONCURRENT_UPLOADS=2")
+	token := s.addUser("alice")
+
+	// 本文を送り終えないリクエストでアップロード枠を埋め続ける
+	const uploads = 5
+	type result struct {
+		status     int
+		retryAfter string
+	}
+	results := make(chan result, uploads)
+	var writers []*io.PipeWriter
+	for i := 0; i < uploads; i++ {
+		pr, pw := io.Pipe()
+		writers = append(writers, pw)
+		defer pw.Close()
+		req := s.newRequest(t, http.MethodPost, "/api/upload", token, nil)
+		req.Body = pr
+		// 長い Content-Length を付けて、サーバーが拒否したリクエストの本文を読み捨てずに応答するようにする
+		req.ContentLength = 1 << 20
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		go func() {
+			resp, err := s.client.Do(req)
+			if err != nil {
+				results <- result{}
+				return
+			}
+			resp.Body.Close()
+			results <- result{resp.StatusCode, resp.Header.Get("Retry-After")}
+		}()
+	}
+
+	rejected := 0
+	timeout := time.After(10 * time.Second)
+	for rejected < uploads-2 {
+		select {
+		case r := <-results:
+			if r.status != http.StatusServiceUnavailable {
+				t.Fatalf("upload finished with status %d while the slots should be held", r.status)
+			}
+			if r.retryAfter == "" {
+				t.Error("503 response has no Retry-After header")
+			}
+			rejected++
+		case <-timeout:
+			t.Fatalf("only %d of %d uploads were rejected", rejected, uploads-2)
+		}
+	}
+
+	// 枠を持っているリクエストを終わらせると、次のアップロードは枠を確保できる
+	// (途中で切断するとタイムアウトのミドルウェアがハンドラーの終了を待たずに戻るため、宣言した長さの本文を送り切る)
+	for _, pw := range writers {
+		go func(pw *io.PipeWriter) {
+			pw.Write(make([]byte, 1<<20))
+			pw.Close()
+		}(pw)
+	}
+	for i := 0; i < 2; i++ {
+		<-results
+	}
+	resp, _ := s.call(t, http.MethodPost, "/api/upload", token, nil)
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		t.Error("upload slots were not released")
+	}
+}
+
+func TestTrackMetadataValidate(t *testing.T) {
+	limits = initialLimits
+	m := trackMetadata{Title: "  Song  ", Artist: "  Band  ", SyncedLyrics: "  [00:01.00] hi  "}
+	if uerr := m.validate(nil); uerr != nil {
+		t.Fatalf("valid metadata rejected: %s", uerr.message)
+	}
+	if m.Title != "Song" || m.Artist != "Band" || m.SyncedLyrics != "[00:01.00] hi" {
+		t.Errorf("metadata not normalized: %+v", m)
+	}
+
+	for _, bad := range []trackMetadata{
+		{Title: ""},
+		{Title: " \t "},
+		{Title: strings.Repeat("a", limits.Title+1)},
+		{Title: "Song", Artist: strings.Repeat("a", limits.Artist+1)},
+		{Title: "Song", Lyrics: strings.Repeat("a", limits.Lyrics+1)},
+		{Title: "Song", SyncedLyrics: "no timestamps"},
+	} {
+		if uerr := bad.validate(nil); uerr == nil || uerr.status != http.StatusBadRequest {
+			t.Errorf("%+v: got %v, want 400", bad, uerr)
+		}
+	}
+}
+
+// uploadTrack は POST /api/upload で1曲アップロードし、ステータスとレスポンスを返す
+func (s *testServer) uploadTrack(t *testing.T, token, title string, audio []byte) (int, []byte) {
+	t.Helper()
+	body, contentType := multipartForm(t, map[string]string{"title": title}, formFile{"file", "a.mp3", audio})
+	req := s.newRequest(t, http.MethodPost, "/api/upload", token, body)
+	req.Header.Set("Content-Type", contentType)
+	resp, data := s.do(t, req)
+	return resp.StatusCode, data
+}
+
+func TestUploadsDirectoryIsCreated(t *testing.T) {
+	// 存在しない深い階層の uploads ディレクトリも起動時に作られる
+	uploadsDir := filepath.Join(t.TempDir(), "fresh", "disk", "uploads")
+	s := newTestServer(t, "UPLOADS_DIR="+uploadsDir, "FILE_NAMING=date-uuid")
+	token := s.addUser("alice")
+	if info, err := os.Stat(uploadsDir); err != nil || !info.IsDir() {
+		t.Fatalf("uploads directory was not created: %v", err)
+	}
+
+	if status, body := s.uploadTrack(t, token, "First", testMP3(time.Second)); status != http.StatusOK {
+		t.Fatalf("upload to a fresh directory: status %d (%s)", status, body)
+	}
+
+	// 起動後に uploads ディレクトリが消えても、アップロード時に作り直す
+	if err := os.RemoveAll(uploadsDir); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := s.uploadTrack(t, token, "Second", testMP3(time.Second)); status != http.StatusOK {
+		t.Fatalf("upload after removing the directory: status %d (%s)", status, body)
+	}
+	var filename string
+	if err := db.QueryRow("SELECT filename FROM tracks WHERE title = 'Second'").Scan(&filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(uploadFilePath(uploadsDir, filename)); err != nil {
+		t.Errorf("uploaded file is missing: %v", err)
+	}
+
+	// 書き込めない場合は 500 を返し、トラックは登録しない
+	if err := os.RemoveAll(uploadsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(uploadsDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := s.uploadTrack(t, token, "Third", testMP3(time.Second)); status != http.StatusInternalServerError {
+		t.Errorf("upload to an unwritable directory: status %d, want 500", status)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks WHERE title = 'Third'"); n != 0 {
+		t.Errorf("track was registered although the file could not be saved")
+	}
+}
+
+func TestDataAndUploadsDirectories(t *testing.T) {
+	// 別の場所にマウントしたディスク上の、まだ存在しないディレクトリも使える
+	dataDir := filepath.Join(t.TempDir(), "mnt", "disk", "data")
+	newTestServer(t, "DATA_DIR="+dataDir)
+	info, err := os.Stat(filepath.Join(dataDir, "soundlike.db"))
+	if err != nil {
+		t.Fatalf("database was not created in DATA_DIR: %v", err)
+	}
+	if dir, _ := os.Stat(dataDir); dir.Mode().Perm() != 0o700 {
+		t.Errorf("DATA_DIR mode = %v, want 0700", dir.Mode().Perm())
+	}
+	if info.Size() == 0 {
+		t.Error("database file is empty")
+	}
+
+	// 未設定なら従来どおり作業ディレクトリの ./data と uploads を使う
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	s := newTestServer(t, "DATA_DIR=", "UPLOADS_DIR=")
+	if _, err := os.Stat(filepath.Join(cwd, "data", "soundlike.db")); err != nil {
+		t.Errorf("database was not created in ./data: %v", err)
+	}
+	if status, body := s.uploadTrack(t, s.addUser("alice"), "Song", testMP3(time.Second)); status != http.StatusOK {
+		t.Fatalf("upload: status %d (%s)", status, body)
+	}
+	var filename string
+	if err := db.QueryRow("SELECT filename FROM tracks WHERE title = 'Song'").Scan(&filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cwd, "uploads", filename)); err != nil {
+		t.Errorf("uploaded file is not in ./uploads: %v", err)
+	}
+}
+
+func TestEnsureWritableDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := ensureWritableDir(dir, 0o755); err != nil {
+		t.Fatalf("ensureWritableDir(%s): %v", dir, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("write test file was left behind: %v", entries)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureWritableDir(file, 0o755); err == nil {
+		t.Error("ensureWritableDir succeeded on a regular file")
+	}
+}
+
+func TestUploadFileName(t *testing.T) {
+	now := time.Date(2024, 1, 31, 23, 59, 0, 0, time.FixedZone("JST", 9*60*60))
+	if name := uploadFileName(fileNamingUUID, now); strings.Contains(name, "/") || !strings.HasSuffix(name, ".mp3") {
+		t.Errorf("uuid naming = %q", name)
+	}
+	// 年月は UTC で決める
+	if name := uploadFileName(fileNamingDateUUID, now); !strings.HasPrefix(name, "2024/01/") || !strings.HasSuffix(name, ".mp3") {
+		t.Errorf("date-uuid naming = %q, want 2024/01/<uuid>.mp3", name)
+	}
+}
+
+func TestDateShardedUploads(t *testing.T) {
+	s := newTestServer(t, "FILE_NAMING=date-uuid")
+	token := s.addUser("alice")
+	audio := testMP3(time.Second)
+	if status, body := s.uploadTrack(t, token, "Song", audio); status != http.StatusOK {
+		t.Fatalf("upload: status %d (%s)", status, body)
+	}
+
+	var id int
+	var filename string
+	if err := db.QueryRow("SELECT id, filename FROM tracks").Scan(&id, &filename); err != nil {
+		t.Fatal(err)
+	}
+	month := time.Now().UTC().Format("2006/01") + "/"
+	if !strings.HasPrefix(filename, month) {
+		t.Fatalf("filename = %q, want it under %s", filename, month)
+	}
+	path := filepath.Join(s.uploadsDir, filepath.FromSlash(filename))
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file not in the date-sharded directory: %v", err)
+	}
+
+	// 入れ子のパスでもストリーミング・配信できる
+	for _, p := range []string{fmt.Sprintf("/api/track/%d/stream", id), "/uploads/" + filename} {
+		if resp, body := s.call(t, http.MethodGet, p, "", nil); resp.StatusCode != http.StatusOK || len(body) != len(audio) {
+			t.Errorf("GET %s: status %d, %d bytes", p, resp.StatusCode, len(body))
+		}
+	}
+
+	// 削除するとファイルと空になった日付のディレクトリも消える
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d", id), token, nil, http.StatusOK, nil)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists after deletion: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.uploadsDir, filepath.FromSlash(month))); !os.IsNotExist(err) {
+		t.Errorf("empty date directory was not removed: %v", err)
+	}
+	if _, err := os.Stat(s.uploadsDir); err != nil {
+		t.Errorf("uploads directory itself was removed: %v", err)
+	}
+}
+
+// uploadedFiles は uploads ディレクトリに保存されている音声ファイルの数を返す
+func (s *testServer) uploadedFiles(t *testing.T) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(s.uploadsDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".mp3") {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBatchUpload(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+
+	type result struct {
+		Index int    `json:"index"`
+		ID    int    `json:"id"`
+		Error string `json:"error"`
+	}
+	batch := func(titles []string, files ...formFile) (int, []result) {
+		t.Helper()
+		metas := make([]map[string]string, len(titles))
+		for i, title := range titles {
+			metas[i] = map[string]string{"title": title}
+		}
+		metadata, _ := json.Marshal(metas)
+		body, contentType := multipartForm(t, map[string]string{"metadata": string(metadata)}, files...)
+		req := s.newRequest(t, http.MethodPost, "/api/upload/batch", token, body)
+		req.Header.Set("Content-Type", contentType)
+		resp, data := s.do(t, req)
+		var res struct {
+			Results []result `json:"results"`
+		}
+		json.Unmarshal(data, &res)
+		return resp.StatusCode, res.Results
+	}
+
+	// 問題のあるファイルだけを除外して登録する
+	status, results := batch([]string{"One", "Broken", "", "Three"},
+		formFile{"files", "1.mp3", testMP3(time.Second)},
+		formFile{"files", "2.mp3", make([]byte, 4096)},
+		formFile{"files", "3.mp3", testMP3(2 * time.Second)},
+		formFile{"files", "4.mp3", testMP3(3 * time.Second)})
+	if status != http.StatusOK || len(results) != 4 {
+		t.Fatalf("batch upload: status %d, results %+v", status, results)
+	}
+	for i, wantOK := range []bool{true, false, false, true} {
+		if ok := results[i].ID != 0 && results[i].Error == ""; ok != wantOK {
+			t.Errorf("result %d = %+v, want success %v", i, results[i], wantOK)
+		}
+	}
+	if n := s.uploadedFiles(t); n != 2 {
+		t.Errorf("%d files stored, want 2", n)
+	}
+
+	// 登録に失敗した場合は、それまでに保存したファイルも削除する
+	mustExec(t, "CREATE TRIGGER reject_boom BEFORE INSERT ON tracks WHEN NEW.title = 'Boom' BEGIN SELECT RAISE(ABORT, 'boom'); END")
+	status, _ = batch([]string{"Fine", "Boom"},
+		formFile{"files", "5.mp3", testMP3(4 * time.Second)},
+		formFile{"files", "6.mp3", testMP3(5 * time.Second)})
+	if status != http.StatusInternalServerError {
+		t.Errorf("batch with a failing insert: status %d, want 500", status)
+	}
+	if n := s.uploadedFiles(t); n != 2 {
+		t.Errorf("%d files stored after a failed batch, want the original 2", n)
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks"); n != 2 {
+		t.Errorf("%d tracks after a failed batch, want 2", n)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import "database/sql"
+
+// createUserStatsSQL はユーザーごとの集計値 (フォロワー数・フォロー数・トラック数) のテーブルとトリガー
+// follows / tracks への追加・削除のたびに COUNT(*) せずに済むよう、トリガーで同じトランザクション内に更新する
+// (フォロー・アップロードの経路が増えても更新漏れがないよう、各ハンドラーではなくトリガーで更新する)
+const createUserStatsSQL = `
+CREATE TABLE IF NOT EXISTS user_stats (
+	user_uid TEXT PRIMARY KEY,
+	follower_count INTEGER NOT NULL DEFAULT 0,
+	following_count INTEGER NOT NULL DEFAULT 0,
+	track_count INTEGER NOT NULL DEFAULT 0
+);
+CREATE TRIGGER IF NOT EXISTS user_stats_follows_insert AFTER INSERT ON follows BEGIN
+	INSERT INTO user_stats (user_uid, follower_count) VALUES (NEW.following_uid, 1)
+		ON CONFLICT(user_uid) DO UPDATE SET follower_count = follower_count + 1;
+	INSERT INTO user_stats (user_uid, following_count) VALUES (NEW.follower_uid, 1)
+		ON CONFLICT(user_uid) DO UPDATE SET following_count = following_count + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS user_stats_follows_delete AFTER DELETE ON follows BEGIN
+	UPDATE user_stats SET follower_count = MAX(follower_count - 1, 0) WHERE user_uid = OLD.following_uid;
+	UPDATE user_stats SET following_count = MAX(following_count - 1, 0) WHERE user_uid = OLD.follower_uid;
+END;
+CREATE TRIGGER IF NOT EXISTS user_stats_tracks_insert AFTER INSERT ON tracks BEGIN
+	INSERT INTO user_stats (user_uid, track_count) VALUES (NEW.uploader_uid, 1)
+		ON CONFLICT(user_uid) DO UPDATE SET track_count = track_count + 1;
+END;
+CREATE TRIGGER IF NOT EXISTS user_stats_tracks_delete AFTER DELETE ON tracks BEGIN
+	UPDATE user_stats SET track_count = MAX(track_count - 1, 0) WHERE user_uid = OLD.uploader_uid;
+END;`
+
+// UserStats はユーザーごとの集計値
+type UserStats struct {
+	FollowerCount  int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+	TrackCount     int `json:"track_count"`
+}
+
+// rebuildUserStats は user_stats を follows / tracks から作り直す
+// トリガーを追加する前のデータや、DBを直接編集した場合のずれを起動時に直すために使う
+func rebuildUserStats() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_stats"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO user_stats (user_uid, follower_count, following_count, track_count)
+		SELECT uid, SUM(followers), SUM(following), SUM(tracks) FROM (
+			SELECT following_uid AS uid, 1 AS followers, 0 AS following, 0 AS tracks FROM follows
+			UNION ALL
+			SELECT follower_uid, 0, 1, 0 FROM follows
+			UNION ALL
+			SELECT uploader_uid, 0, 0, 1 FROM tracks
+		) GROUP BY uid`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadUserStats はユーザーの集計値を返す (フォローもアップロードもしていないユーザーはすべて 0)
+func loadUserStats(uid string) (UserStats, error) {
+	var stats UserStats
+	err := db.QueryRow("SELECT follower_count, following_count, track_count FROM user_stats WHERE user_uid = ?", uid).
+		Scan(&stats.FollowerCount, &stats.FollowingCount, &stats.TrackCount)
+	if err == sql.ErrNoRows {
+		return UserStats{}, nil
+	}
+	return stats, err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUserStatsThroughFollowCycles(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser("alice")
+	bob, carol := s.addUser("bob"), s.addUser("carol")
+
+	stats := func(uid string) UserStats {
+		t.Helper()
+		var st UserStats
+		s.callJSON(t, http.MethodGet, "/api/user/"+uid+"/stats", "", nil, http.StatusOK, &st)
+		return st
+	}
+	check := func(step, uid string, want UserStats) {
+		t.Helper()
+		if got := stats(uid); got != want {
+			t.Errorf("%s: %s stats = %+v, want %+v", step, uid, got, want)
+		}
+	}
+
+	check("initially", "alice", UserStats{})
+	for i := 0; i < 3; i++ {
+		s.callJSON(t, http.MethodPut, "/api/user/alice/follow", bob, nil, http.StatusOK, nil)
+		s.callJSON(t, http.MethodPut, "/api/user/alice/follow", carol, nil, http.StatusOK, nil)
+		s.callJSON(t, http.MethodPut, "/api/user/bob/follow", alice, nil, http.StatusOK, nil)
+		check("after following", "alice", UserStats{FollowerCount: 2, FollowingCount: 1})
+		check("after following", "bob", UserStats{FollowerCount: 1, FollowingCount: 1})
+
+		s.callJSON(t, http.MethodDelete, "/api/user/alice/follow", bob, nil, http.StatusOK, nil)
+		// 既にフォローしていない状態での解除は件数を変えない
+		s.callJSON(t, http.MethodDelete, "/api/user/alice/follow", bob, nil, http.StatusOK, nil)
+		check("after unfollowing", "alice", UserStats{FollowerCount: 1, FollowingCount: 1})
+		check("after unfollowing", "bob", UserStats{FollowerCount: 1})
+
+		s.callJSON(t, http.MethodDelete, "/api/user/alice/follow", carol, nil, http.StatusOK, nil)
+		s.callJSON(t, http.MethodDelete, "/api/user/bob/follow", alice, nil, http.StatusOK, nil)
+		check(fmt.Sprintf("cycle %d", i), "alice", UserStats{})
+		check(fmt.Sprintf("cycle %d", i), "carol", UserStats{})
+	}
+
+	// トラックの追加と削除
+	id := insertTrack(t, "alice", "Song")
+	insertTrack(t, "alice", "Other")
+	check("after uploading", "alice", UserStats{TrackCount: 2})
+	s.callJSON(t, http.MethodDelete, fmt.Sprintf("/api/track/%d", id), alice, nil, http.StatusOK, nil)
+	check("after deleting a track", "alice", UserStats{TrackCount: 1})
+}
+
+func TestRebuildUserStats(t *testing.T) {
+	newTestServer(t)
+	insertTrack(t, "alice", "Song")
+	mustExec(t, "INSERT INTO follows (follower_uid, following_uid) VALUES ('bob', 'alice'), ('carol', 'alice')")
+	// DBを直接編集してずれた集計値は、起動時の作り直しで元に戻る
+	mustExec(t, "UPDATE user_stats SET follower_count = 10, track_count = 0 WHERE user_uid = 'alice'")
+	mustExec(t, "INSERT INTO user_stats (user_uid, follower_count) VALUES ('ghost', 3)")
+
+	if err := rebuildUserStats(); err != nil {
+		t.Fatal(err)
+	}
+	for uid, want := range map[string]UserStats{
+		"alice": {FollowerCount: 2, TrackCount: 1},
+		"bob":   {FollowingCount: 1},
+		"ghost": {},
+	} {
+		got, err := loadUserStats(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s stats after rebuild = %+v, want %+v", uid, got, want)
+		}
+	}
+}
+
+func TestCreatorDashboard(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob := s.addUser("alice"), s.addUser("bob")
+
+	var ids []int
+	for i := 1; i <= 6; i++ {
+		ids = append(ids, insertTrack(t, "alice", fmt.Sprintf("Song %d", i)))
+	}
+	insertTrack(t, "bob", "Not mine")
+	// Song 6 に3件、Song 2 に2件、Song 4 に1件のいいね (本人のいいねは数えない)
+	mustExec(t, `INSERT INTO likes (user_uid, track_id) VALUES
+		('bob', ?), ('carol', ?), ('dave', ?), ('bob', ?), ('carol', ?), ('bob', ?), ('alice', ?)`,
+		ids[5], ids[5], ids[5], ids[1], ids[1], ids[3], ids[0])
+	mustExec(t, "INSERT INTO plays (track_id, user_uid) VALUES (?, 'bob'), (?, NULL), (?, 'carol')", ids[0], ids[0], ids[5])
+	insertComment(t, ids[0], "bob", "nice")
+	mustExec(t, "INSERT INTO follows (follower_uid, following_uid) VALUES ('bob', 'alice')")
+	if err := rebuildUserStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	type dashboard struct {
+		TrackCount    int     `json:"track_count"`
+		TotalPlays    int     `json:"total_plays"`
+		TotalLikes    int     `json:"total_likes"`
+		TotalComments int     `json:"total_comments"`
+		FollowerCount int     `json:"follower_count"`
+		TopTracks     []Track `json:"top_tracks"`
+	}
+	var d dashboard
+	s.callJSON(t, http.MethodGet, "/api/account/dashboard", alice, nil, http.StatusOK, &d)
+	if d.TrackCount != 6 || d.TotalPlays != 3 || d.TotalLikes != 6 || d.TotalComments != 1 || d.FollowerCount != 1 {
+		t.Errorf("dashboard totals = %+v", d)
+	}
+	var top []string
+	for _, track := range d.TopTracks {
+		top = append(top, track.Title)
+	}
+	// いいね数が同じなら新しい順
+	if want := []string{"Song 6", "Song 2", "Song 4", "Song 5", "Song 3"}; fmt.Sprint(top) != fmt.Sprint(want) {
+		t.Errorf("top tracks = %v, want %v", top, want)
+	}
+
+	// 集計結果はしばらくキャッシュする
+	insertComment(t, ids[0], "bob", "again")
+	var cached dashboard
+	s.callJSON(t, http.MethodGet, "/api/account/dashboard", alice, nil, http.StatusOK, &cached)
+	if cached.TotalComments != 1 {
+		t.Errorf("total_comments = %d within the cache TTL, want the cached 1", cached.TotalComments)
+	}
+	// キャッシュはユーザーごと
+	var bobs dashboard
+	s.callJSON(t, http.MethodGet, "/api/account/dashboard", bob, nil, http.StatusOK, &bobs)
+	if bobs.TrackCount != 1 || bobs.TotalComments != 0 || len(bobs.TopTracks) != 1 {
+		t.Errorf("bob's dashboard = %+v", bobs)
+	}
+
+	s.callJSON(t, http.MethodGet, "/api/account/dashboard", "", nil, http.StatusUnauthorized, nil)
+}
+
+func TestPlatformStats(t *testing.T) {
+	s := newTestServer(t)
+	song := insertTrack(t, "alice", "Song")
+	insertTrack(t, "alice", "Other")
+	mustExec(t, "INSERT INTO likes (user_uid, track_id) VALUES ('bob', ?), ('carol', ?)", song, song)
+	insertComment(t, song, "bob", "nice")
+	mustExec(t, "INSERT INTO follows (follower_uid, following_uid) VALUES ('dave', 'alice')")
+	mustExec(t, "INSERT INTO user_settings (user_uid) VALUES ('erin')")
+
+	stats := func() map[string]int {
+		t.Helper()
+		var st map[string]int
+		s.callJSON(t, http.MethodGet, "/api/stats", "", nil, http.StatusOK, &st)
+		return st
+	}
+	// ユーザー数は何らかの活動をしたユーザーの重複なしの人数
+	want := map[string]int{"total_tracks": 2, "total_users": 5, "total_likes": 2, "total_comments": 1}
+	if got := stats(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("stats = %v, want %v", got, want)
+	}
+
+	// 1分間はキャッシュを返し、期限が切れたら数え直す
+	insertTrack(t, "frank", "New")
+	if got := stats(); got["total_tracks"] != 2 {
+		t.Errorf("total_tracks within the cache TTL = %d, want the cached 2", got["total_tracks"])
+	}
+	platformStatsCache.Lock()
+	platformStatsCache.fetchedAt = platformStatsCache.fetchedAt.Add(-time.Minute)
+	platformStatsCache.Unlock()
+	if got := stats(); got["total_tracks"] != 3 || got["total_users"] != 6 {
+		t.Errorf("stats after the cache expired = %v", got)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "runtime/debug"
+
+// ビルド情報 (ビルド時に -ldflags で埋め込む)
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// buildCommit は埋め込まれたコミットを返す
+// 指定がなければ Go が記録した VCS 情報を使い、それもなければ "unknown" を返す
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	var v map[string]string
+	s.callJSON(t, http.MethodGet, "/api/version", "", nil, http.StatusOK, &v)
+	if v["version"] != "dev" || v["commit"] == "" {
+		t.Errorf("default build info = %v", v)
+	}
+	serverTime, err := time.Parse(time.RFC3339, v["server_time"])
+	if err != nil {
+		t.Fatalf("server_time = %q: %v", v["server_time"], err)
+	}
+	if d := time.Since(serverTime); d < -time.Second || d > 5*time.Second {
+		t.Errorf("server_time = %s, want about now", serverTime)
+	}
+
+	// -ldflags で埋め込んだ値を返す
+	defer func(v, c, b string) { version, commit, buildTime = v, c, b }(version, commit, buildTime)
+	version, commit, buildTime = "1.2.0", "abc1234", "2026-01-02T03:04:05Z"
+	s.callJSON(t, http.MethodGet, "/api/version", "", nil, http.StatusOK, &v)
+	if v["version"] != "1.2.0" || v["commit"] != "abc1234" || v["build_time"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("embedded build info = %v", v)
+	}
+}
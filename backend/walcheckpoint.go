@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// checkpointWAL は WAL の内容をデータベース本体に書き戻し、-wal ファイルを切り詰める
+// 読み込み中の接続があって書き戻しきれなかった場合 (busy) もエラーにはせず、結果をログに残す
+func checkpointWAL() error {
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		log.Printf("WAL checkpoint incomplete (database busy): %d of %d frames checkpointed", checkpointed, logFrames)
+	} else {
+		log.Printf("WAL checkpoint complete: %d frames checkpointed", checkpointed)
+	}
+	return nil
+}
+
+// runWALCheckpoints は interval ごとにチェックポイントを実行し続ける (goroutine で起動する)
+// 書き込みの多い環境で soundlike.db-wal が大きくなり続けるのを防ぐ
+func runWALCheckpoints(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := checkpointWAL(); err != nil {
+			log.Printf("error running WAL checkpoint: %v", err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointWALTruncatesLog(t *testing.T) {
+	newTestServer(t)
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("journal_mode = %q, %v; want wal", mode, err)
+	}
+
+	for i := 0; i < 50; i++ {
+		insertTrack(t, "alice", "Song")
+	}
+	walPath := filepath.Join(os.Getenv("DATA_DIR"), "soundlike.db-wal")
+	if info, err := os.Stat(walPath); err != nil || info.Size() == 0 {
+		t.Fatalf("WAL file is empty before the checkpoint: %v", err)
+	}
+
+	if err := checkpointWAL(); err != nil {
+		t.Fatalf("checkpointWAL: %v", err)
+	}
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("WAL file is %d bytes after the checkpoint, want 0", info.Size())
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM tracks"); n != 50 {
+		t.Errorf("tracks = %d after the checkpoint, want 50", n)
+	}
+}
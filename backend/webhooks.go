@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook構造体: ユーザーが登録した外部連携用のURL
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookPayload はアップロード時に外部へPOSTするJSON
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Track     Track     `json:"track"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhookRetryDelays は配信失敗時の再試行間隔 (初回 + 3回まで再試行)
+var webhookRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+// disallowedIPRanges は net.IP のメソッドでは判定できない、公開インターネットにない特殊用途のアドレス範囲
+var disallowedIPRanges = func() []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // 「このネットワーク」(Linux では 0.0.0.0 以外もローカルホストに届く)
+		"100.64.0.0/10", // キャリアグレードNAT (クラウドのメタデータサービスなどに使われる)
+		"192.0.0.0/24",  // IETF プロトコル割り当て
+		"198.18.0.0/15", // ベンチマーク用
+	} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges
+}()
+
+// isDisallowedIP は内部ネットワークやループバックなど、Webhookの送信先や外部の音声URLとして許可しないIPかを判定する (SSRF対策)
+func isDisallowedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, r := range disallowedIPRanges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePublicURL は登録されるURL (Webhookの送信先・外部の音声URL) が http(s) で、外部の公開アドレスを指しているかを確認する
+func validatePublicURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL must use http or https")
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("URL must have a host and no credentials")
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("could not resolve host")
+	}
+	for _, ip := range ips {
+		if isDisallowedIP(ip) {
+			return fmt.Errorf("URL must not point to an internal address")
+		}
+	}
+	return nil
+}
+
+// webhookHTTPClient は接続時にも送信先IPを検査するHTTPクライアント
+// (登録後にDNSの向き先を変えて内部アドレスへ送らせる DNS rebinding を防ぐ)
+var webhookHTTPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isDisallowedIP(ip) {
+					return fmt.Errorf("webhook destination %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	// リダイレクト経由で内部アドレスに誘導されないよう、リダイレクトは追わない
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// generateWebhookSecret は署名用のランダムなシークレットを生成する
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// signWebhookPayload はペイロードの HMAC-SHA256 署名を返す
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook は1件のWebhookを送信し、失敗した場合は間隔を空けて再試行する
+func deliverWebhook(webhookURL, secret, event string, body []byte) {
+	deliveryID := uuid.New().String()
+	signature := signWebhookPayload(secret, body)
+
+	for attempt := 0; ; attempt++ {
+		err := func() error {
+			req, err := http.NewRequestWithContext(context.Background(), "POST", webhookURL, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("User-Agent", "SoundLike-Webhook/1.0")
+			req.Header.Set("X-SoundLike-Event", event)
+			req.Header.Set("X-SoundLike-Delivery", deliveryID)
+			req.Header.Set("X-SoundLike-Signature", signature)
+
+			resp, err := webhookHTTPClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			return nil
+		}()
+		if err == nil {
+			return
+		}
+		if attempt >= len(webhookRetryDelays) {
+			log.Printf("Webhook delivery %s to %s failed permanently: %v", deliveryID, webhookURL, err)
+			return
+		}
+		log.Printf("Webhook delivery %s to %s failed (attempt %d): %v", deliveryID, webhookURL, attempt+1, err)
+		time.Sleep(webhookRetryDelays[attempt])
+	}
+}
+
+// dispatchUploadWebhooks はアップロードしたユーザーが登録している全てのWebhookに通知する
+func dispatchUploadWebhooks(track Track) {
+	rows, err := db.Query("SELECT url, secret FROM webhooks WHERE user_uid = ?", track.UploaderUID)
+	if err != nil {
+		log.Printf("Error getting webhooks for upload: %v", err)
+		return
+	}
+	type target struct{ url, secret string }
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.url, &t.secret); err == nil {
+			targets = append(targets, t)
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(WebhookPayload{Event: "track.uploaded", Track: track, Timestamp: time.Now().UTC()})
+	if err != nil {
+		log.Printf("Error marshaling webhook payload: %v", err)
+		return
+	}
+	for _, t := range targets {
+		go deliverWebhook(t.url, t.secret, "track.uploaded", body)
+	}
+}
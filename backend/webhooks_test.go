@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestIsDisallowedIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip         string
+		disallowed bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"192.0.0.170", true},
+		{"198.18.0.1", true},
+		{"198.19.255.255", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"100.128.0.1", false},
+		{"198.20.0.1", false},
+		{"192.0.2.1", false},
+		{"2001:4860:4860::8888", false},
+	} {
+		if got := isDisallowedIP(net.ParseIP(tc.ip)); got != tc.disallowed {
+			t.Errorf("isDisallowedIP(%s) = %v, want %v", tc.ip, got, tc.disallowed)
+		}
+	}
+}
+
+func TestInternalURLsAreRejected(t *testing.T) {
+	s := newTestServer(t)
+	token := s.addUser("alice")
+	internal := []string{"http://127.0.0.1/hook", "http://100.64.0.1/hook", "http://198.18.0.1/hook", "http://0.0.0.0/hook", "ftp://example.com/hook"}
+
+	for _, u := range internal {
+		s.callJSON(t, http.MethodPost, "/api/webhooks", token, map[string]string{"url": u}, http.StatusBadRequest, nil)
+	}
+	for _, u := range internal {
+		body, contentType := multipartForm(t, map[string]string{"title": "External", "external_url": u + ".mp3"})
+		req := s.newRequest(t, http.MethodPost, "/api/upload", token, body)
+		req.Header.Set("Content-Type", contentType)
+		if resp, data := s.do(t, req); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("external_url %s: status %d (%s), want 400", u, resp.StatusCode, data)
+		}
+	}
+	if n := queryInt(t, "SELECT COUNT(*) FROM webhooks"); n != 0 {
+		t.Errorf("%d webhooks registered", n)
+	}
+}